@@ -4,12 +4,18 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"finedine/backend/handlers"
 	"finedine/backend/internal/cache"
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/loyalty"
 	"finedine/backend/internal/middleware"
+	"finedine/backend/internal/notify"
+	"finedine/backend/internal/plans"
 	"finedine/backend/internal/realtime"
+	"finedine/backend/internal/session"
+	"finedine/backend/internal/webhooks"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -37,6 +43,18 @@ func main() {
 	// Supabase
 	database.InitSupabase()
 
+	// Revoked sessions (in-memory fallback when Redis is down)
+	session.LoadRevoked()
+
+	// Stripe webhook retries (failed events with elapsed backoff)
+	webhooks.StartRetryWorker(time.Minute)
+
+	// Loyalty points older than 12 months
+	loyalty.StartExpiryWorker(time.Hour)
+
+	// Low stock and expiring inventory, one digest per restaurant per day
+	notify.StartInventoryWatcher(15 * time.Minute)
+
 	// WebSocket Hub
 	go realtime.WSHub.Run()
 	log.Println("✅ WebSocket hub started")
@@ -55,8 +73,8 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "X-Device-ID", "X-Captcha-Token"},
+		ExposeHeaders:    []string{"Content-Length", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           12 * 3600,
 	}))
@@ -102,7 +120,7 @@ func main() {
 
 	// ── Auth (public, rate-limited) ─────────────────────────────────────────────
 	auth := v1.Group("/auth")
-	auth.Use(middleware.RateLimiter(20))
+	auth.Use(middleware.RateLimiter("auth", 20))
 	{
 		auth.POST("/register", handlers.Register)
 		auth.POST("/login", handlers.Login)
@@ -114,7 +132,7 @@ func main() {
 
 	// ── Public (read-only browse) ───────────────────────────────────────────────
 	public := v1.Group("")
-	public.Use(middleware.RateLimiter(100))
+	public.Use(middleware.RateLimiter("public", 100))
 	{
 		public.GET("/restaurants", handlers.GetRestaurants)
 		public.GET("/restaurants/nearby", handlers.GetNearbyRestaurants)
@@ -130,16 +148,27 @@ func main() {
 	// ── Protected (authenticated users) ─────────────────────────────────────────
 	protected := v1.Group("")
 	protected.Use(middleware.AuthMiddleware(os.Getenv("SUPABASE_JWT_SECRET")))
-	protected.Use(middleware.RateLimiter(200))
+	protected.Use(middleware.RateLimiter("protected", 200))
 	{
 		// Profile
 		protected.GET("/profile", handlers.GetProfile)
 		protected.PUT("/profile", handlers.UpdateProfile)
 
+		// Sessions
+		protected.GET("/sessions", handlers.GetSessions)
+		protected.DELETE("/sessions", handlers.RevokeAllSessions)
+		protected.DELETE("/sessions/:id", handlers.RevokeSession)
+
 		// Orders
-		protected.POST("/orders", handlers.CreateOrder)
+		protected.POST("/orders", handlers.CheckoutOrder)
+		protected.POST("/orders/checkout", handlers.CheckoutOrder)
 		protected.GET("/orders", handlers.GetUserOrders)
 		protected.GET("/orders/:id", handlers.GetOrderByID)
+		protected.GET("/orders/:id/payment", handlers.GetOrderPayment)
+		protected.GET("/orders/:id/receipt", handlers.GetOrderReceipt)
+		protected.POST("/orders/:id/splits", handlers.CreateOrderSplit)
+		protected.GET("/orders/:id/splits", handlers.GetOrderSplits)
+		protected.POST("/orders/:id/splits/:splitId/pay", handlers.PayOrderSplit)
 		protected.PATCH("/orders/:id/cancel", handlers.CancelOrder)
 
 		// Bookings
@@ -148,6 +177,22 @@ func main() {
 		protected.GET("/bookings/:id", handlers.GetBookingByID)
 		protected.PATCH("/bookings/:id/cancel", handlers.CancelBooking)
 
+		// Deals & coupons
+		protected.POST("/deals/:id/claim", middleware.AbuseGuard("coupon_claim", ""), handlers.ClaimDealCoupon)
+		protected.GET("/coupons", handlers.GetUserCoupons)
+
+		// Loyalty
+		protected.GET("/loyalty", handlers.GetLoyalty)
+
+		// Gift cards
+		protected.POST("/gift-cards", handlers.PurchaseGiftCard)
+		protected.GET("/gift-cards", handlers.GetUserGiftCards)
+		protected.POST("/gift-cards/balance", middleware.AbuseGuard("gift_card_balance", ""), handlers.CheckGiftCardBalance)
+
+		// Referrals
+		protected.GET("/referrals", handlers.GetReferrals)
+		protected.POST("/referrals/apply", middleware.AbuseGuard("referral_apply", ""), handlers.ApplyReferralCode)
+
 		// Favorites
 		protected.POST("/favorites", handlers.AddFavorite)
 		protected.DELETE("/favorites/:restaurantId", handlers.RemoveFavorite)
@@ -161,44 +206,86 @@ func main() {
 
 	// ── Owner (restaurant owners only) ──────────────────────────────────────────
 	owner := v1.Group("/owner")
-	owner.Use(middleware.AuthMiddleware(os.Getenv("SUPABASE_JWT_SECRET")))
+	owner.Use(middleware.OwnerAuth(os.Getenv("SUPABASE_JWT_SECRET")))
 	owner.Use(middleware.RestaurantOwnerOnly())
-	owner.Use(middleware.APIKeyRateLimiter(500))
+	owner.Use(middleware.PlanAPIRateLimiter())
 	{
 		// Restaurant management
 		owner.POST("/restaurants", handlers.CreateRestaurant)
 		owner.PUT("/restaurants/:id", handlers.UpdateRestaurant)
+		owner.GET("/restaurants/:id/tax-rules", handlers.GetTaxRules)
+		owner.PUT("/restaurants/:id/tax-rules", handlers.UpdateTaxRules)
+		owner.GET("/restaurants/:id/loyalty-rules", handlers.GetLoyaltyRules)
+		owner.PUT("/restaurants/:id/loyalty-rules", handlers.UpdateLoyaltyRules)
+
+		// Gift cards
+		owner.POST("/restaurants/:id/gift-cards", handlers.IssueGiftCard)
+		owner.GET("/restaurants/:id/gift-cards", handlers.GetRestaurantGiftCards)
+		owner.GET("/restaurants/:id/gift-cards/:cardId", handlers.GetGiftCardLedger)
+		owner.PATCH("/restaurants/:id/gift-cards/:cardId", handlers.UpdateGiftCardStatus)
 
 		// Orders
 		owner.GET("/restaurants/:id/orders", handlers.GetRestaurantOrders)
+		owner.POST("/restaurants/:id/orders", handlers.CreateRestaurantOrder)
 		owner.PATCH("/restaurants/:id/orders/:orderId/status", handlers.UpdateOrderStatus)
+		owner.POST("/restaurants/:id/orders/:orderId/refund", handlers.RefundOrder)
 
 		// Menu
-		owner.POST("/restaurants/:id/menu", handlers.AddMenuItem)
+		owner.POST("/restaurants/:id/menu", middleware.EnforceQuota(plans.QuotaMenuItems), handlers.AddMenuItem)
 		owner.PUT("/menu-items/:id", handlers.UpdateMenuItem)
 		owner.DELETE("/menu-items/:id", handlers.DeleteMenuItem)
+		owner.GET("/menu-items/:id/recipe", handlers.GetMenuItemRecipe)
+		owner.PUT("/menu-items/:id/recipe", handlers.UpdateMenuItemRecipe)
 
 		// Deals
-		owner.POST("/restaurants/:id/deals", handlers.CreateDeal)
+		owner.POST("/restaurants/:id/deals", middleware.EnforceQuota(plans.QuotaDealsPerMonth), handlers.CreateDeal)
 		owner.PUT("/deals/:id", handlers.UpdateDeal)
 		owner.DELETE("/deals/:id", handlers.DeleteDeal)
 
 		// Inventory
-		owner.GET("/restaurants/:id/inventory", handlers.GetInventory)
-		owner.POST("/restaurants/:id/inventory", handlers.AddInventoryItem)
-		owner.PUT("/inventory/:id", handlers.UpdateInventoryItem)
-		owner.DELETE("/inventory/:id", handlers.DeleteInventoryItem)
+		owner.GET("/restaurants/:id/inventory", middleware.RequireFeature(plans.FeatureInventory), handlers.GetInventory)
+		owner.POST("/restaurants/:id/inventory", middleware.RequireFeature(plans.FeatureInventory), handlers.AddInventoryItem)
+		owner.PUT("/inventory/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.UpdateInventoryItem)
+		owner.DELETE("/inventory/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.DeleteInventoryItem)
+		owner.POST("/inventory/:id/movements", middleware.RequireFeature(plans.FeatureInventory), handlers.RecordStockMovement)
+		owner.GET("/inventory/:id/movements", middleware.RequireFeature(plans.FeatureInventory), handlers.GetStockMovements)
+		owner.GET("/restaurants/:id/inventory/movements", middleware.RequireFeature(plans.FeatureInventory), handlers.GetRestaurantStockMovements)
+		owner.GET("/restaurants/:id/inventory/reconcile", middleware.RequireFeature(plans.FeatureInventory), handlers.GetStockReconciliation)
+		owner.POST("/restaurants/:id/inventory/reconcile", middleware.RequireFeature(plans.FeatureInventory), handlers.ReconcileStock)
+
+		// Suppliers & purchase orders
+		owner.GET("/restaurants/:id/suppliers", middleware.RequireFeature(plans.FeatureInventory), handlers.GetSuppliers)
+		owner.POST("/restaurants/:id/suppliers", middleware.RequireFeature(plans.FeatureInventory), handlers.CreateSupplier)
+		owner.PUT("/suppliers/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.UpdateSupplier)
+		owner.DELETE("/suppliers/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.DeleteSupplier)
+		owner.GET("/restaurants/:id/purchase-orders", middleware.RequireFeature(plans.FeatureInventory), handlers.GetPurchaseOrders)
+		owner.POST("/restaurants/:id/purchase-orders", middleware.RequireFeature(plans.FeatureInventory), handlers.CreatePurchaseOrder)
+		owner.GET("/restaurants/:id/purchase-orders/suggestions", middleware.RequireFeature(plans.FeatureInventory), handlers.GetPurchaseSuggestions)
+		owner.POST("/restaurants/:id/purchase-orders/generate", middleware.RequireFeature(plans.FeatureInventory), handlers.GeneratePurchaseOrders)
+		owner.GET("/purchase-orders/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.GetPurchaseOrder)
+		owner.PUT("/purchase-orders/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.UpdatePurchaseOrder)
+		owner.POST("/purchase-orders/:id/send", middleware.RequireFeature(plans.FeatureInventory), handlers.SendPurchaseOrder)
+		owner.POST("/purchase-orders/:id/receive", middleware.RequireFeature(plans.FeatureInventory), handlers.ReceivePurchaseOrder)
+		owner.DELETE("/purchase-orders/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.DeletePurchaseOrder)
+
+		// Food waste
+		owner.GET("/restaurants/:id/waste", middleware.RequireFeature(plans.FeatureInventory), handlers.GetFoodWaste)
+		owner.POST("/restaurants/:id/waste", middleware.RequireFeature(plans.FeatureInventory), handlers.CreateFoodWaste)
+		owner.GET("/restaurants/:id/waste/report", middleware.RequireFeature(plans.FeatureInventory), handlers.GetFoodWasteReport)
+		owner.PUT("/waste/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.UpdateFoodWaste)
+		owner.DELETE("/waste/:id", middleware.RequireFeature(plans.FeatureInventory), handlers.DeleteFoodWaste)
 
 		// Employees
-		owner.GET("/restaurants/:id/employees", handlers.GetRestaurantEmployees)
-		owner.POST("/restaurants/:id/employees", handlers.CreateEmployee)
-		owner.PUT("/employees/:id", handlers.UpdateEmployee)
-		owner.DELETE("/employees/:id", handlers.DeleteEmployee)
+		owner.GET("/restaurants/:id/employees", middleware.RequireFeature(plans.FeatureScheduling), handlers.GetRestaurantEmployees)
+		owner.POST("/restaurants/:id/employees", middleware.RequireFeature(plans.FeatureScheduling), handlers.CreateEmployee)
+		owner.PUT("/employees/:id", middleware.RequireFeature(plans.FeatureScheduling), handlers.UpdateEmployee)
+		owner.DELETE("/employees/:id", middleware.RequireFeature(plans.FeatureScheduling), handlers.DeleteEmployee)
 
 		// Shifts
-		owner.GET("/restaurants/:id/shifts", handlers.GetRestaurantShifts)
-		owner.POST("/restaurants/:id/shifts", handlers.CreateShift)
-		owner.DELETE("/shifts/:id", handlers.DeleteShift)
+		owner.GET("/restaurants/:id/shifts", middleware.RequireFeature(plans.FeatureScheduling), handlers.GetRestaurantShifts)
+		owner.POST("/restaurants/:id/shifts", middleware.RequireFeature(plans.FeatureScheduling), handlers.CreateShift)
+		owner.DELETE("/shifts/:id", middleware.RequireFeature(plans.FeatureScheduling), handlers.DeleteShift)
+		owner.GET("/restaurants/:id/tips/distribution", middleware.RequireFeature(plans.FeatureScheduling), handlers.GetTipDistribution)
 
 		// Offers
 		owner.GET("/restaurants/:id/offers", handlers.GetRestaurantOffers)
@@ -207,20 +294,41 @@ func main() {
 		owner.DELETE("/offers/:id", handlers.DeleteOffer)
 
 		// Coupons & Transactions
-		owner.POST("/coupons/validate", handlers.ValidateCoupon)
+		owner.POST("/coupons/validate", middleware.AbuseGuard("coupon_validate", ""), handlers.ValidateCoupon)
 		owner.POST("/restaurants/:id/transactions", handlers.CreateTransaction)
 		owner.GET("/restaurants/:id/transactions", handlers.GetRestaurantTransactions)
+		owner.POST("/restaurants/:id/transactions/:transactionId/refund", handlers.RefundTransaction)
+		owner.GET("/restaurants/:id/refunds", handlers.GetRestaurantRefunds)
+
+		// Payouts (Stripe Connect)
+		owner.POST("/restaurants/:id/payouts/onboard", handlers.StartConnectOnboarding)
+		owner.GET("/restaurants/:id/payouts/account", handlers.GetConnectStatus)
+		owner.GET("/restaurants/:id/payouts/balance", handlers.GetRestaurantBalance)
+		owner.GET("/restaurants/:id/payouts", handlers.GetRestaurantPayouts)
+		owner.GET("/restaurants/:id/payouts/reconciliation", handlers.ReconcileTransactions)
 
 		// Bookings
 		owner.GET("/restaurants/:id/bookings", handlers.GetRestaurantBookings)
 		owner.PATCH("/bookings/:id/status", handlers.UpdateBookingStatus)
 
 		// Analytics
-		owner.GET("/restaurants/:id/analytics", handlers.GetRestaurantAnalytics)
+		owner.GET("/restaurants/:id/analytics", middleware.RequireFeature(plans.FeatureAnalytics), handlers.GetRestaurantAnalytics)
+
+		// API keys (POS integrations)
+		owner.GET("/restaurants/:id/api-keys", handlers.GetAPIKeys)
+		owner.POST("/restaurants/:id/api-keys", middleware.RequireFeature(plans.FeatureAPIAccess), handlers.CreateAPIKey)
+		owner.PATCH("/api-keys/:id", handlers.UpdateAPIKey)
+		owner.POST("/api-keys/:id/rotate", handlers.RotateAPIKey)
+		owner.DELETE("/api-keys/:id", handlers.RevokeAPIKey)
 
 		// Subscription
 		owner.POST("/subscription/checkout", handlers.CreateSubscriptionCheckout)
 		owner.GET("/subscription/status", handlers.GetSubscriptionStatus)
+		owner.GET("/subscription/plans", handlers.GetPlans)
+		owner.POST("/subscription/change-plan", handlers.ChangeSubscriptionPlan)
+		owner.POST("/subscription/cancel", handlers.CancelSubscription)
+		owner.POST("/subscription/resume", handlers.ResumeSubscription)
+		owner.POST("/subscription/portal", handlers.CreateBillingPortalSession)
 	}
 
 	// ── Admin ───────────────────────────────────────────────────────────────────
@@ -231,6 +339,11 @@ func main() {
 		admin.GET("/users", handlers.GetAllUsers)
 		admin.GET("/restaurants/pending", handlers.GetPendingRestaurants)
 		admin.PATCH("/restaurants/:id/verify", handlers.VerifyRestaurant)
+		admin.DELETE("/users/:id/sessions", handlers.RevokeUserSessions)
+		admin.GET("/webhooks", handlers.GetWebhookEvents)
+		admin.POST("/webhooks/:id/replay", handlers.ReplayWebhookEvent)
+		admin.GET("/referrals", handlers.AdminGetReferrals)
+		admin.GET("/referrals/stats", handlers.AdminGetReferralStats)
 	}
 
 	// ────────────────────────────────────────────────────────────────────────────
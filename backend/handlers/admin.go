@@ -1,11 +1,14 @@
 ﻿package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
-	"github.com/supabase-community/postgrest-go"
+	"github.com/supabase-community/postgrest-go"
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/session"
+	"finedine/backend/internal/webhooks"
 
 	"github.com/gin-gonic/gin"
 )
@@ -94,4 +97,54 @@ func VerifyRestaurant(c *gin.Context) {
 	})
 }
 
+// RevokeUserSessions - force-logout every device of a user (e.g. ban / stolen account)
+func RevokeUserSessions(c *gin.Context) {
+	targetUserID := c.Param("id")
 
+	if err := session.RevokeAllForUser(targetUserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke user sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All user sessions revoked"})
+}
+
+// GetWebhookEvents - recent Stripe webhook events, e.g. ?status=dead_letter
+func GetWebhookEvents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	events, err := webhooks.List(c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events})
+}
+
+// ReplayWebhookEvent - re-run a failed or dead-lettered event (?force=true for processed ones)
+func ReplayWebhookEvent(c *gin.Context) {
+	eventID := c.Param("id")
+	force := c.Query("force") == "true"
+
+	status, err := webhooks.Replay(eventID, force)
+	if errors.Is(err, webhooks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook event not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":  err.Error(),
+			"status": status,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  status,
+		"message": "Webhook event replayed",
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/plans"
 
 	"github.com/gin-gonic/gin"
 )
@@ -29,6 +31,19 @@ Select("id", "", false).
 		return
 	}
 
+	// Analytics depth depends on the subscription plan
+	if period != "month" && period != "year" {
+		period = "week"
+	}
+	plan := plans.ForRestaurant(restaurantID)
+	if !plan.AllowsAnalyticsPeriod(period) {
+		c.JSON(http.StatusPaymentRequired, gin.H{
+			"error":           "Your " + plan.Name + " plan does not include " + period + " analytics",
+			"allowed_periods": plan.AnalyticsPeriod,
+		})
+		return
+	}
+
 	// Calculate date range
 	now := time.Now()
 	var startDate time.Time
@@ -44,7 +59,7 @@ Select("id", "", false).
 
 	// Fetch orders
 	rawOrders, _, _ := database.Query("orders").
-Select("id, total_amount, status, created_at", "", false).
+Select("id, total, refunded_amount, status, created_at", "", false).
 		Eq("restaurant_id", restaurantID).
 		Gte("created_at", startISO).
 		Execute()
@@ -69,16 +84,20 @@ Select("id, status, booking_date, party_size", "", false).
 	}
 
 	// Aggregate order metrics
-	var totalRevenue float64
+	var totalRevenue, totalRefunded money.Amount
 	var completedOrders, cancelledOrders int
 
 	for _, o := range orders {
+		refunded := money.FromValue(o["refunded_amount"])
+		totalRefunded += refunded
+
 		if status, ok := o["status"].(string); ok {
 			switch status {
 			case "completed", "delivered":
 				completedOrders++
-				if amount, ok := o["total_amount"].(float64); ok {
-					totalRevenue += amount
+				if amount, ok := o["total"]; ok {
+					// Net of partial/full refunds
+					totalRevenue += money.FromValue(amount) - refunded
 				}
 			case "cancelled":
 				cancelledOrders++
@@ -86,10 +105,8 @@ Select("id, status, booking_date, party_size", "", false).
 		}
 	}
 
-	var avgOrder float64
-	if completedOrders > 0 {
-		avgOrder = totalRevenue / float64(completedOrders)
-	}
+	currency := restaurantCurrency(restaurantID)
+	avgOrder := totalRevenue.Div(int64(completedOrders)).Round(currency)
 
 	// Aggregate bookings
 	var confirmedBookings, cancelledBookings int
@@ -104,6 +121,13 @@ Select("id, status, booking_date, party_size", "", false).
 		}
 	}
 
+	// Food waste over the same period
+	var wasteCost money.Amount
+	wasteEntries, _ := wasteSince(restaurantID, startDate.Format("2006-01-02"))
+	for _, w := range wasteEntries {
+		wasteCost += w.TotalCost
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"data": gin.H{
 			"period":              period,
@@ -112,11 +136,16 @@ Select("id, status, booking_date, party_size", "", false).
 			"total_orders":        len(orders),
 			"completed_orders":    completedOrders,
 			"cancelled_orders":    cancelledOrders,
+			"currency":            currency,
 			"total_revenue":       totalRevenue,
+			"total_refunded":      totalRefunded,
 			"average_order_value": avgOrder,
 			"total_bookings":      len(bookings),
 			"confirmed_bookings":  confirmedBookings,
 			"cancelled_bookings":  cancelledBookings,
+			"waste_cost":          wasteCost,
+			"waste_entries":       len(wasteEntries),
+			"waste_by_reason":     bucketWaste(wasteEntries, func(e wasteEntry) string { return e.Reason }),
 			"popular_items":       []interface{}{},
 			"peak_hours":          []interface{}{},
 		},
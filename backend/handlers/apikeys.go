@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"finedine/backend/internal/apikey"
+	"finedine/backend/internal/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const apiKeyColumns = "id, restaurant_id, name, key_prefix, scopes, last_used_at, expires_at, revoked_at, created_at"
+
+// GetAPIKeys - owner lists API keys for a restaurant (never returns secrets)
+func GetAPIKeys(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	result, _, err := database.Query("api_keys").
+		Select(apiKeyColumns, "", false).
+		Eq("restaurant_id", restaurantID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":             result,
+		"available_scopes": apikey.AllScopes,
+	})
+}
+
+// CreateAPIKey - owner issues a new scoped API key; plaintext is returned once
+func CreateAPIKey(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var input struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes" binding:"required"`
+		ExpiresInDays int      `json:"expires_in_days" binding:"min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if err := apikey.ValidateScopes(input.Scopes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	plaintext, prefix, hash, err := apikey.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	row := map[string]interface{}{
+		"restaurant_id": restaurantID,
+		"owner_id":      userID,
+		"name":          input.Name,
+		"key_prefix":    prefix,
+		"key_hash":      hash,
+		"scopes":        input.Scopes,
+	}
+	if input.ExpiresInDays > 0 {
+		row["expires_at"] = time.Now().AddDate(0, 0, input.ExpiresInDays).UTC().Format(time.RFC3339)
+	}
+
+	result, _, err := database.Query("api_keys").
+		Insert(row, false, "", apiKeyColumns, "").
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":    result,
+		"api_key": plaintext,
+		"message": "API key created. Store it now; it will not be shown again.",
+	})
+}
+
+// UpdateAPIKey - owner renames a key or changes its scopes
+func UpdateAPIKey(c *gin.Context) {
+	keyID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var input struct {
+		Name   *string  `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.Name != nil {
+		updates["name"] = *input.Name
+	}
+	if input.Scopes != nil {
+		if err := apikey.ValidateScopes(input.Scopes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["scopes"] = input.Scopes
+	}
+
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid fields to update"})
+		return
+	}
+
+	hash, _, err := ownedAPIKeyHash(keyID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	result, _, err := database.Query("api_keys").
+		Update(updates, "", apiKeyColumns).
+		Eq("id", keyID).
+		Eq("owner_id", userID).
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API key"})
+		return
+	}
+
+	apikey.Forget(hash)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    result,
+		"message": "API key updated successfully",
+	})
+}
+
+// RotateAPIKey - owner replaces a key's secret; the old secret stops working immediately
+func RotateAPIKey(c *gin.Context) {
+	keyID := c.Param("id")
+	userID := c.GetString("userId")
+
+	oldHash, revoked, err := ownedAPIKeyHash(keyID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key has been revoked"})
+		return
+	}
+
+	plaintext, prefix, hash, err := apikey.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	result, _, err := database.Query("api_keys").
+		Update(map[string]interface{}{
+			"key_prefix": prefix,
+			"key_hash":   hash,
+		}, "", apiKeyColumns).
+		Eq("id", keyID).
+		Eq("owner_id", userID).
+		Is("revoked_at", "null").
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate API key"})
+		return
+	}
+
+	// The update only matches a live key; if it was revoked in between,
+	// nothing stored the new hash and the plaintext must not be handed out
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(result, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate API key"})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "API key has been revoked"})
+		return
+	}
+	delete(rows[0], "key_hash")
+
+	apikey.Forget(oldHash)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    rows[0],
+		"api_key": plaintext,
+		"message": "API key rotated. Store it now; it will not be shown again.",
+	})
+}
+
+// RevokeAPIKey - owner permanently disables a key
+func RevokeAPIKey(c *gin.Context) {
+	keyID := c.Param("id")
+	userID := c.GetString("userId")
+
+	hash, _, err := ownedAPIKeyHash(keyID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	_, _, err = database.Query("api_keys").
+		Update(map[string]interface{}{
+			"revoked_at": time.Now().UTC().Format(time.RFC3339),
+		}, "", "").
+		Eq("id", keyID).
+		Eq("owner_id", userID).
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	apikey.Forget(hash)
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+// ownedAPIKeyHash returns the stored hash of a key owned by userID and
+// whether the key has been revoked
+func ownedAPIKeyHash(keyID, userID string) (string, bool, error) {
+	result, _, err := database.Query("api_keys").
+		Select("key_hash, revoked_at", "", false).
+		Eq("id", keyID).
+		Eq("owner_id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return "", false, err
+	}
+
+	var row struct {
+		KeyHash   string  `json:"key_hash"`
+		RevokedAt *string `json:"revoked_at"`
+	}
+	if err := json.Unmarshal(result, &row); err != nil {
+		return "", false, err
+	}
+	return row.KeyHash, row.RevokedAt != nil, nil
+}
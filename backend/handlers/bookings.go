@@ -2,10 +2,12 @@
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
 	"github.com/supabase-community/postgrest-go"
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/loyalty"
 	"finedine/backend/internal/realtime"
 
 	"github.com/gin-gonic/gin"
@@ -192,6 +194,25 @@ func UpdateBookingStatus(c *gin.Context) {
 		return
 	}
 
+	// Verify the booking belongs to one of the owner's restaurants
+	found, _, err := database.Query("bookings").
+		Select("restaurant_id", "", false).
+		Eq("id", bookingID).
+		Single().
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return
+	}
+	var owned struct {
+		RestaurantID string `json:"restaurant_id"`
+	}
+	json.Unmarshal(found, &owned)
+	if err := verifyOwner(owned.RestaurantID, c.GetString("userId")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
 	result, _, err := database.Query("bookings").
 		Update(map[string]interface{}{"status": input.Status}, "", "*, customer:users(id)").
 		Eq("id", bookingID).
@@ -215,6 +236,15 @@ func UpdateBookingStatus(c *gin.Context) {
 						"status":     input.Status,
 					},
 				})
+
+				// An honoured booking earns the restaurant's booking bonus
+				if input.Status == "completed" {
+					go func() {
+						if _, err := loyalty.AwardBooking(bookingID, customerID, owned.RestaurantID); err != nil {
+							log.Printf("⚠️  Failed to award loyalty points for booking %s: %v", bookingID, err)
+						}
+					}()
+				}
 			}
 		}
 	}
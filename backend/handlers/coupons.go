@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"finedine/backend/internal/coupons"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/promo"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// couponError maps coupon engine errors onto responses
+func couponError(c *gin.Context, err error) {
+	var minOrder *coupons.MinOrderError
+	switch {
+	case errors.As(err, &minOrder):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     err.Error(),
+			"min_order": minOrder.MinOrder,
+		})
+	case errors.Is(err, coupons.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found or expired"})
+	case errors.Is(err, coupons.ErrNotYours):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, coupons.ErrUsed),
+		errors.Is(err, coupons.ErrSoldOut),
+		errors.Is(err, coupons.ErrLimitReached):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, coupons.ErrExpired),
+		errors.Is(err, coupons.ErrDealInactive),
+		errors.Is(err, coupons.ErrWrongRestaurant),
+		errors.Is(err, coupons.ErrWrongOrderType),
+		errors.Is(err, coupons.ErrOutsideSchedule),
+		errors.Is(err, coupons.ErrNotCombinable),
+		errors.Is(err, coupons.ErrNoDiscount):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process coupon"})
+	}
+}
+
+// ClaimDealCoupon - customer claims a coupon from a deal
+func ClaimDealCoupon(c *gin.Context) {
+	dealID := c.Param("id")
+	userID := c.GetString("userId")
+
+	coupon, err := coupons.Claim(dealID, userID)
+	if err != nil {
+		if errors.Is(err, coupons.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+			return
+		}
+		couponError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":    coupon,
+		"message": "Coupon claimed successfully",
+	})
+}
+
+// GetUserCoupons - the authenticated user's coupons (?status=active|used|expired)
+func GetUserCoupons(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	query := database.Query("coupons").
+		Select("*, deal:deals(id, title, restaurant_id, min_order, offer_type, valid_till)", "", false).
+		Eq("user_id", userID)
+	if status := c.Query("status"); status != "" {
+		query = query.Eq("status", status)
+	}
+
+	result, _, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch coupons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// ValidateCoupon - owner checks a coupon presented at the till and gets the
+// discount for the basket; nothing is redeemed until the transaction is recorded.
+// Items are optional and only needed for item or category rules.
+func ValidateCoupon(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var input struct {
+		Code         string         `json:"code" binding:"required"`
+		RestaurantID string         `json:"restaurant_id" binding:"required"`
+		OrderTotal   money.Amount   `json:"order_total" binding:"required,gt=0"`
+		OrderType    string         `json:"order_type" binding:"omitempty,oneof=dine_in takeaway delivery"`
+		CustomerID   string         `json:"customer_id"`
+		Items        []checkoutItem `json:"items" binding:"omitempty,dive"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if err := verifyOwner(input.RestaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	basket := coupons.Basket{
+		RestaurantID: input.RestaurantID,
+		UserID:       input.CustomerID,
+		OrderType:    input.OrderType,
+		Subtotal:     input.OrderTotal,
+		Currency:     restaurantCurrency(input.RestaurantID),
+		FirstOrder:   isFirstOrder(input.CustomerID, input.RestaurantID),
+	}
+	if len(input.Items) > 0 {
+		lines, _, err := priceOrderItems(input.RestaurantID, input.Items)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		basket.Lines = promoLines(lines)
+	}
+
+	// Priced with the live offers, as the checkout will be
+	quote, err := coupons.Check(input.Code, basket, promo.Offers(input.RestaurantID, time.Now())...)
+	if err != nil {
+		couponError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    quote,
+		"message": "Coupon is valid",
+	})
+}
+
+// redeemCoupon validates and reserves a coupon for a basket. The caller must
+// coupons.Release it if the order/transaction is not created after all.
+func redeemCoupon(c *gin.Context, code string, basket coupons.Basket, offers ...promo.Promotion) (*coupons.Quote, bool) {
+	quote, err := coupons.Check(code, basket, offers...)
+	if err != nil {
+		couponError(c, err)
+		return nil, false
+	}
+	if err := coupons.Redeem(quote.Coupon.ID, quote.Discount); err != nil {
+		couponError(c, err)
+		return nil, false
+	}
+	return quote, true
+}
+
+// releaseOrderCoupon gives back the coupon used on a cancelled order
+func releaseOrderCoupon(orderID string) {
+	if err := coupons.ReleaseForOrder(orderID); err != nil {
+		log.Printf("⚠️  Failed to release coupon for order %s: %v", orderID, err)
+	}
+}
@@ -1,18 +1,26 @@
 ﻿package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"time"
 
 	"finedine/backend/internal/cache"
 	"github.com/supabase-community/postgrest-go"
 	"finedine/backend/internal/database"
-	"finedine/backend/internal/realtime"
+	"finedine/backend/internal/deals"
+	"finedine/backend/internal/notify"
+	"finedine/backend/internal/promo"
 
 	"github.com/gin-gonic/gin"
 )
 
-// GetActiveDeals - public endpoint with Redis cache
+// dealsCacheTTL bounds how stale claim counts in the cached listing can get
+const dealsCacheTTL = 3 * time.Minute
+
+// GetActiveDeals - public endpoint with Redis cache. Deals are filtered by
+// date range, weekday and time window in each restaurant's timezone, and the
+// cache entry expires at the next boundary so nothing is listed out of hours.
 func GetActiveDeals(c *gin.Context) {
 	cacheKey := cache.DealsKey()
 
@@ -25,10 +33,8 @@ func GetActiveDeals(c *gin.Context) {
 	now := time.Now()
 
 	result, _, err := database.Query("deals").
-Select("*, restaurant:restaurants(id, name, logo_url)", "", false).
+Select("*, restaurant:restaurants(id, name, logo_url, timezone)", "", false).
 		Eq("is_active", "true").
-		Lte("valid_from", now.Format(time.RFC3339)).
-		Gte("valid_until", now.Format(time.RFC3339)).
 		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
 		Execute()
 
@@ -37,30 +43,69 @@ Select("*, restaurant:restaurants(id, name, logo_url)", "", false).
 		return
 	}
 
-	cache.Client.Set(cacheKey, result, 3*time.Minute)
+	live, next, err := deals.Live(result, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deals"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": result, "cached": false})
+	ttl := dealsCacheTTL
+	if !next.IsZero() && next.Sub(now) < ttl {
+		ttl = next.Sub(now)
+	}
+	cache.Client.Set(cacheKey, live, ttl)
+
+	c.JSON(http.StatusOK, gin.H{"data": live, "cached": false})
 }
 
+const (
+	featuredDeals    = 10
+	featuredDealPage = featuredDeals * 3
+)
+
 // GetFeaturedDeals - top deals sorted by discount
 func GetFeaturedDeals(c *gin.Context) {
 	now := time.Now()
 
-	result, _, err := database.Query("deals").
-Select("*, restaurant:restaurants(id, name, logo_url, rating)", "", false).
-		Eq("is_active", "true").
-		Lte("valid_from", now.Format(time.RFC3339)).
-		Gte("valid_until", now.Format(time.RFC3339)).
-		Order("discount_percent", &postgrest.OrderOpts{Ascending: false}).
-Limit(10, "").
-		Execute()
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch featured deals"})
-		return
+	// valid_till is a date or timestamp in the restaurant's timezone; a day
+	// of slack keeps deals ending today anywhere in the world
+	notBefore := now.UTC().AddDate(0, 0, -1).Format("2006-01-02")
+
+	// Deals outside their window are dropped after the query, so keep
+	// paging until enough are live or the table runs out
+	live := make([]json.RawMessage, 0, featuredDeals)
+	for offset := 0; len(live) < featuredDeals; offset += featuredDealPage {
+		result, _, err := database.Query("deals").
+			Select("*, restaurant:restaurants(id, name, logo_url, rating, timezone)", "", false).
+			Eq("is_active", "true").
+			Or("valid_till.is.null,valid_till.eq.,valid_till.gte."+notBefore, "").
+			Order("discount_percent", &postgrest.OrderOpts{Ascending: false}).
+			Order("id", &postgrest.OrderOpts{Ascending: true}).
+			Range(offset, offset+featuredDealPage-1, "").
+			Execute()
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch featured deals"})
+			return
+		}
+
+		page, _, err := deals.Live(result, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch featured deals"})
+			return
+		}
+		live = append(live, page...)
+
+		var rows []json.RawMessage
+		if err := json.Unmarshal(result, &rows); err != nil || len(rows) < featuredDealPage {
+			break
+		}
+	}
+	if len(live) > featuredDeals {
+		live = live[:featuredDeals]
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": result})
+	c.JSON(http.StatusOK, gin.H{"data": live})
 }
 
 // CreateDeal - owner creates a new deal
@@ -87,6 +132,15 @@ Select("id", "", false).
 		return
 	}
 
+	if err := deals.ValidateFields(input, false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := promo.ValidateFields(input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	input["restaurant_id"] = restaurantID
 
 	result, _, err := database.Query("deals").
@@ -99,7 +153,7 @@ Select("id", "", false).
 	}
 
 	cache.Client.Delete(cache.DealsKey())
-	realtime.BroadcastNewDeal(result)
+	announceOffer("deal", restaurantID, result)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"data":    result,
@@ -121,6 +175,15 @@ func UpdateDeal(c *gin.Context) {
 	delete(updates, "restaurant_id")
 	delete(updates, "id")
 
+	if err := deals.ValidateFields(updates, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := promo.ValidateFields(updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	result, _, err := database.Query("deals").
 		Update(updates, "", "").
 		Eq("id", dealID).
@@ -158,4 +221,32 @@ func DeleteDeal(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Deal deleted successfully"})
 }
 
+// announceOffer fans a newly created deal/offer out to the customers targeted
+// by notify.NewOffer, in the background; inactive ones stay quiet
+func announceOffer(kind, restaurantID string, result []byte) {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(result, &rows); err != nil || len(rows) == 0 {
+		return
+	}
+	var row struct {
+		ID          string `json:"id"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		IsActive    *bool  `json:"is_active"`
+	}
+	if err := json.Unmarshal(rows[0], &row); err != nil {
+		return
+	}
+	if row.IsActive != nil && !*row.IsActive {
+		return
+	}
 
+	go notify.NewOffer(notify.Offer{
+		RestaurantID: restaurantID,
+		Kind:         kind,
+		ID:           row.ID,
+		Title:        row.Title,
+		Description:  row.Description,
+		Payload:      rows[0],
+	})
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/giftcards"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/payments"
+	"finedine/backend/internal/realtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const (
+	giftCardHistoryLimit = 100
+	maxGiftCardUnits     = 10000 // whole currency units per card
+)
+
+// giftCardError maps gift card errors onto responses
+func giftCardError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, giftcards.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+	case errors.Is(err, giftcards.ErrInsufficient):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, giftcards.ErrWrongRestaurant),
+		errors.Is(err, giftcards.ErrInactive),
+		errors.Is(err, giftcards.ErrExpired),
+		errors.Is(err, giftcards.ErrEmpty):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process gift card"})
+	}
+}
+
+type giftCardInput struct {
+	Amount         money.Amount `json:"amount" binding:"required,gt=0"`
+	RecipientName  string       `json:"recipient_name"`
+	RecipientEmail string       `json:"recipient_email" binding:"omitempty,email"`
+	Message        string       `json:"message" binding:"max=500"`
+}
+
+func (in *giftCardInput) amount(currency string) (money.Amount, bool) {
+	amount := in.Amount.Round(currency)
+	return amount, amount > 0 && amount <= money.FromFloat(maxGiftCardUnits)
+}
+
+// PurchaseGiftCard - customer buys a restaurant's gift card. The card is
+// created pending and loaded once the PaymentIntent succeeds.
+func PurchaseGiftCard(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var input struct {
+		RestaurantID string `json:"restaurant_id" binding:"required"`
+		giftCardInput
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	currency := restaurantCurrency(input.RestaurantID)
+	amount, ok := input.amount(currency)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Gift card amount is out of range"})
+		return
+	}
+
+	card, err := giftcards.Create(giftcards.Issue{
+		RestaurantID:   input.RestaurantID,
+		Amount:         amount,
+		Currency:       currency,
+		PurchaserID:    userID,
+		RecipientName:  input.RecipientName,
+		RecipientEmail: input.RecipientEmail,
+		Message:        input.Message,
+		Pending:        true,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create gift card"})
+		return
+	}
+
+	destination, applicationFee := payoutDestination(input.RestaurantID, amount.Minor(currency))
+	intent, err := payments.Default.CreatePaymentIntent(payments.IntentParams{
+		Amount:         amount.Minor(currency),
+		Currency:       currency,
+		GiftCardID:     card.ID,
+		CustomerID:     userID,
+		RestaurantID:   input.RestaurantID,
+		IdempotencyKey: "gift-card-" + card.ID,
+
+		DestinationAccount: destination,
+		ApplicationFee:     applicationFee,
+	})
+	if err != nil {
+		// The card stays pending and can never be loaded or spent
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start payment"})
+		return
+	}
+
+	_, _, err = database.Query("payments").
+		Insert(map[string]interface{}{
+			"user_id":                    userID,
+			"amount":                     amount,
+			"currency":                   intent.Currency,
+			"type":                       "gift_card",
+			"status":                     "pending",
+			"stripe_payment_intent_id":   intent.ID,
+			"stripe_destination_account": destination,
+			"application_fee":            money.FromMinor(applicationFee, currency),
+			"metadata":                   map[string]interface{}{"gift_card_id": card.ID},
+		}, false, "", "", "").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":              card,
+		"client_secret":     intent.ClientSecret,
+		"payment_intent_id": intent.ID,
+		"message":           "Complete payment to activate the gift card.",
+	})
+}
+
+// handleGiftCardPurchased loads a gift card once its purchase is paid
+func handleGiftCardPurchased(cardID string, pi stripe.PaymentIntent) error {
+	card, err := giftcards.Activate(cardID, pi.ID)
+	if err != nil || card == nil {
+		return err
+	}
+
+	customerID := pi.Metadata["customer_id"]
+	row := map[string]interface{}{
+		"restaurant_id":   card.RestaurantID,
+		"original_amount": card.InitialBalance,
+		"discount_amount": 0,
+		"final_amount":    card.InitialBalance,
+		"currency":        card.Currency,
+		"payment_method":  "card",
+		"status":          "completed",
+		"gift_card_id":    card.ID,
+	}
+	if customerID != "" {
+		row["customer_id"] = customerID
+	}
+	if pi.LatestCharge != nil {
+		row["stripe_charge_id"] = pi.LatestCharge.ID
+		row["application_fee"] = money.FromMinor(pi.ApplicationFeeAmount, string(pi.Currency))
+	}
+	if _, _, err := database.Query("transactions").
+		Insert(row, false, "", "", "").
+		Execute(); err != nil {
+		log.Printf("⚠️  Failed to record gift card sale %s: %v", card.ID, err)
+	}
+
+	if customerID != "" {
+		database.Query("notifications").
+			Insert(map[string]interface{}{
+				"user_id":       customerID,
+				"restaurant_id": card.RestaurantID,
+				"title":         "Gift card ready",
+				"message":       fmt.Sprintf("Your gift card %s worth %s is ready to use", giftcards.Format(card.Code), card.InitialBalance.Format(card.Currency)),
+				"type":          "general",
+				"read":          false,
+			}, false, "", "", "").
+			Execute()
+
+		realtime.WSHub.SendToUser(customerID, realtime.RealtimeMessage{
+			Type: "gift_card_ready",
+			Payload: map[string]interface{}{
+				"gift_card_id": card.ID,
+				"balance":      card.Balance,
+				"currency":     card.Currency,
+			},
+		})
+	}
+	return nil
+}
+
+// GetUserGiftCards - gift cards the customer has bought
+func GetUserGiftCards(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	result, _, err := database.Query("gift_cards").
+		Select("*, restaurant:restaurants(id, name)", "", false).
+		Eq("purchaser_id", userID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift cards"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// CheckGiftCardBalance - anyone holding a code can see what is left on it
+func CheckGiftCardBalance(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	card, err := giftcards.Lookup(input.Code)
+	if err != nil {
+		giftCardError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"code":          giftcards.Mask(card.Code),
+		"restaurant_id": card.RestaurantID,
+		"balance":       card.Balance,
+		"currency":      card.Currency,
+		"status":        card.Status,
+		"expires_at":    card.ExpiresAt,
+	}})
+}
+
+// IssueGiftCard - owner sells a gift card at the counter; it is loaded at once
+func IssueGiftCard(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var input struct {
+		giftCardInput
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	currency := restaurantCurrency(restaurantID)
+	amount, ok := input.amount(currency)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Gift card amount is out of range"})
+		return
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	card, err := giftcards.Create(giftcards.Issue{
+		RestaurantID:   restaurantID,
+		Amount:         amount,
+		Currency:       currency,
+		RecipientName:  input.RecipientName,
+		RecipientEmail: input.RecipientEmail,
+		Message:        input.Message,
+		ExpiresAt:      input.ExpiresAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue gift card"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":    card,
+		"message": "Gift card issued",
+	})
+}
+
+// GetRestaurantGiftCards - owner lists the restaurant's gift cards
+func GetRestaurantGiftCards(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	query := database.Query("gift_cards").
+		Select("*", "", false).
+		Eq("restaurant_id", restaurantID)
+	if status := c.Query("status"); status != "" {
+		query = query.Eq("status", status)
+	}
+
+	result, _, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift cards"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// ownedGiftCard loads one of the owner's gift cards
+func ownedGiftCard(c *gin.Context) (*giftcards.Card, bool) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+
+	card, err := giftcards.Get(c.Param("cardId"))
+	if err != nil || card.RestaurantID != restaurantID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return nil, false
+	}
+	return card, true
+}
+
+// GetGiftCardLedger - owner views a card with its loads and redemptions
+func GetGiftCardLedger(c *gin.Context) {
+	card, ok := ownedGiftCard(c)
+	if !ok {
+		return
+	}
+
+	entries, err := giftcards.History(card.ID, giftCardHistoryLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch gift card ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"card":   card,
+		"ledger": entries,
+	}})
+}
+
+// UpdateGiftCardStatus - owner disables (e.g. reported stolen) or re-enables a card
+func UpdateGiftCardStatus(c *gin.Context) {
+	var input struct {
+		Status string `json:"status" binding:"required,oneof=active disabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status value"})
+		return
+	}
+
+	card, ok := ownedGiftCard(c)
+	if !ok {
+		return
+	}
+	if card.Status == "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": "This gift card hasn't been paid for yet"})
+		return
+	}
+
+	if err := giftcards.SetStatus(card.ID, input.Status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update gift card"})
+		return
+	}
+	card.Status = input.Status
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    card,
+		"message": "Gift card updated",
+	})
+}
+
+// redeemGiftCard takes up to amount off a gift card. The entry comes back
+// posted, so callers must giftcards.Reverse it if the order or transaction
+// is not created after all.
+func redeemGiftCard(c *gin.Context, code, restaurantID string, amount money.Amount, currency string) (*giftcards.Entry, money.Amount, bool) {
+	if code == "" || amount <= 0 {
+		return nil, 0, true
+	}
+
+	entry, taken, err := giftcards.Redeem(code, restaurantID, amount, currency)
+	if err != nil {
+		giftCardError(c, err)
+		return nil, 0, false
+	}
+	return entry, taken, true
+}
+
+// reverseOrderGiftCards puts back what a cancelled order took from gift cards
+func reverseOrderGiftCards(orderID string) {
+	if err := giftcards.ReverseForOrder(orderID); err != nil {
+		log.Printf("⚠️  Failed to return gift card balance for order %s: %v", orderID, err)
+	}
+}
+
+// recordGiftCardTransactions books each gift card tender of a paid order
+// as its own transaction, linked from the card's ledger
+func recordGiftCardTransactions(restaurantID, customerID, orderID string) {
+	entries, err := giftcards.ForOrder(orderID)
+	if err != nil || len(entries) == 0 {
+		return
+	}
+
+	for _, held := range giftcards.Outstanding(entries) {
+		if held.TransactionID != nil {
+			continue
+		}
+		amount := -held.Amount
+		row := map[string]interface{}{
+			"restaurant_id":    restaurantID,
+			"order_id":         orderID,
+			"original_amount":  amount,
+			"discount_amount":  0,
+			"final_amount":     amount,
+			"gift_card_id":     held.GiftCardID,
+			"gift_card_amount": amount,
+			"currency":         restaurantCurrency(restaurantID),
+			"payment_method":   "gift_card",
+			"status":           "completed",
+		}
+		if customerID != "" {
+			row["customer_id"] = customerID
+		}
+
+		result, _, err := database.Query("transactions").
+			Insert(row, false, "", "", "").
+			Execute()
+		if err != nil {
+			log.Printf("⚠️  Failed to record gift card transaction for order %s: %v", orderID, err)
+			continue
+		}
+		var txs []struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(result, &txs) == nil && len(txs) > 0 {
+			giftcards.LinkTransaction(held.ID, txs[0].ID)
+		}
+	}
+}
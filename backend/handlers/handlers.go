@@ -1,7 +1,7 @@
 ﻿package handlers
 
 // This file contains handlers that do not belong to a more specific file:
-// - Employees, Shifts, Offers, Coupons, Transactions, Auth stubs
+// - Employees, Shifts, Offers, Transactions, Auth stubs
 //
 // Handler architecture: package-level functions (NOT struct methods).
 // Do NOT reintroduce the struct-based Handler pattern from
@@ -12,8 +12,8 @@
 //   restaurants.go    â†’ GetRestaurants, GetRestaurantByID, GetNearbyRestaurants,
 //                       GetRestaurantMenu, SearchRestaurants, CreateRestaurant,
 //                       UpdateRestaurant, AddMenuItem, UpdateMenuItem, DeleteMenuItem
-//   orders.go         â†’ CreateOrder, GetUserOrders, GetOrderByID, CancelOrder,
-//                       GetRestaurantOrders, UpdateOrderStatus
+//   orders.go         â†’ GetUserOrders, GetOrderByID, CancelOrder,
+//                       GetRestaurantOrders, CreateRestaurantOrder, UpdateOrderStatus
 //   bookings.go       â†’ CreateBooking, GetUserBookings, GetBookingByID,
 //                       CancelBooking, GetRestaurantBookings, UpdateBookingStatus
 //   profile.go        â†’ GetProfile, UpdateProfile
@@ -24,17 +24,55 @@
 //                       UpdateDeal, DeleteDeal
 //   inventory.go      â†’ GetInventory, AddInventoryItem, UpdateInventoryItem,
 //                       DeleteInventoryItem
+//   stock.go          â†’ RecordStockMovement, GetStockMovements,
+//                       GetRestaurantStockMovements, GetStockReconciliation,
+//                       ReconcileStock
+//   recipes.go        â†’ GetMenuItemRecipe, UpdateMenuItemRecipe
+//   waste.go          â†’ GetFoodWaste, CreateFoodWaste, UpdateFoodWaste,
+//                       DeleteFoodWaste, GetFoodWasteReport
+//   purchasing.go     â†’ GetSuppliers, CreateSupplier, UpdateSupplier, DeleteSupplier,
+//                       GetPurchaseOrders, GetPurchaseOrder, GetPurchaseSuggestions,
+//                       GeneratePurchaseOrders, CreatePurchaseOrder,
+//                       UpdatePurchaseOrder, SendPurchaseOrder,
+//                       ReceivePurchaseOrder, DeletePurchaseOrder
 //   analytics.go      â†’ GetRestaurantAnalytics
-//   admin.go          â†’ GetAllUsers, GetPendingRestaurants, VerifyRestaurant
+//   admin.go          â†’ GetAllUsers, GetPendingRestaurants, VerifyRestaurant,
+//                       RevokeUserSessions, GetWebhookEvents, ReplayWebhookEvent
 //   subscription.go   â†’ CreateSubscriptionCheckout, GetSubscriptionStatus,
+//                       GetPlans, ChangeSubscriptionPlan, CancelSubscription,
+//                       ResumeSubscription, CreateBillingPortalSession,
 //                       StripeWebhook
+//   sessions.go       â†’ GetSessions, RevokeSession, RevokeAllSessions
+//   apikeys.go        â†’ GetAPIKeys, CreateAPIKey, UpdateAPIKey, RotateAPIKey,
+//                       RevokeAPIKey
+//   payments.go       â†’ CheckoutOrder, GetOrderPayment (+ PaymentIntent webhook handlers)
+//   refunds.go        â†’ RefundOrder, RefundTransaction, GetRestaurantRefunds
+//   payouts.go        â†’ StartConnectOnboarding, GetConnectStatus, GetRestaurantBalance,
+//                       GetRestaurantPayouts, ReconcileTransactions
+//   splits.go         â†’ CreateOrderSplit, GetOrderSplits, PayOrderSplit
+//   tips.go           â†’ GetTipDistribution
+//   receipts.go       â†’ GetTaxRules, UpdateTaxRules, GetOrderReceipt
+//   coupons.go        â†’ ClaimDealCoupon, GetUserCoupons, ValidateCoupon
+//   promotions.go     â†’ priceBasket and basket helpers (no routes)
+//   loyalty.go        â†’ GetLoyalty, GetLoyaltyRules, UpdateLoyaltyRules
+//   giftcards.go      â†’ PurchaseGiftCard, GetUserGiftCards, CheckGiftCardBalance,
+//                       IssueGiftCard, GetRestaurantGiftCards, GetGiftCardLedger,
+//                       UpdateGiftCardStatus
+//   referrals.go      â†’ GetReferrals, ApplyReferralCode, AdminGetReferrals,
+//                       AdminGetReferralStats
 //   handlers.go (this file) â†’ everything else listed below
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/supabase-community/postgrest-go"
+	"finedine/backend/internal/coupons"
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/deals"
+	"finedine/backend/internal/giftcards"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/promo"
 
 	"github.com/gin-gonic/gin"
 )
@@ -81,11 +119,11 @@ func CreateEmployee(c *gin.Context) {
 	userID := c.GetString("userId")
 
 	var input struct {
-		Name       string  `json:"name" binding:"required"`
-		Role       string  `json:"role" binding:"required"`
-		Phone      string  `json:"phone"`
-		Email      string  `json:"email"`
-		HourlyRate float64 `json:"hourly_rate"`
+		Name       string       `json:"name" binding:"required"`
+		Role       string       `json:"role" binding:"required"`
+		Phone      string       `json:"phone"`
+		Email      string       `json:"email"`
+		HourlyRate money.Amount `json:"hourly_rate" binding:"min=0"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -316,7 +354,35 @@ Select("*", "", false).
 	c.JSON(http.StatusOK, gin.H{"data": result})
 }
 
-// CreateOffer - owner creates an offer and notifies favorited users
+// validateOfferFields checks the promotion rules and schedule in an offer body
+func validateOfferFields(fields map[string]interface{}, partial bool) error {
+	if err := promo.ValidateFields(fields); err != nil {
+		return err
+	}
+	return deals.ValidateFields(fields, partial)
+}
+
+// offerRestaurant returns the restaurant an offer belongs to
+func offerRestaurant(offerID string) (string, error) {
+	result, _, err := database.Query("offers").
+		Select("restaurant_id", "", false).
+		Eq("id", offerID).
+		Single().
+		Execute()
+	if err != nil {
+		return "", err
+	}
+	var row struct {
+		RestaurantID string `json:"restaurant_id"`
+	}
+	if err := json.Unmarshal(result, &row); err != nil {
+		return "", err
+	}
+	return row.RestaurantID, nil
+}
+
+// CreateOffer - owner creates an automatic promotion (applied at checkout
+// without a coupon) and notifies favorited users
 func CreateOffer(c *gin.Context) {
 	restaurantID := c.Param("id")
 	userID := c.GetString("userId")
@@ -327,6 +393,11 @@ func CreateOffer(c *gin.Context) {
 		return
 	}
 
+	if err := validateOfferFields(input, false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	_, _, err := database.Query("restaurants").
 Select("id", "", false).
 		Eq("id", restaurantID).
@@ -349,6 +420,8 @@ Select("id", "", false).
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create offer"})
 		return
 	}
+	promo.ForgetOffers(restaurantID)
+	announceOffer("offer", restaurantID, result)
 
 	c.JSON(http.StatusCreated, gin.H{
 		"data":    result,
@@ -359,6 +432,7 @@ Select("id", "", false).
 // UpdateOffer - owner updates an existing offer
 func UpdateOffer(c *gin.Context) {
 	offerID := c.Param("id")
+	userID := c.GetString("userId")
 
 	var updates map[string]interface{}
 	if err := c.ShouldBindJSON(&updates); err != nil {
@@ -369,6 +443,22 @@ func UpdateOffer(c *gin.Context) {
 	delete(updates, "id")
 	delete(updates, "restaurant_id")
 
+	if err := validateOfferFields(updates, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Offers change what customers pay, so only the owner may edit them
+	restaurantID, err := offerRestaurant(offerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
 	result, _, err := database.Query("offers").
 		Update(updates, "", "*").
 		Eq("id", offerID).
@@ -378,6 +468,7 @@ func UpdateOffer(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update offer"})
 		return
 	}
+	promo.ForgetOffers(restaurantID)
 
 	c.JSON(http.StatusOK, gin.H{"data": result})
 }
@@ -385,8 +476,19 @@ func UpdateOffer(c *gin.Context) {
 // DeleteOffer - owner deletes an offer
 func DeleteOffer(c *gin.Context) {
 	offerID := c.Param("id")
+	userID := c.GetString("userId")
 
-	_, _, err := database.Query("offers").
+	restaurantID, err := offerRestaurant(offerID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	_, _, err = database.Query("offers").
 		Delete("", "").
 		Eq("id", offerID).
 		Execute()
@@ -395,46 +497,15 @@ func DeleteOffer(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete offer"})
 		return
 	}
+	promo.ForgetOffers(restaurantID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Offer deleted successfully"})
 }
 
 // â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
-// Coupon & Transaction handlers
+// Transaction handlers
 // â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€â”€
 
-// ValidateCoupon - owner or system validates a coupon code at checkout
-func ValidateCoupon(c *gin.Context) {
-	var input struct {
-		Code         string  `json:"code" binding:"required"`
-		RestaurantID string  `json:"restaurant_id" binding:"required"`
-		OrderTotal   float64 `json:"order_total" binding:"required,gt=0"`
-	}
-
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
-		return
-	}
-
-	result, _, err := database.Query("coupons").
-Select("*", "", false).
-		Eq("code", input.Code).
-		Eq("restaurant_id", input.RestaurantID).
-		Eq("is_active", "true").
-		Single().
-		Execute()
-
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found or expired"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"data":    result,
-		"message": "Coupon is valid",
-	})
-}
-
 // CreateTransaction - record a financial transaction for a restaurant
 func CreateTransaction(c *gin.Context) {
 	restaurantID := c.Param("id")
@@ -461,15 +532,92 @@ Select("id", "", false).
 
 	input["restaurant_id"] = restaurantID
 
+	// A coupon presented in-store is validated and redeemed with the
+	// transaction, on top of the restaurant's live offers
+	var quote *coupons.Quote
+	if code, _ := input["coupon_code"].(string); code != "" {
+		currency := restaurantCurrency(restaurantID)
+		original := money.FromValue(input["original_amount"]).Round(currency)
+		if original <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "original_amount is required to apply a coupon"})
+			return
+		}
+		customerID, _ := input["customer_id"].(string)
+
+		pricing, q, ok := priceBasket(c, code, coupons.Basket{
+			RestaurantID: restaurantID,
+			UserID:       customerID,
+			Subtotal:     original,
+			Currency:     currency,
+		})
+		if !ok {
+			return
+		}
+		quote = q
+		input["coupon_id"] = quote.Coupon.ID
+		input["original_amount"] = original
+		input["discount_amount"] = pricing.Discount
+		input["final_amount"] = pricing.Total
+		input["currency"] = currency
+	}
+	releaseCoupon := func() {
+		if quote != nil {
+			coupons.Release(quote.Coupon.ID)
+		}
+	}
+
+	// A gift card pays for part or all of the bill; the rest is paid by
+	// payment_method as usual
+	var giftCard *giftcards.Entry
+	if code, _ := input["gift_card_code"].(string); code != "" {
+		currency := restaurantCurrency(restaurantID)
+		bill := money.FromValue(input["final_amount"]).Round(currency)
+		if bill <= 0 {
+			releaseCoupon()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "final_amount is required to pay with a gift card"})
+			return
+		}
+
+		var paid money.Amount
+		var ok bool
+		giftCard, paid, ok = redeemGiftCard(c, code, restaurantID, bill, currency)
+		if !ok {
+			releaseCoupon()
+			return
+		}
+		if giftCard != nil {
+			input["gift_card_id"] = giftCard.GiftCardID
+			input["gift_card_amount"] = paid
+			input["currency"] = currency
+			if paid == bill {
+				input["payment_method"] = "gift_card"
+			}
+		}
+	}
+	delete(input, "gift_card_code")
+
 	result, _, err := database.Query("transactions").
 		Insert(input, false, "", "*", "").
 		Execute()
 
 	if err != nil {
+		releaseCoupon()
+		if giftCard != nil {
+			giftcards.Reverse(giftCard)
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction"})
 		return
 	}
 
+	if giftCard != nil {
+		var txs []struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(result, &txs) == nil && len(txs) > 0 {
+			giftcards.LinkTransaction(giftCard.ID, txs[0].ID)
+		}
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"data":    result,
 		"message": "Transaction recorded",
@@ -1,11 +1,14 @@
 ﻿package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
 	"finedine/backend/internal/realtime"
+	"finedine/backend/internal/stock"
 
 	"github.com/gin-gonic/gin"
 )
@@ -45,14 +48,15 @@ func AddInventoryItem(c *gin.Context) {
 	}
 
 	var input struct {
-		Name        string  `json:"name" binding:"required"`
-		Category    string  `json:"category"`
-		Quantity    float64 `json:"quantity" binding:"required,min=0"`
-		Unit        string  `json:"unit" binding:"required"`
-		MinStock    float64 `json:"min_stock"`
-		CostPerUnit float64 `json:"cost_per_unit"`
-		Supplier    string  `json:"supplier"`
-		ExpiryDate  string  `json:"expiry_date"`
+		Name        string       `json:"name" binding:"required"`
+		Category    string       `json:"category"`
+		Quantity    float64      `json:"quantity" binding:"required,min=0"`
+		Unit        string       `json:"unit" binding:"required"`
+		MinStock    float64      `json:"min_stock"`
+		CostPerUnit money.Amount `json:"cost_per_unit" binding:"min=0"`
+		Supplier    string       `json:"supplier"`
+		SupplierID  string       `json:"supplier_id"`
+		ExpiryDate  string       `json:"expiry_date"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -60,20 +64,31 @@ func AddInventoryItem(c *gin.Context) {
 		return
 	}
 
+	var supplierID interface{}
+	if input.SupplierID != "" {
+		if !supplierOf(restaurantID, input.SupplierID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier not found in this restaurant"})
+			return
+		}
+		supplierID = input.SupplierID
+	}
+
 	isLowStock := input.MinStock > 0 && input.Quantity <= input.MinStock
 
+	// The item starts empty; the opening stock goes in as its first movement
 	result, _, err := database.Query("inventory").
 		Insert(map[string]interface{}{
 			"restaurant_id": restaurantID,
 			"name":          input.Name,
 			"category":      input.Category,
-			"quantity":      input.Quantity,
+			"quantity":      0,
 			"unit":          input.Unit,
 			"min_stock":     input.MinStock,
 			"cost_per_unit": input.CostPerUnit,
 			"supplier":      input.Supplier,
+			"supplier_id":   supplierID,
 			"expiry_date":   input.ExpiryDate,
-		}, false, "", "*", "").
+		}, false, "", "", "").
 		Execute()
 
 	if err != nil {
@@ -81,14 +96,44 @@ func AddInventoryItem(c *gin.Context) {
 		return
 	}
 
+	var items []map[string]interface{}
+	if err := json.Unmarshal(result, &items); err != nil || len(items) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add inventory item"})
+		return
+	}
+	item := items[0]
+
+	if input.Quantity > 0 {
+		itemID, _ := item["id"].(string)
+		m, err := stock.Record(stock.Change{
+			InventoryID: itemID,
+			Kind:        stock.Purchase,
+			Quantity:    input.Quantity,
+			Reason:      "Opening stock",
+			UserID:      userID,
+		})
+		if err != nil {
+			database.Query("inventory").Delete("", "").Eq("id", itemID).Execute()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record opening stock"})
+			return
+		}
+		item["quantity"] = m.QuantityAfter
+		item["last_restocked"] = m.CreatedAt
+	}
+
 	if isLowStock {
 		sendLowStockAlert(userID, input.Name, input.Quantity)
+		if itemID, ok := item["id"].(string); ok {
+			stock.MarkLowStockAlerted(itemID)
+		}
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"data": result, "message": "Inventory item added successfully"})
+	c.JSON(http.StatusCreated, gin.H{"data": []map[string]interface{}{item}, "message": "Inventory item added successfully"})
 }
 
-// UpdateInventoryItem - owner updates an inventory item (ownership verified via join)
+// UpdateInventoryItem - owner updates an inventory item (ownership verified via join).
+// A new quantity is treated as a stock count and recorded as an adjustment,
+// with an optional "reason".
 func UpdateInventoryItem(c *gin.Context) {
 	itemID := c.Param("id")
 	userID := c.GetString("userId")
@@ -100,24 +145,66 @@ func UpdateInventoryItem(c *gin.Context) {
 	}
 	delete(updates, "id")
 	delete(updates, "restaurant_id")
+	delete(updates, "last_restocked") // maintained by stock movements
 
-	// Verify item belongs to a restaurant this user owns
-	_, _, err := database.Query("inventory").
-Select("restaurant_id, restaurants!inner(owner_id)", "", false).
-		Eq("id", itemID).
-		Eq("restaurants.owner_id", userID).
-		Single().
-		Execute()
+	reason, _ := updates["reason"].(string)
+	delete(updates, "reason")
+	counted, hasCount := updates["quantity"]
+	delete(updates, "quantity")
 
+	// Verify item belongs to a restaurant this user owns
+	item, err := ownedStockItem(itemID, userID)
 	if err != nil {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or item not found"})
 		return
 	}
 
-	result, _, err := database.Query("inventory").
-		Update(updates, "", "*").
-		Eq("id", itemID).
-		Execute()
+	if supplierID, ok := updates["supplier_id"].(string); ok && !supplierOf(item.RestaurantID, supplierID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier not found in this restaurant"})
+		return
+	}
+
+	if hasCount {
+		quantity, ok := counted.(float64)
+		if !ok || quantity < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be a number of at least 0"})
+			return
+		}
+		if quantity != item.Quantity {
+			if reason == "" {
+				reason = "Stock count"
+			}
+			m, err := stock.Record(stock.Change{
+				InventoryID: itemID,
+				Kind:        stock.Adjustment,
+				Quantity:    quantity,
+				Absolute:    true,
+				Reason:      reason,
+				UserID:      userID,
+			})
+			if err != nil {
+				stockError(c, err)
+				return
+			}
+			if minStock, ok := updates["min_stock"].(float64); ok {
+				item.MinStock = minStock
+			}
+			alertIfLow(userID, item, m)
+		}
+	}
+
+	var result []byte
+	if len(updates) > 0 {
+		result, _, err = database.Query("inventory").
+			Update(updates, "", "*").
+			Eq("id", itemID).
+			Execute()
+	} else {
+		result, _, err = database.Query("inventory").
+			Select("*", "", false).
+			Eq("id", itemID).
+			Execute()
+	}
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory item"})
@@ -146,7 +233,7 @@ func sendLowStockAlert(userID, itemName string, quantity float64) {
 		"user_id": userID,
 		"title":   "Low Stock Alert",
 		"message": fmt.Sprintf("%s is running low (%.2f units remaining)", itemName, quantity),
-		"type":    "general",
+		"type":    "low_stock",
 		"read":    false,
 	}
 
@@ -161,14 +248,14 @@ func sendLowStockAlert(userID, itemName string, quantity float64) {
 }
 
 
-
-func verifyOwner(restaurantID, userID string) error {
-	_, _, err := database.Query("restaurants").
-		Select("id", "", false).
-		Eq("id", restaurantID).
-		Eq("owner_id", userID).
-		Single().
-		Execute()
-	return err
+
+func verifyOwner(restaurantID, userID string) error {
+	_, _, err := database.Query("restaurants").
+		Select("id", "", false).
+		Eq("id", restaurantID).
+		Eq("owner_id", userID).
+		Single().
+		Execute()
+	return err
 }
 
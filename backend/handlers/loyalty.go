@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/loyalty"
+	"finedine/backend/internal/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+const loyaltyHistoryLimit = 50
+
+// GetLoyalty - customer's points balance, tier and ledger history
+func GetLoyalty(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	limit := loyaltyHistoryLimit
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 200 {
+		limit = n
+	}
+
+	summary, err := loyalty.ForUser(userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty points"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+// GetLoyaltyRules - owner reads the restaurant's loyalty configuration
+func GetLoyaltyRules(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": loyalty.ForRestaurant(restaurantID)})
+}
+
+// UpdateLoyaltyRules - owner replaces the restaurant's loyalty configuration.
+// Points already earned keep their value; only new orders are affected.
+func UpdateLoyaltyRules(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var rules loyalty.Rules
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if err := rules.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	_, _, err := database.Query("restaurants").
+		Update(map[string]interface{}{"loyalty_rules": rules}, "", "").
+		Eq("id", restaurantID).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save loyalty rules"})
+		return
+	}
+	loyalty.Forget(restaurantID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    rules,
+		"message": "Loyalty rules updated successfully",
+	})
+}
+
+// redeemPoints spends up to requested points against base (what is left to
+// pay after promotions). The entry comes back posted, so callers must
+// loyalty.Reverse it if the order is not created after all.
+func redeemPoints(c *gin.Context, userID, restaurantID string, requested int, base money.Amount, currency string) (*loyalty.Entry, money.Amount, bool) {
+	if requested <= 0 {
+		return nil, 0, true
+	}
+
+	rules := loyalty.ForRestaurant(restaurantID)
+	if !rules.Enabled {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": loyalty.ErrDisabled.Error()})
+		return nil, 0, false
+	}
+
+	points, value := loyalty.Quote(rules, requested, base, currency)
+	if points <= 0 {
+		return nil, 0, true
+	}
+
+	entry, err := loyalty.Redeem(userID, restaurantID, points)
+	if errors.Is(err, loyalty.ErrInsufficient) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return nil, 0, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem points"})
+		return nil, 0, false
+	}
+	return entry, value, true
+}
+
+// awardOrderPoints credits a completed order in the background
+func awardOrderPoints(orderID string) {
+	go func() {
+		if _, err := loyalty.AwardOrder(orderID); err != nil {
+			log.Printf("⚠️  Failed to award loyalty points for order %s: %v", orderID, err)
+		}
+	}()
+}
+
+// reverseOrderPoints gives back points spent on a cancelled order
+func reverseOrderPoints(orderID string) {
+	if err := loyalty.ReverseOrder(orderID); err != nil {
+		log.Printf("⚠️  Failed to return loyalty points for order %s: %v", orderID, err)
+	}
+}
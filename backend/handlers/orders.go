@@ -7,74 +7,12 @@ import (
 	"finedine/backend/internal/cache"
 	"github.com/supabase-community/postgrest-go"
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
 	"finedine/backend/internal/realtime"
 
 	"github.com/gin-gonic/gin"
 )
 
-// CreateOrder - authenticated user places an order
-func CreateOrder(c *gin.Context) {
-	userID := c.GetString("userId")
-
-	var input struct {
-		RestaurantID  string                   `json:"restaurant_id" binding:"required"`
-		OrderType     string                   `json:"order_type" binding:"required,oneof=dine_in takeaway delivery"`
-		Items         []map[string]interface{} `json:"items" binding:"required,min=1"`
-		Subtotal      float64                  `json:"subtotal" binding:"required,gt=0"`
-		Total         float64                  `json:"total" binding:"required,gt=0"`
-		CouponCode    string                   `json:"coupon_code"`
-		CustomerNotes string                   `json:"customer_notes"`
-	}
-
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
-		return
-	}
-
-	orderData := map[string]interface{}{
-		"customer_id":    userID,
-		"restaurant_id":  input.RestaurantID,
-		"order_type":     input.OrderType,
-		"items":          input.Items,
-		"subtotal":       input.Subtotal,
-		"total":          input.Total,
-		"status":         "pending",
-		"coupon_code":    input.CouponCode,
-		"customer_notes": input.CustomerNotes,
-	}
-
-	result, _, err := database.Query("orders").
-		Insert(orderData, false, "", "*, restaurant:restaurants(name, logo_url)", "").
-		Execute()
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
-		return
-	}
-
-	var orders []map[string]interface{}
-	if err := json.Unmarshal(result, &orders); err != nil || len(orders) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Order creation failed"})
-		return
-	}
-
-	order := orders[0]
-
-	// Real-time notification to restaurant owner
-	realtime.WSHub.SendToUser(input.RestaurantID, map[string]interface{}{
-		"type":    "new_order",
-		"payload": order,
-	})
-
-	// Publish to Redis for downstream services
-	cache.Client.Publish("orders:new", order)
-
-	c.JSON(http.StatusCreated, gin.H{
-		"data":    order,
-		"message": "Order created successfully",
-	})
-}
-
 // GetUserOrders - list the authenticated user's order history
 func GetUserOrders(c *gin.Context) {
 	userID := c.GetString("userId")
@@ -130,6 +68,14 @@ func CancelOrder(c *gin.Context) {
 		return
 	}
 
+	// Give back any coupon, points and gift card balance spent on the order
+	var cancelled []map[string]interface{}
+	if json.Unmarshal(result, &cancelled) == nil && len(cancelled) > 0 {
+		releaseOrderCoupon(orderID)
+		reverseOrderPoints(orderID)
+		reverseOrderGiftCards(orderID)
+	}
+
 	realtime.SendOrderUpdate(orderID, userID, "cancelled")
 
 	c.JSON(http.StatusOK, gin.H{
@@ -177,6 +123,66 @@ Select("*, customer:users(id, full_name, phone)", "", false).
 	c.JSON(http.StatusOK, gin.H{"data": result})
 }
 
+// CreateRestaurantOrder - owner or POS integration records an order placed in-store
+func CreateRestaurantOrder(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var input struct {
+		OrderType     string                   `json:"order_type" binding:"required,oneof=dine_in takeaway delivery"`
+		Items         []map[string]interface{} `json:"items" binding:"required,min=1"`
+		Subtotal      money.Amount             `json:"subtotal" binding:"required,gt=0"`
+		Total         money.Amount             `json:"total" binding:"required,gt=0"`
+		CustomerName  string                   `json:"customer_name"`
+		CustomerPhone string                   `json:"customer_phone"`
+		TableNumber   string                   `json:"table_number"`
+		ExternalRef   string                   `json:"external_ref"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	currency := restaurantCurrency(restaurantID)
+
+	orderData := map[string]interface{}{
+		"restaurant_id":  restaurantID,
+		"order_type":     input.OrderType,
+		"items":          input.Items,
+		"subtotal":       input.Subtotal.Round(currency),
+		"total":          input.Total.Round(currency),
+		"currency":       currency,
+		"status":         "accepted",
+		"customer_name":  input.CustomerName,
+		"customer_phone": input.CustomerPhone,
+		"table_number":   input.TableNumber,
+		"external_ref":   input.ExternalRef,
+		"source":         "pos",
+	}
+
+	result, _, err := database.Query("orders").
+		Insert(orderData, false, "", "", "").
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
+
+	cache.Client.Publish("orders:new", orderData)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":    result,
+		"message": "Order recorded successfully",
+	})
+}
+
 // UpdateOrderStatus - owner updates status and pushes real-time to customer
 func UpdateOrderStatus(c *gin.Context) {
 	orderID := c.Param("orderId")
@@ -184,7 +190,7 @@ func UpdateOrderStatus(c *gin.Context) {
 	userID := c.GetString("userId")
 
 	var input struct {
-		Status string `json:"status" binding:"required,oneof=pending confirmed preparing ready completed cancelled"`
+		Status string `json:"status" binding:"required,oneof=pending accepted preparing ready completed cancelled"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -205,10 +211,13 @@ Select("id", "", false).
 		return
 	}
 
+	// Completed and cancelled orders are final; only an order that is still
+	// open moves, so the side effects below run once
 	result, _, err := database.Query("orders").
 		Update(map[string]interface{}{"status": input.Status}, "", "*, customer:users(id)").
 		Eq("id", orderID).
 		Eq("restaurant_id", restaurantID).
+		In("status", []string{"pending", "accepted", "preparing", "ready"}).
 		Execute()
 
 	if err != nil {
@@ -216,18 +225,36 @@ Select("id", "", false).
 		return
 	}
 
-	// Push real-time update to customer
 	var orders []map[string]interface{}
-	if err := json.Unmarshal(result, &orders); err == nil && len(orders) > 0 {
-		order := orders[0]
-		if customer, ok := order["customer"].(map[string]interface{}); ok {
-			if customerID, ok := customer["id"].(string); ok {
-				realtime.SendOrderUpdate(orderID, customerID, input.Status)
-				cache.Client.Publish("orders:status_update", map[string]interface{}{
-					"order_id": orderID,
-					"status":   input.Status,
-				})
-			}
+	if err := json.Unmarshal(result, &orders); err != nil || len(orders) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order not found or already completed or cancelled"})
+		return
+	}
+
+	order := orders[0]
+	switch input.Status {
+	case "completed":
+		// Stock leaves the kitchen either way; points and referrals only
+		// follow a paid order
+		depleteOrderStock(order, userID)
+		if paymentStatus, _ := order["payment_status"].(string); paymentStatus == "paid" {
+			awardOrderPoints(orderID)
+			qualifyReferral(orderID)
+		}
+	case "cancelled":
+		releaseOrderCoupon(orderID)
+		reverseOrderPoints(orderID)
+		reverseOrderGiftCards(orderID)
+	}
+
+	// Push real-time update to customer
+	if customer, ok := order["customer"].(map[string]interface{}); ok {
+		if customerID, ok := customer["id"].(string); ok {
+			realtime.SendOrderUpdate(orderID, customerID, input.Status)
+			cache.Client.Publish("orders:status_update", map[string]interface{}{
+				"order_id": orderID,
+				"status":   input.Status,
+			})
 		}
 	}
 
@@ -0,0 +1,568 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/coupons"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/giftcards"
+	"finedine/backend/internal/loyalty"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/payments"
+	"finedine/backend/internal/realtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v76"
+)
+
+type checkoutItem struct {
+	MenuItemID string `json:"menu_item_id" binding:"required"`
+	Quantity   int    `json:"quantity" binding:"required,min=1"`
+	Notes      string `json:"notes"`
+}
+
+// pricedLine is an order line priced from the menu; stored in orders.items
+type pricedLine struct {
+	MenuItemID string       `json:"menu_item_id"`
+	Name       string       `json:"name"`
+	Price      money.Amount `json:"price"`
+	Category   string       `json:"category"`
+	Quantity   int          `json:"quantity"`
+	LineTotal  money.Amount `json:"line_total"`
+	Notes      string       `json:"notes"`
+}
+
+const currencyCacheTTL = 10 * time.Minute
+
+// restaurantCurrency is the currency a restaurant prices and charges in;
+// restaurants without one use the platform default
+func restaurantCurrency(restaurantID string) string {
+	key := "currency:" + restaurantID
+	var currency string
+	if err := cache.SafeGet(key, &currency); err == nil && currency != "" {
+		return currency
+	}
+
+	currency = payments.Currency()
+	result, _, err := database.Query("restaurants").
+		Select("currency", "", false).
+		Eq("id", restaurantID).
+		Single().
+		Execute()
+	if err == nil {
+		var row struct {
+			Currency string `json:"currency"`
+		}
+		if json.Unmarshal(result, &row) == nil && money.ValidCurrency(row.Currency) {
+			currency = money.Normalize(row.Currency)
+		}
+	}
+
+	cache.SafeSet(key, currency, currencyCacheTTL)
+	return currency
+}
+
+// orderCurrency is the currency an order was placed in; orders from before
+// currencies were recorded fall back to the restaurant's
+func orderCurrency(stored, restaurantID string) string {
+	if money.ValidCurrency(stored) {
+		return money.Normalize(stored)
+	}
+	return restaurantCurrency(restaurantID)
+}
+
+// priceOrderItems looks up current menu prices so the client never sets the total
+func priceOrderItems(restaurantID string, items []checkoutItem) ([]pricedLine, money.Amount, error) {
+	ids := make([]string, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.MenuItemID)
+	}
+
+	result, _, err := database.Query("menu_items").
+		Select("id, name, price, category, is_available", "", false).
+		Eq("restaurant_id", restaurantID).
+		In("id", ids).
+		Execute()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var menu []struct {
+		ID          string       `json:"id"`
+		Name        string       `json:"name"`
+		Price       money.Amount `json:"price"`
+		Category    string       `json:"category"`
+		IsAvailable bool         `json:"is_available"`
+	}
+	if err := json.Unmarshal(result, &menu); err != nil {
+		return nil, 0, err
+	}
+
+	byID := make(map[string]int, len(menu))
+	for i, m := range menu {
+		byID[m.ID] = i
+	}
+
+	var subtotal money.Amount
+	lines := make([]pricedLine, 0, len(items))
+	for _, it := range items {
+		i, ok := byID[it.MenuItemID]
+		if !ok || !menu[i].IsAvailable {
+			return nil, 0, fmt.Errorf("menu item %s is not available", it.MenuItemID)
+		}
+		m := menu[i]
+		lineTotal := m.Price.Mul(int64(it.Quantity))
+		subtotal += lineTotal
+		lines = append(lines, pricedLine{
+			MenuItemID: m.ID,
+			Name:       m.Name,
+			Price:      m.Price,
+			Category:   m.Category,
+			Quantity:   it.Quantity,
+			LineTotal:  lineTotal,
+			Notes:      it.Notes,
+		})
+	}
+
+	return lines, subtotal, nil
+}
+
+// CheckoutOrder - customer creates an order and a PaymentIntent for its server-computed total
+func CheckoutOrder(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var input struct {
+		RestaurantID  string         `json:"restaurant_id" binding:"required"`
+		OrderType     string         `json:"order_type" binding:"required,oneof=dine_in takeaway delivery"`
+		Items         []checkoutItem `json:"items" binding:"required,min=1,dive"`
+		CustomerNotes string         `json:"customer_notes"`
+		Tip           *tipInput      `json:"tip"`
+		CouponCode    string         `json:"coupon_code"`
+		RedeemPoints  int            `json:"redeem_points" binding:"gte=0"`
+		GiftCardCode  string         `json:"gift_card_code"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if err := input.Tip.checkEmployee(input.RestaurantID); err == errUnknownEmployee {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check tip employee"})
+		return
+	}
+
+	lines, subtotal, err := priceOrderItems(input.RestaurantID, input.Items)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currency := restaurantCurrency(input.RestaurantID)
+
+	// Offers and the coupon are priced together; the coupon is redeemed up
+	// front so it can't be spent on two checkouts at once, and every failure
+	// below hands it back.
+	pricing, quote, ok := priceBasket(c, input.CouponCode, coupons.Basket{
+		RestaurantID: input.RestaurantID,
+		UserID:       userID,
+		OrderType:    input.OrderType,
+		Lines:        promoLines(lines),
+		Subtotal:     subtotal,
+		Currency:     currency,
+	})
+	if !ok {
+		return
+	}
+	var couponCode interface{}
+	if quote != nil {
+		couponCode = quote.Coupon.Code
+	}
+	releaseCoupon := func() {
+		if quote != nil {
+			coupons.Release(quote.Coupon.ID)
+		}
+	}
+
+	// Points pay for part of what is left after promotions
+	redemption, pointsDiscount, ok := redeemPoints(c, userID, input.RestaurantID, input.RedeemPoints, subtotal-pricing.Discount, currency)
+	if !ok {
+		releaseCoupon()
+		return
+	}
+	var giftCard *giftcards.Entry
+	release := func() {
+		releaseCoupon()
+		if redemption != nil {
+			if err := loyalty.Reverse(redemption); err != nil {
+				log.Printf("⚠️  Failed to return %d loyalty points to %s: %v", -redemption.Points, userID, err)
+			}
+		}
+		if giftCard != nil {
+			if err := giftcards.Reverse(giftCard); err != nil {
+				log.Printf("⚠️  Failed to return %s to gift card %s: %v", -giftCard.Amount, giftCard.GiftCardID, err)
+			}
+		}
+	}
+	pointsRedeemed := 0
+	if redemption != nil {
+		pointsRedeemed = -redemption.Points
+	}
+	discount := pricing.Discount + pointsDiscount
+
+	breakdown := orderTax(input.RestaurantID, currency, lines, discount)
+	total := breakdown.Total
+	tip := input.Tip.amount(subtotal, currency)
+
+	// A gift card is a tender, not a discount: tax is unchanged and it pays
+	// for the food (never the tip) before the card is charged
+	giftCard, giftCardAmount, ok := redeemGiftCard(c, input.GiftCardCode, input.RestaurantID, total, currency)
+	if !ok {
+		release()
+		return
+	}
+	charged := total - giftCardAmount
+	amount := (charged + tip).Minor(currency)
+	paidInFull := amount == 0
+	status, paymentStatus := "awaiting_payment", "pending"
+	if paidInFull {
+		status, paymentStatus = "pending", "paid"
+	}
+	// Commission is taken on the food, never on the tip. Gift card money was
+	// already paid to the restaurant when the card was sold.
+	destination, applicationFee := payoutDestination(input.RestaurantID, charged.Minor(currency))
+
+	result, _, err := database.Query("orders").
+		Insert(map[string]interface{}{
+			"customer_id":      userID,
+			"restaurant_id":    input.RestaurantID,
+			"order_type":       input.OrderType,
+			"items":            lines,
+			"subtotal":         subtotal,
+			"discount":         discount,
+			"promotions":       pricing.Applied,
+			"coupon_code":      couponCode,
+			"points_redeemed":  pointsRedeemed,
+			"points_discount":  pointsDiscount,
+			"gift_card_amount": giftCardAmount,
+			"total":            total,
+			"tax_amount":       breakdown.Tax,
+			"service_charge":   breakdown.ServiceCharge,
+			"tax_inclusive":    breakdown.Inclusive,
+			"tax_lines":        breakdown.Lines,
+			"tip_amount":       tip,
+			"currency":         currency,
+			"status":           status,
+			"payment_status":   paymentStatus,
+			"customer_notes":   input.CustomerNotes,
+		}, false, "", "", "").
+		Execute()
+
+	if err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
+	}
+
+	var orders []map[string]interface{}
+	if err := json.Unmarshal(result, &orders); err != nil || len(orders) == 0 {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Order creation failed"})
+		return
+	}
+	order := orders[0]
+	orderID, _ := order["id"].(string)
+	if quote != nil {
+		if err := coupons.LinkOrder(quote.Coupon.ID, orderID); err != nil {
+			log.Printf("⚠️  Failed to link coupon %s to order %s: %v", quote.Coupon.ID, orderID, err)
+		}
+	}
+	if redemption != nil {
+		// A later reversal is then recorded against the order too, so
+		// cancelling it can't hand the points back twice
+		if err := loyalty.LinkOrder(redemption.ID, orderID); err != nil {
+			log.Printf("⚠️  Failed to link points redemption %s to order %s: %v", redemption.ID, orderID, err)
+		} else {
+			redemption.OrderID = &orderID
+		}
+	}
+	if giftCard != nil {
+		if err := giftcards.LinkOrder(giftCard.ID, orderID); err != nil {
+			log.Printf("⚠️  Failed to link gift card redemption %s to order %s: %v", giftCard.ID, orderID, err)
+		} else {
+			giftCard.OrderID = &orderID
+		}
+	}
+
+	if paidInFull {
+		releaseToKitchen(order)
+		c.JSON(http.StatusCreated, gin.H{
+			"data":    order,
+			"message": "Order paid with gift card and sent to the restaurant.",
+		})
+		return
+	}
+
+	intent, err := payments.Default.CreatePaymentIntent(payments.IntentParams{
+		Amount:         amount,
+		Currency:       currency,
+		OrderID:        orderID,
+		CustomerID:     userID,
+		RestaurantID:   input.RestaurantID,
+		IdempotencyKey: "order-" + orderID,
+
+		DestinationAccount: destination,
+		ApplicationFee:     applicationFee,
+	})
+	// abandon cancels the order and hands back everything it redeemed
+	abandon := func() {
+		database.Query("orders").
+			Update(map[string]interface{}{
+				"status":         "cancelled",
+				"payment_status": "failed",
+			}, "", "").
+			Eq("id", orderID).
+			Execute()
+		release()
+	}
+	if err != nil {
+		abandon()
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start payment"})
+		return
+	}
+
+	_, _, err = database.Query("payments").
+		Insert(map[string]interface{}{
+			"user_id":                    userID,
+			"order_id":                   orderID,
+			"amount":                     charged + tip,
+			"currency":                   intent.Currency,
+			"type":                       "order",
+			"status":                     "pending",
+			"stripe_payment_intent_id":   intent.ID,
+			"stripe_destination_account": destination,
+			"application_fee":            money.FromMinor(applicationFee, currency),
+		}, false, "", "", "").
+		Execute()
+
+	if err != nil {
+		// Without a payments row the webhook can't match the intent, so
+		// it must not be payable
+		if cancelErr := payments.Default.CancelPaymentIntent(intent.ID); cancelErr != nil {
+			log.Printf("⚠️  Failed to cancel PaymentIntent %s for order %s: %v", intent.ID, orderID, cancelErr)
+		}
+		abandon()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	if input.Tip != nil {
+		if err := recordPendingTip(input.Tip, tip, input.RestaurantID, orderID, "", intent.ID); err != nil {
+			log.Printf("⚠️  Failed to record tip for order %s: %v", orderID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":              order,
+		"client_secret":     intent.ClientSecret,
+		"payment_intent_id": intent.ID,
+		"message":           "Order created. Complete payment to send it to the restaurant.",
+	})
+}
+
+// GetOrderPayment - payment status for one of the user's orders
+func GetOrderPayment(c *gin.Context) {
+	orderID := c.Param("id")
+	userID := c.GetString("userId")
+
+	result, _, err := database.Query("payments").
+		Select("id, order_id, amount, currency, status, stripe_payment_intent_id, created_at", "", false).
+		Eq("order_id", orderID).
+		Eq("user_id", userID).
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// handlePaymentIntentSucceeded - mark paid and release the order to the kitchen
+func handlePaymentIntentSucceeded(pi stripe.PaymentIntent) error {
+	_, _, err := database.Query("payments").
+		Update(map[string]interface{}{"status": "completed"}, "", "").
+		Eq("stripe_payment_intent_id", pi.ID).
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	if err := markTipsPaid(pi.ID); err != nil {
+		return err
+	}
+	go recordCardFingerprint(pi)
+
+	if cardID := pi.Metadata["gift_card_id"]; cardID != "" {
+		return handleGiftCardPurchased(cardID, pi)
+	}
+
+	orderID := pi.Metadata["order_id"]
+	if orderID == "" {
+		return nil
+	}
+
+	if pi.Metadata["split_id"] != "" {
+		return handleSplitPaymentSucceeded(pi)
+	}
+
+	// Only the first awaiting_payment -> pending transition notifies the kitchen
+	result, _, err := database.Query("orders").
+		Update(map[string]interface{}{
+			"status":         "pending",
+			"payment_status": "paid",
+		}, "", "*").
+		Eq("id", orderID).
+		Eq("status", "awaiting_payment").
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	var orders []map[string]interface{}
+	if err := json.Unmarshal(result, &orders); err != nil {
+		return nil
+	}
+	if len(orders) == 0 {
+		// Already released by an earlier delivery, or cancelled while the
+		// customer was paying
+		_, err := refundIfCancelled(orderID, pi)
+		return err
+	}
+	order := orders[0]
+
+	restaurantID, _ := order["restaurant_id"].(string)
+	customerID, _ := order["customer_id"].(string)
+	if err := recordOnlineTransaction(restaurantID, customerID, orderID, money.FromMinor(pi.Amount, string(pi.Currency)), pi); err != nil {
+		log.Printf("⚠️  Failed to record transaction for order %s: %v", orderID, err)
+	}
+	releaseToKitchen(order)
+	return nil
+}
+
+// refundIfCancelled gives the money back for a payment that succeeded on an
+// order cancelled in the meantime; nothing else would ever refund it. It
+// reports whether the payment was refunded.
+func refundIfCancelled(orderID string, pi stripe.PaymentIntent) (bool, error) {
+	result, _, err := database.Query("orders").
+		Select("status", "", false).
+		Eq("id", orderID).
+		Single().
+		Execute()
+	if err != nil {
+		return false, err
+	}
+	var order struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(result, &order); err != nil || order.Status != "cancelled" {
+		return false, nil
+	}
+
+	if err := refundPayment(pi, "cancelled-order-"+pi.ID); err != nil {
+		return false, fmt.Errorf("refund payment %s for cancelled order %s: %w", pi.ID, orderID, err)
+	}
+	_, _, err = database.Query("orders").
+		Update(map[string]interface{}{"payment_status": "refunded"}, "", "").
+		Eq("id", orderID).
+		Eq("status", "cancelled").
+		Execute()
+	return true, err
+}
+
+// refundPayment refunds a succeeded PaymentIntent in full and marks its
+// payment refunded
+func refundPayment(pi stripe.PaymentIntent, idempotencyKey string) error {
+	refund, err := payments.Default.Refund(payments.RefundParams{
+		PaymentIntentID: pi.ID,
+		Reason:          "requested_by_customer",
+		IdempotencyKey:  idempotencyKey,
+		ReverseTransfer: pi.TransferData != nil,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, _, err = database.Query("payments").
+		Update(map[string]interface{}{
+			"status":          "refunded",
+			"refunded_amount": money.FromMinor(refund.Amount, string(pi.Currency)),
+		}, "", "").
+		Eq("stripe_payment_intent_id", pi.ID).
+		Execute()
+	return err
+}
+
+// releaseToKitchen finishes an order that has just been paid in full: gift
+// card tenders are booked, the invoice issued and the kitchen notified
+func releaseToKitchen(order map[string]interface{}) {
+	orderID, _ := order["id"].(string)
+	restaurantID, _ := order["restaurant_id"].(string)
+	customerID, _ := order["customer_id"].(string)
+
+	recordGiftCardTransactions(restaurantID, customerID, orderID)
+	issueInvoiceAsync(restaurantID, orderID)
+
+	if restaurantID != "" {
+		realtime.WSHub.SendToUser(restaurantID, map[string]interface{}{
+			"type":    "new_order",
+			"payload": order,
+		})
+	}
+	cache.Client.Publish("orders:new", order)
+
+	if customerID != "" {
+		realtime.SendOrderUpdate(orderID, customerID, "pending")
+	}
+}
+
+// handlePaymentIntentFailed - record the failure; the customer may retry the same intent
+func handlePaymentIntentFailed(pi stripe.PaymentIntent) error {
+	_, _, err := database.Query("payments").
+		Update(map[string]interface{}{"status": "failed"}, "", "").
+		Eq("stripe_payment_intent_id", pi.ID).
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	orderID := pi.Metadata["order_id"]
+	if orderID == "" {
+		return nil
+	}
+
+	if splitID := pi.Metadata["split_id"]; splitID != "" {
+		_, _, err = database.Query("order_splits").
+			Update(map[string]interface{}{"status": "failed"}, "", "").
+			Eq("id", splitID).
+			Neq("status", "paid").
+			Execute()
+		return err
+	}
+
+	_, _, err = database.Query("orders").
+		Update(map[string]interface{}{"payment_status": "failed"}, "", "").
+		Eq("id", orderID).
+		Eq("status", "awaiting_payment").
+		Execute()
+	return err
+}
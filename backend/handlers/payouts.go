@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v76"
+)
+
+type restaurantConnect struct {
+	ID                string   `json:"id"`
+	StripeAccountID   string   `json:"stripe_account_id"`
+	ChargesEnabled    bool     `json:"connect_charges_enabled"`
+	PayoutsEnabled    bool     `json:"connect_payouts_enabled"`
+	CommissionPercent *float64 `json:"commission_percent"`
+}
+
+func loadRestaurantConnect(restaurantID string) (*restaurantConnect, error) {
+	result, _, err := database.Query("restaurants").
+		Select("id, stripe_account_id, connect_charges_enabled, connect_payouts_enabled, commission_percent", "", false).
+		Eq("id", restaurantID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var rc restaurantConnect
+	if err := json.Unmarshal(result, &rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// payoutDestination returns the connected account and platform fee for a
+// charge of amount, or "" when the restaurant can't receive funds yet
+// (the platform then keeps the charge and settles manually).
+func payoutDestination(restaurantID string, amount int64) (string, int64) {
+	rc, err := loadRestaurantConnect(restaurantID)
+	if err != nil || rc.StripeAccountID == "" || !rc.ChargesEnabled {
+		return "", 0
+	}
+
+	override := -1.0
+	if rc.CommissionPercent != nil {
+		override = *rc.CommissionPercent
+	}
+	return rc.StripeAccountID, payments.ApplicationFee(amount, payments.CommissionPercent(override))
+}
+
+// ownedConnectAccount loads the restaurant and requires a connected account
+func ownedConnectAccount(c *gin.Context) (*restaurantConnect, bool) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+
+	rc, err := loadRestaurantConnect(restaurantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+		return nil, false
+	}
+	if rc.StripeAccountID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payouts are not set up for this restaurant"})
+		return nil, false
+	}
+	return rc, true
+}
+
+// StartConnectOnboarding - create (once) the restaurant's connected account
+// and return a Stripe-hosted onboarding link
+func StartConnectOnboarding(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	rc, err := loadRestaurantConnect(restaurantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Restaurant not found"})
+		return
+	}
+
+	accountID := rc.StripeAccountID
+	if accountID == "" {
+		accountID, err = payments.Default.CreateConnectedAccount(c.GetString("email"), restaurantID)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create payout account"})
+			return
+		}
+
+		_, _, err = database.Query("restaurants").
+			Update(map[string]interface{}{"stripe_account_id": accountID}, "", "").
+			Eq("id", restaurantID).
+			Execute()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payout account"})
+			return
+		}
+	}
+
+	base := frontendBaseURL() + "/restaurants/" + restaurantID + "/payouts"
+	url, err := payments.Default.CreateOnboardingLink(accountID, base+"?refresh=1", base+"?onboarded=1")
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create onboarding link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"account_id": accountID,
+		"url":        url,
+	})
+}
+
+// GetConnectStatus - onboarding / capability state of the connected account
+func GetConnectStatus(c *gin.Context) {
+	rc, ok := ownedConnectAccount(c)
+	if !ok {
+		return
+	}
+
+	acct, err := payments.Default.GetConnectedAccount(rc.StripeAccountID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch payout account"})
+		return
+	}
+
+	if acct.ChargesEnabled != rc.ChargesEnabled || acct.PayoutsEnabled != rc.PayoutsEnabled {
+		syncConnectFlags(acct.ID, acct.ChargesEnabled, acct.PayoutsEnabled)
+	}
+
+	override := -1.0
+	if rc.CommissionPercent != nil {
+		override = *rc.CommissionPercent
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":               acct,
+		"commission_percent": payments.CommissionPercent(override),
+	})
+}
+
+// GetRestaurantBalance - available and pending funds on the connected account
+func GetRestaurantBalance(c *gin.Context) {
+	rc, ok := ownedConnectAccount(c)
+	if !ok {
+		return
+	}
+
+	bal, err := payments.Default.GetBalance(rc.StripeAccountID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": bal})
+}
+
+// GetRestaurantPayouts - recent payouts to the restaurant's bank account
+func GetRestaurantPayouts(c *gin.Context) {
+	rc, ok := ownedConnectAccount(c)
+	if !ok {
+		return
+	}
+
+	list, err := payments.Default.ListPayouts(rc.StripeAccountID, 50)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch payouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// ReconcileTransactions - compare recorded online transactions with the
+// connected account's Stripe balance transactions (?from=&to= RFC3339)
+func ReconcileTransactions(c *gin.Context) {
+	rc, ok := ownedConnectAccount(c)
+	if !ok {
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -30)
+	if raw := c.Query("from"); raw != "" {
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			from = t
+		}
+	}
+	if raw := c.Query("to"); raw != "" {
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			to = t
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	result, _, err := database.Query("transactions").
+		Select("id, order_id, final_amount, application_fee, stripe_charge_id, currency, created_at", "", false).
+		Eq("restaurant_id", rc.ID).
+		Not("stripe_charge_id", "is", "null").
+		Gte("created_at", from.Format(time.RFC3339)).
+		Lt("created_at", to.Format(time.RFC3339)).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transactions"})
+		return
+	}
+
+	var local []struct {
+		ID             string       `json:"id"`
+		OrderID        string       `json:"order_id"`
+		FinalAmount    money.Amount `json:"final_amount"`
+		ApplicationFee money.Amount `json:"application_fee"`
+		ChargeID       string       `json:"stripe_charge_id"`
+		Currency       string       `json:"currency"`
+	}
+	if err := json.Unmarshal(result, &local); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read transactions"})
+		return
+	}
+
+	remote, err := payments.Default.ListBalanceTransactions(rc.StripeAccountID, from, to)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch Stripe balance transactions"})
+		return
+	}
+
+	byCharge := make(map[string]payments.BalanceTransaction, len(remote))
+	for _, bt := range remote {
+		if bt.Type == "payment" && bt.ChargeID != "" {
+			byCharge[bt.ChargeID] = bt
+		}
+	}
+
+	matched := 0
+	mismatched := []gin.H{}
+	missingInStripe := []gin.H{}
+	for _, tx := range local {
+		bt, found := byCharge[tx.ChargeID]
+		if !found {
+			missingInStripe = append(missingInStripe, gin.H{"transaction_id": tx.ID, "charge_id": tx.ChargeID})
+			continue
+		}
+		delete(byCharge, tx.ChargeID)
+
+		// The restaurant receives the charge minus the platform commission
+		expected := (tx.FinalAmount - tx.ApplicationFee).Minor(orderCurrency(tx.Currency, rc.ID))
+		if bt.Amount != expected {
+			mismatched = append(mismatched, gin.H{
+				"transaction_id":         tx.ID,
+				"charge_id":              tx.ChargeID,
+				"expected_amount":        expected,
+				"stripe_amount":          bt.Amount,
+				"balance_transaction_id": bt.ID,
+			})
+			continue
+		}
+		matched++
+	}
+
+	missingLocally := []payments.BalanceTransaction{}
+	for _, bt := range byCharge {
+		missingLocally = append(missingLocally, bt)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"from":              from,
+			"to":                to,
+			"matched":           matched,
+			"mismatched":        mismatched,
+			"missing_in_stripe": missingInStripe,
+			"missing_locally":   missingLocally,
+		},
+	})
+}
+
+// recordOnlineTransaction mirrors a paid destination charge into
+// `transactions` so it can be reconciled against Stripe
+func recordOnlineTransaction(restaurantID, customerID, orderID string, amount money.Amount, pi stripe.PaymentIntent) error {
+	if pi.TransferData == nil || pi.LatestCharge == nil {
+		return nil
+	}
+
+	row := map[string]interface{}{
+		"restaurant_id":    restaurantID,
+		"order_id":         orderID,
+		"original_amount":  amount,
+		"discount_amount":  0,
+		"final_amount":     amount,
+		"application_fee":  money.FromMinor(pi.ApplicationFeeAmount, string(pi.Currency)),
+		"currency":         money.Normalize(string(pi.Currency)),
+		"payment_method":   "card",
+		"status":           "completed",
+		"stripe_charge_id": pi.LatestCharge.ID,
+	}
+	if customerID != "" {
+		row["customer_id"] = customerID
+	}
+
+	_, _, err := database.Query("transactions").
+		Insert(row, false, "", "", "").
+		Execute()
+	return err
+}
+
+// handleAccountUpdated keeps connect capability flags in sync
+func handleAccountUpdated(event stripe.Event) error {
+	var acct stripe.Account
+	if err := json.Unmarshal(event.Data.Raw, &acct); err != nil {
+		return err
+	}
+	return syncConnectFlags(acct.ID, acct.ChargesEnabled, acct.PayoutsEnabled)
+}
+
+func syncConnectFlags(accountID string, chargesEnabled, payoutsEnabled bool) error {
+	_, _, err := database.Query("restaurants").
+		Update(map[string]interface{}{
+			"connect_charges_enabled": chargesEnabled,
+			"connect_payouts_enabled": payoutsEnabled,
+		}, "", "").
+		Eq("stripe_account_id", accountID).
+		Execute()
+	return err
+}
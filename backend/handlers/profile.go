@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/notify"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,7 +14,7 @@ func GetProfile(c *gin.Context) {
 	userID := c.GetString("userId")
 
 	result, _, err := database.Query("users").
-Select("id, email, full_name, phone, address, role, points, favorites, cuisine_preferences, restaurant_id, created_at", "", false).
+Select("id, email, full_name, phone, address, role, points, favorites, cuisine_preferences, latitude, longitude, notification_preferences, restaurant_id, created_at", "", false).
 		Eq("id", userID).
 		Single().
 		Execute()
@@ -47,8 +48,15 @@ func UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	if prefs, ok := updates["notification_preferences"]; ok && prefs != nil {
+		if err := notify.ValidatePreferences(prefs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	result, _, err := database.Query("users").
-		Update(updates, "", "id, email, full_name, phone, address, role, points, favorites, cuisine_preferences, restaurant_id").
+		Update(updates, "", "id, email, full_name, phone, address, role, points, favorites, cuisine_preferences, latitude, longitude, notification_preferences, restaurant_id").
 		Eq("id", userID).
 		Execute()
 
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"time"
+
+	"finedine/backend/internal/coupons"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/promo"
+
+	"github.com/gin-gonic/gin"
+)
+
+// promoLines turns menu-priced order lines into promo engine lines
+func promoLines(lines []pricedLine) []promo.Line {
+	out := make([]promo.Line, 0, len(lines))
+	for _, l := range lines {
+		out = append(out, promo.Line{
+			ItemID:    l.MenuItemID,
+			Category:  l.Category,
+			Quantity:  l.Quantity,
+			UnitPrice: l.Price,
+		})
+	}
+	return out
+}
+
+// isFirstOrder reports whether the customer has never ordered from the
+// restaurant; abandoned checkouts and cancelled orders don't count
+func isFirstOrder(userID, restaurantID string) bool {
+	if userID == "" {
+		return false
+	}
+	_, count, err := database.Query("orders").
+		Select("id", "exact", true).
+		Eq("customer_id", userID).
+		Eq("restaurant_id", restaurantID).
+		Not("status", "in", "(awaiting_payment,cancelled,rejected)").
+		Execute()
+	return err == nil && count == 0
+}
+
+// priceBasket applies the restaurant's live offers and, if a code is given,
+// the coupon to a basket. This is the single pricing path for orders; a
+// coupon comes back redeemed, so callers must coupons.Release it (quote !=
+// nil) if the order is not created after all.
+func priceBasket(c *gin.Context, code string, basket coupons.Basket) (promo.Result, *coupons.Quote, bool) {
+	offers := promo.Offers(basket.RestaurantID, time.Now())
+	if code != "" || promo.UsesFirstOrder(offers) {
+		basket.FirstOrder = isFirstOrder(basket.UserID, basket.RestaurantID)
+	}
+
+	if code == "" {
+		return promo.Apply(basket.Promo(), offers...), nil, true
+	}
+
+	quote, ok := redeemCoupon(c, code, basket, offers...)
+	if !ok {
+		return promo.Result{}, nil, false
+	}
+	return promo.Result{
+		Subtotal: basket.Subtotal,
+		Discount: basket.Subtotal - quote.Total,
+		Total:    quote.Total,
+		Applied:  quote.Applied,
+	}, quote, true
+}
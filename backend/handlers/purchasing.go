@@ -0,0 +1,492 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/purchasing"
+
+	"github.com/gin-gonic/gin"
+)
+
+/* ---- SUPPLIERS ---- */
+
+// supplierOf reports whether supplierID is one of the restaurant's suppliers
+func supplierOf(restaurantID, supplierID string) bool {
+	_, _, err := database.Query("suppliers").
+		Select("id", "", false).
+		Eq("id", supplierID).
+		Eq("restaurant_id", restaurantID).
+		Single().
+		Execute()
+	return err == nil
+}
+
+// GetSuppliers - owner lists a restaurant's suppliers
+func GetSuppliers(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	result, _, err := database.Query("suppliers").
+		Select("*", "", false).
+		Eq("restaurant_id", restaurantID).
+		Order("name", nil).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suppliers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// CreateSupplier - owner adds a supplier
+func CreateSupplier(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var input struct {
+		Name         string `json:"name" binding:"required"`
+		ContactName  string `json:"contact_name"`
+		Email        string `json:"email" binding:"omitempty,email"`
+		Phone        string `json:"phone"`
+		Address      string `json:"address"`
+		LeadTimeDays *int   `json:"lead_time_days" binding:"omitempty,min=0"`
+		Notes        string `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	result, _, err := database.Query("suppliers").
+		Insert(map[string]interface{}{
+			"restaurant_id":  restaurantID,
+			"name":           input.Name,
+			"contact_name":   input.ContactName,
+			"email":          input.Email,
+			"phone":          input.Phone,
+			"address":        input.Address,
+			"lead_time_days": input.LeadTimeDays,
+			"notes":          input.Notes,
+		}, false, "", "", "").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add supplier"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": result, "message": "Supplier added successfully"})
+}
+
+// ownedSupplier verifies the supplier belongs to a restaurant the user owns
+func ownedSupplier(supplierID, userID string) bool {
+	_, _, err := database.Query("suppliers").
+		Select("id, restaurants!inner(owner_id)", "", false).
+		Eq("id", supplierID).
+		Eq("restaurants.owner_id", userID).
+		Single().
+		Execute()
+	return err == nil
+}
+
+// UpdateSupplier - owner updates a supplier
+func UpdateSupplier(c *gin.Context) {
+	supplierID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var updates map[string]interface{}
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	delete(updates, "id")
+	delete(updates, "restaurant_id")
+
+	if !ownedSupplier(supplierID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or supplier not found"})
+		return
+	}
+
+	result, _, err := database.Query("suppliers").
+		Update(updates, "", "*").
+		Eq("id", supplierID).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update supplier"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result, "message": "Supplier updated successfully"})
+}
+
+// DeleteSupplier - owner removes a supplier; its items and orders keep
+// working without one
+func DeleteSupplier(c *gin.Context) {
+	supplierID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if !ownedSupplier(supplierID, userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or supplier not found"})
+		return
+	}
+
+	database.Query("inventory").
+		Update(map[string]interface{}{"supplier_id": nil}, "", "").
+		Eq("supplier_id", supplierID).
+		Execute()
+
+	if _, _, err := database.Query("suppliers").Delete("", "").Eq("id", supplierID).Execute(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete supplier"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Supplier deleted successfully"})
+}
+
+/* ---- PURCHASE ORDERS ---- */
+
+func purchaseOrderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, purchasing.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, purchasing.ErrNotDraft),
+		errors.Is(err, purchasing.ErrNotReceivable),
+		errors.Is(err, purchasing.ErrReceiveRace):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, purchasing.ErrOverReceived),
+		errors.Is(err, purchasing.ErrUnknownLine),
+		errors.Is(err, purchasing.ErrNothingToDo):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process purchase order"})
+	}
+}
+
+// ownedPurchaseOrder loads a purchase order of a restaurant the user owns
+func ownedPurchaseOrder(c *gin.Context) (*purchasing.Order, bool) {
+	po, err := purchasing.Get(c.Param("id"))
+	if err != nil {
+		purchaseOrderError(c, err)
+		return nil, false
+	}
+	if err := verifyOwner(po.RestaurantID, c.GetString("userId")); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return nil, false
+	}
+	return po, true
+}
+
+// checkOrderLines verifies every line's item belongs to the restaurant, and
+// fills in missing unit costs from the item's cost_per_unit
+func checkOrderLines(c *gin.Context, restaurantID string, lines []purchasing.NewLine) bool {
+	ids := make([]string, 0, len(lines))
+	for _, l := range lines {
+		ids = append(ids, l.InventoryID)
+	}
+	result, _, err := database.Query("inventory").
+		Select("id, cost_per_unit", "", false).
+		Eq("restaurant_id", restaurantID).
+		In("id", ids).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load inventory"})
+		return false
+	}
+	var items []struct {
+		ID          string       `json:"id"`
+		CostPerUnit money.Amount `json:"cost_per_unit"`
+	}
+	json.Unmarshal(result, &items)
+	byID := map[string]money.Amount{}
+	for _, it := range items {
+		byID[it.ID] = it.CostPerUnit
+	}
+
+	for i, l := range lines {
+		cost, ok := byID[l.InventoryID]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Inventory item " + l.InventoryID + " not found in this restaurant"})
+			return false
+		}
+		if l.UnitCost.IsZero() {
+			lines[i].UnitCost = cost
+		}
+	}
+	return true
+}
+
+// GetPurchaseOrders - owner lists purchase orders, optionally by ?status=
+func GetPurchaseOrders(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	orders, err := purchasing.List(restaurantID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": orders})
+}
+
+// GetPurchaseOrder - owner views one purchase order with its lines
+func GetPurchaseOrder(c *gin.Context) {
+	po, ok := ownedPurchaseOrder(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": po})
+}
+
+// GetPurchaseSuggestions - owner sees which low items to reorder and how
+// much, optionally for one ?supplier_id=
+func GetPurchaseSuggestions(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	suggestions, err := purchasing.Suggest(restaurantID, c.Query("supplier_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build suggestions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": suggestions})
+}
+
+// GeneratePurchaseOrders - owner turns the suggestions into draft orders,
+// one per supplier (optionally only for supplier_id)
+func GeneratePurchaseOrders(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var input struct {
+		SupplierID string `json:"supplier_id"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+			return
+		}
+	}
+	if input.SupplierID != "" && !supplierOf(restaurantID, input.SupplierID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier not found in this restaurant"})
+		return
+	}
+
+	drafts, err := purchasing.Generate(restaurantID, input.SupplierID, userID, restaurantCurrency(restaurantID))
+	if err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": drafts, "message": "Draft purchase orders created"})
+}
+
+type purchaseOrderInput struct {
+	SupplierID string               `json:"supplier_id"`
+	Notes      string               `json:"notes"`
+	ExpectedAt string               `json:"expected_at"`
+	Lines      []purchasing.NewLine `json:"items" binding:"required,min=1,dive"`
+}
+
+// CreatePurchaseOrder - owner drafts a purchase order by hand
+func CreatePurchaseOrder(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var input purchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if input.SupplierID != "" && !supplierOf(restaurantID, input.SupplierID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier not found in this restaurant"})
+		return
+	}
+	if !checkOrderLines(c, restaurantID, input.Lines) {
+		return
+	}
+
+	po, err := purchasing.Create(purchasing.NewOrder{
+		RestaurantID: restaurantID,
+		SupplierID:   input.SupplierID,
+		Notes:        input.Notes,
+		ExpectedAt:   input.ExpectedAt,
+		CreatedBy:    userID,
+		Currency:     restaurantCurrency(restaurantID),
+		Lines:        input.Lines,
+	})
+	if err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": po, "message": "Purchase order created"})
+}
+
+// UpdatePurchaseOrder - owner edits a draft's supplier, notes, date and lines
+func UpdatePurchaseOrder(c *gin.Context) {
+	po, ok := ownedPurchaseOrder(c)
+	if !ok {
+		return
+	}
+	if po.Status != purchasing.Draft {
+		purchaseOrderError(c, purchasing.ErrNotDraft)
+		return
+	}
+
+	var input purchaseOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if input.SupplierID != "" && !supplierOf(po.RestaurantID, input.SupplierID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier not found in this restaurant"})
+		return
+	}
+	if !checkOrderLines(c, po.RestaurantID, input.Lines) {
+		return
+	}
+
+	if err := purchasing.ReplaceLines(po, input.Lines, restaurantCurrency(po.RestaurantID)); err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+	var supplierID, expectedAt interface{}
+	if input.SupplierID != "" {
+		supplierID = input.SupplierID
+	}
+	if input.ExpectedAt != "" {
+		expectedAt = input.ExpectedAt
+	}
+	database.Query("purchase_orders").
+		Update(map[string]interface{}{
+			"supplier_id": supplierID,
+			"notes":       input.Notes,
+			"expected_at": expectedAt,
+		}, "", "").
+		Eq("id", po.ID).
+		Execute()
+
+	updated, err := purchasing.Get(po.ID)
+	if err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": updated, "message": "Purchase order updated"})
+}
+
+// SendPurchaseOrder - owner marks a draft as sent to the supplier
+func SendPurchaseOrder(c *gin.Context) {
+	po, ok := ownedPurchaseOrder(c)
+	if !ok {
+		return
+	}
+
+	if err := purchasing.MarkSent(po); err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+
+	sent, err := purchasing.Get(po.ID)
+	if err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sent, "message": "Purchase order sent"})
+}
+
+// ReceivePurchaseOrder - owner books a delivery. Each receipt is a line_id
+// with the quantity delivered and optionally the invoiced unit_cost; no
+// receipts receives everything outstanding.
+func ReceivePurchaseOrder(c *gin.Context) {
+	po, ok := ownedPurchaseOrder(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Items []purchasing.Receipt `json:"items" binding:"dive"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+			return
+		}
+	}
+
+	movements, err := purchasing.Receive(po, input.Items, c.GetString("userId"))
+	if err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+
+	received, err := purchasing.Get(po.ID)
+	if err != nil {
+		purchaseOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"purchase_order": received,
+			"movements":      movements,
+		},
+		"message": "Delivery received",
+	})
+}
+
+// DeletePurchaseOrder - owner discards a draft
+func DeletePurchaseOrder(c *gin.Context) {
+	po, ok := ownedPurchaseOrder(c)
+	if !ok {
+		return
+	}
+	if po.Status != purchasing.Draft {
+		purchaseOrderError(c, purchasing.ErrNotDraft)
+		return
+	}
+
+	if _, _, err := database.Query("purchase_orders").Delete("", "").Eq("id", po.ID).Execute(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete purchase order"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Purchase order deleted"})
+}
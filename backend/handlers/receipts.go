@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/receipt"
+	"finedine/backend/internal/tax"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetTaxRules - owner reads the restaurant's tax configuration
+func GetTaxRules(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tax.ForRestaurant(restaurantID)})
+}
+
+// UpdateTaxRules - owner replaces the restaurant's tax configuration.
+// Only new orders are affected; existing orders keep their tax lines.
+func UpdateTaxRules(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var rules tax.Rules
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if err := rules.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	_, _, err := database.Query("restaurants").
+		Update(map[string]interface{}{"tax_rules": rules}, "", "").
+		Eq("id", restaurantID).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save tax rules"})
+		return
+	}
+	tax.Forget(restaurantID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    rules,
+		"message": "Tax rules updated successfully",
+	})
+}
+
+// orderTax prices order lines under the restaurant's rules. A basket-level
+// discount is spread over the lines pro rata, so each tax rate is charged on
+// what the customer actually pays for those items.
+func orderTax(restaurantID, currency string, lines []pricedLine, discount money.Amount) tax.Breakdown {
+	var subtotal money.Amount
+	for _, line := range lines {
+		subtotal += line.LineTotal
+	}
+
+	items := make([]tax.Item, 0, len(lines))
+	remaining := discount
+	for i, line := range lines {
+		amount := line.LineTotal
+		if discount > 0 && subtotal > 0 {
+			share := remaining
+			if i < len(lines)-1 {
+				share = money.Amount(int64(discount) * int64(line.LineTotal) / int64(subtotal))
+				remaining -= share
+			}
+			amount -= share
+		}
+		items = append(items, tax.Item{Category: line.Category, Amount: amount})
+	}
+	return tax.Compute(tax.ForRestaurant(restaurantID), currency, items)
+}
+
+type invoice struct {
+	ID            string    `json:"id"`
+	InvoiceNumber string    `json:"invoice_number"`
+	IssuedAt      time.Time `json:"issued_at"`
+}
+
+// issueInvoice assigns the order the restaurant's next invoice number;
+// calling it again for the same order returns the existing invoice
+func issueInvoice(restaurantID, orderID string) (*invoice, error) {
+	resultStr := database.Client.Rpc("issue_invoice", "", map[string]interface{}{
+		"p_restaurant_id": restaurantID,
+		"p_order_id":      orderID,
+		"p_prefix":        tax.ForRestaurant(restaurantID).Prefix(),
+	})
+
+	var invoices []invoice
+	if err := json.Unmarshal([]byte(resultStr), &invoices); err != nil {
+		return nil, fmt.Errorf("issue_invoice: %s", resultStr)
+	}
+	if len(invoices) == 0 {
+		return nil, errors.New("issue_invoice returned no invoice")
+	}
+	return &invoices[0], nil
+}
+
+// issueInvoiceAsync is used from payment webhooks, where a missing invoice
+// is recovered lazily the first time the receipt is requested
+func issueInvoiceAsync(restaurantID, orderID string) {
+	if restaurantID == "" || orderID == "" {
+		return
+	}
+	if _, err := issueInvoice(restaurantID, orderID); err != nil {
+		log.Printf("⚠️  Failed to issue invoice for order %s: %v", orderID, err)
+	}
+}
+
+// GetOrderReceipt - itemised receipt for a paid order, for its customer or the
+// restaurant owner. ?format=html (default), pdf or json.
+func GetOrderReceipt(c *gin.Context) {
+	orderID := c.Param("id")
+	userID := c.GetString("userId")
+
+	result, _, err := database.Query("orders").
+		Select("id, customer_id, customer_name, restaurant_id, status, payment_status, items, subtotal, discount, service_charge, tax_amount, tax_inclusive, tax_lines, tip_amount, total, refunded_amount, currency, restaurant:restaurants(name, address, phone, owner_id)", "", false).
+		Eq("id", orderID).
+		Single().
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var order struct {
+		ID             string                   `json:"id"`
+		CustomerID     string                   `json:"customer_id"`
+		CustomerName   string                   `json:"customer_name"`
+		RestaurantID   string                   `json:"restaurant_id"`
+		Status         string                   `json:"status"`
+		PaymentStatus  string                   `json:"payment_status"`
+		Items          []map[string]interface{} `json:"items"`
+		Subtotal       money.Amount             `json:"subtotal"`
+		Discount       money.Amount             `json:"discount"`
+		ServiceCharge  money.Amount             `json:"service_charge"`
+		TaxAmount      money.Amount             `json:"tax_amount"`
+		TaxInclusive   bool                     `json:"tax_inclusive"`
+		TaxLines       []receipt.TaxLine        `json:"tax_lines"`
+		TipAmount      money.Amount             `json:"tip_amount"`
+		Total          money.Amount             `json:"total"`
+		RefundedAmount money.Amount             `json:"refunded_amount"`
+		Currency       string                   `json:"currency"`
+		Restaurant     struct {
+			Name    string `json:"name"`
+			Address string `json:"address"`
+			Phone   string `json:"phone"`
+			OwnerID string `json:"owner_id"`
+		} `json:"restaurant"`
+	}
+	if err := json.Unmarshal(result, &order); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read order"})
+		return
+	}
+
+	if userID == "" || (order.CustomerID != userID && order.Restaurant.OwnerID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	switch {
+	case order.PaymentStatus == "paid",
+		order.PaymentStatus == "partially_refunded",
+		order.PaymentStatus == "refunded",
+		order.Status == "completed":
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "A receipt is only available once the order is paid"})
+		return
+	}
+
+	inv, err := issueInvoice(order.RestaurantID, order.ID)
+	if err != nil {
+		log.Printf("⚠️  Failed to issue invoice for order %s: %v", order.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue invoice"})
+		return
+	}
+
+	r := receipt.Receipt{
+		InvoiceNumber:     inv.InvoiceNumber,
+		IssuedAt:          inv.IssuedAt,
+		RestaurantName:    order.Restaurant.Name,
+		RestaurantAddress: order.Restaurant.Address,
+		RestaurantPhone:   order.Restaurant.Phone,
+		OrderID:           order.ID,
+		CustomerName:      order.CustomerName,
+		Currency:          orderCurrency(order.Currency, order.RestaurantID),
+		TaxInclusive:      order.TaxInclusive,
+		Subtotal:          order.Subtotal,
+		Discount:          order.Discount,
+		ServiceCharge:     order.ServiceCharge,
+		TaxLines:          order.TaxLines,
+		Tax:               order.TaxAmount,
+		Tip:               order.TipAmount,
+		Total:             order.Total + order.TipAmount,
+		Refunded:          order.RefundedAmount,
+	}
+	for _, item := range order.Items {
+		name, _ := item["name"].(string)
+		price := money.FromValue(item["price"])
+		qty, _ := item["quantity"].(float64)
+		lineTotal := money.FromValue(item["line_total"])
+		if _, ok := item["line_total"]; !ok {
+			lineTotal = price.Mul(int64(qty))
+		}
+		r.Items = append(r.Items, receipt.Item{
+			Name:      name,
+			Quantity:  int(qty),
+			UnitPrice: price,
+			Total:     lineTotal,
+		})
+	}
+
+	switch c.DefaultQuery("format", "html") {
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", inv.InvoiceNumber+".pdf"))
+		c.Data(http.StatusOK, "application/pdf", r.PDF())
+	case "json":
+		c.JSON(http.StatusOK, gin.H{"data": r})
+	default:
+		body, err := r.HTML()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render receipt"})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", body)
+	}
+}
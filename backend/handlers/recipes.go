@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/realtime"
+	"finedine/backend/internal/stock"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ownedMenuItemRestaurant returns the restaurant of a menu item the user owns
+func ownedMenuItemRestaurant(menuItemID, userID string) (string, error) {
+	result, _, err := database.Query("menu_items").
+		Select("restaurant_id, restaurants!inner(owner_id)", "", false).
+		Eq("id", menuItemID).
+		Eq("restaurants.owner_id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return "", err
+	}
+	var item struct {
+		RestaurantID string `json:"restaurant_id"`
+	}
+	if err := json.Unmarshal(result, &item); err != nil {
+		return "", err
+	}
+	return item.RestaurantID, nil
+}
+
+// GetMenuItemRecipe - owner views the ingredients a menu item uses per portion
+func GetMenuItemRecipe(c *gin.Context) {
+	menuItemID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if _, err := ownedMenuItemRestaurant(menuItemID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or menu item not found"})
+		return
+	}
+
+	ingredients, err := stock.Recipe(menuItemID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recipe"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": ingredients})
+}
+
+// UpdateMenuItemRecipe - owner replaces a menu item's recipe. Each ingredient
+// is an inventory item of the same restaurant with a per-portion quantity, in
+// the item's unit or one convertible to it (an empty list clears the recipe).
+func UpdateMenuItemRecipe(c *gin.Context) {
+	menuItemID := c.Param("id")
+	userID := c.GetString("userId")
+
+	restaurantID, err := ownedMenuItemRestaurant(menuItemID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or menu item not found"})
+		return
+	}
+
+	var input struct {
+		Ingredients []struct {
+			InventoryID string  `json:"inventory_id" binding:"required"`
+			Quantity    float64 `json:"quantity" binding:"required,gt=0"`
+			Unit        string  `json:"unit"`
+		} `json:"ingredients" binding:"dive"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	ids := make([]string, 0, len(input.Ingredients))
+	for _, ing := range input.Ingredients {
+		ids = append(ids, ing.InventoryID)
+	}
+	items := map[string]stock.Item{}
+	if len(ids) > 0 {
+		result, _, err := database.Query("inventory").
+			Select("id, name, unit", "", false).
+			Eq("restaurant_id", restaurantID).
+			In("id", ids).
+			Execute()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load inventory"})
+			return
+		}
+		var rows []stock.Item
+		json.Unmarshal(result, &rows)
+		for _, it := range rows {
+			items[it.ID] = it
+		}
+	}
+
+	ingredients := make([]stock.Ingredient, 0, len(input.Ingredients))
+	seen := map[string]bool{}
+	for _, ing := range input.Ingredients {
+		item, ok := items[ing.InventoryID]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Inventory item " + ing.InventoryID + " not found in this restaurant"})
+			return
+		}
+		if seen[ing.InventoryID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": item.Name + " is listed more than once"})
+			return
+		}
+		seen[ing.InventoryID] = true
+		if _, ok := stock.Convert(ing.Quantity, ing.Unit, item.Unit); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is stocked in %s and can't be measured in %s", item.Name, item.Unit, ing.Unit)})
+			return
+		}
+		unit := ing.Unit
+		if unit == "" {
+			unit = item.Unit
+		}
+		ingredients = append(ingredients, stock.Ingredient{
+			InventoryID: ing.InventoryID,
+			Quantity:    ing.Quantity,
+			Unit:        unit,
+		})
+	}
+
+	if err := stock.SetRecipe(menuItemID, ingredients); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save recipe"})
+		return
+	}
+
+	saved, err := stock.Recipe(menuItemID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recipe"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": saved, "message": "Recipe updated"})
+}
+
+// orderStockLines reads the sold menu items out of an order row
+func orderStockLines(order map[string]interface{}) []stock.Line {
+	raw, _ := order["items"].([]interface{})
+	lines := make([]stock.Line, 0, len(raw))
+	for _, r := range raw {
+		item, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := item["menu_item_id"].(string)
+		if id == "" {
+			id, _ = item["id"].(string)
+		}
+		qty, _ := item["quantity"].(float64)
+		if qty < 1 {
+			qty = 1
+		}
+		if id != "" {
+			lines = append(lines, stock.Line{MenuItemID: id, Quantity: qty})
+		}
+	}
+	return lines
+}
+
+// depleteOrderStock takes a completed order's ingredients out of stock in the
+// background, alerts the owner about low stock and takes dishes whose
+// ingredients ran out off the menu
+func depleteOrderStock(order map[string]interface{}, ownerID string) {
+	orderID, _ := order["id"].(string)
+	restaurantID, _ := order["restaurant_id"].(string)
+	lines := orderStockLines(order)
+
+	go func() {
+		depleted, err := stock.Deplete(orderID, restaurantID, lines)
+		if err != nil {
+			log.Printf("⚠️  Stock depletion failed for order %s: %v", orderID, err)
+			return
+		}
+
+		for _, d := range depleted {
+			item := d.Item
+			alertIfLow(ownerID, &item, d.Movement)
+			if !d.Movement.RanOut() {
+				continue
+			}
+
+			dishes, err := stock.DisableDishes(restaurantID, item.ID)
+			if err != nil {
+				log.Printf("⚠️  Failed to disable dishes using %s: %v", item.Name, err)
+				continue
+			}
+			if len(dishes) == 0 {
+				continue
+			}
+			cache.SafeDelete(cache.MenuKey(item.RestaurantID))
+
+			names := make([]string, 0, len(dishes))
+			for _, dish := range dishes {
+				names = append(names, dish.Name)
+			}
+			notification := map[string]interface{}{
+				"user_id": ownerID,
+				"title":   "Out of Stock",
+				"message": fmt.Sprintf("%s ran out. Marked unavailable: %s", item.Name, strings.Join(names, ", ")),
+				"type":    "low_stock",
+				"read":    false,
+			}
+			database.Query("notifications").
+				Insert(notification, false, "", "", "").
+				Execute()
+
+			realtime.WSHub.SendToUser(ownerID, realtime.RealtimeMessage{
+				Type: "out_of_stock",
+				Payload: map[string]interface{}{
+					"inventory_id": item.ID,
+					"dishes":       dishes,
+				},
+			})
+		}
+	}()
+}
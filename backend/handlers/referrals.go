@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/payments"
+	"finedine/backend/internal/realtime"
+	"finedine/backend/internal/referrals"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// GetReferrals - the customer's referral code, the program and who they referred
+func GetReferrals(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	code, err := referrals.CodeFor(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referral code"})
+		return
+	}
+
+	result, _, err := database.Query("referrals").
+		Select("id, status, referrer_reward, rewarded_at, created_at", "", false).
+		Eq("referrer_id", userID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"code":      code,
+		"program":   referrals.Current(),
+		"referrals": json.RawMessage(result),
+	}})
+}
+
+// ApplyReferralCode - a new customer says who referred them
+func ApplyReferralCode(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	ref, err := referrals.Apply(userID, input.Code, c.GetHeader("X-Device-ID"))
+	switch {
+	case errors.Is(err, referrals.ErrInvalidCode):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, referrals.ErrAlreadyReferred):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, referrals.ErrOwnCode),
+		errors.Is(err, referrals.ErrNotNewCustomer),
+		errors.Is(err, referrals.ErrSelfReferral):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply referral code"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":    ref,
+		"message": "Referral code applied. Your reward arrives after your first completed order.",
+	})
+}
+
+// qualifyReferral rewards a referral once the referred customer's first
+// order is completed, in the background
+func qualifyReferral(orderID string) {
+	go func() {
+		ref, err := referrals.Qualify(orderID)
+		if err != nil {
+			log.Printf("⚠️  Referral check failed for order %s: %v", orderID, err)
+			return
+		}
+		if ref == nil {
+			return
+		}
+
+		for _, side := range []struct {
+			userID, message string
+		}{
+			{ref.ReferrerID, "A friend you referred completed their first order. Your reward is ready!"},
+			{ref.ReferredUserID, "Thanks for joining through a friend. Your welcome reward is ready!"},
+		} {
+			database.Query("notifications").
+				Insert(map[string]interface{}{
+					"user_id": side.userID,
+					"title":   "Referral reward",
+					"message": side.message,
+					"type":    "general",
+					"read":    false,
+				}, false, "", "", "").
+				Execute()
+
+			realtime.WSHub.SendToUser(side.userID, realtime.RealtimeMessage{
+				Type:    "referral_rewarded",
+				Payload: map[string]interface{}{"referral_id": ref.ID},
+			})
+		}
+	}()
+}
+
+// recordCardFingerprint stores which card paid, so one person's accounts can
+// be recognised across referrals. It calls Stripe, so run it in a goroutine.
+func recordCardFingerprint(pi stripe.PaymentIntent) {
+	if pi.LatestCharge == nil || pi.LatestCharge.ID == "" {
+		return
+	}
+	fingerprint, err := payments.Default.CardFingerprint(pi.LatestCharge.ID)
+	if err != nil || fingerprint == "" {
+		return
+	}
+	database.Query("payments").
+		Update(map[string]interface{}{"card_fingerprint": fingerprint}, "", "").
+		Eq("stripe_payment_intent_id", pi.ID).
+		Execute()
+}
+
+// AdminGetReferrals - all referrals, e.g. ?status=rejected for fraud review
+func AdminGetReferrals(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	query := database.Query("referrals").
+		Select("*, referrer:users!referrer_id(id, name, email), referred:users!referred_user_id(id, name, email)", "", false)
+	if status := c.Query("status"); status != "" {
+		query = query.Eq("status", status)
+	}
+
+	result, _, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// AdminGetReferralStats - program totals, fraud rejections and top referrers
+func AdminGetReferralStats(c *gin.Context) {
+	result, _, err := database.Query("referrals").
+		Select("referrer_id, status, fraud_reason, referrer_reward, referred_reward", "", false).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referral stats"})
+		return
+	}
+
+	var rows []struct {
+		ReferrerID     string            `json:"referrer_id"`
+		Status         string            `json:"status"`
+		FraudReason    *string           `json:"fraud_reason"`
+		ReferrerReward *referrals.Reward `json:"referrer_reward"`
+		ReferredReward *referrals.Reward `json:"referred_reward"`
+	}
+	if err := json.Unmarshal(result, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read referral stats"})
+		return
+	}
+
+	byStatus := map[string]int{}
+	byFraudReason := map[string]int{}
+	rewardedBy := map[string]int{}
+	pointsIssued, couponsIssued, rewardErrors := 0, 0, 0
+	for _, r := range rows {
+		byStatus[r.Status]++
+		if r.FraudReason != nil {
+			byFraudReason[*r.FraudReason]++
+		}
+		if r.Status == "rewarded" {
+			rewardedBy[r.ReferrerID]++
+		}
+		for _, reward := range []*referrals.Reward{r.ReferrerReward, r.ReferredReward} {
+			switch {
+			case reward == nil:
+			case reward.Error != "":
+				rewardErrors++
+			case reward.Type == "coupon":
+				couponsIssued++
+			default:
+				pointsIssued += reward.Points
+			}
+		}
+	}
+
+	type referrer struct {
+		UserID   string `json:"user_id"`
+		Rewarded int    `json:"rewarded"`
+	}
+	top := make([]referrer, 0, len(rewardedBy))
+	for id, n := range rewardedBy {
+		top = append(top, referrer{UserID: id, Rewarded: n})
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Rewarded > top[j].Rewarded })
+	if len(top) > 10 {
+		top = top[:10]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"total":           len(rows),
+		"by_status":       byStatus,
+		"by_fraud_reason": byFraudReason,
+		"points_issued":   pointsIssued,
+		"coupons_issued":  couponsIssued,
+		"reward_errors":   rewardErrors,
+		"top_referrers":   top,
+		"program":         referrals.Current(),
+	}})
+}
@@ -0,0 +1,575 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/giftcards"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/payments"
+	"finedine/backend/internal/realtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+type orderLine struct {
+	MenuItemID string `json:"menu_item_id" binding:"required"`
+	Quantity   int    `json:"quantity" binding:"required,min=1"`
+}
+
+// RefundOrder - owner refunds a paid order in full, by line items, or by amount
+func RefundOrder(c *gin.Context) {
+	restaurantID := c.Param("id")
+	orderID := c.Param("orderId")
+	userID := c.GetString("userId")
+
+	var input struct {
+		Items  []orderLine  `json:"items" binding:"omitempty,dive"`
+		Amount money.Amount `json:"amount" binding:"min=0"`
+		Reason string       `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	result, _, err := database.Query("orders").
+		Select("id, customer_id, subtotal, total, gift_card_amount, items, payment_status, refunded_amount, refunded_items, currency", "", false).
+		Eq("id", orderID).
+		Eq("restaurant_id", restaurantID).
+		Single().
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var order struct {
+		ID             string                   `json:"id"`
+		CustomerID     string                   `json:"customer_id"`
+		Subtotal       money.Amount             `json:"subtotal"`
+		Total          money.Amount             `json:"total"`
+		GiftCardAmount money.Amount             `json:"gift_card_amount"`
+		Items          []map[string]interface{} `json:"items"`
+		PaymentStatus  string                   `json:"payment_status"`
+		RefundedAmount money.Amount             `json:"refunded_amount"`
+		RefundedItems  map[string]int           `json:"refunded_items"`
+		Currency       string                   `json:"currency"`
+	}
+	if err := json.Unmarshal(result, &order); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read order"})
+		return
+	}
+
+	if order.PaymentStatus != "paid" && order.PaymentStatus != "partially_refunded" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order has no captured payment to refund"})
+		return
+	}
+
+	currency := orderCurrency(order.Currency, restaurantID)
+	remaining := order.Total - order.RefundedAmount
+
+	amount := remaining
+	var refundedItems map[string]int
+	switch {
+	case len(input.Items) > 0:
+		amount, refundedItems, err = refundLines(order.Items, order.RefundedItems, input.Items)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Item prices are pre-tax; refund their share of tax and service too
+		if order.Subtotal > 0 && order.Total != order.Subtotal {
+			amount = amount.MulFloat(order.Total.Float64() / order.Subtotal.Float64())
+		}
+	case input.Amount > 0:
+		amount = input.Amount
+	}
+	amount = amount.Round(currency)
+
+	if amount <= 0 || amount > remaining {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Refund amount exceeds the refundable balance",
+			"refundable": remaining,
+		})
+		return
+	}
+
+	// The card part (one payment, or one per split share) is refunded
+	// before the gift card part; tips are never part of the refundable total
+	paymentResult, _, err := database.Query("payments").
+		Select("id, amount, refunded_amount, status, stripe_payment_intent_id, stripe_destination_account", "", false).
+		Eq("order_id", orderID).
+		In("status", []string{"completed", "refunded"}).
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read payments"})
+		return
+	}
+
+	var paid []struct {
+		ID                 string       `json:"id"`
+		Amount             money.Amount `json:"amount"`
+		RefundedAmount     money.Amount `json:"refunded_amount"`
+		Status             string       `json:"status"`
+		PaymentIntentID    string       `json:"stripe_payment_intent_id"`
+		DestinationAccount string       `json:"stripe_destination_account"`
+	}
+	if err := json.Unmarshal(paymentResult, &paid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read payments"})
+		return
+	}
+
+	var cardRefunded money.Amount
+	for _, p := range paid {
+		cardRefunded += p.RefundedAmount
+	}
+	cardLeft := order.Total - order.GiftCardAmount - cardRefunded
+	if cardLeft < 0 {
+		cardLeft = 0
+	}
+	toCard := amount
+	if toCard > cardLeft {
+		toCard = cardLeft
+	}
+	toGiftCard := amount - toCard
+	giftCardLeft := order.GiftCardAmount - (order.RefundedAmount - cardRefunded)
+	if toGiftCard > giftCardLeft {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "The order's payments can't cover this refund",
+			"refundable": cardLeft + giftCardLeft,
+		})
+		return
+	}
+
+	newRefunded := order.RefundedAmount + amount
+	fullyRefunded := newRefunded >= order.Total
+
+	paymentStatus := "partially_refunded"
+	paymentRowStatus := "completed"
+	if fullyRefunded {
+		paymentStatus = "refunded"
+		paymentRowStatus = "refunded"
+	}
+
+	// Book the refund on the order before asking the provider, only if no
+	// other refund moved refunded_amount since it was read, so two refunds
+	// can't both spend the same balance
+	claim := map[string]interface{}{
+		"refunded_amount": newRefunded,
+		"payment_status":  paymentStatus,
+	}
+	if refundedItems != nil {
+		claim["refunded_items"] = refundedItems
+	}
+	claimed, err := updateRefundedAmount("orders", orderID, order.RefundedAmount, claim)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record refund"})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another refund was issued for this order meanwhile; reload and try again"})
+		return
+	}
+
+	var refunded money.Amount
+	refundRows := []map[string]interface{}{}
+
+	// fail gives the part that wasn't refunded back to the order's balance
+	fail := func(status int, message string) {
+		rollback := map[string]interface{}{
+			"refunded_amount": order.RefundedAmount + refunded,
+			"payment_status":  "partially_refunded",
+		}
+		if refunded == 0 {
+			rollback["payment_status"] = order.PaymentStatus
+			if refundedItems != nil {
+				rollback["refunded_items"] = order.RefundedItems
+			}
+		}
+		if _, rbErr := updateRefundedAmount("orders", orderID, newRefunded, rollback); rbErr != nil {
+			log.Printf("⚠️  Failed to roll back refund on order %s: %v", orderID, rbErr)
+		}
+		c.JSON(status, gin.H{"error": message, "refunded": refunded})
+	}
+
+	left := toCard
+	for _, p := range paid {
+		if left <= 0 {
+			break
+		}
+		if p.Status != "completed" {
+			continue
+		}
+		part := p.Amount - p.RefundedAmount
+		if part > left {
+			part = left
+		}
+		if part <= 0 {
+			continue
+		}
+		partCents := part.Minor(currency)
+
+		providerRefund, err := payments.Default.Refund(payments.RefundParams{
+			PaymentIntentID: p.PaymentIntentID,
+			Amount:          partCents,
+			Reason:          input.Reason,
+			// Same payment + same cumulative state => same refund, so retries never double-refund
+			IdempotencyKey: fmt.Sprintf("refund-%s-%d-%d", p.ID, p.RefundedAmount.Minor(currency), partCents),
+			// Destination charges: take the money back from the restaurant too
+			ReverseTransfer: p.DestinationAccount != "",
+		})
+		if err != nil {
+			fail(http.StatusBadGateway, "Payment provider rejected the refund")
+			return
+		}
+		left -= part
+		refunded += part
+
+		_, _, err = database.Query("payments").
+			Update(map[string]interface{}{
+				"refunded_amount": p.RefundedAmount + part,
+				"status":          paymentRowStatus,
+			}, "", "").
+			Eq("id", p.ID).
+			Execute()
+		if err != nil {
+			log.Printf("⚠️  Refund %s issued but payment %s was not updated: %v", providerRefund.ID, p.ID, err)
+		}
+
+		refundRows = append(refundRows, recordRefund(map[string]interface{}{
+			"restaurant_id":      restaurantID,
+			"order_id":           orderID,
+			"payment_id":         p.ID,
+			"amount":             part,
+			"reason":             input.Reason,
+			"items":              input.Items,
+			"provider_refund_id": providerRefund.ID,
+			"status":             providerRefund.Status,
+			"created_by":         userID,
+		})...)
+	}
+	if left > 0 {
+		fail(http.StatusConflict, "The order's card payments can't cover this refund")
+		return
+	}
+
+	if toGiftCard > 0 {
+		back, err := giftcards.RefundOrder(orderID, toGiftCard, "Order refund")
+		refunded += back
+		if back > 0 {
+			refundRows = append(refundRows, recordRefund(map[string]interface{}{
+				"restaurant_id": restaurantID,
+				"order_id":      orderID,
+				"amount":        back,
+				"reason":        input.Reason,
+				"items":         input.Items,
+				"status":        "succeeded",
+				"created_by":    userID,
+			})...)
+		}
+		if err != nil || back < toGiftCard {
+			fail(http.StatusInternalServerError, "Failed to return the gift card balance")
+			return
+		}
+	}
+
+	notifyRefund(order.CustomerID, "order_id", orderID, money.New(amount, currency), fullyRefunded)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":           refundRows,
+		"refunded_total": newRefunded,
+		"payment_status": paymentStatus,
+		"message":        "Refund issued successfully",
+	})
+}
+
+// recordRefund stores a refund that already happened; failing to record it
+// must not fail the request, so errors are only logged
+func recordRefund(row map[string]interface{}) []map[string]interface{} {
+	result, _, err := database.Query("refunds").
+		Insert(row, false, "", "", "").
+		Execute()
+	if err != nil {
+		log.Printf("⚠️  Refund of %v on %v issued but not recorded: %v", row["amount"], row["order_id"], err)
+		return nil
+	}
+	var rows []map[string]interface{}
+	json.Unmarshal(result, &rows)
+	return rows
+}
+
+// RefundTransaction - owner refunds an in-store transaction in full or in part
+func RefundTransaction(c *gin.Context) {
+	restaurantID := c.Param("id")
+	transactionID := c.Param("transactionId")
+	userID := c.GetString("userId")
+
+	var input struct {
+		Amount money.Amount `json:"amount" binding:"min=0"`
+		Reason string       `json:"reason"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	result, _, err := database.Query("transactions").
+		Select("id, customer_id, order_id, final_amount, refunded_amount, status, currency", "", false).
+		Eq("id", transactionID).
+		Eq("restaurant_id", restaurantID).
+		Single().
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	var txn struct {
+		CustomerID     string       `json:"customer_id"`
+		OrderID        string       `json:"order_id"`
+		FinalAmount    money.Amount `json:"final_amount"`
+		RefundedAmount money.Amount `json:"refunded_amount"`
+		Status         string       `json:"status"`
+		Currency       string       `json:"currency"`
+	}
+	if err := json.Unmarshal(result, &txn); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read transaction"})
+		return
+	}
+
+	if txn.OrderID != "" {
+		// Online payments must go back through the payment provider
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This transaction belongs to an online order; refund the order instead"})
+		return
+	}
+
+	if txn.Status == "refunded" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction is already fully refunded"})
+		return
+	}
+
+	currency := orderCurrency(txn.Currency, restaurantID)
+	remaining := txn.FinalAmount - txn.RefundedAmount
+	amount := remaining
+	if input.Amount > 0 {
+		amount = input.Amount.Round(currency)
+	}
+
+	if amount <= 0 || amount > remaining {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Refund amount exceeds the refundable balance",
+			"refundable": remaining,
+		})
+		return
+	}
+
+	newRefunded := txn.RefundedAmount + amount
+	fullyRefunded := newRefunded >= txn.FinalAmount
+
+	updates := map[string]interface{}{"refunded_amount": newRefunded}
+	if fullyRefunded {
+		updates["status"] = "refunded"
+	}
+
+	// Only applies if no other refund moved refunded_amount since it was read
+	claimed, err := updateRefundedAmount("transactions", transactionID, txn.RefundedAmount, updates)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund transaction"})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another refund was issued for this transaction meanwhile; reload and try again"})
+		return
+	}
+
+	refundRow, _, err := database.Query("refunds").
+		Insert(map[string]interface{}{
+			"restaurant_id":  restaurantID,
+			"transaction_id": transactionID,
+			"amount":         amount,
+			"reason":         input.Reason,
+			"status":         "succeeded",
+			"created_by":     userID,
+		}, false, "", "", "").
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund applied but failed to record it"})
+		return
+	}
+
+	notifyRefund(txn.CustomerID, "transaction_id", transactionID, money.New(amount, currency), fullyRefunded)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":           refundRow,
+		"refunded_total": newRefunded,
+		"message":        "Transaction refunded successfully",
+	})
+}
+
+// GetRestaurantRefunds - owner lists refunds issued by a restaurant
+func GetRestaurantRefunds(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	result, _, err := database.Query("refunds").
+		Select("*", "", false).
+		Eq("restaurant_id", restaurantID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// updateRefundedAmount applies a refund change to an order or transaction
+// if its refunded_amount is still from, and reports whether it did
+func updateRefundedAmount(table, id string, from money.Amount, updates map[string]interface{}) (bool, error) {
+	query := database.Query(table).
+		Update(updates, "", "").
+		Eq("id", id)
+	if from == 0 {
+		query = query.Or("refunded_amount.is.null,refunded_amount.eq.0", "")
+	} else {
+		query = query.Eq("refunded_amount", from.String())
+	}
+
+	result, _, err := query.Execute()
+	if err != nil {
+		return false, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(result, &rows); err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+// refundLines prices lines to refund, counting what earlier refunds already
+// took back, and returns the refunded quantity per item including them
+func refundLines(orderItems []map[string]interface{}, refunded map[string]int, lines []orderLine) (money.Amount, map[string]int, error) {
+	after := map[string]int{}
+	for id, qty := range refunded {
+		after[id] = qty
+	}
+
+	// The same item twice in one request counts once
+	combined := []orderLine{}
+	index := map[string]int{}
+	for _, line := range lines {
+		after[line.MenuItemID] += line.Quantity
+		if i, ok := index[line.MenuItemID]; ok {
+			combined[i].Quantity += line.Quantity
+			continue
+		}
+		index[line.MenuItemID] = len(combined)
+		combined = append(combined, line)
+	}
+
+	for _, line := range combined {
+		for _, item := range orderItems {
+			if id, _ := item["menu_item_id"].(string); id != line.MenuItemID {
+				continue
+			}
+			qty, _ := item["quantity"].(float64)
+			if float64(after[line.MenuItemID]) > qty {
+				return 0, nil, fmt.Errorf("cannot refund %d of item %s; %d already refunded of %.0f ordered",
+					line.Quantity, line.MenuItemID, refunded[line.MenuItemID], qty)
+			}
+			break
+		}
+	}
+
+	amount, err := orderLinesAmount(orderItems, combined)
+	if err != nil {
+		return 0, nil, err
+	}
+	return amount, after, nil
+}
+
+// orderLinesAmount prices a selection of lines from the order's item snapshot
+func orderLinesAmount(orderItems []map[string]interface{}, lines []orderLine) (money.Amount, error) {
+	var amount money.Amount
+	for _, line := range lines {
+		found := false
+		for _, item := range orderItems {
+			if id, _ := item["menu_item_id"].(string); id != line.MenuItemID {
+				continue
+			}
+			price := money.FromValue(item["price"])
+			qty, _ := item["quantity"].(float64)
+			if float64(line.Quantity) > qty {
+				return 0, fmt.Errorf("cannot select %d of item %s; only %.0f ordered", line.Quantity, line.MenuItemID, qty)
+			}
+			amount += price.Mul(int64(line.Quantity))
+			found = true
+			break
+		}
+		if !found {
+			return 0, fmt.Errorf("item %s is not part of this order", line.MenuItemID)
+		}
+	}
+	return amount, nil
+}
+
+// notifyRefund - DB notification + real-time push to the customer
+func notifyRefund(customerID, refField, refID string, amount money.Money, full bool) {
+	if customerID == "" {
+		return
+	}
+
+	title := "Partial refund issued"
+	if full {
+		title = "Refund issued"
+	}
+
+	database.Query("notifications").
+		Insert(map[string]interface{}{
+			"user_id": customerID,
+			"title":   title,
+			"message": fmt.Sprintf("%s has been refunded to your original payment method", amount),
+			"type":    "general",
+			"read":    false,
+		}, false, "", "", "").
+		Execute()
+
+	realtime.WSHub.SendToUser(customerID, realtime.RealtimeMessage{
+		Type: "refund_issued",
+		Payload: map[string]interface{}{
+			refField:   refID,
+			"amount":   amount.Amount,
+			"currency": amount.Currency,
+			"full":     full,
+		},
+	})
+}
@@ -8,6 +8,8 @@ import (
 
 	"finedine/backend/internal/cache"
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/deals"
+	"finedine/backend/internal/money"
 
 	"github.com/supabase-community/postgrest-go"
 
@@ -180,6 +182,15 @@ func CreateRestaurant(c *gin.Context) {
 		return
 	}
 
+	if !normalizeCurrencyField(input) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a 3-letter ISO 4217 code"})
+		return
+	}
+	if !validTimezoneField(input) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA name such as Europe/London"})
+		return
+	}
+
 	input["owner_id"] = userID
 	input["is_verified"] = false
 	input["is_open"] = false
@@ -222,6 +233,15 @@ func UpdateRestaurant(c *gin.Context) {
 	delete(updates, "rating")
 	delete(updates, "review_count")
 
+	if !normalizeCurrencyField(updates) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a 3-letter ISO 4217 code"})
+		return
+	}
+	if !validTimezoneField(updates) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA name such as Europe/London"})
+		return
+	}
+
 	// Verify ownership
 	_, _, err := database.Query("restaurants").
 		Select("id", "", false).
@@ -248,6 +268,13 @@ func UpdateRestaurant(c *gin.Context) {
 	// Bust both caches
 	cache.Client.Delete(cache.RestaurantKey(restaurantID))
 	cache.Client.Delete(cache.RestaurantsListKey("all"))
+	if _, ok := updates["currency"]; ok {
+		cache.SafeDelete("currency:" + restaurantID)
+	}
+	if _, ok := updates["timezone"]; ok {
+		// Deal windows are evaluated in this timezone
+		cache.SafeDelete(cache.DealsKey())
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"data":    result,
@@ -360,3 +387,27 @@ func DeleteMenuItem(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Menu item deleted successfully"})
 }
+
+// normalizeCurrencyField validates and lower-cases an optional "currency" field
+func normalizeCurrencyField(fields map[string]interface{}) bool {
+	raw, ok := fields["currency"]
+	if !ok {
+		return true
+	}
+	currency, _ := raw.(string)
+	if !money.ValidCurrency(currency) {
+		return false
+	}
+	fields["currency"] = money.Normalize(currency)
+	return true
+}
+
+// validTimezoneField checks an optional "timezone" field
+func validTimezoneField(fields map[string]interface{}) bool {
+	raw, ok := fields["timezone"]
+	if !ok {
+		return true
+	}
+	name, _ := raw.(string)
+	return deals.ValidTimezone(name)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+
+	"finedine/backend/internal/session"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSessions - list the authenticated user's device sessions
+func GetSessions(c *gin.Context) {
+	userID := c.GetString("userId")
+	currentID := c.GetString("sessionId")
+
+	sessions, err := session.ListForUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	data := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		data = append(data, gin.H{
+			"id":           s.ID,
+			"device_id":    s.DeviceID,
+			"user_agent":   s.UserAgent,
+			"ip_address":   s.IPAddress,
+			"created_at":   s.CreatedAt,
+			"last_seen_at": s.LastSeenAt,
+			"revoked_at":   s.RevokedAt,
+			"current":      s.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// RevokeSession - log out a single device (must belong to the user)
+func RevokeSession(c *gin.Context) {
+	sessionID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if _, err := session.Get(sessionID, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := session.Revoke(sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeAllSessions - log out every device, including the current one
+func RevokeAllSessions(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	if err := session.RevokeAllForUser(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions revoked successfully"})
+}
@@ -0,0 +1,665 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/payments"
+	"finedine/backend/internal/realtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/supabase-community/postgrest-go"
+)
+
+const maxSplitParts = 20
+
+type splitOrder struct {
+	ID             string                   `json:"id"`
+	CustomerID     string                   `json:"customer_id"`
+	RestaurantID   string                   `json:"restaurant_id"`
+	OrderType      string                   `json:"order_type"`
+	Subtotal       money.Amount             `json:"subtotal"`
+	Total          money.Amount             `json:"total"`
+	GiftCardAmount money.Amount             `json:"gift_card_amount"`
+	Items          []map[string]interface{} `json:"items"`
+	Status         string                   `json:"status"`
+	PaymentStatus  string                   `json:"payment_status"`
+	Currency       string                   `json:"currency"`
+}
+
+func loadSplitOrder(orderID string) (*splitOrder, error) {
+	result, _, err := database.Query("orders").
+		Select("id, customer_id, restaurant_id, order_type, subtotal, total, gift_card_amount, items, status, payment_status, currency", "", false).
+		Eq("id", orderID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var order splitOrder
+	if err := json.Unmarshal(result, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// evenSplit divides minor units into parts, spreading the remainder over the first parts
+func evenSplit(cents int64, parts int) []int64 {
+	out := make([]int64, parts)
+	base := cents / int64(parts)
+	remainder := cents % int64(parts)
+	for i := range out {
+		out[i] = base
+		if int64(i) < remainder {
+			out[i]++
+		}
+	}
+	return out
+}
+
+// scaleShares spreads tax and service charge over item shares in proportion
+// to their value, so the shares add up to the order total exactly
+func scaleShares(amounts []int64, totalCents int64) []int64 {
+	var sum int64
+	for _, a := range amounts {
+		sum += a
+	}
+	if sum == 0 || sum == totalCents {
+		return amounts
+	}
+
+	out := make([]int64, len(amounts))
+	var allocated int64
+	for i, a := range amounts {
+		out[i] = a * totalCents / sum
+		allocated += out[i]
+	}
+	for i := 0; allocated < totalCents; i = (i + 1) % len(out) {
+		out[i]++
+		allocated++
+	}
+	return out
+}
+
+var errIntentInFlight = errors.New("a payment for this order is already being processed")
+
+// cancelOpenIntents cancels the order's unpaid PaymentIntents: the one from
+// checkout and those of unpaid shares. Nothing is cancelled if any of them
+// has already been confirmed, since that money may still arrive.
+func cancelOpenIntents(orderID string) error {
+	result, _, err := database.Query("payments").
+		Select("stripe_payment_intent_id", "", false).
+		Eq("order_id", orderID).
+		In("status", []string{"pending", "failed"}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		IntentID string `json:"stripe_payment_intent_id"`
+	}
+	if err := json.Unmarshal(result, &rows); err != nil {
+		return err
+	}
+
+	var open []string
+	for _, row := range rows {
+		if row.IntentID == "" {
+			continue
+		}
+		intent, err := payments.Default.GetPaymentIntent(row.IntentID)
+		if err != nil {
+			return err
+		}
+		switch intent.Status {
+		case payments.IntentProcessing, payments.IntentSucceeded:
+			return errIntentInFlight
+		case payments.IntentCanceled:
+		default:
+			open = append(open, intent.ID)
+		}
+	}
+
+	for _, id := range open {
+		if err := payments.Default.CancelPaymentIntent(id); err != nil {
+			return err
+		}
+		database.Query("payments").
+			Update(map[string]interface{}{"status": "failed"}, "", "").
+			Eq("stripe_payment_intent_id", id).
+			Eq("status", "pending").
+			Execute()
+		database.Query("tips").
+			Delete("", "").
+			Eq("stripe_payment_intent_id", id).
+			Eq("status", "pending").
+			Execute()
+	}
+	return nil
+}
+
+// CreateOrderSplit - split an unpaid dine-in order across several payers,
+// evenly, by item, or by custom amounts. Replaces any unpaid split.
+func CreateOrderSplit(c *gin.Context) {
+	orderID := c.Param("id")
+	userID := c.GetString("userId")
+
+	var input struct {
+		Mode   string `json:"mode" binding:"required,oneof=even items custom"`
+		Parts  int    `json:"parts"`
+		Shares []struct {
+			PayerName string       `json:"payer_name"`
+			Items     []orderLine  `json:"items" binding:"omitempty,dive"`
+			Amount    money.Amount `json:"amount" binding:"min=0"`
+		} `json:"shares" binding:"omitempty,dive"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	order, err := loadSplitOrder(orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	// The ordering customer or the restaurant's staff may split the bill
+	if order.CustomerID != userID && verifyOwner(order.RestaurantID, userID) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if order.OrderType != "dine_in" && order.OrderType != "dinein" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only dine-in orders can be split"})
+		return
+	}
+	if order.Status == "cancelled" || order.Status == "rejected" || order.Status == "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is " + order.Status})
+		return
+	}
+	switch order.PaymentStatus {
+	case "unpaid", "pending", "failed":
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order already has payments; its split can no longer change"})
+		return
+	}
+
+	currency := orderCurrency(order.Currency, order.RestaurantID)
+	// Gift card money from checkout is already paid; the shares cover the rest
+	due := order.Total - order.GiftCardAmount
+	totalCents := due.Minor(currency)
+	var amounts []int64
+	names := []string{}
+	itemsByShare := [][]orderLine{}
+
+	switch input.Mode {
+	case "even":
+		if input.Parts < 2 || input.Parts > maxSplitParts {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("parts must be between 2 and %d", maxSplitParts)})
+			return
+		}
+		amounts = evenSplit(totalCents, input.Parts)
+		for i := 0; i < input.Parts; i++ {
+			names = append(names, fmt.Sprintf("Guest %d", i+1))
+			itemsByShare = append(itemsByShare, nil)
+		}
+
+	case "items", "custom":
+		if len(input.Shares) < 2 || len(input.Shares) > maxSplitParts {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("between 2 and %d shares are required", maxSplitParts)})
+			return
+		}
+
+		assigned := map[string]int{}
+		for i, share := range input.Shares {
+			var cents int64
+			if input.Mode == "items" {
+				if len(share.Items) == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Each share needs at least one item"})
+					return
+				}
+				amount, err := orderLinesAmount(order.Items, share.Items)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				for _, line := range share.Items {
+					assigned[line.MenuItemID] += line.Quantity
+				}
+				cents = amount.Minor(currency)
+			} else {
+				cents = share.Amount.Minor(currency)
+			}
+			if cents <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Each share must be greater than zero"})
+				return
+			}
+
+			name := share.PayerName
+			if name == "" {
+				name = fmt.Sprintf("Guest %d", i+1)
+			}
+			amounts = append(amounts, cents)
+			names = append(names, name)
+			itemsByShare = append(itemsByShare, share.Items)
+		}
+
+		// An item can't be split across more units than were ordered
+		for _, item := range order.Items {
+			id, _ := item["menu_item_id"].(string)
+			qty, _ := item["quantity"].(float64)
+			if float64(assigned[id]) > qty {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("item %s is assigned more times than it was ordered", id)})
+				return
+			}
+		}
+
+		var sum int64
+		for _, a := range amounts {
+			sum += a
+		}
+		if input.Mode == "items" && order.Subtotal > 0 {
+			// Items are priced before tax/service; they must cover the whole bill
+			if sum != order.Subtotal.Minor(currency) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":          "Every item on the order must be assigned to a share",
+					"order_subtotal": order.Subtotal,
+					"shares_total":   money.FromMinor(sum, currency),
+				})
+				return
+			}
+			amounts = scaleShares(amounts, totalCents)
+			sum = totalCents
+		}
+		if sum != totalCents {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":        "Shares must add up to the amount due",
+				"amount_due":   due,
+				"shares_total": money.FromMinor(sum, currency),
+			})
+			return
+		}
+	}
+
+	// Checkout's PaymentIntent and the replaced shares' ones must never be
+	// paid on top of the new shares
+	if err := cancelOpenIntents(orderID); err == errIntentInFlight {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to cancel pending payments"})
+		return
+	}
+
+	// Drop the previous (unpaid) split before writing the new one
+	_, _, err = database.Query("order_splits").
+		Delete("", "").
+		Eq("order_id", orderID).
+		Neq("status", "paid").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replace existing split"})
+		return
+	}
+
+	rows := make([]map[string]interface{}, 0, len(amounts))
+	for i, cents := range amounts {
+		rows = append(rows, map[string]interface{}{
+			"order_id":      orderID,
+			"restaurant_id": order.RestaurantID,
+			"position":      i + 1,
+			"payer_name":    names[i],
+			"amount":        money.FromMinor(cents, currency),
+			"items":         itemsByShare[i],
+			"split_mode":    input.Mode,
+			"status":        "pending",
+		})
+	}
+
+	result, _, err := database.Query("order_splits").
+		Insert(rows, false, "", "", "").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to split order"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"data":    result,
+		"message": "Order split created",
+	})
+}
+
+// GetOrderSplits - shares of a split bill and their payment state, for the
+// ordering customer or the restaurant owner
+func GetOrderSplits(c *gin.Context) {
+	orderID := c.Param("id")
+	userID := c.GetString("userId")
+
+	order, err := loadSplitOrder(orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if order.CustomerID != userID && verifyOwner(order.RestaurantID, userID) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	result, _, err := database.Query("order_splits").
+		Select("id, position, payer_name, amount, tip_amount, items, split_mode, status, paid_at", "", false).
+		Eq("order_id", orderID).
+		Order("position", &postgrest.OrderOpts{Ascending: true}).
+		Execute()
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch split"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// PayOrderSplit - any guest at the table pays one share, optionally with a tip
+func PayOrderSplit(c *gin.Context) {
+	orderID := c.Param("id")
+	splitID := c.Param("splitId")
+	userID := c.GetString("userId")
+
+	var input struct {
+		Tip *tipInput `json:"tip"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	result, _, err := database.Query("order_splits").
+		Select("id, restaurant_id, amount, status, stripe_payment_intent_id, order:orders(currency, status)", "", false).
+		Eq("id", splitID).
+		Eq("order_id", orderID).
+		Single().
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Split not found"})
+		return
+	}
+
+	var split struct {
+		ID           string       `json:"id"`
+		RestaurantID string       `json:"restaurant_id"`
+		Amount       money.Amount `json:"amount"`
+		Status       string       `json:"status"`
+		IntentID     string       `json:"stripe_payment_intent_id"`
+		Order        struct {
+			Currency string `json:"currency"`
+			Status   string `json:"status"`
+		} `json:"order"`
+	}
+	if err := json.Unmarshal(result, &split); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read split"})
+		return
+	}
+	if split.Status == "paid" {
+		c.JSON(http.StatusConflict, gin.H{"error": "This share has already been paid"})
+		return
+	}
+	if s := split.Order.Status; s == "cancelled" || s == "rejected" || s == "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is " + s})
+		return
+	}
+	if err := input.Tip.checkEmployee(split.RestaurantID); err == errUnknownEmployee {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check tip employee"})
+		return
+	}
+
+	currency := orderCurrency(split.Order.Currency, split.RestaurantID)
+	tip := input.Tip.amount(split.Amount, currency)
+	baseCents := split.Amount.Minor(currency)
+	tipCents := tip.Minor(currency)
+
+	// Commission is taken on the food, never on the tip
+	destination, applicationFee := payoutDestination(split.RestaurantID, baseCents)
+
+	// A share has one live PaymentIntent. While it is unconfirmed a new tip
+	// only changes its amount; once the guest has confirmed it, paying again
+	// is refused so the share can't be charged twice.
+	var intent *payments.Intent
+	if split.IntentID != "" {
+		existing, err := payments.Default.GetPaymentIntent(split.IntentID)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start payment"})
+			return
+		}
+		switch existing.Status {
+		case payments.IntentProcessing, payments.IntentSucceeded:
+			c.JSON(http.StatusConflict, gin.H{"error": "A payment for this share is already being processed"})
+			return
+		case payments.IntentCanceled:
+			// Start over with a new intent below
+		default:
+			intent = existing
+			if existing.Amount != baseCents+tipCents {
+				if intent, err = payments.Default.UpdatePaymentIntent(existing.ID, baseCents+tipCents, applicationFee); err != nil {
+					c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start payment"})
+					return
+				}
+			}
+		}
+	}
+
+	if intent == nil {
+		intent, err = payments.Default.CreatePaymentIntent(payments.IntentParams{
+			Amount:       baseCents + tipCents,
+			Currency:     currency,
+			OrderID:      orderID,
+			SplitID:      splitID,
+			CustomerID:   userID,
+			RestaurantID: split.RestaurantID,
+			// Keyed on the intent it replaces, so retries don't open a second one
+			IdempotencyKey: fmt.Sprintf("split-%s-%s", splitID, split.IntentID),
+
+			DestinationAccount: destination,
+			ApplicationFee:     applicationFee,
+		})
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to start payment"})
+			return
+		}
+	}
+
+	_, _, err = database.Query("order_splits").
+		Update(map[string]interface{}{
+			"payer_user_id":            userID,
+			"tip_amount":               tip,
+			"stripe_payment_intent_id": intent.ID,
+			"status":                   "pending",
+		}, "", "").
+		Eq("id", splitID).
+		Neq("status", "paid").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	if intent.ID == split.IntentID {
+		// Same intent, possibly with a new tip: bring the pending payment
+		// and tip in line with it
+		_, _, err = database.Query("payments").
+			Update(map[string]interface{}{"amount": split.Amount + tip}, "", "").
+			Eq("stripe_payment_intent_id", intent.ID).
+			Eq("status", "pending").
+			Execute()
+		if err == nil {
+			_, _, err = database.Query("tips").
+				Delete("", "").
+				Eq("stripe_payment_intent_id", intent.ID).
+				Eq("status", "pending").
+				Execute()
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+			return
+		}
+	} else {
+		if split.IntentID != "" {
+			// The replaced intent was cancelled and will never be paid
+			database.Query("payments").
+				Update(map[string]interface{}{"status": "failed"}, "", "").
+				Eq("stripe_payment_intent_id", split.IntentID).
+				Eq("status", "pending").
+				Execute()
+		}
+		if err := insertSplitPayment(userID, orderID, split.Amount+tip, intent, destination, money.FromMinor(applicationFee, currency)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+			return
+		}
+	}
+
+	if input.Tip != nil {
+		if err := recordPendingTip(input.Tip, tip, split.RestaurantID, orderID, splitID, intent.ID); err != nil {
+			log.Printf("⚠️  Failed to record tip for split %s: %v", splitID, err)
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"client_secret":     intent.ClientSecret,
+		"payment_intent_id": intent.ID,
+		"amount":            split.Amount,
+		"tip_amount":        tip,
+	})
+}
+
+// insertSplitPayment records the pending payment behind a share's intent
+func insertSplitPayment(userID, orderID string, amount money.Amount, intent *payments.Intent, destination string, applicationFee money.Amount) error {
+	_, _, err := database.Query("payments").
+		Insert(map[string]interface{}{
+			"user_id":                    userID,
+			"order_id":                   orderID,
+			"amount":                     amount,
+			"currency":                   intent.Currency,
+			"type":                       "order_split",
+			"status":                     "pending",
+			"stripe_payment_intent_id":   intent.ID,
+			"stripe_destination_account": destination,
+			"application_fee":            applicationFee,
+		}, false, "", "", "").
+		Execute()
+	return err
+}
+
+// handleSplitPaymentSucceeded - mark the share paid and settle the order
+// once every share is in
+func handleSplitPaymentSucceeded(pi stripe.PaymentIntent) error {
+	splitID := pi.Metadata["split_id"]
+	orderID := pi.Metadata["order_id"]
+
+	// Cancelled while the guest was paying
+	if refunded, err := refundIfCancelled(orderID, pi); err != nil || refunded {
+		return err
+	}
+
+	result, _, err := database.Query("order_splits").
+		Update(map[string]interface{}{
+			"status":  "paid",
+			"paid_at": time.Now().UTC().Format(time.RFC3339),
+		}, "", "*").
+		Eq("id", splitID).
+		Neq("status", "paid").
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	var splits []struct {
+		RestaurantID string       `json:"restaurant_id"`
+		PayerUserID  string       `json:"payer_user_id"`
+		Amount       money.Amount `json:"amount"`
+		Position     int          `json:"position"`
+	}
+	if err := json.Unmarshal(result, &splits); err != nil {
+		return nil
+	}
+	if len(splits) == 0 {
+		// Already handled, unless the share was replaced by a new split
+		// while the guest was paying; then nothing else would refund it
+		_, count, err := database.Query("order_splits").
+			Select("id", "exact", true).
+			Eq("id", splitID).
+			Execute()
+		if err != nil || count > 0 {
+			return err
+		}
+		return refundPayment(pi, "replaced-split-"+pi.ID)
+	}
+	split := splits[0]
+
+	_, outstanding, err := database.Query("order_splits").
+		Select("id", "exact", true).
+		Eq("order_id", orderID).
+		Neq("status", "paid").
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	paymentStatus := "partially_paid"
+	if outstanding == 0 {
+		paymentStatus = "paid"
+	}
+	_, _, err = database.Query("orders").
+		Update(map[string]interface{}{"payment_status": paymentStatus}, "", "").
+		Eq("id", orderID).
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	if err := recordOnlineTransaction(split.RestaurantID, split.PayerUserID, orderID, money.FromMinor(pi.Amount, string(pi.Currency)), pi); err != nil {
+		log.Printf("⚠️  Failed to record transaction for split %s: %v", splitID, err)
+	}
+	if paymentStatus == "paid" {
+		// A checkout order that was split goes to the kitchen once every
+		// share is in
+		result, _, err := database.Query("orders").
+			Update(map[string]interface{}{"status": "pending"}, "", "").
+			Eq("id", orderID).
+			Eq("status", "awaiting_payment").
+			Execute()
+		if err != nil {
+			log.Printf("⚠️  Failed to release split order %s: %v", orderID, err)
+		}
+		var orders []map[string]interface{}
+		if err == nil && json.Unmarshal(result, &orders) == nil && len(orders) > 0 {
+			releaseToKitchen(orders[0])
+		} else {
+			issueInvoiceAsync(split.RestaurantID, orderID)
+		}
+	}
+
+	realtime.WSHub.SendToUser(split.RestaurantID, realtime.RealtimeMessage{
+		Type: "split_paid",
+		Payload: map[string]interface{}{
+			"order_id":       orderID,
+			"split_id":       splitID,
+			"position":       split.Position,
+			"payment_status": paymentStatus,
+		},
+	})
+	return nil
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/stock"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// ownedStockItem loads an inventory item if it belongs to a restaurant the user owns
+func ownedStockItem(itemID, userID string) (*stock.Item, error) {
+	result, _, err := database.Query("inventory").
+		Select("id, restaurant_id, name, unit, quantity, min_stock, restaurants!inner(owner_id)", "", false).
+		Eq("id", itemID).
+		Eq("restaurants.owner_id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var item stock.Item
+	if err := json.Unmarshal(result, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// alertIfLow sends the low stock alert when a movement takes an item down
+// to its minimum level
+func alertIfLow(ownerID string, item *stock.Item, m *stock.Movement) {
+	if m.CrossedBelow(item.MinStock) {
+		sendLowStockAlert(ownerID, item.Name, m.QuantityAfter)
+		stock.MarkLowStockAlerted(item.ID)
+	}
+}
+
+func stockError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, stock.ErrInvalidKind):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, stock.ErrInsufficient):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, stock.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record stock movement"})
+	}
+}
+
+// RecordStockMovement - owner records stock coming in, going out or being counted.
+// Purchases, usage and waste take a positive quantity; adjustments are signed;
+// transfers move stock to to_inventory_id (e.g. another branch).
+func RecordStockMovement(c *gin.Context) {
+	itemID := c.Param("id")
+	userID := c.GetString("userId")
+
+	item, err := ownedStockItem(itemID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or item not found"})
+		return
+	}
+
+	var input struct {
+		Kind          string       `json:"kind" binding:"required"`
+		Quantity      float64      `json:"quantity" binding:"required"`
+		UnitCost      money.Amount `json:"unit_cost" binding:"min=0"`
+		Reason        string       `json:"reason"`
+		ToInventoryID string       `json:"to_inventory_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if !stock.ValidKind(input.Kind) {
+		stockError(c, stock.ErrInvalidKind)
+		return
+	}
+	kind := stock.Kind(input.Kind)
+
+	if kind == stock.Transfer {
+		if input.ToInventoryID == "" || input.ToInventoryID == itemID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to_inventory_id must be another inventory item"})
+			return
+		}
+		if _, err := ownedStockItem(input.ToInventoryID, userID); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied to destination item"})
+			return
+		}
+		out, in, err := stock.Move(itemID, input.ToInventoryID, input.Quantity, input.Reason, userID)
+		if err != nil {
+			stockError(c, err)
+			return
+		}
+		alertIfLow(userID, item, out)
+		c.JSON(http.StatusCreated, gin.H{
+			"data":    []*stock.Movement{out, in},
+			"message": "Stock transferred",
+		})
+		return
+	}
+
+	m, err := stock.Record(stock.Change{
+		InventoryID: itemID,
+		Kind:        kind,
+		Quantity:    stock.Signed(kind, input.Quantity),
+		UnitCost:    input.UnitCost,
+		Reason:      input.Reason,
+		UserID:      userID,
+	})
+	if err != nil {
+		stockError(c, err)
+		return
+	}
+	alertIfLow(userID, item, m)
+
+	c.JSON(http.StatusCreated, gin.H{"data": m, "message": "Stock movement recorded"})
+}
+
+// GetStockMovements - owner views the movement history of one inventory item
+func GetStockMovements(c *gin.Context) {
+	itemID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if _, err := ownedStockItem(itemID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or item not found"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	movements, err := stock.History(itemID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": movements})
+}
+
+// GetRestaurantStockMovements - owner views movements across a restaurant's
+// inventory, optionally filtered by ?kind= and a ?from= / ?to= date range
+func GetRestaurantStockMovements(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	query := database.Query("inventory_movements").
+		Select("*, inventory(name, unit)", "", false).
+		Eq("restaurant_id", restaurantID)
+	if kind := c.Query("kind"); kind != "" {
+		if !stock.ValidKind(kind) {
+			stockError(c, stock.ErrInvalidKind)
+			return
+		}
+		query = query.Eq("kind", kind)
+	}
+	if from := c.Query("from"); from != "" {
+		query = query.Gte("created_at", from)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Lt("created_at", to)
+	}
+
+	result, _, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// GetStockReconciliation - owner lists items whose quantity doesn't match
+// their movement history
+func GetStockReconciliation(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	drifts, err := stock.Reconcile(restaurantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile inventory"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": drifts})
+}
+
+// ReconcileStock - owner accepts the current quantities and books the
+// differences as adjustments, for all items or the listed inventory_ids
+func ReconcileStock(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var input struct {
+		InventoryIDs []string `json:"inventory_ids"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+			return
+		}
+	}
+	only := map[string]bool{}
+	for _, id := range input.InventoryIDs {
+		only[id] = true
+	}
+
+	drifts, err := stock.Reconcile(restaurantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile inventory"})
+		return
+	}
+
+	booked := []stock.Drift{}
+	for _, d := range drifts {
+		if len(only) > 0 && !only[d.InventoryID] {
+			continue
+		}
+		if err := stock.Book(restaurantID, d, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to book adjustment for " + d.Name})
+			return
+		}
+		booked = append(booked, d)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": booked, "message": "Inventory reconciled"})
+}
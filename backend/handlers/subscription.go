@@ -3,20 +3,75 @@
 import (
 	"encoding/json"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"time"
 
+	"finedine/backend/internal/config"
 	"finedine/backend/internal/database"
+	"finedine/backend/internal/plans"
+	"finedine/backend/internal/webhooks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v76"
+	portalsession "github.com/stripe/stripe-go/v76/billingportal/session"
 	"github.com/stripe/stripe-go/v76/checkout/session"
+	"github.com/stripe/stripe-go/v76/subscription"
 	"github.com/stripe/stripe-go/v76/webhook"
 )
 
 func init() {
 	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+	registerStripeWebhooks()
+}
+
+func frontendBaseURL() string {
+	if url := os.Getenv("FRONTEND_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
+
+// restaurantBilling is the Stripe state stored on a restaurant row
+type restaurantBilling struct {
+	ID                   string     `json:"id"`
+	Plan                 string     `json:"subscription_plan"`
+	Status               string     `json:"subscription_status"`
+	StripeCustomerID     string     `json:"stripe_customer_id"`
+	StripeSubscriptionID string     `json:"stripe_subscription_id"`
+	TrialEndsAt          *time.Time `json:"trial_ends_at"`
+}
+
+// ownedRestaurantBilling loads billing state for a restaurant the user owns
+func ownedRestaurantBilling(restaurantID, userID string) (*restaurantBilling, error) {
+	result, _, err := database.Query("restaurants").
+		Select("id, subscription_plan, subscription_status, stripe_customer_id, stripe_subscription_id, trial_ends_at", "", false).
+		Eq("id", restaurantID).
+		Eq("owner_id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var billing restaurantBilling
+	if err := json.Unmarshal(result, &billing); err != nil {
+		return nil, err
+	}
+	return &billing, nil
+}
+
+// hasLiveSubscription - a Stripe subscription exists and has not ended
+func (b *restaurantBilling) hasLiveSubscription() bool {
+	if b.StripeSubscriptionID == "" {
+		return false
+	}
+	switch b.Status {
+	case "active", "trialing", "past_due", "incomplete", "unpaid":
+		return true
+	}
+	return false
 }
 
 // CreateSubscriptionCheckout - generate a Stripe Checkout session for subscription
@@ -26,6 +81,7 @@ func CreateSubscriptionCheckout(c *gin.Context) {
 
 	var input struct {
 		RestaurantID string `json:"restaurant_id" binding:"required"`
+		Plan         string `json:"plan"`
 		PriceID      string `json:"price_id"`
 	}
 
@@ -34,18 +90,52 @@ func CreateSubscriptionCheckout(c *gin.Context) {
 		return
 	}
 
-	priceID := input.PriceID
-	if priceID == "" {
-		priceID = os.Getenv("STRIPE_ANNUAL_PRICE_ID")
+	// Only prices that belong to a known paid plan may be purchased
+	plan := plans.Get(plans.Pro)
+	switch {
+	case input.Plan != "":
+		plan = plans.Get(input.Plan)
+		if plan.ID != input.Plan || plan.ID == plans.Free {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown plan"})
+			return
+		}
+	case input.PriceID != "":
+		p, ok := plans.ByPriceID(input.PriceID)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown price"})
+			return
+		}
+		plan = p
 	}
+
+	priceID := plan.StripePriceID
 	if priceID == "" {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stripe price ID not configured"})
 		return
 	}
 
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:3000"
+	billing, err := ownedRestaurantBilling(input.RestaurantID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if billing.hasLiveSubscription() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Restaurant already has a subscription. Change the plan instead."})
+		return
+	}
+
+	frontendURL := frontendBaseURL()
+
+	subscriptionData := &stripe.CheckoutSessionSubscriptionDataParams{
+		Metadata: map[string]string{
+			"restaurant_id": input.RestaurantID,
+			"plan":          plan.ID,
+		},
+	}
+	// One trial per restaurant
+	if plan.TrialDays > 0 && billing.TrialEndsAt == nil {
+		subscriptionData.TrialPeriodDays = stripe.Int64(int64(plan.TrialDays))
 	}
 
 	params := &stripe.CheckoutSessionParams{
@@ -56,13 +146,21 @@ func CreateSubscriptionCheckout(c *gin.Context) {
 				Quantity: stripe.Int64(1),
 			},
 		},
-		SuccessURL:    stripe.String(frontendURL + "/subscription/success?session_id={CHECKOUT_SESSION_ID}"),
-		CancelURL:     stripe.String(frontendURL + "/subscription/cancel"),
-		CustomerEmail: stripe.String(email),
+		SuccessURL: stripe.String(frontendURL + "/subscription/success?session_id={CHECKOUT_SESSION_ID}"),
+		CancelURL:  stripe.String(frontendURL + "/subscription/cancel"),
 		Metadata: map[string]string{
 			"user_id":       userID,
 			"restaurant_id": input.RestaurantID,
+			"plan":          plan.ID,
 		},
+		SubscriptionData: subscriptionData,
+	}
+
+	// Reuse the Stripe customer so payment methods and invoices stay together
+	if billing.StripeCustomerID != "" {
+		params.Customer = stripe.String(billing.StripeCustomerID)
+	} else {
+		params.CustomerEmail = stripe.String(email)
 	}
 
 	sess, err := session.New(params)
@@ -74,6 +172,7 @@ func CreateSubscriptionCheckout(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"session_id": sess.ID,
 		"url":        sess.URL,
+		"trial_days": subscriptionData.TrialPeriodDays,
 	})
 }
 
@@ -82,7 +181,8 @@ func GetSubscriptionStatus(c *gin.Context) {
 	userID := c.GetString("userId")
 
 	result, _, err := database.Query("restaurants").
-Select("id, name, subscription_status, subscription_expires_at", "", false).
+		Select("id, name, subscription_plan, subscription_status, current_period_end:subscription_expires_at, "+
+			"subscription_cancel_at_period_end, trial_ends_at, payment_method_status", "", false).
 		Eq("owner_id", userID).
 		Execute()
 
@@ -94,7 +194,185 @@ Select("id, name, subscription_status, subscription_expires_at", "", false).
 	c.JSON(http.StatusOK, gin.H{"data": result})
 }
 
-// StripeWebhook - handle Stripe event callbacks
+// GetPlans - list subscription plans with their features, quotas and rate limits
+func GetPlans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": plans.All()})
+}
+
+// ChangeSubscriptionPlan - upgrade or downgrade with proration.
+// Upgrades are invoiced immediately; downgrades are credited on the next invoice.
+func ChangeSubscriptionPlan(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var input struct {
+		RestaurantID string `json:"restaurant_id" binding:"required"`
+		Plan         string `json:"plan" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "restaurant_id and plan are required"})
+		return
+	}
+
+	plan := plans.Get(input.Plan)
+	if plan.ID != input.Plan || plan.ID == plans.Free {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown plan. To move to free, cancel the subscription."})
+		return
+	}
+	if plan.StripePriceID == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stripe price ID not configured"})
+		return
+	}
+
+	billing, err := ownedRestaurantBilling(input.RestaurantID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if !billing.hasLiveSubscription() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No active subscription to change"})
+		return
+	}
+	if billing.Plan == plan.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Restaurant is already on this plan"})
+		return
+	}
+
+	current, err := subscription.Get(billing.StripeSubscriptionID, nil)
+	if err != nil || current.Items == nil || len(current.Items.Data) == 0 {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to load subscription"})
+		return
+	}
+
+	proration := "create_prorations"
+	if plans.IsUpgrade(billing.Plan, plan.ID) {
+		proration = "always_invoice"
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:    stripe.String(current.Items.Data[0].ID),
+				Price: stripe.String(plan.StripePriceID),
+			},
+		},
+		ProrationBehavior: stripe.String(proration),
+		CancelAtPeriodEnd: stripe.Bool(false),
+	}
+	params.AddMetadata("plan", plan.ID)
+	params.AddMetadata("restaurant_id", billing.ID)
+
+	updated, err := subscription.Update(billing.StripeSubscriptionID, params)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to change plan"})
+		return
+	}
+
+	if err := syncSubscription(updated); err != nil {
+		log.Printf("⚠️  Plan changed in Stripe but local sync failed for %s: %v", billing.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plan":      plan.ID,
+		"proration": proration,
+		"message":   "Subscription plan changed",
+	})
+}
+
+// CancelSubscription - cancel at the end of the current billing period
+func CancelSubscription(c *gin.Context) {
+	setCancelAtPeriodEnd(c, true)
+}
+
+// ResumeSubscription - undo a pending cancellation before the period ends
+func ResumeSubscription(c *gin.Context) {
+	setCancelAtPeriodEnd(c, false)
+}
+
+func setCancelAtPeriodEnd(c *gin.Context, cancel bool) {
+	userID := c.GetString("userId")
+
+	var input struct {
+		RestaurantID string `json:"restaurant_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "restaurant_id is required"})
+		return
+	}
+
+	billing, err := ownedRestaurantBilling(input.RestaurantID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if !billing.hasLiveSubscription() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No active subscription"})
+		return
+	}
+
+	updated, err := subscription.Update(billing.StripeSubscriptionID, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(cancel),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update subscription"})
+		return
+	}
+
+	if err := syncSubscription(updated); err != nil {
+		log.Printf("⚠️  Subscription updated in Stripe but local sync failed for %s: %v", billing.ID, err)
+	}
+
+	message := "Subscription resumed"
+	if cancel {
+		message = "Subscription will cancel at the end of the billing period"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"cancel_at_period_end": updated.CancelAtPeriodEnd,
+		"current_period_end":   time.Unix(updated.CurrentPeriodEnd, 0).UTC(),
+		"message":              message,
+	})
+}
+
+// CreateBillingPortalSession - Stripe-hosted page to manage payment methods and invoices
+func CreateBillingPortalSession(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	var input struct {
+		RestaurantID string `json:"restaurant_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "restaurant_id is required"})
+		return
+	}
+
+	billing, err := ownedRestaurantBilling(input.RestaurantID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if billing.StripeCustomerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Restaurant has no billing account yet"})
+		return
+	}
+
+	sess, err := portalsession.New(&stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(billing.StripeCustomerID),
+		ReturnURL: stripe.String(frontendBaseURL() + "/subscription"),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create billing portal session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": sess.URL})
+}
+
+// StripeWebhook - verify, persist and process a Stripe event.
+// Processing is idempotent per event ID; failures are retried by the
+// webhook retry worker and can be replayed from the admin API.
 func StripeWebhook(c *gin.Context) {
 	const maxBodyBytes = int64(65536)
 	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
@@ -105,91 +383,218 @@ func StripeWebhook(c *gin.Context) {
 		return
 	}
 
-	// Verify webhook signature when secret is configured
-	webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+	cfg := config.Load()
 	var event stripe.Event
 
-	if webhookSecret != "" {
+	switch {
+	case cfg.StripeWebhookSecret != "":
 		sigHeader := c.GetHeader("Stripe-Signature")
-		event, err = webhook.ConstructEvent(payload, sigHeader, webhookSecret)
+		event, err = webhook.ConstructEvent(payload, sigHeader, cfg.StripeWebhookSecret)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook signature verification failed"})
 			return
 		}
-	} else {
+	case !cfg.AllowUnsignedWebhooks:
+		// Fail closed: without a signing secret nothing is trusted
+		log.Println("🚨 STRIPE_WEBHOOK_SECRET is not set — refusing unsigned webhook")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Webhook signing is not configured"})
+		return
+	default:
+		// Explicit local-development bypass (see config.AllowUnsignedWebhooks)
 		if err := json.Unmarshal(payload, &event); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
 			return
 		}
 	}
 
-	switch event.Type {
+	if event.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+
+	status, err := webhooks.Receive(event, payload)
+	if err != nil && status != webhooks.StatusDeadLetter {
+		// Non-2xx makes Stripe redeliver as well; the event log dedupes
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		return
+	}
 
-	case "checkout.session.completed":
-		var sess stripe.CheckoutSession
-		if err := json.Unmarshal(event.Data.Raw, &sess); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Error parsing checkout session"})
-			return
+	c.JSON(http.StatusOK, gin.H{"received": true, "status": status})
+}
+
+// registerStripeWebhooks wires event types to their handlers
+func registerStripeWebhooks() {
+	webhooks.Register("payment_intent.succeeded", func(event stripe.Event) error {
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return err
 		}
-		restaurantID := sess.Metadata["restaurant_id"]
-		if restaurantID == "" {
-			break
+		return handlePaymentIntentSucceeded(pi)
+	})
+	webhooks.Register("payment_intent.payment_failed", func(event stripe.Event) error {
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return err
 		}
-		expiresAt := time.Now().AddDate(1, 0, 0)
-		database.Query("restaurants").
-			Update(map[string]interface{}{
-				"subscription_status":     "active",
-				"subscription_expires_at": expiresAt.Format(time.RFC3339),
-				"stripe_customer_id":      sess.Customer,
-				"stripe_subscription_id":  sess.Subscription,
-			}, "", "").
-			Eq("id", restaurantID).
-			Execute()
+		return handlePaymentIntentFailed(pi)
+	})
+	webhooks.Register("checkout.session.completed", handleCheckoutSessionCompleted)
+	webhooks.Register("customer.subscription.created", handleSubscriptionUpdated)
+	webhooks.Register("customer.subscription.updated", handleSubscriptionUpdated)
+	webhooks.Register("customer.subscription.deleted", handleSubscriptionDeleted)
+	webhooks.Register("invoice.paid", invoicePaymentState("ok", ""))
+	webhooks.Register("invoice.payment_failed", invoicePaymentState("failed", "past_due"))
+	webhooks.Register("invoice.payment_action_required", invoicePaymentState("requires_action", ""))
+	webhooks.Register("account.updated", handleAccountUpdated)
+}
 
-	case "customer.subscription.deleted":
-		var sub stripe.Subscription
-		if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
-			break
-		}
-		// Find by stripe_subscription_id and deactivate
-		database.Query("restaurants").
-			Update(map[string]interface{}{
-				"subscription_status": "expired",
-			}, "", "").
-			Eq("stripe_subscription_id", string(sub.ID)).
-			Execute()
+// paymentMethodState summarises whether the subscription can be charged
+func paymentMethodState(sub *stripe.Subscription) string {
+	switch sub.Status {
+	case stripe.SubscriptionStatusPastDue, stripe.SubscriptionStatusUnpaid:
+		return "failed"
+	case stripe.SubscriptionStatusIncomplete:
+		return "requires_action"
+	}
+	if sub.DefaultPaymentMethod == nil && sub.DefaultSource == nil {
+		return "missing"
+	}
+	return "ok"
+}
 
-	case "customer.subscription.updated":
-		var sub stripe.Subscription
-		if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
-			break
-		}
-		status := "active"
-		if sub.Status != stripe.SubscriptionStatusActive {
-			status = string(sub.Status)
+// syncSubscription copies a Stripe subscription onto its restaurant row.
+// The period end comes from Stripe, never from a locally assumed term.
+func syncSubscription(sub *stripe.Subscription) error {
+	updates := map[string]interface{}{
+		"subscription_status":               string(sub.Status),
+		"subscription_cancel_at_period_end": sub.CancelAtPeriodEnd,
+		"stripe_subscription_id":            sub.ID,
+		"payment_method_status":             paymentMethodState(sub),
+	}
+	if sub.CurrentPeriodEnd > 0 {
+		updates["subscription_expires_at"] = time.Unix(sub.CurrentPeriodEnd, 0).UTC().Format(time.RFC3339)
+	}
+	if sub.TrialEnd > 0 {
+		updates["trial_ends_at"] = time.Unix(sub.TrialEnd, 0).UTC().Format(time.RFC3339)
+	}
+	if sub.Customer != nil {
+		updates["stripe_customer_id"] = sub.Customer.ID
+	}
+	if sub.Items != nil && len(sub.Items.Data) > 0 && sub.Items.Data[0].Price != nil {
+		if plan, ok := plans.ByPriceID(sub.Items.Data[0].Price.ID); ok {
+			updates["subscription_plan"] = plan.ID
 		}
-		database.Query("restaurants").
-			Update(map[string]interface{}{
-				"subscription_status": status,
-			}, "", "").
-			Eq("stripe_subscription_id", string(sub.ID)).
-			Execute()
+	}
 
-	case "invoice.payment_failed":
+	query := database.Query("restaurants").Update(updates, "", "id")
+	if restaurantID := sub.Metadata["restaurant_id"]; restaurantID != "" {
+		query = query.Eq("id", restaurantID)
+	} else {
+		query = query.Eq("stripe_subscription_id", sub.ID)
+	}
+
+	result, _, err := query.Execute()
+	if err != nil {
+		return err
+	}
+	forgetRestaurantPlans(result)
+	return nil
+}
+
+func handleCheckoutSessionCompleted(event stripe.Event) error {
+	var sess stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &sess); err != nil {
+		return err
+	}
+	if sess.Metadata["restaurant_id"] == "" || sess.Subscription == nil {
+		return nil
+	}
+
+	// The session only carries IDs; fetch the subscription for its real period
+	sub, err := subscription.Get(sess.Subscription.ID, nil)
+	if err != nil {
+		return err
+	}
+	if sub.Metadata == nil {
+		sub.Metadata = map[string]string{}
+	}
+	sub.Metadata["restaurant_id"] = sess.Metadata["restaurant_id"]
+	return syncSubscription(sub)
+}
+
+func handleSubscriptionUpdated(event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return err
+	}
+	return syncSubscription(&sub)
+}
+
+func handleSubscriptionDeleted(event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"subscription_status":               "expired",
+		"subscription_cancel_at_period_end": false,
+	}
+	if sub.EndedAt > 0 {
+		updates["subscription_expires_at"] = time.Unix(sub.EndedAt, 0).UTC().Format(time.RFC3339)
+	}
+
+	result, _, err := database.Query("restaurants").
+		Update(updates, "", "id").
+		Eq("stripe_subscription_id", sub.ID).
+		Execute()
+	if err != nil {
+		return err
+	}
+	forgetRestaurantPlans(result)
+	return nil
+}
+
+// invoicePaymentState records the outcome of a subscription charge.
+// status, when set, also moves the subscription (e.g. to past_due).
+func invoicePaymentState(paymentState, status string) webhooks.Handler {
+	return func(event stripe.Event) error {
 		var inv stripe.Invoice
 		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
-			break
+			return err
+		}
+		if inv.Customer == nil || inv.Subscription == nil {
+			return nil
 		}
-		// Mark subscription as past_due so owner sees it in the dashboard
-		database.Query("restaurants").
-			Update(map[string]interface{}{
-				"subscription_status": "past_due",
-			}, "", "").
-			Eq("stripe_customer_id", string(inv.Customer.ID)).
+
+		updates := map[string]interface{}{
+			"payment_method_status": paymentState,
+		}
+		if status != "" {
+			updates["subscription_status"] = status
+		}
+
+		result, _, err := database.Query("restaurants").
+			Update(updates, "", "id").
+			Eq("stripe_customer_id", inv.Customer.ID).
 			Execute()
+		if err != nil {
+			return err
+		}
+		forgetRestaurantPlans(result)
+		return nil
 	}
-
-	c.JSON(http.StatusOK, gin.H{"received": true})
 }
 
-
+// forgetRestaurantPlans busts cached plans for restaurants returned by an update
+func forgetRestaurantPlans(result []byte) {
+	var rows []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(result, &rows); err != nil {
+		return
+	}
+	for _, r := range rows {
+		plans.Forget(r.ID)
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errUnknownEmployee = errors.New("tip employee does not work at this restaurant")
+
+// tipInput.Value is a percentage for "percentage" tips and an amount for "fixed"
+type tipInput struct {
+	Type       string       `json:"type" binding:"omitempty,oneof=percentage fixed"`
+	Value      money.Amount `json:"value" binding:"min=0"`
+	EmployeeID string       `json:"employee_id"`
+}
+
+// amount returns the tip on base, rounded to the currency's minor unit
+func (t *tipInput) amount(base money.Amount, currency string) money.Amount {
+	if t == nil || t.Value <= 0 {
+		return 0
+	}
+	tip := t.Value
+	if t.Type == "percentage" {
+		tip = base.Percent(math.Min(t.Value.Float64(), 100))
+	}
+	return tip.Round(currency)
+}
+
+// checkEmployee reports whether a tip's named employee works at the restaurant
+func (t *tipInput) checkEmployee(restaurantID string) error {
+	if t == nil || t.EmployeeID == "" {
+		return nil
+	}
+	_, count, err := database.Query("employees").
+		Select("id", "exact", true).
+		Eq("id", t.EmployeeID).
+		Eq("restaurant_id", restaurantID).
+		Execute()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUnknownEmployee
+	}
+	return nil
+}
+
+// recordPendingTip stores a tip before it is paid; it is counted once the
+// PaymentIntent succeeds
+func recordPendingTip(tip *tipInput, amount money.Amount, restaurantID, orderID, splitID, paymentIntentID string) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	tipType := tip.Type
+	if tipType == "" {
+		tipType = "fixed"
+	}
+
+	row := map[string]interface{}{
+		"restaurant_id":            restaurantID,
+		"order_id":                 orderID,
+		"amount":                   amount,
+		"tip_type":                 tipType,
+		"tip_value":                tip.Value,
+		"status":                   "pending",
+		"stripe_payment_intent_id": paymentIntentID,
+	}
+	if splitID != "" {
+		row["split_id"] = splitID
+	}
+	if tip.EmployeeID != "" {
+		row["employee_id"] = tip.EmployeeID
+	}
+
+	_, _, err := database.Query("tips").
+		Insert(row, false, "", "", "").
+		Execute()
+	return err
+}
+
+// markTipsPaid confirms tips attached to a succeeded PaymentIntent
+func markTipsPaid(paymentIntentID string) error {
+	_, _, err := database.Query("tips").
+		Update(map[string]interface{}{"status": "paid"}, "", "").
+		Eq("stripe_payment_intent_id", paymentIntentID).
+		Eq("status", "pending").
+		Execute()
+	return err
+}
+
+// shiftHours parses "HH:MM" start/end times; overnight shifts wrap
+func shiftHours(start, end string) float64 {
+	s, err1 := time.Parse("15:04", strings.TrimSpace(start))
+	e, err2 := time.Parse("15:04", strings.TrimSpace(end))
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	d := e.Sub(s)
+	if d <= 0 {
+		d += 24 * time.Hour
+	}
+	return d.Hours()
+}
+
+// GetTipDistribution - split paid tips across staff for ?from=&to= (YYYY-MM-DD).
+// Tips for a named employee go to them; the rest are pooled per day and
+// shared by hours worked on that day's shifts.
+func GetTipDistribution(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -7)
+	if raw := c.Query("from"); raw != "" {
+		if t, err := time.Parse("2006-01-02", raw); err == nil {
+			from = t
+		}
+	}
+	if raw := c.Query("to"); raw != "" {
+		if t, err := time.Parse("2006-01-02", raw); err == nil {
+			to = t.AddDate(0, 0, 1)
+		}
+	}
+
+	tipsResult, _, err := database.Query("tips").
+		Select("amount, employee_id, created_at", "", false).
+		Eq("restaurant_id", restaurantID).
+		Eq("status", "paid").
+		Gte("created_at", from.Format(time.RFC3339)).
+		Lt("created_at", to.Format(time.RFC3339)).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tips"})
+		return
+	}
+
+	shiftsResult, _, err := database.Query("shifts").
+		Select("employee_id, shift_date, start_time, end_time, employee:employees(id, name)", "", false).
+		Eq("restaurant_id", restaurantID).
+		Gte("shift_date", from.Format("2006-01-02")).
+		Lt("shift_date", to.Format("2006-01-02")).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shifts"})
+		return
+	}
+
+	var tips []struct {
+		Amount     money.Amount `json:"amount"`
+		EmployeeID string       `json:"employee_id"`
+		CreatedAt  time.Time    `json:"created_at"`
+	}
+	var shifts []struct {
+		EmployeeID string `json:"employee_id"`
+		ShiftDate  string `json:"shift_date"`
+		StartTime  string `json:"start_time"`
+		EndTime    string `json:"end_time"`
+		Employee   *struct {
+			Name string `json:"name"`
+		} `json:"employee"`
+	}
+	json.Unmarshal(tipsResult, &tips)
+	json.Unmarshal(shiftsResult, &shifts)
+
+	type staffShare struct {
+		EmployeeID string       `json:"employee_id"`
+		Name       string       `json:"name"`
+		Hours      float64      `json:"hours"`
+		DirectTips money.Amount `json:"direct_tips"`
+		PooledTips money.Amount `json:"pooled_tips"`
+		Total      money.Amount `json:"total"`
+	}
+	staff := map[string]*staffShare{}
+	share := func(id string) *staffShare {
+		if s, ok := staff[id]; ok {
+			return s
+		}
+		s := &staffShare{EmployeeID: id}
+		staff[id] = s
+		return s
+	}
+
+	// hours[date][employee]
+	hours := map[string]map[string]float64{}
+	for _, sh := range shifts {
+		h := shiftHours(sh.StartTime, sh.EndTime)
+		if h <= 0 {
+			continue
+		}
+		date := sh.ShiftDate
+		if len(date) > 10 {
+			date = date[:10]
+		}
+		if hours[date] == nil {
+			hours[date] = map[string]float64{}
+		}
+		hours[date][sh.EmployeeID] += h
+
+		s := share(sh.EmployeeID)
+		s.Hours += h
+		if sh.Employee != nil {
+			s.Name = sh.Employee.Name
+		}
+	}
+
+	var totalTips, undistributed money.Amount
+	pooled := map[string]money.Amount{}
+	for _, t := range tips {
+		totalTips += t.Amount
+		if t.EmployeeID != "" {
+			share(t.EmployeeID).DirectTips += t.Amount
+			continue
+		}
+		pooled[t.CreatedAt.UTC().Format("2006-01-02")] += t.Amount
+	}
+
+	for date, amount := range pooled {
+		var dayHours float64
+		for _, h := range hours[date] {
+			dayHours += h
+		}
+		if dayHours == 0 {
+			undistributed += amount
+			continue
+		}
+		for employeeID, h := range hours[date] {
+			share(employeeID).PooledTips += amount.MulFloat(h / dayHours)
+		}
+	}
+
+	currency := restaurantCurrency(restaurantID)
+	result := make([]*staffShare, 0, len(staff))
+	for _, s := range staff {
+		s.Hours = math.Round(s.Hours*100) / 100
+		s.DirectTips = s.DirectTips.Round(currency)
+		s.PooledTips = s.PooledTips.Round(currency)
+		s.Total = s.DirectTips + s.PooledTips
+		result = append(result, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"from":          from.Format("2006-01-02"),
+			"to":            to.AddDate(0, 0, -1).Format("2006-01-02"),
+			"currency":      currency,
+			"total_tips":    totalTips.Round(currency),
+			"undistributed": undistributed.Round(currency),
+			"staff":         result,
+		},
+	})
+}
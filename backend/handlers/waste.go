@@ -0,0 +1,535 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/deals"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/stock"
+
+	"github.com/gin-gonic/gin"
+	"github.com/supabase-community/postgrest-go"
+)
+
+// wasteInput is a food waste entry as the owner records it. Entries linked
+// to an inventory item take its name, category, unit and cost by default.
+type wasteInput struct {
+	ItemName        string        `json:"item_name"`
+	Category        string        `json:"category"`
+	Quantity        float64       `json:"quantity" binding:"required,gt=0"`
+	Unit            string        `json:"unit"`
+	Reason          string        `json:"reason" binding:"required,oneof=expired spoiled overproduction customer_return preparation_error other"`
+	CostPerUnit     *money.Amount `json:"cost_per_unit" binding:"omitempty,min=0"`
+	Date            string        `json:"date"`
+	Time            string        `json:"time"`
+	RecordedBy      string        `json:"recorded_by"`
+	Notes           string        `json:"notes"`
+	InventoryItemID string        `json:"inventory_item_id"`
+}
+
+type wasteItem struct {
+	stock.Item
+	Category    string       `json:"category"`
+	CostPerUnit money.Amount `json:"cost_per_unit"`
+}
+
+type wasteEntry struct {
+	ID              string       `json:"id"`
+	RestaurantID    string       `json:"restaurant_id"`
+	ItemName        string       `json:"item_name"`
+	Category        string       `json:"category"`
+	Quantity        float64      `json:"quantity"`
+	Unit            string       `json:"unit"`
+	Reason          string       `json:"reason"`
+	TotalCost       money.Amount `json:"total_cost"`
+	Date            string       `json:"date"`
+	InventoryItemID *string      `json:"inventory_item_id"`
+}
+
+// wasteRow turns the input into a food_waste row, filling in from the linked
+// inventory item and computing total_cost
+func wasteRow(restaurantID, userID string, in wasteInput, item *wasteItem) (map[string]interface{}, float64, error) {
+	stockQty := 0.0
+	if item != nil {
+		if in.ItemName == "" {
+			in.ItemName = item.Name
+		}
+		if in.Category == "" {
+			in.Category = item.Category
+		}
+		if in.Unit == "" {
+			in.Unit = item.Unit
+		}
+		qty, ok := stock.Convert(in.Quantity, in.Unit, item.Unit)
+		if !ok {
+			return nil, 0, fmt.Errorf("%s is stocked in %s and can't be measured in %s", item.Name, item.Unit, in.Unit)
+		}
+		stockQty = qty
+		if in.CostPerUnit == nil {
+			perWasteUnit, _ := stock.Convert(1, in.Unit, item.Unit)
+			cost := item.CostPerUnit.MulFloat(perWasteUnit)
+			in.CostPerUnit = &cost
+		}
+	}
+	if in.ItemName == "" {
+		return nil, 0, errors.New("item_name is required when no inventory item is linked")
+	}
+
+	var costPerUnit money.Amount
+	if in.CostPerUnit != nil {
+		costPerUnit = *in.CostPerUnit
+	}
+	if in.Date == "" {
+		in.Date = time.Now().In(deals.Location(restaurantTimezone(restaurantID))).Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", in.Date); err != nil {
+		return nil, 0, errors.New("date must be YYYY-MM-DD")
+	}
+	if in.RecordedBy == "" {
+		in.RecordedBy = userID
+	}
+
+	row := map[string]interface{}{
+		"restaurant_id":     restaurantID,
+		"item_name":         in.ItemName,
+		"category":          in.Category,
+		"quantity":          in.Quantity,
+		"unit":              in.Unit,
+		"reason":            in.Reason,
+		"cost_per_unit":     costPerUnit,
+		"total_cost":        costPerUnit.MulFloat(in.Quantity).Round(restaurantCurrency(restaurantID)),
+		"date":              in.Date,
+		"time":              in.Time,
+		"recorded_by":       in.RecordedBy,
+		"notes":             in.Notes,
+		"inventory_item_id": nil,
+	}
+	if item != nil {
+		row["inventory_item_id"] = item.ID
+	}
+	return row, stockQty, nil
+}
+
+// restaurantTimezone is the restaurant's IANA timezone, "" when unknown
+func restaurantTimezone(restaurantID string) string {
+	result, _, err := database.Query("restaurants").
+		Select("timezone", "", false).
+		Eq("id", restaurantID).
+		Single().
+		Execute()
+	if err != nil {
+		return ""
+	}
+	var r struct {
+		Timezone string `json:"timezone"`
+	}
+	json.Unmarshal(result, &r)
+	return r.Timezone
+}
+
+// loadWasteItem loads a linked inventory item of the restaurant
+func loadWasteItem(restaurantID, itemID string) (*wasteItem, error) {
+	if itemID == "" {
+		return nil, nil
+	}
+	result, _, err := database.Query("inventory").
+		Select("id, restaurant_id, name, unit, quantity, min_stock, category, cost_per_unit", "", false).
+		Eq("id", itemID).
+		Eq("restaurant_id", restaurantID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, errors.New("inventory item not found in this restaurant")
+	}
+	var item wasteItem
+	if err := json.Unmarshal(result, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// takeWastedStock records the waste movement for a linked entry
+func takeWastedStock(ownerID, wasteID string, item *wasteItem, quantity float64, reason string) error {
+	m, err := stock.Record(stock.Change{
+		InventoryID: item.ID,
+		Kind:        stock.Waste,
+		Quantity:    -quantity,
+		Reason:      "Food waste: " + reason,
+		UserID:      ownerID,
+		ReferenceID: wasteID,
+	})
+	if err != nil {
+		return err
+	}
+	alertIfLow(ownerID, &item.Item, m)
+	return nil
+}
+
+// returnWastedStock puts back the stock a waste entry took, when the entry
+// is corrected or deleted, and reports how much went back to which item
+func returnWastedStock(userID, wasteID, note string) (map[string]float64, error) {
+	result, _, err := database.Query("inventory_movements").
+		Select("inventory_id, quantity", "", false).
+		Eq("reference_id", wasteID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var movements []struct {
+		InventoryID string  `json:"inventory_id"`
+		Quantity    float64 `json:"quantity"`
+	}
+	if err := json.Unmarshal(result, &movements); err != nil {
+		return nil, err
+	}
+	balance := map[string]float64{}
+	for _, m := range movements {
+		balance[m.InventoryID] += m.Quantity
+	}
+	returned := map[string]float64{}
+	for inventoryID, qty := range balance {
+		if qty >= 0 {
+			continue
+		}
+		if _, err := stock.Record(stock.Change{
+			InventoryID: inventoryID,
+			Kind:        stock.Adjustment,
+			Quantity:    -qty,
+			Reason:      note,
+			UserID:      userID,
+			ReferenceID: wasteID,
+		}); err != nil {
+			return returned, err
+		}
+		returned[inventoryID] = -qty
+	}
+	return returned, nil
+}
+
+// ownedWasteEntry returns the restaurant of a waste entry the user owns
+func ownedWasteEntry(wasteID, userID string) (string, error) {
+	result, _, err := database.Query("food_waste").
+		Select("restaurant_id, restaurants!inner(owner_id)", "", false).
+		Eq("id", wasteID).
+		Eq("restaurants.owner_id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return "", err
+	}
+	var entry struct {
+		RestaurantID string `json:"restaurant_id"`
+	}
+	if err := json.Unmarshal(result, &entry); err != nil {
+		return "", err
+	}
+	return entry.RestaurantID, nil
+}
+
+func wasteStockError(c *gin.Context, err error) {
+	if errors.Is(err, stock.ErrInsufficient) {
+		c.JSON(http.StatusConflict, gin.H{"error": "More waste recorded than there is in stock"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inventory for waste entry"})
+}
+
+// GetFoodWaste - owner lists waste entries, filtered by ?from= / ?to= dates,
+// ?reason= and ?category=
+func GetFoodWaste(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	query := database.Query("food_waste").
+		Select("*", "", false).
+		Eq("restaurant_id", restaurantID)
+	if from := c.Query("from"); from != "" {
+		query = query.Gte("date", from)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Lte("date", to)
+	}
+	if reason := c.Query("reason"); reason != "" {
+		query = query.Eq("reason", reason)
+	}
+	if category := c.Query("category"); category != "" {
+		query = query.Eq("category", category)
+	}
+
+	result, _, err := query.
+		Order("date", &postgrest.OrderOpts{Ascending: false}).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch food waste"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// CreateFoodWaste - owner records wasted food; a linked inventory item loses
+// the wasted quantity
+func CreateFoodWaste(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	var input wasteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	item, err := loadWasteItem(restaurantID, input.InventoryItemID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	row, stockQty, err := wasteRow(restaurantID, userID, input, item)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, _, err := database.Query("food_waste").
+		Insert(row, false, "", "", "").
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record food waste"})
+		return
+	}
+	var entries []wasteEntry
+	if err := json.Unmarshal(result, &entries); err != nil || len(entries) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record food waste"})
+		return
+	}
+
+	if item != nil {
+		if err := takeWastedStock(userID, entries[0].ID, item, stockQty, input.Reason); err != nil {
+			database.Query("food_waste").Delete("", "").Eq("id", entries[0].ID).Execute()
+			wasteStockError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": result, "message": "Food waste recorded"})
+}
+
+// UpdateFoodWaste - owner corrects a waste entry (full replacement). Stock
+// taken for the old entry is put back and taken again for the new one.
+func UpdateFoodWaste(c *gin.Context) {
+	wasteID := c.Param("id")
+	userID := c.GetString("userId")
+
+	restaurantID, err := ownedWasteEntry(wasteID, userID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or entry not found"})
+		return
+	}
+
+	var input wasteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	item, err := loadWasteItem(restaurantID, input.InventoryItemID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	row, stockQty, err := wasteRow(restaurantID, userID, input, item)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	delete(row, "restaurant_id")
+
+	returned, err := returnWastedStock(userID, wasteID, "Waste entry corrected")
+	if err != nil {
+		wasteStockError(c, err)
+		return
+	}
+	if item != nil {
+		if err := takeWastedStock(userID, wasteID, item, stockQty, input.Reason); err != nil {
+			// Keep the old entry's stock out, as before the correction
+			for inventoryID, qty := range returned {
+				stock.Record(stock.Change{
+					InventoryID: inventoryID,
+					Kind:        stock.Waste,
+					Quantity:    -qty,
+					Reason:      "Waste entry correction failed",
+					UserID:      userID,
+					ReferenceID: wasteID,
+				})
+			}
+			wasteStockError(c, err)
+			return
+		}
+	}
+
+	result, _, err := database.Query("food_waste").
+		Update(row, "", "*").
+		Eq("id", wasteID).
+		Execute()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update food waste"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result, "message": "Food waste updated"})
+}
+
+// DeleteFoodWaste - owner removes a waste entry and gets its stock back
+func DeleteFoodWaste(c *gin.Context) {
+	wasteID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if _, err := ownedWasteEntry(wasteID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied or entry not found"})
+		return
+	}
+
+	if _, err := returnWastedStock(userID, wasteID, "Waste entry deleted"); err != nil {
+		wasteStockError(c, err)
+		return
+	}
+
+	if _, _, err := database.Query("food_waste").Delete("", "").Eq("id", wasteID).Execute(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete food waste"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Food waste deleted"})
+}
+
+/* ---- REPORTS ---- */
+
+// wasteSince loads the restaurant's waste entries dated from (YYYY-MM-DD) on
+func wasteSince(restaurantID, from string) ([]wasteEntry, error) {
+	result, _, err := database.Query("food_waste").
+		Select("id, restaurant_id, item_name, category, quantity, unit, reason, total_cost, date, inventory_item_id", "", false).
+		Eq("restaurant_id", restaurantID).
+		Gte("date", from).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	entries := []wasteEntry{}
+	if err := json.Unmarshal(result, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+type wasteBucket struct {
+	Key     string       `json:"key"`
+	Entries int          `json:"entries"`
+	Cost    money.Amount `json:"total_cost"`
+}
+
+// bucketWaste sums entries by key, most costly first
+func bucketWaste(entries []wasteEntry, key func(wasteEntry) string) []wasteBucket {
+	byKey := map[string]*wasteBucket{}
+	for _, e := range entries {
+		k := key(e)
+		b, ok := byKey[k]
+		if !ok {
+			b = &wasteBucket{Key: k}
+			byKey[k] = b
+		}
+		b.Entries++
+		b.Cost += e.TotalCost
+	}
+	out := make([]wasteBucket, 0, len(byKey))
+	for _, b := range byKey {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Cost != out[j].Cost {
+			return out[i].Cost > out[j].Cost
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// wastePeriodStart is the first date a week / month / year report covers
+func wastePeriodStart(period string, now time.Time) time.Time {
+	switch period {
+	case "month":
+		return now.AddDate(0, -1, 0)
+	case "year":
+		return now.AddDate(-1, 0, 0)
+	}
+	return now.AddDate(0, 0, -7)
+}
+
+// GetFoodWasteReport - owner waste totals for ?period=week|month|year, by
+// reason, category, item and day (month for a yearly report)
+func GetFoodWasteReport(c *gin.Context) {
+	restaurantID := c.Param("id")
+	userID := c.GetString("userId")
+
+	if err := verifyOwner(restaurantID, userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	period := c.DefaultQuery("period", "week")
+	if period != "month" && period != "year" {
+		period = "week"
+	}
+	now := time.Now().In(deals.Location(restaurantTimezone(restaurantID)))
+	from := wastePeriodStart(period, now).Format("2006-01-02")
+
+	entries, err := wasteSince(restaurantID, from)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build waste report"})
+		return
+	}
+
+	var total money.Amount
+	for _, e := range entries {
+		total += e.TotalCost
+	}
+
+	timeline := bucketWaste(entries, func(e wasteEntry) string {
+		if period == "year" && len(e.Date) >= 7 {
+			return e.Date[:7]
+		}
+		return e.Date
+	})
+	sort.Slice(timeline, func(i, j int) bool { return timeline[i].Key < timeline[j].Key })
+
+	topItems := bucketWaste(entries, func(e wasteEntry) string { return e.ItemName })
+	if len(topItems) > 10 {
+		topItems = topItems[:10]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"period":      period,
+		"start_date":  from,
+		"end_date":    now.Format("2006-01-02"),
+		"currency":    restaurantCurrency(restaurantID),
+		"entries":     len(entries),
+		"total_cost":  total,
+		"by_reason":   bucketWaste(entries, func(e wasteEntry) string { return e.Reason }),
+		"by_category": bucketWaste(entries, func(e wasteEntry) string { return e.Category }),
+		"top_items":   topItems,
+		"timeline":    timeline,
+	}})
+}
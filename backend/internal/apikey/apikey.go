@@ -0,0 +1,222 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+)
+
+/*
+-----------------------------------------------------
+RESTAURANT API KEYS (POS INTEGRATIONS)
+-----------------------------------------------------
+- Keys look like fd_live_<prefix>_<secret>
+- Only a SHA-256 hash is stored; the plaintext is shown once
+- Each key is bound to one restaurant and a set of scopes
+- Lookups are cached in Redis for a short time
+*/
+
+const (
+	keyPrefix     = "fd_live_"
+	lookupTTL     = time.Minute
+	touchInterval = 5 * time.Minute
+)
+
+type Scope string
+
+const (
+	ScopeOrdersRead        Scope = "orders:read"
+	ScopeOrdersWrite       Scope = "orders:write"
+	ScopeBookingsRead      Scope = "bookings:read"
+	ScopeInventoryRead     Scope = "inventory:read"
+	ScopeTransactionsRead  Scope = "transactions:read"
+	ScopeTransactionsWrite Scope = "transactions:write"
+)
+
+// AllScopes lists every scope an owner may grant
+var AllScopes = []Scope{
+	ScopeOrdersRead,
+	ScopeOrdersWrite,
+	ScopeBookingsRead,
+	ScopeInventoryRead,
+	ScopeTransactionsRead,
+	ScopeTransactionsWrite,
+}
+
+// RouteScopes maps "METHOD /full/route" to the scope an API key needs.
+// Owner routes missing from this table are only reachable with a JWT.
+var RouteScopes = map[string]Scope{
+	"GET /api/v1/owner/restaurants/:id/orders":                   ScopeOrdersRead,
+	"POST /api/v1/owner/restaurants/:id/orders":                  ScopeOrdersWrite,
+	"PATCH /api/v1/owner/restaurants/:id/orders/:orderId/status": ScopeOrdersWrite,
+	"GET /api/v1/owner/restaurants/:id/bookings":                 ScopeBookingsRead,
+	"GET /api/v1/owner/restaurants/:id/inventory":                ScopeInventoryRead,
+	"GET /api/v1/owner/restaurants/:id/transactions":             ScopeTransactionsRead,
+	"POST /api/v1/owner/restaurants/:id/transactions":            ScopeTransactionsWrite,
+}
+
+type Key struct {
+	ID           string     `json:"id"`
+	RestaurantID string     `json:"restaurant_id"`
+	OwnerID      string     `json:"owner_id"`
+	Name         string     `json:"name"`
+	KeyPrefix    string     `json:"key_prefix"`
+	Scopes       []string   `json:"scopes"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *Key) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == string(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUsable reports whether the key is neither revoked nor expired
+func (k *Key) IsUsable() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt)
+}
+
+/*
+-----------------------------------------------------
+GENERATION & HASHING
+-----------------------------------------------------
+*/
+
+// Generate returns a new plaintext key, its public prefix and its hash
+func Generate() (plaintext, prefix, hash string, err error) {
+	prefixBytes := make([]byte, 4)
+	secretBytes := make([]byte, 24)
+	if _, err = rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err = rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(prefixBytes)
+	plaintext = keyPrefix + prefix + "_" + hex.EncodeToString(secretBytes)
+	return plaintext, prefix, Hash(plaintext), nil
+}
+
+// Hash returns the hex SHA-256 of a plaintext key
+func Hash(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateScopes rejects unknown scope names
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, s := range scopes {
+		known := false
+		for _, a := range AllScopes {
+			if s == string(a) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown scope: %s", s)
+		}
+	}
+	return nil
+}
+
+/*
+-----------------------------------------------------
+LOOKUP (called from OwnerAuth)
+-----------------------------------------------------
+*/
+
+func lookupKey(hash string) string {
+	return "apikey:" + hash
+}
+
+// Lookup resolves a plaintext key to its record. Revoked and expired keys
+// are returned as errors.
+func Lookup(plaintext string) (*Key, error) {
+	if !strings.HasPrefix(plaintext, keyPrefix) {
+		return nil, fmt.Errorf("malformed API key")
+	}
+
+	hash := Hash(plaintext)
+
+	var key Key
+	if err := cache.SafeGet(lookupKey(hash), &key); err != nil {
+		result, _, err := database.Query("api_keys").
+			Select("id, restaurant_id, owner_id, name, key_prefix, scopes, last_used_at, expires_at, revoked_at, created_at", "", false).
+			Eq("key_hash", hash).
+			Single().
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("API key not found")
+		}
+		if err := json.Unmarshal(result, &key); err != nil {
+			return nil, err
+		}
+		cache.SafeSet(lookupKey(hash), key, lookupTTL)
+	}
+
+	if !key.IsUsable() {
+		return nil, fmt.Errorf("API key revoked or expired")
+	}
+
+	touch(key.ID)
+	return &key, nil
+}
+
+// Forget drops a cached lookup so revocations apply immediately
+func Forget(hash string) {
+	cache.SafeDelete(lookupKey(hash))
+}
+
+var (
+	lastTouched = make(map[string]time.Time)
+	touchMu     sync.Mutex
+)
+
+// touch records last_used_at at most once per touchInterval per key
+func touch(keyID string) {
+	now := time.Now()
+
+	touchMu.Lock()
+	if last, ok := lastTouched[keyID]; ok && now.Sub(last) < touchInterval {
+		touchMu.Unlock()
+		return
+	}
+	lastTouched[keyID] = now
+	touchMu.Unlock()
+
+	go func() {
+		_, _, err := database.Query("api_keys").
+			Update(map[string]interface{}{
+				"last_used_at": now.UTC().Format(time.RFC3339),
+			}, "", "").
+			Eq("id", keyID).
+			Execute()
+		if err != nil {
+			log.Printf("⚠️  Failed to record API key usage %s: %v", keyID, err)
+		}
+	}()
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"time"
 
@@ -190,6 +191,84 @@ func (r *RedisClient) Expire(key string, expiration time.Duration) error {
 	return r.client.Expire(r.ctx, key, expiration).Err()
 }
 
+// Increment bumps a counter and starts its expiry on first use
+func (r *RedisClient) Increment(key string, expiration time.Duration) (int64, error) {
+	if r == nil || r.client == nil {
+		return 0, fmt.Errorf("Redis not available")
+	}
+
+	return incrementScript.Run(r.ctx, r.client, []string{key}, expiration.Milliseconds()).Int64()
+}
+
+// incrementScript starts the expiry with the first hit, in the same step,
+// so a counter can never be left without one
+var incrementScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func (r *RedisClient) TTL(key string) (time.Duration, error) {
+	if r == nil || r.client == nil {
+		return 0, fmt.Errorf("Redis not available")
+	}
+
+	return r.client.TTL(r.ctx, key).Result()
+}
+
+/*
+-----------------------------------------------------
+RATE LIMITING (SLIDING WINDOW LOG)
+-----------------------------------------------------
+Atomic across replicas: one sorted set per limiter key,
+scored by request time in milliseconds.
+*/
+
+var slidingWindowScript = redis.NewScript(`
+local key    = KEYS[1]
+local now    = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit  = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local reset = window
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+// SlidingWindow records a hit for key and reports whether it fits in
+// limit requests per window, how many remain and when a slot frees up.
+func (r *RedisClient) SlidingWindow(key string, limit int, window time.Duration) (bool, int, time.Duration, error) {
+	if r == nil || r.client == nil {
+		return false, 0, 0, fmt.Errorf("Redis not available")
+	}
+
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%d", now, rand.Int63())
+
+	res, err := slidingWindowScript.Run(r.ctx, r.client, []string{key},
+		now, window.Milliseconds(), limit, member).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	return res[0] == 1, int(res[1]), time.Duration(res[2]) * time.Millisecond, nil
+}
+
 /*
 -----------------------------------------------------
 PUB / SUB (REAL-TIME)
@@ -282,6 +361,10 @@ func DealsKey() string {
 	return "deals:active"
 }
 
+func RateLimitKey(group, identity string) string {
+	return "ratelimit:" + group + ":" + identity
+}
+
 /*
 -----------------------------------------------------
 GLOBAL HELPER FUNCTIONS (SAFE TO CALL EVEN IF NIL)
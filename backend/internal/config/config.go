@@ -18,10 +18,15 @@ type Config struct {
 	FCMServerKey       string
 	GoogleMapsAPIKey   string
 	FrontendURL        string
+
+	// AllowUnsignedWebhooks accepts Stripe events without a signature.
+	// Local testing only: it needs STRIPE_ALLOW_UNSIGNED_WEBHOOKS=true and
+	// ENVIRONMENT=development, and is ignored everywhere else.
+	AllowUnsignedWebhooks bool
 }
 
 func Load() *Config {
-	return &Config{
+	cfg := &Config{
 		Environment:        getEnv("ENVIRONMENT", "development"),
 		Port:               getEnv("PORT", "8080"),
 		DatabaseURL:        getEnv("DATABASE_URL", ""),
@@ -35,6 +40,9 @@ func Load() *Config {
 		GoogleMapsAPIKey:   getEnv("GOOGLE_MAPS_API_KEY", ""),
 		FrontendURL:        getEnv("FRONTEND_URL", "http://localhost:8081"),
 	}
+	cfg.AllowUnsignedWebhooks = cfg.Environment == "development" &&
+		getEnv("STRIPE_ALLOW_UNSIGNED_WEBHOOKS", "") == "true"
+	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
@@ -0,0 +1,395 @@
+package coupons
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/deals"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/promo"
+)
+
+/*
+-----------------------------------------------------
+COUPON ENGINE
+-----------------------------------------------------
+- Customers claim coupons from deals (max_coupons,
+  per_user_limit)
+- A coupon is checked against everything it depends
+  on: status, expiry, the deal and its schedule,
+  restaurant, order type and minimum order
+- What a coupon is worth comes from its deal's
+  promotion rules (or plain discount_percent), priced
+  by the promo engine together with any automatic
+  offers it has to stack with
+- Redeem flips active -> used in one conditional
+  UPDATE, so two checkouts can never both win
+- Release gives a coupon back if the order or
+  transaction it was reserved for never happened
+  (or the order is cancelled)
+*/
+
+var (
+	ErrNotFound        = errors.New("coupon not found")
+	ErrNotYours        = errors.New("this coupon belongs to another customer")
+	ErrUsed            = errors.New("coupon has already been used")
+	ErrExpired         = errors.New("coupon has expired")
+	ErrDealInactive    = errors.New("the deal for this coupon is no longer active")
+	ErrWrongRestaurant = errors.New("coupon is not valid at this restaurant")
+	ErrWrongOrderType  = errors.New("coupon is not valid for this order type")
+	ErrOutsideSchedule = errors.New("this deal isn't available right now")
+	ErrNotCombinable   = errors.New("a better offer already applies to this order and can't be combined with this coupon")
+	ErrNoDiscount      = errors.New("this coupon doesn't apply to anything in the order")
+	ErrSoldOut         = errors.New("all coupons for this deal have been claimed")
+	ErrLimitReached    = errors.New("you have already claimed this deal")
+)
+
+// MinOrderError reports the minimum basket a coupon needs
+type MinOrderError struct {
+	MinOrder money.Amount
+}
+
+func (e *MinOrderError) Error() string {
+	return fmt.Sprintf("order must be at least %s to use this coupon", e.MinOrder)
+}
+
+type Deal struct {
+	ID              string       `json:"id"`
+	RestaurantID    string       `json:"restaurant_id"`
+	Title           string       `json:"title"`
+	DiscountPercent int          `json:"discount_percent"`
+	OfferType       string       `json:"offer_type"`
+	MaxCoupons      *int         `json:"max_coupons"`
+	ClaimedCoupons  int          `json:"claimed_coupons"`
+	PerUserLimit    *int         `json:"per_user_limit"`
+	MinOrder        money.Amount `json:"min_order"`
+	Rules           []promo.Rule `json:"rules"`
+	Stackable       bool         `json:"stackable"`
+	IsActive        bool         `json:"is_active"`
+	Restaurant      *struct {
+		Timezone string `json:"timezone"`
+	} `json:"restaurant"`
+	deals.Schedule
+}
+
+// Location is the timezone the deal's schedule is evaluated in
+func (d *Deal) Location() *time.Location {
+	if d.Restaurant == nil {
+		return time.UTC
+	}
+	return deals.Location(d.Restaurant.Timezone)
+}
+
+type Coupon struct {
+	ID              string     `json:"id"`
+	Code            string     `json:"code"`
+	DealID          string     `json:"deal_id"`
+	UserID          string     `json:"user_id"`
+	DiscountPercent int        `json:"discount_percent"`
+	Status          string     `json:"status"`
+	ExpiresAt       *time.Time `json:"expires_at"`
+	Deal            *Deal      `json:"deal"`
+}
+
+// Basket is what a coupon is checked and priced against
+type Basket struct {
+	RestaurantID string
+	UserID       string // "" when the customer isn't known (in-store)
+	OrderType    string // dine_in | takeaway | delivery, or "" to skip
+	Lines        []promo.Line
+	Subtotal     money.Amount
+	Currency     string
+	FirstOrder   bool
+}
+
+// Promo is the basket as the promo engine sees it
+func (b Basket) Promo() promo.Basket {
+	return promo.Basket{Lines: b.Lines, Subtotal: b.Subtotal, Currency: b.Currency, FirstOrder: b.FirstOrder}
+}
+
+// Quote is a priced basket. Discount is the coupon's own share; Total and
+// Applied include any offers it was stacked with.
+type Quote struct {
+	Coupon   *Coupon         `json:"coupon"`
+	Discount money.Amount    `json:"discount"`
+	Total    money.Amount    `json:"total"`
+	Currency string          `json:"currency"`
+	Applied  []promo.Applied `json:"applied"`
+}
+
+const dealColumns = "id, restaurant_id, title, discount_percent, offer_type, max_coupons, claimed_coupons, per_user_limit, min_order, rules, stackable, is_active, valid_from, valid_till, days_available, start_time, end_time, restaurant:restaurants(timezone)"
+
+// Lookup loads a coupon by code together with its deal
+func Lookup(code string) (*Coupon, error) {
+	result, _, err := database.Query("coupons").
+		Select("id, code, deal_id, user_id, discount_percent, status, expires_at, deal:deals("+dealColumns+")", "", false).
+		Eq("code", strings.ToUpper(strings.TrimSpace(code))).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var c Coupon
+	if err := json.Unmarshal(result, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// orderTypeAllowed maps a deal's offer_type onto order types
+func orderTypeAllowed(offerType, orderType string) bool {
+	switch offerType {
+	case "dinein":
+		return orderType == "dine_in" || orderType == "dinein"
+	case "pickup":
+		return orderType == "takeaway" || orderType == "delivery" || orderType == "pickup"
+	}
+	return true
+}
+
+// Validate checks every constraint a coupon has against the basket
+func (c *Coupon) Validate(b Basket, now time.Time) error {
+	switch c.Status {
+	case "used":
+		return ErrUsed
+	case "expired":
+		return ErrExpired
+	}
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return ErrExpired
+	}
+	if b.UserID != "" && c.UserID != "" && c.UserID != b.UserID {
+		return ErrNotYours
+	}
+
+	d := c.Deal
+	if d == nil || !d.IsActive {
+		return ErrDealInactive
+	}
+	if till, ok := d.Until(d.Location()); ok && !now.Before(till) {
+		return ErrExpired
+	}
+	if !d.ActiveAt(now, d.Location()) {
+		return ErrOutsideSchedule
+	}
+	if d.RestaurantID != b.RestaurantID {
+		return ErrWrongRestaurant
+	}
+	if b.OrderType != "" && !orderTypeAllowed(d.OfferType, b.OrderType) {
+		return ErrWrongOrderType
+	}
+	if d.MinOrder > 0 && b.Subtotal < d.MinOrder {
+		return &MinOrderError{MinOrder: d.MinOrder}
+	}
+	return nil
+}
+
+// Promotion is the coupon as the promo engine sees it. Deals without rules
+// are a plain percentage off, at the rate frozen on the coupon when claimed.
+func (c *Coupon) Promotion() promo.Promotion {
+	p := promo.Promotion{ID: c.ID, Kind: "coupon"}
+	if c.Deal != nil {
+		p.Title = c.Deal.Title
+		p.Rules = c.Deal.Rules
+		p.Stackable = c.Deal.Stackable
+	}
+	if len(p.Rules) == 0 {
+		pct := c.DiscountPercent
+		if pct == 0 && c.Deal != nil {
+			pct = c.Deal.DiscountPercent
+		}
+		if pct > 0 {
+			p.Rules = []promo.Rule{{Type: promo.PercentOff, Percent: float64(pct)}}
+		}
+	}
+	return p
+}
+
+// Check looks up, validates and prices a coupon for a basket, together with
+// the automatic offers it competes or stacks with
+func Check(code string, b Basket, offers ...promo.Promotion) (*Quote, error) {
+	c, err := Lookup(code)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(b, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	coupon := c.Promotion()
+	if coupon.Discount(b.Promo()) <= 0 {
+		return nil, ErrNoDiscount
+	}
+	promos := append(append([]promo.Promotion{}, offers...), coupon)
+	result := promo.Apply(b.Promo(), promos...)
+	own, ok := result.Find(c.ID)
+	if !ok {
+		return nil, ErrNotCombinable
+	}
+
+	return &Quote{
+		Coupon:   c,
+		Discount: own.Discount,
+		Total:    result.Total,
+		Currency: money.Normalize(b.Currency),
+		Applied:  result.Applied,
+	}, nil
+}
+
+// Redeem marks the coupon used; only one caller can ever succeed
+func Redeem(couponID string, discount money.Amount) error {
+	result, _, err := database.Query("coupons").
+		Update(map[string]interface{}{
+			"status":          "used",
+			"used_at":         time.Now().UTC().Format(time.RFC3339),
+			"discount_amount": discount,
+		}, "", "").
+		Eq("id", couponID).
+		Eq("status", "active").
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(result, &rows); err != nil || len(rows) == 0 {
+		return ErrUsed
+	}
+	return nil
+}
+
+// LinkOrder records the order a coupon was redeemed on
+func LinkOrder(couponID, orderID string) error {
+	_, _, err := database.Query("coupons").
+		Update(map[string]interface{}{"order_id": orderID}, "", "").
+		Eq("id", couponID).
+		Execute()
+	return err
+}
+
+var released = map[string]interface{}{
+	"status":          "active",
+	"used_at":         nil,
+	"discount_amount": nil,
+	"order_id":        nil,
+}
+
+// Release returns a redeemed coupon to the customer
+func Release(couponID string) error {
+	_, _, err := database.Query("coupons").
+		Update(released, "", "").
+		Eq("id", couponID).
+		Eq("status", "used").
+		Execute()
+	return err
+}
+
+// ReleaseForOrder returns the coupon used on a cancelled order
+func ReleaseForOrder(orderID string) error {
+	_, _, err := database.Query("coupons").
+		Update(released, "", "").
+		Eq("order_id", orderID).
+		Eq("status", "used").
+		Execute()
+	return err
+}
+
+/*
+-----------------------------------------------------
+CLAIMING
+-----------------------------------------------------
+*/
+
+const (
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O, 1/I
+	codeLength   = 8
+	claimRetries = 5
+)
+
+func newCode() (string, error) {
+	buf := make([]byte, codeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+func loadDeal(dealID string) (*Deal, error) {
+	result, _, err := database.Query("deals").
+		Select(dealColumns, "", false).
+		Eq("id", dealID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var d Deal
+	if err := json.Unmarshal(result, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Claim issues the user a coupon for a deal. The coupon_claim SQL function
+// checks max_coupons and per_user_limit under the deal lock and counts the
+// claim together with the insert, so concurrent claims can't exceed either.
+func Claim(dealID, userID string) (*Coupon, error) {
+	d, err := loadDeal(dealID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !d.IsActive {
+		return nil, ErrDealInactive
+	}
+	till, hasTill := d.Until(d.Location())
+	if hasTill && !now.Before(till) {
+		return nil, ErrExpired
+	}
+
+	var expiresAt interface{}
+	if hasTill {
+		expiresAt = till.Format(time.RFC3339)
+	}
+
+	// Retry only on the rare clash of a random code
+	for i := 0; i < claimRetries; i++ {
+		code, err := newCode()
+		if err != nil {
+			return nil, err
+		}
+		resultStr := database.Client.Rpc("coupon_claim", "", map[string]interface{}{
+			"p_deal_id":    dealID,
+			"p_user_id":    userID,
+			"p_code":       code,
+			"p_expires_at": expiresAt,
+		})
+
+		var created []Coupon
+		if err := json.Unmarshal([]byte(resultStr), &created); err == nil && len(created) > 0 {
+			created[0].Deal = d
+			return &created[0], nil
+		}
+		switch {
+		case strings.Contains(resultStr, "sold out"):
+			return nil, ErrSoldOut
+		case strings.Contains(resultStr, "limit reached"):
+			return nil, ErrLimitReached
+		case strings.Contains(resultStr, "deal not found"):
+			return nil, ErrNotFound
+		case strings.Contains(resultStr, "coupons_code_key"):
+			continue
+		}
+		return nil, fmt.Errorf("coupon_claim: %s", resultStr)
+	}
+	return nil, errors.New("coupon claim failed, please try again")
+}
@@ -0,0 +1,297 @@
+package deals
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // restaurant timezones must resolve in minimal containers
+)
+
+/*
+-----------------------------------------------------
+DEAL SCHEDULES
+-----------------------------------------------------
+- valid_from / valid_till: a date (whole day) or an
+  RFC3339 timestamp; either may be empty
+- days_available: weekday names ("mon", "Monday");
+  empty means every day
+- start_time / end_time: "HH:MM" in the restaurant's
+  timezone; an end before the start runs past midnight
+  and belongs to the day it started
+- Everything is evaluated in the restaurant's timezone
+*/
+
+type Schedule struct {
+	ValidFrom     string   `json:"valid_from"`
+	ValidTill     string   `json:"valid_till"`
+	DaysAvailable []string `json:"days_available"`
+	StartTime     string   `json:"start_time"`
+	EndTime       string   `json:"end_time"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) < 3 {
+		return 0, false
+	}
+	d, ok := weekdays[name[:3]]
+	return d, ok
+}
+
+// parseClock returns minutes since midnight for "HH:MM" or "HH:MM:SS"
+func parseClock(raw string) (int, bool) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.Hour()*60 + t.Minute(), true
+		}
+	}
+	return 0, false
+}
+
+// parseBound reads a date or timestamp; a date is midnight in loc, or the
+// following midnight when endOfDay is set (valid through that day)
+func parseBound(raw string, loc *time.Location, endOfDay bool) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", raw, loc); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// Validate rejects schedules that could never be evaluated
+func (s *Schedule) Validate() error {
+	if s.ValidFrom != "" {
+		if _, ok := parseBound(s.ValidFrom, time.UTC, false); !ok {
+			return fmt.Errorf("valid_from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+		}
+	}
+	if s.ValidTill != "" {
+		if _, ok := parseBound(s.ValidTill, time.UTC, true); !ok {
+			return fmt.Errorf("valid_till must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+		}
+	}
+	for _, day := range s.DaysAvailable {
+		if _, ok := parseWeekday(day); !ok {
+			return fmt.Errorf("unknown weekday %q in days_available", day)
+		}
+	}
+	if _, ok := parseClock(s.StartTime); s.StartTime != "" && !ok {
+		return fmt.Errorf("start_time must be HH:MM")
+	}
+	if _, ok := parseClock(s.EndTime); s.EndTime != "" && !ok {
+		return fmt.Errorf("end_time must be HH:MM")
+	}
+	return nil
+}
+
+// ValidateFields checks the schedule columns in a raw deal insert, or in an
+// update when partial is set (a window may then change one end at a time)
+func ValidateFields(fields map[string]interface{}, partial bool) error {
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var s Schedule
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return fmt.Errorf("invalid deal schedule: %v", err)
+	}
+	if !partial && (s.StartTime == "") != (s.EndTime == "") {
+		return fmt.Errorf("start_time and end_time must be set together")
+	}
+	return s.Validate()
+}
+
+func (s *Schedule) dayAllowed(d time.Weekday) bool {
+	if len(s.DaysAvailable) == 0 {
+		return true
+	}
+	for _, name := range s.DaysAvailable {
+		if wd, ok := parseWeekday(name); ok && wd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// window returns the daily window in minutes; ok is false for all-day deals
+func (s *Schedule) window() (start, end int, ok bool) {
+	start, okStart := parseClock(s.StartTime)
+	end, okEnd := parseClock(s.EndTime)
+	if !okStart || !okEnd || start == end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// Until is the instant the deal stops being valid, if it has one
+func (s *Schedule) Until(loc *time.Location) (time.Time, bool) {
+	return parseBound(s.ValidTill, loc, true)
+}
+
+// ActiveAt reports whether the deal can be used at now
+func (s *Schedule) ActiveAt(now time.Time, loc *time.Location) bool {
+	if from, ok := parseBound(s.ValidFrom, loc, false); ok && now.Before(from) {
+		return false
+	}
+	if till, ok := s.Until(loc); ok && !now.Before(till) {
+		return false
+	}
+
+	t := now.In(loc)
+	start, end, ok := s.window()
+	if !ok {
+		return s.dayAllowed(t.Weekday())
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if start < end {
+		return minute >= start && minute < end && s.dayAllowed(t.Weekday())
+	}
+	// Overnight: tonight's window, or the tail of yesterday's
+	if minute >= start {
+		return s.dayAllowed(t.Weekday())
+	}
+	return minute < end && s.dayAllowed(t.AddDate(0, 0, -1).Weekday())
+}
+
+// NextChange is the first instant after now at which ActiveAt may flip:
+// the date bounds, or the next midnight / start / end in loc. ok is false
+// for deals that never change.
+func (s *Schedule) NextChange(now time.Time, loc *time.Location) (next time.Time, ok bool) {
+	consider := func(t time.Time) {
+		if t.After(now) && (!ok || t.Before(next)) {
+			next, ok = t, true
+		}
+	}
+
+	if from, has := parseBound(s.ValidFrom, loc, false); has {
+		consider(from)
+	}
+	till, hasTill := s.Until(loc)
+	if hasTill {
+		if !now.Before(till) {
+			return time.Time{}, false
+		}
+		consider(till)
+	}
+
+	start, end, hasWindow := s.window()
+	if !hasWindow && len(s.DaysAvailable) == 0 {
+		return next, ok
+	}
+
+	t := now.In(loc)
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		y, m, d := day.Date()
+		if len(s.DaysAvailable) > 0 {
+			consider(time.Date(y, m, d, 0, 0, 0, 0, loc))
+		}
+		if hasWindow {
+			consider(time.Date(y, m, d, start/60, start%60, 0, 0, loc))
+			consider(time.Date(y, m, d, end/60, end%60, 0, 0, loc))
+		}
+	}
+	return next, ok
+}
+
+/*
+-----------------------------------------------------
+TIMEZONES
+-----------------------------------------------------
+*/
+
+var (
+	locMu     sync.RWMutex
+	locations = map[string]*time.Location{}
+)
+
+// ValidTimezone checks an IANA zone name such as "Europe/Berlin"
+func ValidTimezone(name string) bool {
+	if strings.TrimSpace(name) == "" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// Location resolves a restaurant timezone, falling back to UTC
+func Location(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	locMu.RLock()
+	loc, ok := locations[name]
+	locMu.RUnlock()
+	if ok {
+		return loc
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = time.UTC
+	}
+	locMu.Lock()
+	locations[name] = loc
+	locMu.Unlock()
+	return loc
+}
+
+/*
+-----------------------------------------------------
+LISTINGS
+-----------------------------------------------------
+*/
+
+// row is a deal as listed, with its restaurant's timezone embedded
+type row struct {
+	Schedule
+	Restaurant *struct {
+		Timezone string `json:"timezone"`
+	} `json:"restaurant"`
+}
+
+// Live filters a PostgREST deal listing (embedding restaurant:restaurants(timezone))
+// to the deals usable at now. next is when that set can next change, or zero.
+func Live(result []byte, now time.Time) (live []json.RawMessage, next time.Time, err error) {
+	var rows []json.RawMessage
+	if err := json.Unmarshal(result, &rows); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	live = make([]json.RawMessage, 0, len(rows))
+	for _, raw := range rows {
+		var r row
+		if err := json.Unmarshal(raw, &r); err != nil {
+			continue
+		}
+		loc := time.UTC
+		if r.Restaurant != nil {
+			loc = Location(r.Restaurant.Timezone)
+		}
+		if r.ActiveAt(now, loc) {
+			live = append(live, raw)
+		}
+		if change, ok := r.NextChange(now, loc); ok && (next.IsZero() || change.Before(next)) {
+			next = change
+		}
+	}
+	return live, next, nil
+}
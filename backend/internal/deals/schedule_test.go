@@ -0,0 +1,174 @@
+package deals
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%s): %v", name, err)
+	}
+	return loc
+}
+
+// at is a wall-clock time in loc
+func at(t *testing.T, loc *time.Location, wall string) time.Time {
+	t.Helper()
+	ts, err := time.ParseInLocation("2006-01-02 15:04", wall, loc)
+	if err != nil {
+		t.Fatalf("ParseInLocation(%s): %v", wall, err)
+	}
+	return ts
+}
+
+func TestActiveAt(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	newYork := mustLoad(t, "America/New_York")
+
+	// 2026-03-05 is a Thursday
+	overnightFridays := Schedule{DaysAvailable: []string{"Friday"}, StartTime: "22:00", EndTime: "02:00"}
+	lunch := Schedule{DaysAvailable: []string{"mon", "tue", "wed", "thu", "fri"}, StartTime: "11:00", EndTime: "15:00"}
+	earlyHours := Schedule{StartTime: "01:00", EndTime: "01:30"}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		loc      *time.Location
+		now      time.Time
+		want     bool
+	}{
+		{"overnight, friday evening", overnightFridays, berlin, at(t, berlin, "2026-03-06 23:00"), true},
+		{"overnight, after midnight belongs to friday", overnightFridays, berlin, at(t, berlin, "2026-03-07 01:59"), true},
+		{"overnight, end is exclusive", overnightFridays, berlin, at(t, berlin, "2026-03-07 02:00"), false},
+		{"overnight, saturday evening", overnightFridays, berlin, at(t, berlin, "2026-03-07 23:00"), false},
+		{"overnight, tail of thursday", overnightFridays, berlin, at(t, berlin, "2026-03-06 01:00"), false},
+		{"overnight, judged in the restaurant's zone", overnightFridays, berlin, time.Date(2026, 3, 6, 21, 30, 0, 0, time.UTC), true},
+
+		{"lunch, weekday", lunch, newYork, at(t, newYork, "2026-03-06 12:00"), true},
+		{"lunch, weekend", lunch, newYork, at(t, newYork, "2026-03-07 12:00"), false},
+		{"lunch, before start", lunch, newYork, at(t, newYork, "2026-03-06 10:59"), false},
+
+		{"valid through the last day", Schedule{ValidTill: "2026-03-08"}, newYork, at(t, newYork, "2026-03-08 23:59"), true},
+		{"expired the next midnight", Schedule{ValidTill: "2026-03-08"}, newYork, at(t, newYork, "2026-03-09 00:00"), false},
+		{"not yet valid", Schedule{ValidFrom: "2026-03-08"}, newYork, at(t, newYork, "2026-03-07 23:59"), false},
+		{"valid from midnight", Schedule{ValidFrom: "2026-03-08"}, newYork, at(t, newYork, "2026-03-08 00:00"), true},
+		{"timestamp bound", Schedule{ValidTill: "2026-03-08T12:00:00Z"}, berlin, time.Date(2026, 3, 8, 11, 59, 0, 0, time.UTC), true},
+
+		// US clocks go back an hour at 02:00 on 2026-11-01; 01:15 happens twice
+		{"fall back, first 01:15", earlyHours, newYork, time.Date(2026, 11, 1, 5, 15, 0, 0, time.UTC), true},
+		{"fall back, second 01:15", earlyHours, newYork, time.Date(2026, 11, 1, 6, 15, 0, 0, time.UTC), true},
+		{"fall back, 01:45", earlyHours, newYork, time.Date(2026, 11, 1, 6, 45, 0, 0, time.UTC), false},
+		// ...and forward at 02:00 on 2026-03-08: 01:59 EST is followed by 03:00 EDT
+		{"spring forward, before the jump", Schedule{StartTime: "01:30", EndTime: "02:30"}, newYork, time.Date(2026, 3, 8, 6, 59, 0, 0, time.UTC), true},
+		{"spring forward, after the jump", Schedule{StartTime: "01:30", EndTime: "02:30"}, newYork, time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC), false},
+
+		{"always", Schedule{}, time.UTC, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"equal start and end means all day", Schedule{StartTime: "09:00", EndTime: "09:00"}, time.UTC, time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.schedule.ActiveAt(tt.now, tt.loc); got != tt.want {
+			t.Errorf("%s: ActiveAt(%s) = %v, want %v", tt.name, tt.now.In(tt.loc).Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
+
+func TestNextChange(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	newYork := mustLoad(t, "America/New_York")
+	evenings := Schedule{StartTime: "18:00", EndTime: "20:00"}
+	overnight := Schedule{StartTime: "22:00", EndTime: "02:00"}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		loc      *time.Location
+		now      time.Time
+		want     time.Time // zero: never changes
+	}{
+		{"overnight, waiting for the start", overnight, berlin, at(t, berlin, "2026-03-06 21:00"), at(t, berlin, "2026-03-06 22:00")},
+		{"overnight, running past midnight", overnight, berlin, at(t, berlin, "2026-03-06 23:00"), at(t, berlin, "2026-03-07 02:00")},
+		{"overnight, in the tail", overnight, berlin, at(t, berlin, "2026-03-07 01:00"), at(t, berlin, "2026-03-07 02:00")},
+
+		// Across a DST change the next start is 23 or 25 hours away, not 24
+		{"spring forward", evenings, newYork, at(t, newYork, "2026-03-07 21:00"), time.Date(2026, 3, 8, 22, 0, 0, 0, time.UTC)},
+		{"fall back", evenings, newYork, at(t, newYork, "2026-10-31 21:00"), time.Date(2026, 11, 1, 23, 0, 0, 0, time.UTC)},
+
+		{"weekday list flips at midnight", Schedule{DaysAvailable: []string{"mon"}}, berlin, at(t, berlin, "2026-03-08 15:00"), at(t, berlin, "2026-03-09 00:00")},
+		{"expiry comes first", Schedule{ValidTill: "2026-03-10", StartTime: "10:00", EndTime: "23:00"}, newYork, at(t, newYork, "2026-03-10 23:30"), at(t, newYork, "2026-03-11 00:00")},
+		{"start date", Schedule{ValidFrom: "2026-04-01"}, berlin, at(t, berlin, "2026-03-30 12:00"), at(t, berlin, "2026-04-01 00:00")},
+
+		{"expired deals never change", Schedule{ValidTill: "2026-03-01", StartTime: "18:00", EndTime: "20:00"}, berlin, at(t, berlin, "2026-03-06 12:00"), time.Time{}},
+		{"always-on deals never change", Schedule{}, berlin, at(t, berlin, "2026-03-06 12:00"), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.schedule.NextChange(tt.now, tt.loc)
+		if tt.want.IsZero() {
+			if ok {
+				t.Errorf("%s: NextChange = %s, want none", tt.name, got.In(tt.loc).Format(time.RFC3339))
+			}
+			continue
+		}
+		if !ok || !got.Equal(tt.want) {
+			t.Errorf("%s: NextChange = %s (ok=%v), want %s", tt.name,
+				got.In(tt.loc).Format(time.RFC3339), ok, tt.want.In(tt.loc).Format(time.RFC3339))
+		}
+	}
+}
+
+func TestNextChangeFlipsActiveAt(t *testing.T) {
+	loc := mustLoad(t, "America/New_York")
+	s := Schedule{DaysAvailable: []string{"sat", "sun"}, StartTime: "23:00", EndTime: "01:00"}
+
+	// Walking from one change to the next must alternate ActiveAt
+	now := at(t, loc, "2026-10-30 12:00")
+	prev := s.ActiveAt(now, loc)
+	flips := 0
+	for i := 0; i < 20; i++ {
+		next, ok := s.NextChange(now, loc)
+		if !ok {
+			t.Fatal("NextChange found nothing for a recurring deal")
+		}
+		if !next.After(now) {
+			t.Fatalf("NextChange(%s) = %s, not after now", now, next)
+		}
+		now = next
+		if cur := s.ActiveAt(now, loc); cur != prev {
+			flips++
+			prev = cur
+		}
+	}
+	if flips < 4 {
+		t.Errorf("ActiveAt flipped %d times over 20 changes, want at least 4", flips)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		ok       bool
+	}{
+		{"empty", Schedule{}, true},
+		{"full", Schedule{ValidFrom: "2026-01-01", ValidTill: "2026-01-31T23:00:00Z", DaysAvailable: []string{"Mon", "friday"}, StartTime: "17:00", EndTime: "19:30:00"}, true},
+		{"bad date", Schedule{ValidTill: "31/01/2026"}, false},
+		{"bad weekday", Schedule{DaysAvailable: []string{"someday"}}, false},
+		{"bad time", Schedule{StartTime: "25:00"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.schedule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+
+	if err := ValidateFields(map[string]interface{}{"start_time": "10:00"}, false); err == nil {
+		t.Error("ValidateFields accepted a start_time without an end_time")
+	}
+	if err := ValidateFields(map[string]interface{}{"start_time": "10:00"}, true); err != nil {
+		t.Errorf("ValidateFields rejected a partial update: %v", err)
+	}
+}
@@ -0,0 +1,534 @@
+package giftcards
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+/*
+-----------------------------------------------------
+GIFT CARDS (STORED VALUE)
+-----------------------------------------------------
+- A restaurant's gift card is a code with a balance
+  in the restaurant's currency; it can be spent in
+  parts across any number of orders
+- Cards bought online start pending and are loaded
+  when the payment succeeds; cards sold at the counter
+  are loaded straight away
+- gift_card_ledger records every load, redemption
+  and reversal with the balance after it. All changes
+  go through the gift_card_post SQL function, which
+  locks the card and refuses to go below zero
+- Redemptions are linked to the order and to the
+  transactions row they paid for
+*/
+
+var (
+	ErrNotFound        = errors.New("gift card not found")
+	ErrWrongRestaurant = errors.New("gift card is not valid at this restaurant")
+	ErrInactive        = errors.New("gift card is not active")
+	ErrExpired         = errors.New("gift card has expired")
+	ErrEmpty           = errors.New("gift card has no balance left")
+	ErrInsufficient    = errors.New("gift card balance is too low")
+	ErrReversed        = errors.New("gift card redemption was already reversed")
+)
+
+const (
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O, 1/I
+	codeLength   = 16
+	issueRetries = 5
+)
+
+type Card struct {
+	ID             string       `json:"id"`
+	RestaurantID   string       `json:"restaurant_id"`
+	Code           string       `json:"code"`
+	Currency       string       `json:"currency"`
+	InitialBalance money.Amount `json:"initial_balance"`
+	Balance        money.Amount `json:"balance"`
+	Status         string       `json:"status"` // pending | active | disabled
+	PurchaserID    *string      `json:"purchaser_id"`
+	RecipientName  string       `json:"recipient_name"`
+	RecipientEmail string       `json:"recipient_email"`
+	Message        string       `json:"message"`
+	ExpiresAt      *time.Time   `json:"expires_at"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+type Entry struct {
+	ID            string       `json:"id"`
+	GiftCardID    string       `json:"gift_card_id"`
+	Kind          string       `json:"kind"` // load | redeem | reversal | adjust
+	Amount        money.Amount `json:"amount"`
+	BalanceAfter  money.Amount `json:"balance_after"`
+	OrderID       *string      `json:"order_id"`
+	TransactionID *string      `json:"transaction_id"`
+	Note          string       `json:"note"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+/*
+-----------------------------------------------------
+CODES
+-----------------------------------------------------
+*/
+
+func newCode() (string, error) {
+	buf := make([]byte, codeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// Normalize accepts codes typed with spaces, dashes or in lower case
+func Normalize(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Format groups a code in fours for printing: ABCD-EFGH-JKLM-NPQR
+func Format(code string) string {
+	var parts []string
+	for len(code) > 4 {
+		parts = append(parts, code[:4])
+		code = code[4:]
+	}
+	return strings.Join(append(parts, code), "-")
+}
+
+// Mask hides all but the last four characters
+func Mask(code string) string {
+	if len(code) <= 4 {
+		return code
+	}
+	return strings.Repeat("•", 4) + code[len(code)-4:]
+}
+
+/*
+-----------------------------------------------------
+CARDS
+-----------------------------------------------------
+*/
+
+func decodeCard(result []byte) (*Card, error) {
+	var card Card
+	if err := json.Unmarshal(result, &card); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// Lookup finds a card by its code
+func Lookup(code string) (*Card, error) {
+	code = Normalize(code)
+	if code == "" {
+		return nil, ErrNotFound
+	}
+	result, _, err := database.Query("gift_cards").
+		Select("*", "", false).
+		Eq("code", code).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return decodeCard(result)
+}
+
+// Get loads a card by id
+func Get(cardID string) (*Card, error) {
+	result, _, err := database.Query("gift_cards").
+		Select("*", "", false).
+		Eq("id", cardID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return decodeCard(result)
+}
+
+// Usable checks a card can pay at the restaurant now
+func (card *Card) Usable(restaurantID string, now time.Time) error {
+	switch {
+	case card.RestaurantID != restaurantID:
+		return ErrWrongRestaurant
+	case card.Status != "active":
+		return ErrInactive
+	case card.ExpiresAt != nil && now.After(*card.ExpiresAt):
+		return ErrExpired
+	case card.Balance <= 0:
+		return ErrEmpty
+	}
+	return nil
+}
+
+// Issue is a card to create
+type Issue struct {
+	RestaurantID   string
+	Amount         money.Amount
+	Currency       string
+	PurchaserID    string
+	RecipientName  string
+	RecipientEmail string
+	Message        string
+	ExpiresAt      *time.Time
+	Pending        bool // paid online: loaded by Activate once the payment succeeds
+	Note           string
+}
+
+// Create issues a card with a fresh code. Cards that aren't pending are
+// loaded with the amount straight away.
+func Create(in Issue) (*Card, error) {
+	row := map[string]interface{}{
+		"restaurant_id":   in.RestaurantID,
+		"currency":        in.Currency,
+		"initial_balance": in.Amount,
+		"balance":         0,
+		"status":          "active",
+		"recipient_name":  in.RecipientName,
+		"recipient_email": in.RecipientEmail,
+		"message":         in.Message,
+	}
+	if in.Pending {
+		row["status"] = "pending"
+	}
+	if in.PurchaserID != "" {
+		row["purchaser_id"] = in.PurchaserID
+	}
+	if in.ExpiresAt != nil {
+		row["expires_at"] = in.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+
+	// Codes are random; a clash with the unique index just means another draw
+	var card *Card
+	for i := 0; i < issueRetries && card == nil; i++ {
+		code, err := newCode()
+		if err != nil {
+			return nil, err
+		}
+		row["code"] = code
+		result, _, err := database.Query("gift_cards").
+			Insert(row, false, "", "", "").
+			Execute()
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate") {
+				continue
+			}
+			return nil, err
+		}
+		var cards []Card
+		if err := json.Unmarshal(result, &cards); err != nil || len(cards) == 0 {
+			return nil, fmt.Errorf("gift card insert returned nothing")
+		}
+		card = &cards[0]
+	}
+	if card == nil {
+		return nil, fmt.Errorf("could not allocate a gift card code")
+	}
+
+	if !in.Pending {
+		note := in.Note
+		if note == "" {
+			note = "Sold in store"
+		}
+		entry, err := Post(Posting{CardID: card.ID, Amount: in.Amount, Kind: "load", Note: note})
+		if err != nil {
+			return nil, err
+		}
+		card.Balance = entry.BalanceAfter
+	}
+	return card, nil
+}
+
+// Activate loads a pending card once its purchase is paid. Only the first
+// call for a card does anything, so webhook redeliveries are harmless.
+func Activate(cardID, paymentIntentID string) (*Card, error) {
+	result, _, err := database.Query("gift_cards").
+		Update(map[string]interface{}{"status": "active"}, "", "").
+		Eq("id", cardID).
+		Eq("status", "pending").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var cards []Card
+	if err := json.Unmarshal(result, &cards); err != nil || len(cards) == 0 {
+		return nil, nil
+	}
+	card := &cards[0]
+
+	entry, err := Post(Posting{
+		CardID: card.ID,
+		Amount: card.InitialBalance,
+		Kind:   "load",
+		Note:   "Purchased online (" + paymentIntentID + ")",
+	})
+	if err != nil {
+		return nil, err
+	}
+	card.Balance = entry.BalanceAfter
+	return card, nil
+}
+
+// SetStatus enables or disables a card; pending cards are left alone
+func SetStatus(cardID, status string) error {
+	_, _, err := database.Query("gift_cards").
+		Update(map[string]interface{}{"status": status}, "", "").
+		Eq("id", cardID).
+		Neq("status", "pending").
+		Execute()
+	return err
+}
+
+/*
+-----------------------------------------------------
+LEDGER
+-----------------------------------------------------
+*/
+
+// Posting is one balance change
+type Posting struct {
+	CardID        string
+	Amount        money.Amount // negative to spend
+	Kind          string
+	OrderID       string
+	TransactionID string
+	Note          string
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Post applies a balance change through gift_card_post
+func Post(p Posting) (*Entry, error) {
+	resultStr := database.Client.Rpc("gift_card_post", "", map[string]interface{}{
+		"p_gift_card_id":   p.CardID,
+		"p_amount":         p.Amount,
+		"p_kind":           p.Kind,
+		"p_order_id":       nullable(p.OrderID),
+		"p_transaction_id": nullable(p.TransactionID),
+		"p_note":           nullable(p.Note),
+	})
+
+	var entries []Entry
+	if err := json.Unmarshal([]byte(resultStr), &entries); err != nil || len(entries) == 0 {
+		switch {
+		case strings.Contains(resultStr, "insufficient balance"):
+			return nil, ErrInsufficient
+		case strings.Contains(resultStr, "gift card not active"):
+			return nil, ErrInactive
+		case strings.Contains(resultStr, "already reversed"):
+			return nil, ErrReversed
+		}
+		return nil, fmt.Errorf("gift_card_post: %s", resultStr)
+	}
+	return &entries[0], nil
+}
+
+// Redeem spends up to requested from the card at a restaurant and returns
+// the ledger entry and the amount actually taken (the card may hold less)
+func Redeem(code, restaurantID string, requested money.Amount, currency string) (*Entry, money.Amount, error) {
+	card, err := Lookup(code)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := card.Usable(restaurantID, time.Now()); err != nil {
+		return nil, 0, err
+	}
+	if money.Normalize(card.Currency) != money.Normalize(currency) {
+		return nil, 0, ErrWrongRestaurant
+	}
+
+	amount := requested.Round(currency)
+	if amount > card.Balance {
+		amount = card.Balance
+	}
+	if amount <= 0 {
+		return nil, 0, nil
+	}
+
+	entry, err := Post(Posting{CardID: card.ID, Amount: -amount, Kind: "redeem"})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry, amount, nil
+}
+
+// LinkOrder records the order a redemption paid for
+func LinkOrder(entryID, orderID string) error {
+	_, _, err := database.Query("gift_card_ledger").
+		Update(map[string]interface{}{"order_id": orderID}, "", "").
+		Eq("id", entryID).
+		Execute()
+	return err
+}
+
+// LinkTransaction records the transactions row a redemption is booked under
+func LinkTransaction(entryID, transactionID string) error {
+	_, _, err := database.Query("gift_card_ledger").
+		Update(map[string]interface{}{"transaction_id": transactionID}, "", "").
+		Eq("id", entryID).
+		Execute()
+	return err
+}
+
+// Reverse puts a redemption back on the card. For an order, gift_card_post
+// caps it at what the order still holds, so repeating it is harmless.
+func Reverse(e *Entry) error {
+	if e == nil || e.Amount >= 0 {
+		return nil
+	}
+	p := Posting{
+		CardID: e.GiftCardID,
+		Amount: -e.Amount,
+		Kind:   "reversal",
+		Note:   "Redemption reversed",
+	}
+	if e.OrderID != nil {
+		p.OrderID = *e.OrderID
+	}
+	if e.TransactionID != nil {
+		p.TransactionID = *e.TransactionID
+	}
+	if _, err := Post(p); err != nil && !errors.Is(err, ErrReversed) {
+		return err
+	}
+	return nil
+}
+
+// ForOrder lists the ledger entries booked against an order
+func ForOrder(orderID string) ([]Entry, error) {
+	result, _, err := database.Query("gift_card_ledger").
+		Select("*", "", false).
+		Eq("order_id", orderID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(result, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Outstanding is what the order still holds on each card: redemptions
+// minus reversals, keyed by card, with the redemption entry to reverse
+func Outstanding(entries []Entry) map[string]*Entry {
+	held := map[string]*Entry{}
+	for i := range entries {
+		e := entries[i]
+		h, ok := held[e.GiftCardID]
+		if !ok {
+			h = &Entry{GiftCardID: e.GiftCardID, OrderID: e.OrderID}
+			held[e.GiftCardID] = h
+		}
+		switch e.Kind {
+		case "redeem", "reversal":
+			h.Amount += e.Amount
+		}
+		if e.Kind == "redeem" {
+			h.ID = e.ID
+			h.TransactionID = e.TransactionID
+		}
+	}
+	for id, h := range held {
+		if h.Amount >= 0 {
+			delete(held, id)
+		}
+	}
+	return held
+}
+
+// ReverseForOrder gives back whatever a cancelled order took from gift cards
+func ReverseForOrder(orderID string) error {
+	entries, err := ForOrder(orderID)
+	if err != nil {
+		return err
+	}
+	for _, held := range Outstanding(entries) {
+		if err := Reverse(held); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefundOrder puts up to amount of what an order paid by gift card back on
+// its cards and returns how much went back. gift_card_post caps each
+// reversal at what the order still holds on the card.
+func RefundOrder(orderID string, amount money.Amount, note string) (money.Amount, error) {
+	entries, err := ForOrder(orderID)
+	if err != nil {
+		return 0, err
+	}
+	held := Outstanding(entries)
+	cards := make([]string, 0, len(held))
+	for id := range held {
+		cards = append(cards, id)
+	}
+	sort.Strings(cards)
+
+	var back money.Amount
+	for _, id := range cards {
+		if back >= amount {
+			break
+		}
+		part := -held[id].Amount
+		if part > amount-back {
+			part = amount - back
+		}
+		e, err := Post(Posting{CardID: id, Amount: part, Kind: "reversal", OrderID: orderID, Note: note})
+		if errors.Is(err, ErrReversed) {
+			continue
+		}
+		if err != nil {
+			return back, err
+		}
+		back += e.Amount
+	}
+	return back, nil
+}
+
+// History lists a card's ledger, newest first
+func History(cardID string, limit int) ([]Entry, error) {
+	result, _, err := database.Query("gift_card_ledger").
+		Select("*", "", false).
+		Eq("gift_card_id", cardID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	if err := json.Unmarshal(result, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,520 @@
+package loyalty
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+/*
+-----------------------------------------------------
+LOYALTY POINTS
+-----------------------------------------------------
+- users.points is the balance; loyalty_ledger is the
+  history it is derived from (earn, redeem, reversal,
+  expire, adjust)
+- Every change goes through the loyalty_post SQL
+  function, which locks the user row, refuses to go
+  negative and awards each order / booking only once
+- Restaurants opt in and set their own earn rate,
+  booking bonus and what a point is worth there
+- Tiers come from points earned in the last 12 months
+  and multiply what is earned
+- Earned points expire after 12 months; redemptions
+  use up the lots that expire first
+*/
+
+var (
+	ErrInsufficient = errors.New("not enough loyalty points")
+	ErrDisabled     = errors.New("this restaurant doesn't take loyalty points")
+)
+
+const (
+	Expiry         = 365 * 24 * time.Hour
+	rulesCacheTTL  = 5 * time.Minute
+	expiringWindow = 30 * 24 * time.Hour
+)
+
+/*
+-----------------------------------------------------
+RESTAURANT RULES
+-----------------------------------------------------
+*/
+
+type Rules struct {
+	Enabled          bool         `json:"enabled"`
+	PointsPerUnit    float64      `json:"points_per_unit"`    // per 1.00 of order total
+	BookingPoints    int          `json:"booking_points"`     // for an honoured booking
+	PointValue       money.Amount `json:"point_value"`        // what one point is worth when redeemed
+	MaxRedeemPercent float64      `json:"max_redeem_percent"` // of the order after discounts
+}
+
+var defaults = Rules{
+	PointsPerUnit:    1,
+	PointValue:       money.FromFloat(0.01),
+	MaxRedeemPercent: 50,
+}
+
+// The balance is shared by every restaurant, so no single restaurant may
+// hand out points much faster than the default rate
+const (
+	MaxPointsPerUnit = 10
+	MaxBookingPoints = 500
+)
+
+// UnmarshalJSON keeps the default earn rate only when points_per_unit is
+// missing; an explicit 0 turns earning on orders off
+func (r *Rules) UnmarshalJSON(b []byte) error {
+	type plain Rules
+	p := plain{PointsPerUnit: defaults.PointsPerUnit}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*r = Rules(p)
+	return nil
+}
+
+func (r *Rules) withDefaults() Rules {
+	out := *r
+	if out.PointValue == 0 {
+		out.PointValue = defaults.PointValue
+	}
+	if out.MaxRedeemPercent == 0 {
+		out.MaxRedeemPercent = defaults.MaxRedeemPercent
+	}
+	return out
+}
+
+func (r *Rules) Validate() error {
+	if r.PointsPerUnit < 0 || r.PointsPerUnit > MaxPointsPerUnit {
+		return fmt.Errorf("points_per_unit must be between 0 and %d", MaxPointsPerUnit)
+	}
+	if r.BookingPoints < 0 || r.BookingPoints > MaxBookingPoints {
+		return fmt.Errorf("booking_points must be between 0 and %d", MaxBookingPoints)
+	}
+	if r.PointValue < 0 {
+		return fmt.Errorf("point_value cannot be negative")
+	}
+	if r.MaxRedeemPercent < 0 || r.MaxRedeemPercent > 100 {
+		return fmt.Errorf("max_redeem_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+func cacheKey(restaurantID string) string {
+	return "loyalty_rules:" + restaurantID
+}
+
+// ForRestaurant returns the restaurant's rules with defaults filled in;
+// restaurants that never configured loyalty have it disabled
+func ForRestaurant(restaurantID string) Rules {
+	var rules Rules
+	if err := cache.SafeGet(cacheKey(restaurantID), &rules); err == nil {
+		return rules.withDefaults()
+	}
+
+	result, _, err := database.Query("restaurants").
+		Select("loyalty_rules", "", false).
+		Eq("id", restaurantID).
+		Single().
+		Execute()
+	if err != nil {
+		return rules.withDefaults()
+	}
+
+	var row struct {
+		LoyaltyRules *Rules `json:"loyalty_rules"`
+	}
+	if json.Unmarshal(result, &row) == nil && row.LoyaltyRules != nil {
+		rules = *row.LoyaltyRules
+	}
+
+	cache.SafeSet(cacheKey(restaurantID), rules, rulesCacheTTL)
+	return rules.withDefaults()
+}
+
+// Forget drops cached rules after the owner edits them
+func Forget(restaurantID string) {
+	cache.SafeDelete(cacheKey(restaurantID))
+}
+
+/*
+-----------------------------------------------------
+TIERS
+-----------------------------------------------------
+*/
+
+type Tier struct {
+	Name       string  `json:"name"`
+	MinPoints  int     `json:"min_points"` // earned in the last 12 months
+	Multiplier float64 `json:"multiplier"`
+}
+
+var Tiers = []Tier{
+	{Name: "bronze", MinPoints: 0, Multiplier: 1},
+	{Name: "silver", MinPoints: 1000, Multiplier: 1.1},
+	{Name: "gold", MinPoints: 5000, Multiplier: 1.25},
+	{Name: "platinum", MinPoints: 15000, Multiplier: 1.5},
+}
+
+// TierFor returns the tier for points earned in the last year, and the next one up
+func TierFor(earned int) (Tier, *Tier) {
+	current := Tiers[0]
+	for i, t := range Tiers {
+		if earned < t.MinPoints {
+			return current, &Tiers[i]
+		}
+		current = t
+	}
+	return current, nil
+}
+
+// EarnedSince sums the points a user earned after the given time
+func EarnedSince(userID string, since time.Time) int {
+	result, _, err := database.Query("loyalty_ledger").
+		Select("points", "", false).
+		Eq("user_id", userID).
+		Eq("kind", "earn").
+		Gte("created_at", since.UTC().Format(time.RFC3339)).
+		Execute()
+	if err != nil {
+		return 0
+	}
+	var rows []struct {
+		Points int `json:"points"`
+	}
+	if json.Unmarshal(result, &rows) != nil {
+		return 0
+	}
+	total := 0
+	for _, r := range rows {
+		total += r.Points
+	}
+	return total
+}
+
+/*
+-----------------------------------------------------
+LEDGER
+-----------------------------------------------------
+*/
+
+type Entry struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"user_id"`
+	RestaurantID *string    `json:"restaurant_id"`
+	OrderID      *string    `json:"order_id"`
+	BookingID    *string    `json:"booking_id"`
+	Kind         string     `json:"kind"`
+	Points       int        `json:"points"`
+	Remaining    int        `json:"remaining"`
+	Note         string     `json:"note"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Posting is one balance change
+type Posting struct {
+	UserID       string
+	Points       int // negative to spend
+	Kind         string
+	RestaurantID string
+	OrderID      string
+	BookingID    string
+	Note         string
+	ExpiresAt    *time.Time
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Post applies a balance change through loyalty_post
+func Post(p Posting) (*Entry, error) {
+	params := map[string]interface{}{
+		"p_user_id":       p.UserID,
+		"p_points":        p.Points,
+		"p_kind":          p.Kind,
+		"p_restaurant_id": nullable(p.RestaurantID),
+		"p_order_id":      nullable(p.OrderID),
+		"p_booking_id":    nullable(p.BookingID),
+		"p_note":          nullable(p.Note),
+		"p_expires_at":    nil,
+	}
+	if p.ExpiresAt != nil {
+		params["p_expires_at"] = p.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+
+	resultStr := database.Client.Rpc("loyalty_post", "", params)
+
+	var entries []Entry
+	if err := json.Unmarshal([]byte(resultStr), &entries); err != nil || len(entries) == 0 {
+		if strings.Contains(resultStr, "insufficient points") {
+			return nil, ErrInsufficient
+		}
+		return nil, fmt.Errorf("loyalty_post: %s", resultStr)
+	}
+	return &entries[0], nil
+}
+
+func earnExpiry() *time.Time {
+	t := time.Now().UTC().Add(Expiry)
+	return &t
+}
+
+// earn awards points scaled by the user's current tier
+func earn(userID string, base float64, p Posting) (*Entry, error) {
+	tier, _ := TierFor(EarnedSince(userID, time.Now().Add(-Expiry)))
+	points := int(math.Floor(base * tier.Multiplier))
+	if points <= 0 {
+		return nil, nil
+	}
+	p.UserID = userID
+	p.Points = points
+	p.Kind = "earn"
+	p.ExpiresAt = earnExpiry()
+	return Post(p)
+}
+
+// AwardOrder credits the customer for a completed order; safe to call twice
+func AwardOrder(orderID string) (*Entry, error) {
+	result, _, err := database.Query("orders").
+		Select("id, customer_id, restaurant_id, total, status", "", false).
+		Eq("id", orderID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var order struct {
+		CustomerID   string       `json:"customer_id"`
+		RestaurantID string       `json:"restaurant_id"`
+		Total        money.Amount `json:"total"`
+		Status       string       `json:"status"`
+	}
+	if err := json.Unmarshal(result, &order); err != nil {
+		return nil, err
+	}
+	if order.Status != "completed" || order.CustomerID == "" {
+		return nil, nil
+	}
+
+	rules := ForRestaurant(order.RestaurantID)
+	if !rules.Enabled {
+		return nil, nil
+	}
+	return earn(order.CustomerID, order.Total.Float64()*rules.PointsPerUnit, Posting{
+		RestaurantID: order.RestaurantID,
+		OrderID:      orderID,
+		Note:         "Order completed",
+	})
+}
+
+// AwardBooking credits the customer for an honoured booking; safe to call twice
+func AwardBooking(bookingID, customerID, restaurantID string) (*Entry, error) {
+	if customerID == "" {
+		return nil, nil
+	}
+	rules := ForRestaurant(restaurantID)
+	if !rules.Enabled || rules.BookingPoints <= 0 {
+		return nil, nil
+	}
+	return earn(customerID, float64(rules.BookingPoints), Posting{
+		RestaurantID: restaurantID,
+		BookingID:    bookingID,
+		Note:         "Booking honoured",
+	})
+}
+
+// Quote works out how many of the requested points can be spent on an order
+// and what they are worth; the rest of the basket is always paid in money
+func Quote(rules Rules, requested int, base money.Amount, currency string) (int, money.Amount) {
+	if !rules.Enabled || requested <= 0 || base <= 0 || rules.PointValue <= 0 {
+		return 0, 0
+	}
+	limit := base.Percent(rules.MaxRedeemPercent)
+	maxPoints := int(int64(limit) / int64(rules.PointValue))
+	points := requested
+	if points > maxPoints {
+		points = maxPoints
+	}
+	return points, rules.PointValue.Mul(int64(points)).Round(currency)
+}
+
+// Redeem spends points on an order
+func Redeem(userID, restaurantID string, points int) (*Entry, error) {
+	return Post(Posting{
+		UserID:       userID,
+		Points:       -points,
+		Kind:         "redeem",
+		RestaurantID: restaurantID,
+		Note:         "Redeemed at checkout",
+	})
+}
+
+// LinkOrder records the order a redemption paid for
+func LinkOrder(entryID, orderID string) error {
+	_, _, err := database.Query("loyalty_ledger").
+		Update(map[string]interface{}{"order_id": orderID}, "", "").
+		Eq("id", entryID).
+		Execute()
+	return err
+}
+
+// Reverse gives back the points of a redemption whose order never happened
+func Reverse(e *Entry) error {
+	if e == nil || e.Points >= 0 {
+		return nil
+	}
+	p := Posting{
+		UserID:    e.UserID,
+		Points:    -e.Points,
+		Kind:      "reversal",
+		Note:      "Redemption reversed",
+		ExpiresAt: earnExpiry(),
+	}
+	if e.RestaurantID != nil {
+		p.RestaurantID = *e.RestaurantID
+	}
+	if e.OrderID != nil {
+		p.OrderID = *e.OrderID
+	}
+	_, err := Post(p)
+	return err
+}
+
+// ReverseOrder gives back points spent on a cancelled order. The
+// loyalty_reverse_order SQL function works out what hasn't been reversed
+// yet under the user lock; calling it twice gives back nothing more.
+func ReverseOrder(orderID string) error {
+	resultStr := database.Client.Rpc("loyalty_reverse_order", "", map[string]interface{}{
+		"p_order_id": orderID,
+	})
+
+	var entries []Entry
+	if err := json.Unmarshal([]byte(resultStr), &entries); err != nil {
+		return fmt.Errorf("loyalty_reverse_order: %s", resultStr)
+	}
+	return nil
+}
+
+/*
+-----------------------------------------------------
+SUMMARY
+-----------------------------------------------------
+*/
+
+type Summary struct {
+	Balance        int     `json:"balance"`
+	Tier           Tier    `json:"tier"`
+	NextTier       *Tier   `json:"next_tier"`
+	EarnedThisYear int     `json:"earned_last_12_months"`
+	ExpiringSoon   int     `json:"expiring_within_30_days"`
+	History        []Entry `json:"history"`
+}
+
+// ForUser builds the balance, tier and recent history for a user
+func ForUser(userID string, historyLimit int) (*Summary, error) {
+	result, _, err := database.Query("users").
+		Select("points", "", false).
+		Eq("id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var user struct {
+		Points int `json:"points"`
+	}
+	if err := json.Unmarshal(result, &user); err != nil {
+		return nil, err
+	}
+
+	earned := EarnedSince(userID, time.Now().Add(-Expiry))
+	tier, next := TierFor(earned)
+	s := &Summary{
+		Balance:        user.Points,
+		Tier:           tier,
+		NextTier:       next,
+		EarnedThisYear: earned,
+		History:        []Entry{},
+	}
+
+	if result, _, err := database.Query("loyalty_ledger").
+		Select("remaining", "", false).
+		Eq("user_id", userID).
+		Gt("remaining", "0").
+		Lte("expires_at", time.Now().Add(expiringWindow).UTC().Format(time.RFC3339)).
+		Execute(); err == nil {
+		var lots []struct {
+			Remaining int `json:"remaining"`
+		}
+		if json.Unmarshal(result, &lots) == nil {
+			for _, l := range lots {
+				s.ExpiringSoon += l.Remaining
+			}
+		}
+	}
+
+	result, _, err = database.Query("loyalty_ledger").
+		Select("*", "", false).
+		Eq("user_id", userID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(historyLimit, "").
+		Execute()
+	if err == nil {
+		json.Unmarshal(result, &s.History)
+	}
+	return s, nil
+}
+
+/*
+-----------------------------------------------------
+EXPIRY WORKER
+-----------------------------------------------------
+*/
+
+var workerOnce sync.Once
+
+// StartExpiryWorker periodically expires points past their 12 months
+func StartExpiryWorker(interval time.Duration) {
+	workerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				expireDue()
+			}
+		}()
+		log.Println("✅ Loyalty expiry worker started")
+	})
+}
+
+func expireDue() {
+	if database.Client == nil {
+		return
+	}
+	resultStr := database.Client.Rpc("loyalty_expire", "", map[string]interface{}{})
+	var expired int
+	if err := json.Unmarshal([]byte(resultStr), &expired); err != nil {
+		log.Printf("⚠️  Loyalty expiry failed: %s", resultStr)
+		return
+	}
+	if expired > 0 {
+		log.Printf("⏳ Expired %d loyalty point lots", expired)
+	}
+}
@@ -0,0 +1,95 @@
+package loyalty
+
+import (
+	"encoding/json"
+	"testing"
+
+	"finedine/backend/internal/money"
+)
+
+func TestQuote(t *testing.T) {
+	rules := Rules{Enabled: true, PointValue: money.FromFloat(0.01), MaxRedeemPercent: 50}
+
+	tests := []struct {
+		name      string
+		rules     Rules
+		requested int
+		base      float64
+		currency  string
+		points    int
+		value     string
+	}{
+		{"within the limit", rules, 500, 30, "usd", 500, "5"},
+		{"capped at half the order", rules, 2000, 30, "usd", 1500, "15"},
+		{"limit rounds down to whole points", rules, 1000, 9.99, "usd", 499, "4.99"},
+		{"zero-decimal currency", Rules{Enabled: true, PointValue: money.FromFloat(1), MaxRedeemPercent: 100}, 800, 1200, "jpy", 800, "800"},
+		{"disabled", Rules{PointValue: money.FromFloat(0.01), MaxRedeemPercent: 50}, 500, 30, "usd", 0, "0"},
+		{"nothing requested", rules, 0, 30, "usd", 0, "0"},
+		{"empty order", rules, 500, 0, "usd", 0, "0"},
+	}
+
+	for _, tt := range tests {
+		points, value := Quote(tt.rules, tt.requested, money.FromFloat(tt.base), tt.currency)
+		want, _ := money.Parse(tt.value)
+		if points != tt.points || value != want {
+			t.Errorf("%s: Quote = %d points worth %s, want %d worth %s", tt.name, points, value, tt.points, tt.value)
+		}
+	}
+}
+
+func TestTierFor(t *testing.T) {
+	tests := []struct {
+		earned  int
+		current string
+		next    string // "" at the top tier
+	}{
+		{0, "bronze", "silver"},
+		{999, "bronze", "silver"},
+		{1000, "silver", "gold"},
+		{4999, "silver", "gold"},
+		{5000, "gold", "platinum"},
+		{15000, "platinum", ""},
+		{1000000, "platinum", ""},
+	}
+	for _, tt := range tests {
+		current, next := TierFor(tt.earned)
+		nextName := ""
+		if next != nil {
+			nextName = next.Name
+		}
+		if current.Name != tt.current || nextName != tt.next {
+			t.Errorf("TierFor(%d) = %s, %q; want %s, %q", tt.earned, current.Name, nextName, tt.current, tt.next)
+		}
+	}
+}
+
+func TestRulesDefaults(t *testing.T) {
+	var r Rules
+	if err := json.Unmarshal([]byte(`{"enabled": true}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	r = r.withDefaults()
+	if r.PointsPerUnit != 1 || r.PointValue != money.FromFloat(0.01) || r.MaxRedeemPercent != 50 {
+		t.Errorf("withDefaults = %+v", r)
+	}
+	var off Rules
+	if err := json.Unmarshal([]byte(`{"enabled": true, "points_per_unit": 0}`), &off); err != nil {
+		t.Fatal(err)
+	}
+	if off = off.withDefaults(); off.PointsPerUnit != 0 {
+		t.Errorf("a configured points_per_unit of 0 became %g", off.PointsPerUnit)
+	}
+	custom := (&Rules{PointsPerUnit: 2, MaxRedeemPercent: 20}).withDefaults()
+	if custom.PointsPerUnit != 2 || custom.MaxRedeemPercent != 20 {
+		t.Errorf("withDefaults overrode set values: %+v", custom)
+	}
+	if err := (&Rules{MaxRedeemPercent: 120}).Validate(); err == nil {
+		t.Error("Validate accepted max_redeem_percent over 100")
+	}
+	if err := (&Rules{PointsPerUnit: MaxPointsPerUnit + 1}).Validate(); err == nil {
+		t.Error("Validate accepted points_per_unit over the cap")
+	}
+	if err := (&Rules{BookingPoints: MaxBookingPoints + 1}).Validate(); err == nil {
+		t.Error("Validate accepted booking_points over the cap")
+	}
+}
@@ -0,0 +1,309 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+-----------------------------------------------------
+BRUTE-FORCE & ABUSE PROTECTION
+-----------------------------------------------------
+- Failure counters per IP and per account
+- Exponential lockout once the threshold is crossed
+- Suspicious activity written to `security_events`
+- Optional CAPTCHA challenge after repeated failures
+- Redis-backed, with an in-memory fallback
+*/
+
+const (
+	failureWindow    = 15 * time.Minute
+	captchaThreshold = 3
+	lockoutThreshold = 5
+	baseLockout      = time.Minute
+	maxLockout       = time.Hour
+)
+
+// CaptchaVerifier checks a client-supplied CAPTCHA token (hCaptcha,
+// reCAPTCHA, Turnstile, ...). No verifier means no challenge.
+type CaptchaVerifier interface {
+	Verify(token, remoteIP string) (bool, error)
+}
+
+var captchaVerifier CaptchaVerifier
+
+// SetCaptchaVerifier enables CAPTCHA challenges for guarded endpoints
+func SetCaptchaVerifier(v CaptchaVerifier) {
+	captchaVerifier = v
+}
+
+type failureRecord struct {
+	count       int
+	firstAt     time.Time
+	lockedUntil time.Time
+}
+
+type abuseStore struct {
+	mu      sync.Mutex
+	records map[string]*failureRecord
+}
+
+var localAbuse = &abuseStore{records: make(map[string]*failureRecord)}
+
+func abuseFailKey(action, identity string) string {
+	return "abuse:fail:" + action + ":" + identity
+}
+
+func abuseLockKey(action, identity string) string {
+	return "abuse:lock:" + action + ":" + identity
+}
+
+// lockoutFor grows exponentially with each failure past the threshold
+func lockoutFor(failures int) time.Duration {
+	if failures < lockoutThreshold {
+		return 0
+	}
+	shift := failures - lockoutThreshold
+	if shift > 10 {
+		return maxLockout
+	}
+	d := baseLockout << uint(shift)
+	if d > maxLockout {
+		return maxLockout
+	}
+	return d
+}
+
+// lockedFor returns how long identity is still locked out of action
+func lockedFor(action, identity string) time.Duration {
+	if cache.Client.IsAvailable() {
+		if ttl, err := cache.Client.TTL(abuseLockKey(action, identity)); err == nil && ttl > 0 {
+			return ttl
+		}
+	}
+
+	localAbuse.mu.Lock()
+	defer localAbuse.mu.Unlock()
+	if r, ok := localAbuse.records[abuseFailKey(action, identity)]; ok {
+		if remaining := time.Until(r.lockedUntil); remaining > 0 {
+			return remaining
+		}
+	}
+	return 0
+}
+
+// failures returns the current failure count within the window
+func failures(action, identity string) int {
+	if cache.Client.IsAvailable() {
+		if val, err := cache.Client.GetString(abuseFailKey(action, identity)); err == nil {
+			if n, err := strconv.Atoi(val); err == nil {
+				return n
+			}
+		}
+	}
+
+	localAbuse.mu.Lock()
+	defer localAbuse.mu.Unlock()
+	if r, ok := localAbuse.records[abuseFailKey(action, identity)]; ok && time.Since(r.firstAt) < failureWindow {
+		return r.count
+	}
+	return 0
+}
+
+// recordFailure bumps the counter and applies a lockout when due
+func recordFailure(action, identity string) (int, time.Duration) {
+	key := abuseFailKey(action, identity)
+
+	if cache.Client.IsAvailable() {
+		if n, err := cache.Client.Increment(key, failureWindow); err == nil {
+			lockout := lockoutFor(int(n))
+			if lockout > 0 {
+				cache.Client.SetString(abuseLockKey(action, identity), "1", lockout)
+			}
+			return int(n), lockout
+		}
+	}
+
+	localAbuse.mu.Lock()
+	defer localAbuse.mu.Unlock()
+
+	r, ok := localAbuse.records[key]
+	if !ok || time.Since(r.firstAt) > failureWindow {
+		r = &failureRecord{firstAt: time.Now()}
+		localAbuse.records[key] = r
+	}
+	r.count++
+	lockout := lockoutFor(r.count)
+	if lockout > 0 {
+		r.lockedUntil = time.Now().Add(lockout)
+	}
+	return r.count, lockout
+}
+
+// resetFailures clears the counter after a successful attempt
+func resetFailures(action, identity string) {
+	key := abuseFailKey(action, identity)
+	cache.SafeDelete(key)
+
+	localAbuse.mu.Lock()
+	delete(localAbuse.records, key)
+	localAbuse.mu.Unlock()
+}
+
+// logSecurityEvent records suspicious activity without blocking the request
+func logSecurityEvent(c *gin.Context, action, event, identity string, details map[string]interface{}) {
+	log.Printf("🚨 Security event [%s] %s for %s from %s", action, event, identity, c.ClientIP())
+
+	if database.Client == nil {
+		return
+	}
+
+	row := map[string]interface{}{
+		"action":     action,
+		"event":      event,
+		"identity":   identity,
+		"ip_address": c.ClientIP(),
+		"user_agent": c.Request.UserAgent(),
+		"details":    details,
+	}
+	go func() {
+		database.Query("security_events").
+			Insert(row, false, "", "", "").
+			Execute()
+	}()
+}
+
+// accountIdentity reads the account a request targets: a JSON body field
+// (e.g. "email") or, when field is empty, the authenticated user.
+func accountIdentity(c *gin.Context, field string) string {
+	if field == "" {
+		if userID := c.GetString("userId"); userID != "" {
+			return "user:" + userID
+		}
+		return ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	if val, ok := payload[field].(string); ok && val != "" {
+		return "acct:" + strings.ToLower(strings.TrimSpace(val))
+	}
+	return ""
+}
+
+func isAttemptFailure(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden,
+		http.StatusNotFound, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+/*
+-----------------------------------------------------
+ABUSE GUARD MIDDLEWARE
+-----------------------------------------------------
+Sign-in and password resets go straight to Supabase
+from the client, so only the backend's own guessable
+endpoints (coupon claims, gift card balances,
+referral codes) are guarded here.
+*/
+
+// AbuseGuard counts failed attempts at action per IP and per account.
+// accountField is the JSON body field identifying the account, or "" to
+// use the authenticated user.
+func AbuseGuard(action, accountField string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identities := []string{"ip:" + c.ClientIP()}
+		if account := accountIdentity(c, accountField); account != "" {
+			identities = append(identities, account)
+		}
+
+		// 1. Locked out?
+		for _, identity := range identities {
+			if remaining := lockedFor(action, identity); remaining > 0 {
+				retryAfter := int(remaining.Seconds() + 0.999)
+				logSecurityEvent(c, action, "blocked_while_locked", identity, nil)
+				c.Header("Retry-After", strconv.Itoa(retryAfter))
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error":       "Too many failed attempts. Please try again later.",
+					"retry_after": retryAfter,
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		// 2. CAPTCHA challenge after repeated failures
+		if captchaVerifier != nil {
+			worst := 0
+			for _, identity := range identities {
+				if n := failures(action, identity); n > worst {
+					worst = n
+				}
+			}
+			if worst >= captchaThreshold {
+				token := c.GetHeader("X-Captcha-Token")
+				ok := false
+				if token != "" {
+					ok, _ = captchaVerifier.Verify(token, c.ClientIP())
+				}
+				if !ok {
+					c.JSON(http.StatusForbidden, gin.H{
+						"error":            "CAPTCHA verification required",
+						"captcha_required": true,
+					})
+					c.Abort()
+					return
+				}
+			}
+		}
+
+		c.Next()
+
+		// 3. Count the outcome
+		status := c.Writer.Status()
+		switch {
+		case status >= 200 && status < 300:
+			for _, identity := range identities[1:] {
+				resetFailures(action, identity)
+			}
+
+		case isAttemptFailure(status):
+			for _, identity := range identities {
+				count, lockout := recordFailure(action, identity)
+				if lockout > 0 {
+					logSecurityEvent(c, action, "lockout", identity, map[string]interface{}{
+						"failures":        count,
+						"lockout_seconds": int(lockout.Seconds()),
+					})
+				} else if count == captchaThreshold {
+					logSecurityEvent(c, action, "repeated_failures", identity, map[string]interface{}{
+						"failures": count,
+					})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"finedine/backend/internal/apikey"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+-----------------------------------------------------
+OWNER AUTH (JWT OR API KEY)
+-----------------------------------------------------
+- X-API-Key present  -> validate hashed restaurant key
+- otherwise          -> regular Supabase JWT auth
+- API keys only reach routes listed in apikey.RouteScopes
+- API keys are bound to the restaurant they were issued for
+*/
+func OwnerAuth(jwtSecret string) gin.HandlerFunc {
+	jwtAuth := AuthMiddleware(jwtSecret)
+
+	return func(c *gin.Context) {
+		rawKey := c.GetHeader("X-API-Key")
+		if rawKey == "" {
+			jwtAuth(c)
+			return
+		}
+
+		key, err := apikey.Lookup(rawKey)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			c.Abort()
+			return
+		}
+
+		scope, allowed := apikey.RouteScopes[c.Request.Method+" "+c.FullPath()]
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint is not available to API keys"})
+			c.Abort()
+			return
+		}
+
+		if !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "API key is missing scope " + string(scope),
+			})
+			c.Abort()
+			return
+		}
+
+		if strings.Contains(c.FullPath(), "/restaurants/:id") && c.Param("id") != key.RestaurantID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is not valid for this restaurant"})
+			c.Abort()
+			return
+		}
+
+		// Act on behalf of the restaurant owner
+		c.Set("userId", key.OwnerID)
+		c.Set("userRole", "restaurant_owner")
+		c.Set("apiKeyId", key.ID)
+		c.Set("apiKeyRestaurantId", key.RestaurantID)
+
+		c.Next()
+	}
+}
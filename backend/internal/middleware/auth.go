@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"finedine/backend/internal/session"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -16,9 +19,10 @@ JWT CLAIMS
 Compatible with Supabase JWT structure
 */
 type Claims struct {
-	UserID string `json:"sub"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID    string `json:"sub"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
 	jwt.RegisteredClaims
 }
 
@@ -28,6 +32,7 @@ AUTH MIDDLEWARE
 -----------------------------------------------------
 - Validates Bearer token
 - Verifies signature using JWT secret
+- Rejects revoked sessions / users
 - Injects user context
 */
 func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
@@ -77,10 +82,28 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
+		// Reject tokens from revoked sessions or banned users
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		if session.IsRevoked(claims.SessionID, claims.UserID, issuedAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		session.Touch(claims.SessionID, claims.UserID, session.Device{
+			DeviceID:  c.GetHeader("X-Device-ID"),
+			UserAgent: c.Request.UserAgent(),
+			IPAddress: c.ClientIP(),
+		})
+
 		// Inject user context
 		c.Set("userId", claims.UserID)
 		c.Set("userEmail", claims.Email)
 		c.Set("userRole", claims.Role)
+		c.Set("sessionId", claims.SessionID)
 
 		c.Next()
 	}
@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/plans"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+-----------------------------------------------------
+PLAN GATING & QUOTAS
+-----------------------------------------------------
+- Reads the restaurant's current subscription plan
+- RequireFeature  -> 402 if the plan lacks a feature
+- EnforceQuota    -> 402 if a numeric quota is used up
+- PlanAPIRateLimiter -> per-plan API calls per minute for API keys
+*/
+
+// planResources maps routes on a single row to the table it lives in; the
+// restaurant is read from the row
+var planResources = []struct{ route, table string }{
+	{"/inventory/:id", "inventory"},
+	{"/suppliers/:id", "suppliers"},
+	{"/purchase-orders/:id", "purchase_orders"},
+	{"/waste/:id", "food_waste"},
+	{"/employees/:id", "employees"},
+	{"/shifts/:id", "shifts"},
+}
+
+// planRestaurantID resolves which restaurant a request acts on. ok is false
+// when the route is on a row that can't be found, so gates must deny it.
+func planRestaurantID(c *gin.Context) (string, bool) {
+	if id := c.GetString("apiKeyRestaurantId"); id != "" {
+		return id, true
+	}
+	path := c.FullPath()
+	if strings.Contains(path, "/restaurants/:id") {
+		return c.Param("id"), true
+	}
+	if id := c.GetString("planRestaurantId"); id != "" {
+		return id, true
+	}
+
+	for _, r := range planResources {
+		if !strings.HasSuffix(path, r.route) && !strings.Contains(path, r.route+"/") {
+			continue
+		}
+		restaurantID := resourceRestaurant(r.table, c.Param("id"))
+		c.Set("planRestaurantId", restaurantID)
+		return restaurantID, restaurantID != ""
+	}
+	return "", true
+}
+
+func resourceRestaurant(table, id string) string {
+	result, _, err := database.Query(table).
+		Select("restaurant_id", "", false).
+		Eq("id", id).
+		Single().
+		Execute()
+	if err != nil {
+		return ""
+	}
+	var row struct {
+		RestaurantID string `json:"restaurant_id"`
+	}
+	if json.Unmarshal(result, &row) != nil {
+		return ""
+	}
+	return row.RestaurantID
+}
+
+// currentPlan loads (once per request) the plan for the target restaurant
+func currentPlan(c *gin.Context) *plans.Plan {
+	if p, ok := c.Get("plan"); ok {
+		return p.(*plans.Plan)
+	}
+
+	restaurantID, _ := planRestaurantID(c)
+	if restaurantID == "" {
+		return nil
+	}
+
+	p := plans.ForRestaurant(restaurantID)
+	c.Set("plan", p)
+	return p
+}
+
+func upgradeRequired(c *gin.Context, message string, required *plans.Plan) {
+	c.JSON(http.StatusPaymentRequired, gin.H{
+		"error":         message,
+		"required_plan": required.ID,
+	})
+	c.Abort()
+}
+
+// RequireFeature rejects requests for restaurants whose plan lacks feature
+func RequireFeature(feature plans.Feature) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := planRestaurantID(c); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			c.Abort()
+			return
+		}
+
+		p := currentPlan(c)
+		if p == nil {
+			c.Next()
+			return
+		}
+
+		if !p.Has(feature) {
+			upgradeRequired(c,
+				fmt.Sprintf("Your %s plan does not include %s", p.Name, feature),
+				plans.CheapestWith(feature))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// EnforceQuota rejects creations once the plan's quota is used up
+func EnforceQuota(quota plans.Quota) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := currentPlan(c)
+		if p == nil || p.Limit(quota) == plans.Unlimited {
+			c.Next()
+			return
+		}
+
+		restaurantID, _ := planRestaurantID(c)
+		used, err := quotaUsage(restaurantID, quota)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check plan quota"})
+			c.Abort()
+			return
+		}
+
+		if used >= p.Limit(quota) {
+			next := p
+			for _, candidate := range plans.All() {
+				limit := candidate.Limit(quota)
+				if limit == plans.Unlimited || limit > p.Limit(quota) {
+					next = candidate
+					break
+				}
+			}
+			upgradeRequired(c,
+				fmt.Sprintf("Your %s plan allows %d %s", p.Name, p.Limit(quota), quota),
+				next)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// quotaUsage counts how much of quota a restaurant has consumed
+func quotaUsage(restaurantID string, quota plans.Quota) (int, error) {
+	switch quota {
+	case plans.QuotaMenuItems:
+		_, count, err := database.Query("menu_items").
+			Select("id", "exact", true).
+			Eq("restaurant_id", restaurantID).
+			Execute()
+		return int(count), err
+
+	case plans.QuotaDealsPerMonth:
+		now := time.Now().UTC()
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		_, count, err := database.Query("deals").
+			Select("id", "exact", true).
+			Eq("restaurant_id", restaurantID).
+			Gte("created_at", monthStart.Format(time.RFC3339)).
+			Execute()
+		return int(count), err
+	}
+
+	return 0, fmt.Errorf("unknown quota: %s", quota)
+}
+
+// PlanAPIRateLimiter limits API-key traffic by the restaurant's plan.
+// JWT requests pass straight through.
+func PlanAPIRateLimiter() gin.HandlerFunc {
+	startJanitor()
+
+	return func(c *gin.Context) {
+		if c.GetString("apiKeyId") == "" {
+			c.Next()
+			return
+		}
+
+		p := currentPlan(c)
+		if p == nil || !p.Has(plans.FeatureAPIAccess) {
+			upgradeRequired(c, "API access is not included in your plan",
+				plans.CheapestWith(plans.FeatureAPIAccess))
+			return
+		}
+
+		limit := p.Rate(plans.RateAPICalls)
+		if limit == plans.Unlimited {
+			c.Next()
+			return
+		}
+
+		key := cache.RateLimitKey("apikey", rateLimitIdentity(c))
+		enforce(c, key, limit, "API rate limit exceeded for your plan")
+	}
+}
@@ -2,115 +2,147 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"finedine/backend/internal/cache"
+
 	"github.com/gin-gonic/gin"
 )
 
-type visitor struct {
-	lastSeen time.Time
-	count    int
+/*
+-----------------------------------------------------
+RATE LIMITER
+-----------------------------------------------------
+- Sliding window, keyed by route group + identity
+- Identity: API key > user ID > client IP
+- Shared across replicas via Redis
+- Falls back to an in-process window if Redis is down
+- Emits X-RateLimit-* and Retry-After headers
+*/
+
+const rateLimitWindow = time.Minute
+
+// localWindows is the in-process fallback store (one per process,
+// namespaced by group so limiters never share counters)
+type localWindows struct {
+	mu   sync.Mutex
+	hits map[string][]time.Time
 }
 
 var (
-	visitors = make(map[string]*visitor)
-	mu       sync.RWMutex
+	fallbackWindows = &localWindows{hits: make(map[string][]time.Time)}
+	janitorOnce     sync.Once
 )
 
-// Rate limiter middleware
-func RateLimiter(requestsPerMinute int) gin.HandlerFunc {
-	// Cleanup old visitors every minute
-	go func() {
-		for {
-			time.Sleep(time.Minute)
-			mu.Lock()
-			for ip, v := range visitors {
-				if time.Since(v.lastSeen) > 3*time.Minute {
-					delete(visitors, ip)
-				}
-			}
-			mu.Unlock()
-		}
-	}()
-
-	return func(c *gin.Context) {
-		ip := c.ClientIP()
-
-		mu.Lock()
-		v, exists := visitors[ip]
-		if !exists {
-			visitors[ip] = &visitor{lastSeen: time.Now(), count: 1}
-			mu.Unlock()
-			c.Next()
-			return
-		}
+// allow records a hit and reports allowed / remaining / time until a slot frees
+func (w *localWindows) allow(key string, limit int, window time.Duration) (bool, int, time.Duration) {
+	now := time.Now()
+	cutoff := now.Add(-window)
 
-		if time.Since(v.lastSeen) > time.Minute {
-			v.lastSeen = time.Now()
-			v.count = 1
-			mu.Unlock()
-			c.Next()
-			return
-		}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		if v.count >= requestsPerMinute {
-			mu.Unlock()
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded. Please try again later.",
-			})
-			c.Abort()
-			return
-		}
+	hits := w.hits[key]
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	hits = hits[i:]
 
-		v.count++
-		v.lastSeen = time.Now()
-		mu.Unlock()
+	allowed := len(hits) < limit
+	if allowed {
+		hits = append(hits, now)
+	}
+	w.hits[key] = hits
 
-		c.Next()
+	reset := window
+	if len(hits) > 0 {
+		reset = hits[0].Add(window).Sub(now)
 	}
+	return allowed, limit - len(hits), reset
 }
 
-// API key rate limiter (for restaurant owners with subscriptions)
-func APIKeyRateLimiter(requestsPerMinute int) gin.HandlerFunc {
-	apiKeyVisitors := make(map[string]*visitor)
-	apiKeyMu := sync.RWMutex{}
+// cleanup drops keys whose windows are empty
+func (w *localWindows) cleanup(window time.Duration) {
+	cutoff := time.Now().Add(-window)
 
-	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
-		if apiKey == "" {
-			c.Next()
-			return
-		}
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		apiKeyMu.Lock()
-		v, exists := apiKeyVisitors[apiKey]
-		if !exists {
-			apiKeyVisitors[apiKey] = &visitor{lastSeen: time.Now(), count: 1}
-			apiKeyMu.Unlock()
-			c.Next()
-			return
+	for key, hits := range w.hits {
+		if len(hits) == 0 || !hits[len(hits)-1].After(cutoff) {
+			delete(w.hits, key)
 		}
+	}
+}
 
-		if time.Since(v.lastSeen) > time.Minute {
-			v.lastSeen = time.Now()
-			v.count = 1
-			apiKeyMu.Unlock()
-			c.Next()
-			return
+func startJanitor() {
+	janitorOnce.Do(func() {
+		go func() {
+			for {
+				time.Sleep(rateLimitWindow)
+				fallbackWindows.cleanup(rateLimitWindow)
+			}
+		}()
+	})
+}
+
+// rateLimitIdentity picks the most specific caller identity available. An
+// API key counts only once it has been verified; made-up keys would
+// otherwise each get a fresh window.
+func rateLimitIdentity(c *gin.Context) string {
+	if keyID := c.GetString("apiKeyId"); keyID != "" {
+		return "key:" + keyID
+	}
+	if userID := c.GetString("userId"); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// hit checks the shared Redis window first and the local one as fallback
+func hit(key string, limit int) (bool, int, time.Duration) {
+	if cache.Client.IsAvailable() {
+		allowed, remaining, reset, err := cache.Client.SlidingWindow(key, limit, rateLimitWindow)
+		if err == nil {
+			return allowed, remaining, reset
 		}
+	}
+	return fallbackWindows.allow(key, limit, rateLimitWindow)
+}
 
-		if v.count >= requestsPerMinute {
-			apiKeyMu.Unlock()
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "API rate limit exceeded",
-			})
-			c.Abort()
-			return
+func enforce(c *gin.Context, key string, limit int, message string) {
+	allowed, remaining, reset := hit(key, limit)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(reset).Unix(), 10))
+
+	if !allowed {
+		retryAfter := int(reset.Seconds() + 0.999)
+		if retryAfter < 1 {
+			retryAfter = 1
 		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": message})
+		c.Abort()
+		return
+	}
 
-		v.count++
-		apiKeyMu.Unlock()
-		c.Next()
+	c.Next()
+}
+
+// Rate limiter middleware, scoped to a route group
+func RateLimiter(group string, requestsPerMinute int) gin.HandlerFunc {
+	startJanitor()
+
+	return func(c *gin.Context) {
+		key := cache.RateLimitKey(group, rateLimitIdentity(c))
+		enforce(c, key, requestsPerMinute, "Rate limit exceeded. Please try again later.")
 	}
 }
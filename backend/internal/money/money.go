@@ -0,0 +1,323 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+-----------------------------------------------------
+MONEY
+-----------------------------------------------------
+- Amount is a fixed-point decimal with 4 fractional
+  digits (1 = 0.0001), so sums never drift like float64
+- Intermediate results (tax, percentages, shares) keep
+  4 digits; anything charged, stored or refunded is
+  rounded to the currency's minor unit with Round
+- Rounding is half away from zero ("commercial")
+- JSON (and therefore PostgREST numeric columns) is
+  encoded as a plain decimal number: 12.5, 0.0001
+- Currency codes are ISO 4217, lower-case like Stripe
+*/
+
+type Amount int64
+
+const (
+	digits = 4
+	scale  = 10000
+)
+
+var ErrInvalid = errors.New("invalid money amount")
+
+// roundDiv divides with half-away-from-zero rounding
+func roundDiv(n, d int64) int64 {
+	if d < 0 {
+		n, d = -n, -d
+	}
+	q, r := n/d, n%d
+	if 2*abs(r) >= d {
+		if n < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return q
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// FromFloat converts a float (e.g. a value decoded into interface{})
+// to the nearest Amount
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * scale))
+}
+
+// FromMinor converts minor units (Stripe amounts, cents) to an Amount
+func FromMinor(minor int64, currency string) Amount {
+	return Amount(minor * pow10(digits-Exponent(currency)))
+}
+
+// FromValue reads an amount out of loosely typed JSON (map[string]interface{})
+func FromValue(v interface{}) Amount {
+	switch x := v.(type) {
+	case float64:
+		return FromFloat(x)
+	case int:
+		return Amount(int64(x) * scale)
+	case int64:
+		return Amount(x * scale)
+	case Amount:
+		return x
+	case json.Number:
+		a, _ := Parse(x.String())
+		return a
+	case string:
+		a, _ := Parse(x)
+		return a
+	}
+	return 0
+}
+
+// Parse reads a decimal string exactly; extra digits are rounded
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrInvalid
+	}
+
+	neg := false
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, ErrInvalid
+		}
+		a := FromFloat(f)
+		if neg {
+			a = -a
+		}
+		return a, nil
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, ErrInvalid
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || w > math.MaxInt64/scale {
+		return 0, ErrInvalid
+	}
+
+	var f int64
+	roundUp := false
+	for i, ch := range frac {
+		if ch < '0' || ch > '9' {
+			return 0, ErrInvalid
+		}
+		switch {
+		case i < digits:
+			f = f*10 + int64(ch-'0')
+		case i == digits:
+			roundUp = ch >= '5'
+		}
+	}
+	for i := len(frac); i < digits; i++ {
+		f *= 10
+	}
+
+	a := w*scale + f
+	if roundUp {
+		a++
+	}
+	if neg {
+		a = -a
+	}
+	return Amount(a), nil
+}
+
+// Mul multiplies by an integer quantity
+func (a Amount) Mul(qty int64) Amount {
+	return a * Amount(qty)
+}
+
+// MulFloat multiplies by a ratio (fractional quantities, weights)
+func (a Amount) MulFloat(f float64) Amount {
+	return Amount(math.Round(float64(a) * f))
+}
+
+// Percent returns pct% of a, e.g. a tax or service charge rate
+func (a Amount) Percent(pct float64) Amount {
+	return a.MulFloat(pct / 100)
+}
+
+// Div divides into n parts, rounding the result
+func (a Amount) Div(n int64) Amount {
+	if n == 0 {
+		return 0
+	}
+	return Amount(roundDiv(int64(a), n))
+}
+
+// Round rounds to the currency's minor unit
+func (a Amount) Round(currency string) Amount {
+	unit := pow10(digits - Exponent(currency))
+	return Amount(roundDiv(int64(a), unit) * unit)
+}
+
+// Minor returns the amount in the currency's minor units (for Stripe)
+func (a Amount) Minor(currency string) int64 {
+	return roundDiv(int64(a), pow10(digits-Exponent(currency)))
+}
+
+// Float64 is for display and legacy callers only; never sum floats
+func (a Amount) Float64() float64 {
+	return float64(a) / scale
+}
+
+func (a Amount) IsZero() bool     { return a == 0 }
+func (a Amount) IsPositive() bool { return a > 0 }
+
+// String is the shortest exact decimal form: 12.5, -0.0001, 3
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	whole, frac := v/scale, v%scale
+	if frac == 0 {
+		return sign + strconv.FormatInt(whole, 10)
+	}
+	f := strings.TrimRight(fmt.Sprintf("%0*d", digits, frac), "0")
+	return sign + strconv.FormatInt(whole, 10) + "." + f
+}
+
+// Format renders with exactly the currency's decimals: 12.50, 1250 (JPY)
+func (a Amount) Format(currency string) string {
+	exp := Exponent(currency)
+	minor := a.Minor(currency)
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(minor, 10)
+	}
+	unit := pow10(exp)
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/unit, exp, minor%unit)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts numbers, numeric strings and null
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*a = 0
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	v, err := Parse(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalid, s)
+	}
+	*a = v
+	return nil
+}
+
+// Sum adds amounts
+func Sum(amounts ...Amount) Amount {
+	var total Amount
+	for _, v := range amounts {
+		total += v
+	}
+	return total
+}
+
+/*
+-----------------------------------------------------
+CURRENCIES
+-----------------------------------------------------
+*/
+
+// Exceptions to the usual two minor-unit digits
+var exponents = map[string]int{
+	"bif": 0, "clp": 0, "djf": 0, "gnf": 0, "isk": 0, "jpy": 0,
+	"kmf": 0, "krw": 0, "pyg": 0, "rwf": 0, "ugx": 0, "vnd": 0,
+	"vuv": 0, "xaf": 0, "xof": 0, "xpf": 0,
+	"bhd": 3, "jod": 3, "kwd": 3, "omr": 3, "tnd": 3,
+}
+
+// Exponent is the number of decimals in the currency's minor unit
+func Exponent(currency string) int {
+	if exp, ok := exponents[Normalize(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// Normalize lower-cases a currency code
+func Normalize(currency string) string {
+	return strings.ToLower(strings.TrimSpace(currency))
+}
+
+// ValidCurrency checks the shape of an ISO 4217 code
+func ValidCurrency(currency string) bool {
+	c := Normalize(currency)
+	if len(c) != 3 {
+		return false
+	}
+	for _, ch := range c {
+		if ch < 'a' || ch > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// Money pairs an amount with its currency for API responses
+type Money struct {
+	Amount   Amount `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func New(amount Amount, currency string) Money {
+	return Money{Amount: amount, Currency: Normalize(currency)}
+}
+
+// String renders e.g. "USD 12.50"
+func (m Money) String() string {
+	return strings.ToUpper(m.Currency) + " " + m.Amount.Format(m.Currency)
+}
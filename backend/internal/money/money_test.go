@@ -0,0 +1,151 @@
+package money
+
+import "testing"
+
+func mustParse(t *testing.T, s string) Amount {
+	t.Helper()
+	a, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return a
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"12.5", 125000},
+		{"0.0001", 1},
+		{"3", 30000},
+		{"-1.25", -12500},
+		{".5", 5000},
+		{"1.00005", 10001}, // fifth digit rounds half up
+		{"1.00004", 10000},
+		{"1e2", 1000000},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.in); got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "abc", "1.2.3", "-", "1,5"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     string
+		minor    int64
+	}{
+		{"12.345", "usd", "12.35", 1235}, // half away from zero
+		{"12.344", "usd", "12.34", 1234},
+		{"-12.345", "usd", "-12.35", -1235}, // away from zero when negative too
+		{"0.005", "eur", "0.01", 1},
+		{"0.0049", "eur", "0", 0},
+		{"1234.5", "jpy", "1235", 1235},  // no minor unit
+		{"1.2345", "kwd", "1.235", 1235}, // three decimals
+		{"19.999", "USD", "20", 2000},    // codes are case-insensitive
+	}
+	for _, tt := range tests {
+		a := mustParse(t, tt.in)
+		if got := a.Round(tt.currency).String(); got != tt.want {
+			t.Errorf("%s.Round(%s) = %s, want %s", tt.in, tt.currency, got, tt.want)
+		}
+		if got := a.Minor(tt.currency); got != tt.minor {
+			t.Errorf("%s.Minor(%s) = %d, want %d", tt.in, tt.currency, got, tt.minor)
+		}
+	}
+}
+
+func TestFromMinor(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		currency string
+		want     string
+	}{
+		{1250, "usd", "12.5"},
+		{1250, "jpy", "1250"},
+		{1250, "bhd", "1.25"},
+		{-99, "gbp", "-0.99"},
+	}
+	for _, tt := range tests {
+		if got := FromMinor(tt.minor, tt.currency).String(); got != tt.want {
+			t.Errorf("FromMinor(%d, %s) = %s, want %s", tt.minor, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestPercentAndDiv(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Amount
+		want string
+	}{
+		{"8.875% of 19.99", mustParse(t, "19.99").Percent(8.875), "1.7741"},
+		{"15% of 0.07", mustParse(t, "0.07").Percent(15), "0.0105"},
+		{"10 / 3", mustParse(t, "10").Div(3), "3.3333"},
+		{"-10 / 3", mustParse(t, "-10").Div(3), "-3.3333"},
+		{"0.0005 / 2", mustParse(t, "0.0005").Div(2), "0.0003"},
+		{"divide by zero", mustParse(t, "5").Div(0), "0"},
+	}
+	for _, tt := range tests {
+		if got := tt.got.String(); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSumDoesNotDrift(t *testing.T) {
+	var total Amount
+	for i := 0; i < 1000; i++ {
+		total += mustParse(t, "0.1")
+	}
+	if got := total.String(); got != "100" {
+		t.Errorf("1000 x 0.1 = %s, want 100", got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     string
+	}{
+		{"12.5", "usd", "12.50"},
+		{"-0.5", "usd", "-0.50"},
+		{"1250", "jpy", "1250"},
+		{"1.2", "kwd", "1.200"},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.in).Format(tt.currency); got != tt.want {
+			t.Errorf("%s.Format(%s) = %s, want %s", tt.in, tt.currency, got, tt.want)
+		}
+	}
+	if got := New(mustParse(t, "12.5"), "USD").String(); got != "USD 12.50" {
+		t.Errorf("Money.String() = %s, want USD 12.50", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var a Amount
+	for in, want := range map[string]Amount{`12.5`: 125000, `"3.10"`: 31000, `null`: 0} {
+		if err := a.UnmarshalJSON([]byte(in)); err != nil {
+			t.Fatalf("UnmarshalJSON(%s): %v", in, err)
+		}
+		if a != want {
+			t.Errorf("UnmarshalJSON(%s) = %d, want %d", in, a, want)
+		}
+	}
+	out, _ := mustParse(t, "12.50").MarshalJSON()
+	if string(out) != "12.5" {
+		t.Errorf("MarshalJSON = %s, want 12.5", out)
+	}
+}
@@ -0,0 +1,314 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/deals"
+	"finedine/backend/internal/realtime"
+	"finedine/backend/internal/stock"
+)
+
+/*
+-----------------------------------------------------
+INVENTORY WATCHER
+-----------------------------------------------------
+- Checks every inventory item for quantity <= min_stock
+  and for an expiry_date within INVENTORY_EXPIRY_WARN_DAYS
+  (default 3) or already past
+- Each condition is alerted once: low stock until the
+  item is back above min_stock (low_stock_alerted_at),
+  expiry once per expiry_date while it is coming up
+  (expiry_alerted_for) and once more when it has
+  passed (expired_alerted_for)
+- New alerts go out as one digest per restaurant per
+  local day, from DigestHour in the restaurant's
+  timezone, as a low_stock notification plus an
+  inventory_digest socket message to the owner
+*/
+
+const (
+	DigestHour            = 8
+	defaultExpiryWarnDays = 3
+	inventoryPage         = 1000
+)
+
+func expiryWarnDays() int {
+	if n, err := strconv.Atoi(os.Getenv("INVENTORY_EXPIRY_WARN_DAYS")); err == nil && n >= 0 {
+		return n
+	}
+	return defaultExpiryWarnDays
+}
+
+type watchedItem struct {
+	stock.Item
+	ExpiryDate        *string    `json:"expiry_date"`
+	LowStockAlertedAt *time.Time `json:"low_stock_alerted_at"`
+	ExpiryAlertedFor  *string    `json:"expiry_alerted_for"`
+	ExpiredAlertedFor *string    `json:"expired_alerted_for"`
+	Restaurant        struct {
+		Name     string `json:"name"`
+		OwnerID  string `json:"owner_id"`
+		Timezone string `json:"timezone"`
+	} `json:"restaurants"`
+}
+
+func (w *watchedItem) low() bool {
+	return w.MinStock > 0 && w.Quantity <= w.MinStock
+}
+
+// expiry returns the item's expiry date, if it has one
+func (w *watchedItem) expiry() (string, bool) {
+	if w.ExpiryDate == nil || len(*w.ExpiryDate) < 10 {
+		return "", false
+	}
+	return (*w.ExpiryDate)[:10], true
+}
+
+// DigestItem is one line of an inventory digest
+type DigestItem struct {
+	InventoryID string  `json:"inventory_id"`
+	Name        string  `json:"name"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	MinStock    float64 `json:"min_stock,omitempty"`
+	ExpiryDate  string  `json:"expiry_date,omitempty"`
+}
+
+// Digest is what one restaurant's owner hears about in a day
+type Digest struct {
+	RestaurantID   string       `json:"restaurant_id"`
+	RestaurantName string       `json:"restaurant_name"`
+	LowStock       []DigestItem `json:"low_stock"`
+	Expiring       []DigestItem `json:"expiring"`
+	Expired        []DigestItem `json:"expired"`
+	StillLow       int          `json:"still_low"`
+
+	ownerID  string
+	timezone string
+}
+
+func (d *Digest) empty() bool {
+	return len(d.LowStock) == 0 && len(d.Expiring) == 0 && len(d.Expired) == 0
+}
+
+func (d *Digest) message() string {
+	list := func(items []DigestItem, detail func(DigestItem) string) string {
+		parts := make([]string, 0, len(items))
+		for _, it := range items {
+			parts = append(parts, fmt.Sprintf("%s (%s)", it.Name, detail(it)))
+		}
+		return strings.Join(parts, ", ")
+	}
+	remaining := func(it DigestItem) string { return strings.TrimSpace(fmt.Sprintf("%.2f %s", it.Quantity, it.Unit)) }
+	expires := func(it DigestItem) string { return it.ExpiryDate }
+
+	var sections []string
+	if len(d.LowStock) > 0 {
+		sections = append(sections, "Low stock: "+list(d.LowStock, remaining))
+	}
+	if len(d.Expiring) > 0 {
+		sections = append(sections, "Expiring soon: "+list(d.Expiring, expires))
+	}
+	if len(d.Expired) > 0 {
+		sections = append(sections, "Expired: "+list(d.Expired, expires))
+	}
+	if d.StillLow > 0 {
+		sections = append(sections, fmt.Sprintf("%d more item(s) still low from earlier alerts", d.StillLow))
+	}
+	return strings.Join(sections, ". ")
+}
+
+var inventoryWatcherOnce sync.Once
+
+// StartInventoryWatcher periodically checks stock levels and expiry dates
+func StartInventoryWatcher(interval time.Duration) {
+	inventoryWatcherOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				watchInventory(time.Now())
+			}
+		}()
+		log.Println("✅ Inventory watcher started")
+	})
+}
+
+func loadWatchedItems() ([]watchedItem, error) {
+	items := []watchedItem{}
+	for offset := 0; ; offset += inventoryPage {
+		result, _, err := database.Query("inventory").
+			Select("id, restaurant_id, name, unit, quantity, min_stock, expiry_date, low_stock_alerted_at, expiry_alerted_for, expired_alerted_for, restaurants!inner(name, owner_id, timezone)", "", false).
+			Order("id", nil).
+			Range(offset, offset+inventoryPage-1, "").
+			Execute()
+		if err != nil {
+			return nil, err
+		}
+		var page []watchedItem
+		if err := json.Unmarshal(result, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if len(page) < inventoryPage {
+			return items, nil
+		}
+	}
+}
+
+// alertedFor reports whether an alert column already covers this expiry date
+func alertedFor(alerted *string, expiry string) bool {
+	return alerted != nil && strings.HasPrefix(*alerted, expiry)
+}
+
+func watchInventory(now time.Time) {
+	if database.Client == nil {
+		return
+	}
+	items, err := loadWatchedItems()
+	if err != nil {
+		log.Printf("⚠️  Inventory watcher: failed to load inventory: %v", err)
+		return
+	}
+
+	warnDays := expiryWarnDays()
+	digests := map[string]*Digest{}
+	var recovered []string
+	for i := range items {
+		w := &items[i]
+		d, ok := digests[w.RestaurantID]
+		if !ok {
+			d = &Digest{
+				RestaurantID:   w.RestaurantID,
+				RestaurantName: w.Restaurant.Name,
+				ownerID:        w.Restaurant.OwnerID,
+				timezone:       w.Restaurant.Timezone,
+			}
+			digests[w.RestaurantID] = d
+		}
+		line := DigestItem{InventoryID: w.ID, Name: w.Name, Quantity: w.Quantity, Unit: w.Unit}
+
+		switch {
+		case w.low() && w.LowStockAlertedAt == nil:
+			line.MinStock = w.MinStock
+			d.LowStock = append(d.LowStock, line)
+		case w.low():
+			d.StillLow++
+		case w.LowStockAlertedAt != nil:
+			recovered = append(recovered, w.ID)
+		}
+
+		expiry, ok := w.expiry()
+		if !ok || alertedFor(w.ExpiredAlertedFor, expiry) {
+			continue
+		}
+		date, err := time.ParseInLocation("2006-01-02", expiry, deals.Location(d.timezone))
+		if err != nil {
+			continue
+		}
+		today := now.In(date.Location())
+		today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, date.Location())
+		daysLeft := int(date.Sub(today).Hours() / 24)
+		expiring := DigestItem{InventoryID: w.ID, Name: w.Name, Quantity: w.Quantity, Unit: w.Unit, ExpiryDate: expiry}
+		switch {
+		case daysLeft < 0:
+			d.Expired = append(d.Expired, expiring)
+		case daysLeft <= warnDays && !alertedFor(w.ExpiryAlertedFor, expiry):
+			d.Expiring = append(d.Expiring, expiring)
+		}
+	}
+
+	// Items back above min_stock may be alerted again next time they run low
+	if len(recovered) > 0 {
+		database.Query("inventory").
+			Update(map[string]interface{}{"low_stock_alerted_at": nil}, "", "").
+			In("id", recovered).
+			Execute()
+	}
+
+	sent := 0
+	for _, d := range digests {
+		if d.empty() || d.ownerID == "" {
+			continue
+		}
+		if sendDigest(d, now) {
+			sent++
+		}
+	}
+	if sent > 0 {
+		log.Printf("📦 Sent %d inventory digests", sent)
+	}
+}
+
+// claimDigest records today's digest for the restaurant; false means it
+// already went out today (possibly from another instance)
+func claimDigest(restaurantID, today string) bool {
+	result, _, err := database.Query("restaurants").
+		Update(map[string]interface{}{"inventory_digest_on": today}, "", "").
+		Eq("id", restaurantID).
+		Or("inventory_digest_on.is.null,inventory_digest_on.neq."+today, "").
+		Execute()
+	if err != nil {
+		return false
+	}
+	var rows []map[string]interface{}
+	return json.Unmarshal(result, &rows) == nil && len(rows) > 0
+}
+
+func sendDigest(d *Digest, now time.Time) bool {
+	local := now.In(deals.Location(d.timezone))
+	if local.Hour() < DigestHour {
+		return false
+	}
+	if !claimDigest(d.RestaurantID, local.Format("2006-01-02")) {
+		return false
+	}
+
+	notification := map[string]interface{}{
+		"user_id":         d.ownerID,
+		"restaurant_id":   d.RestaurantID,
+		"restaurant_name": d.RestaurantName,
+		"title":           "Daily Inventory Digest",
+		"message":         d.message(),
+		"type":            "low_stock",
+		"read":            false,
+	}
+	if _, _, err := database.Query("notifications").
+		Insert(notification, false, "", "", "").
+		Execute(); err != nil {
+		log.Printf("⚠️  Inventory digest for %s not stored: %v", d.RestaurantID, err)
+	}
+	realtime.WSHub.SendToUser(d.ownerID, realtime.RealtimeMessage{
+		Type:    "inventory_digest",
+		Payload: d,
+	})
+
+	if len(d.LowStock) > 0 {
+		ids := make([]string, 0, len(d.LowStock))
+		for _, it := range d.LowStock {
+			ids = append(ids, it.InventoryID)
+		}
+		stock.MarkLowStockAlerted(ids...)
+	}
+	for _, it := range d.Expiring {
+		database.Query("inventory").
+			Update(map[string]interface{}{"expiry_alerted_for": it.ExpiryDate}, "", "").
+			Eq("id", it.InventoryID).
+			Execute()
+	}
+	for _, it := range d.Expired {
+		database.Query("inventory").
+			Update(map[string]interface{}{"expired_alerted_for": it.ExpiryDate}, "", "").
+			Eq("id", it.InventoryID).
+			Execute()
+	}
+	return true
+}
@@ -0,0 +1,396 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/realtime"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+/*
+-----------------------------------------------------
+DEAL / OFFER FAN-OUT
+-----------------------------------------------------
+- Audience: customers who favorited the restaurant,
+  whose cuisine_preferences include its cuisine, or
+  who are within their chosen radius of it
+- Each user's notification_preferences decide which
+  of those reasons they want to hear about
+- At most MaxOffersPerDay offer notifications per
+  user per day (Redis, in-process fallback)
+- Writes a notifications row (type offer) and pushes
+  a new_deal message to that user's sockets only
+*/
+
+const (
+	defaultMaxOffersPerDay = 3
+	defaultNearbyRadiusKm  = 5.0
+	offerWindow            = 24 * time.Hour
+	offerSweepInterval     = time.Hour
+	audienceBatch          = 200
+)
+
+// Preferences live in users.notification_preferences; a missing value keeps the default
+type Preferences struct {
+	Offers          *bool    `json:"offers"`
+	Favorites       *bool    `json:"favorites"`
+	Cuisine         *bool    `json:"cuisine"`
+	Nearby          *bool    `json:"nearby"`
+	NearbyRadiusKm  *float64 `json:"nearby_radius_km"`
+	MaxOffersPerDay *int     `json:"max_offers_per_day"`
+}
+
+func enabled(v *bool) bool { return v == nil || *v }
+
+func (p *Preferences) radiusKm() float64 {
+	if p.NearbyRadiusKm != nil && *p.NearbyRadiusKm > 0 {
+		return *p.NearbyRadiusKm
+	}
+	return defaultNearbyRadiusKm
+}
+
+func (p *Preferences) maxPerDay() int {
+	if p.MaxOffersPerDay != nil && *p.MaxOffersPerDay >= 0 {
+		return *p.MaxOffersPerDay
+	}
+	return defaultMaxOffersPerDay
+}
+
+// wants reports whether the user accepts an offer reached them for reason
+func (p *Preferences) wants(reason string) bool {
+	if !enabled(p.Offers) {
+		return false
+	}
+	switch reason {
+	case "favorite":
+		return enabled(p.Favorites)
+	case "cuisine":
+		return enabled(p.Cuisine)
+	case "nearby":
+		return enabled(p.Nearby)
+	}
+	return false
+}
+
+// ValidatePreferences checks a notification_preferences body before it is saved
+func ValidatePreferences(raw interface{}) error {
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	var p Preferences
+	if err := json.Unmarshal(buf, &p); err != nil {
+		return fmt.Errorf("notification_preferences is malformed")
+	}
+	if p.NearbyRadiusKm != nil && (*p.NearbyRadiusKm <= 0 || *p.NearbyRadiusKm > 100) {
+		return fmt.Errorf("nearby_radius_km must be between 0 and 100")
+	}
+	if p.MaxOffersPerDay != nil && (*p.MaxOffersPerDay < 0 || *p.MaxOffersPerDay > 50) {
+		return fmt.Errorf("max_offers_per_day must be between 0 and 50")
+	}
+	return nil
+}
+
+type restaurant struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	OwnerID     string   `json:"owner_id"`
+	CuisineType string   `json:"cuisine_type"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+}
+
+type candidate struct {
+	ID          string      `json:"id"`
+	Latitude    *float64    `json:"latitude"`
+	Longitude   *float64    `json:"longitude"`
+	Preferences Preferences `json:"notification_preferences"`
+}
+
+const candidateColumns = "id, latitude, longitude, notification_preferences"
+
+/*
+-----------------------------------------------------
+RATE LIMIT
+-----------------------------------------------------
+*/
+
+var local = struct {
+	mu        sync.Mutex
+	counts    map[string]*windowCount
+	lastSweep time.Time
+}{counts: make(map[string]*windowCount)}
+
+type windowCount struct {
+	start time.Time
+	n     int
+}
+
+// allowOffer counts an offer notification against the user's daily budget
+func allowOffer(userID string, limit int) bool {
+	if limit == 0 {
+		return false
+	}
+	key := "notify:offers:" + userID
+
+	if cache.Client.IsAvailable() {
+		if n, err := cache.Client.Increment(key, offerWindow); err == nil {
+			return int(n) <= limit
+		}
+	}
+
+	now := time.Now()
+	local.mu.Lock()
+	defer local.mu.Unlock()
+
+	// Drop finished windows so the fallback doesn't keep every user ever notified
+	if now.Sub(local.lastSweep) > offerSweepInterval {
+		for k, w := range local.counts {
+			if now.Sub(w.start) > offerWindow {
+				delete(local.counts, k)
+			}
+		}
+		local.lastSweep = now
+	}
+
+	w, ok := local.counts[key]
+	if !ok || now.Sub(w.start) > offerWindow {
+		w = &windowCount{start: now}
+		local.counts[key] = w
+	}
+	w.n++
+	return w.n <= limit
+}
+
+/*
+-----------------------------------------------------
+AUDIENCE
+-----------------------------------------------------
+*/
+
+func decodeCandidates(result []byte) []candidate {
+	var users []candidate
+	if err := json.Unmarshal(result, &users); err != nil {
+		return nil
+	}
+	return users
+}
+
+// distanceKm is the great-circle distance between two points
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// candidatePages runs a users query one page at a time, so large audiences
+// aren't cut off at the API's row limit
+func candidatePages(filter func(*postgrest.FilterBuilder) *postgrest.FilterBuilder, each func([]candidate)) {
+	for offset := 0; ; offset += audienceBatch {
+		result, _, err := filter(database.Query("users").Select(candidateColumns, "", false)).
+			Order("id", nil).
+			Range(offset, offset+audienceBatch-1, "").
+			Execute()
+		if err != nil {
+			log.Printf("⚠️  Offer fan-out: failed to load users: %v", err)
+			return
+		}
+		page := decodeCandidates(result)
+		each(page)
+		if len(page) < audienceBatch {
+			return
+		}
+	}
+}
+
+// audience maps each targeted user to the first reason they qualified for
+// and want to hear about
+func audience(r restaurant) map[string]candidate {
+	users := map[string]candidate{}
+	add := func(list []candidate, reason string) {
+		for _, u := range list {
+			if u.ID == "" || u.ID == r.OwnerID {
+				continue
+			}
+			if _, seen := users[u.ID]; seen {
+				continue
+			}
+			// A user who muted this reason may still qualify for another
+			if u.Preferences.wants(reason) {
+				users[u.ID] = u
+			}
+		}
+	}
+
+	// 1. Favorites (favorites table, and the legacy users.favorites array)
+	var favIDs []string
+	for offset := 0; ; offset += audienceBatch {
+		result, _, err := database.Query("favorites").
+			Select("user_id", "", false).
+			Eq("restaurant_id", r.ID).
+			Order("user_id", nil).
+			Range(offset, offset+audienceBatch-1, "").
+			Execute()
+		if err != nil {
+			break
+		}
+		var rows []struct {
+			UserID string `json:"user_id"`
+		}
+		if json.Unmarshal(result, &rows) != nil {
+			break
+		}
+		for _, row := range rows {
+			favIDs = append(favIDs, row.UserID)
+		}
+		if len(rows) < audienceBatch {
+			break
+		}
+	}
+	for start := 0; start < len(favIDs); start += audienceBatch {
+		end := start + audienceBatch
+		if end > len(favIDs) {
+			end = len(favIDs)
+		}
+		if result, _, err := database.Query("users").
+			Select(candidateColumns, "", false).
+			In("id", favIDs[start:end]).
+			Execute(); err == nil {
+			add(decodeCandidates(result), "favorite")
+		}
+	}
+	candidatePages(func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+		return q.Contains("favorites", []string{r.ID})
+	}, func(page []candidate) {
+		add(page, "favorite")
+	})
+
+	// 2. Cuisine preferences
+	if r.CuisineType != "" {
+		candidatePages(func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+			return q.Contains("cuisine_preferences", []string{r.CuisineType})
+		}, func(page []candidate) {
+			add(page, "cuisine")
+		})
+	}
+
+	// 3. Proximity: a bounding box on the largest radius users may pick,
+	// then each user's own radius
+	if r.Latitude != nil && r.Longitude != nil {
+		const maxRadiusKm = 100.0
+		lat, lng := *r.Latitude, *r.Longitude
+		dLat := maxRadiusKm / 111.0
+		dLng := dLat / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+		candidatePages(func(q *postgrest.FilterBuilder) *postgrest.FilterBuilder {
+			return q.
+				Gte("latitude", strconv.FormatFloat(lat-dLat, 'f', 6, 64)).
+				Lte("latitude", strconv.FormatFloat(lat+dLat, 'f', 6, 64)).
+				Gte("longitude", strconv.FormatFloat(lng-dLng, 'f', 6, 64)).
+				Lte("longitude", strconv.FormatFloat(lng+dLng, 'f', 6, 64))
+		}, func(page []candidate) {
+			var near []candidate
+			for _, u := range page {
+				if u.Latitude == nil || u.Longitude == nil {
+					continue
+				}
+				if distanceKm(lat, lng, *u.Latitude, *u.Longitude) <= u.Preferences.radiusKm() {
+					near = append(near, u)
+				}
+			}
+			add(near, "nearby")
+		})
+	}
+
+	return users
+}
+
+/*
+-----------------------------------------------------
+FAN-OUT
+-----------------------------------------------------
+*/
+
+// Offer is what a deal/offer notification says
+type Offer struct {
+	RestaurantID string
+	Kind         string // "deal" | "offer"
+	ID           string
+	Title        string
+	Description  string
+	Payload      interface{} // pushed over the socket as-is
+}
+
+// NewOffer notifies the restaurant's audience about a new deal or offer.
+// It does database work per user, so call it in a goroutine.
+func NewOffer(o Offer) {
+	result, _, err := database.Query("restaurants").
+		Select("id, name, owner_id, cuisine_type, latitude, longitude", "", false).
+		Eq("id", o.RestaurantID).
+		Single().
+		Execute()
+	if err != nil {
+		log.Printf("⚠️  Offer fan-out: restaurant %s not found: %v", o.RestaurantID, err)
+		return
+	}
+	var r restaurant
+	if err := json.Unmarshal(result, &r); err != nil {
+		return
+	}
+
+	message := o.Description
+	if message == "" {
+		message = fmt.Sprintf("New at %s: %s", r.Name, o.Title)
+	}
+
+	sent, limited := 0, 0
+	var rows []map[string]interface{}
+	for userID, u := range audience(r) {
+		if !allowOffer(userID, u.Preferences.maxPerDay()) {
+			limited++
+			continue
+		}
+		rows = append(rows, map[string]interface{}{
+			"user_id":         userID,
+			"restaurant_id":   r.ID,
+			"restaurant_name": r.Name,
+			"title":           o.Title,
+			"message":         message,
+			"type":            "offer",
+			"read":            false,
+		})
+		realtime.SendNewDeal(userID, map[string]interface{}{
+			"kind":          o.Kind,
+			"id":            o.ID,
+			"restaurant_id": r.ID,
+			"deal":          o.Payload,
+		})
+		sent++
+	}
+
+	for start := 0; start < len(rows); start += audienceBatch {
+		end := start + audienceBatch
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if _, _, err := database.Query("notifications").
+			Insert(rows[start:end], false, "", "", "").
+			Execute(); err != nil {
+			log.Printf("⚠️  Offer fan-out: failed to store notifications for %s: %v", o.ID, err)
+		}
+	}
+
+	log.Printf("📣 %s %s: notified %d users (%d over their daily limit)", o.Kind, o.ID, sent, limited)
+}
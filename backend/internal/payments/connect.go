@@ -0,0 +1,83 @@
+package payments
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+-----------------------------------------------------
+MARKETPLACE PAYOUTS (STRIPE CONNECT)
+-----------------------------------------------------
+- Each restaurant onboards an Express connected account
+- Customer orders are destination charges to that account
+- The platform keeps a commission as the application fee
+- Balances, payouts and balance transactions are read
+  from the connected account for reporting/reconciliation
+*/
+
+// DefaultCommissionPercent applies when PLATFORM_COMMISSION_PERCENT is unset
+const DefaultCommissionPercent = 10.0
+
+type ConnectedAccount struct {
+	ID               string   `json:"id"`
+	ChargesEnabled   bool     `json:"charges_enabled"`
+	PayoutsEnabled   bool     `json:"payouts_enabled"`
+	DetailsSubmitted bool     `json:"details_submitted"`
+	CurrentlyDue     []string `json:"currently_due"`
+	DisabledReason   string   `json:"disabled_reason,omitempty"`
+}
+
+type BalanceAmount struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type Balance struct {
+	Available []BalanceAmount `json:"available"`
+	Pending   []BalanceAmount `json:"pending"`
+}
+
+type Payout struct {
+	ID          string    `json:"id"`
+	Amount      int64     `json:"amount"`
+	Currency    string    `json:"currency"`
+	Status      string    `json:"status"`
+	ArrivalDate time.Time `json:"arrival_date"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type BalanceTransaction struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Amount    int64     `json:"amount"`
+	Fee       int64     `json:"fee"`
+	Net       int64     `json:"net"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	// ChargeID is the originating platform charge, when the
+	// transaction came from a destination charge transfer
+	ChargeID string `json:"charge_id,omitempty"`
+}
+
+// CommissionPercent is the platform's cut. A restaurant-level override
+// (negative = none) takes precedence over the environment default.
+func CommissionPercent(override float64) float64 {
+	if override >= 0 {
+		return override
+	}
+	if raw := os.Getenv("PLATFORM_COMMISSION_PERCENT"); raw != "" {
+		if pct, err := strconv.ParseFloat(raw, 64); err == nil && pct >= 0 && pct <= 100 {
+			return pct
+		}
+	}
+	return DefaultCommissionPercent
+}
+
+// ApplicationFee returns the commission on amount (smallest currency unit)
+func ApplicationFee(amount int64, percent float64) int64 {
+	return int64(math.Round(float64(amount) * percent / 100))
+}
@@ -0,0 +1,261 @@
+package payments
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+-----------------------------------------------------
+FAKE PROVIDER
+-----------------------------------------------------
+In-memory Provider for tests and offline runs:
+- Intents start as requires_payment_method; Succeed
+  and Process move them along the way a card
+  payment and the webhook would
+- Idempotency keys return the original intent or
+  refund, like Stripe does
+- Refunds can't exceed what the intent captured
+- Connected accounts are created fully onboarded
+*/
+
+const intentRequiresPaymentMethod = "requires_payment_method"
+
+type Fake struct {
+	mu       sync.Mutex
+	seq      int
+	intents  map[string]*fakeIntent
+	refunds  map[string]*Refund // by idempotency key
+	accounts map[string]*ConnectedAccount
+
+	// Refunds lists every refund issued, in order
+	Refunds []Refund
+}
+
+type fakeIntent struct {
+	Intent
+	params   IntentParams
+	refunded int64
+}
+
+var _ Provider = (*Fake)(nil)
+
+func NewFake() *Fake {
+	return &Fake{
+		intents:  map[string]*fakeIntent{},
+		refunds:  map[string]*Refund{},
+		accounts: map[string]*ConnectedAccount{},
+	}
+}
+
+func (f *Fake) id(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_fake_%d", prefix, f.seq)
+}
+
+func (f *Fake) intent(id string) (*fakeIntent, error) {
+	pi, ok := f.intents[id]
+	if !ok {
+		return nil, fmt.Errorf("no such payment_intent: %s", id)
+	}
+	return pi, nil
+}
+
+func (f *Fake) CreatePaymentIntent(params IntentParams) (*Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if params.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	if params.IdempotencyKey != "" {
+		for _, pi := range f.intents {
+			if pi.params.IdempotencyKey == params.IdempotencyKey {
+				out := pi.Intent
+				return &out, nil
+			}
+		}
+	}
+
+	id := f.id("pi")
+	pi := &fakeIntent{
+		Intent: Intent{
+			ID:           id,
+			ClientSecret: id + "_secret",
+			Status:       intentRequiresPaymentMethod,
+			Amount:       params.Amount,
+			Currency:     params.Currency,
+		},
+		params: params,
+	}
+	f.intents[id] = pi
+	out := pi.Intent
+	return &out, nil
+}
+
+func (f *Fake) GetPaymentIntent(id string) (*Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, err := f.intent(id)
+	if err != nil {
+		return nil, err
+	}
+	out := pi.Intent
+	return &out, nil
+}
+
+func (f *Fake) UpdatePaymentIntent(id string, amount, applicationFee int64) (*Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, err := f.intent(id)
+	if err != nil {
+		return nil, err
+	}
+	if pi.Status != intentRequiresPaymentMethod {
+		return nil, fmt.Errorf("payment_intent %s is %s and can no longer be updated", id, pi.Status)
+	}
+	pi.Amount = amount
+	pi.params.Amount = amount
+	pi.params.ApplicationFee = applicationFee
+	out := pi.Intent
+	return &out, nil
+}
+
+func (f *Fake) CancelPaymentIntent(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, err := f.intent(id)
+	if err != nil {
+		return err
+	}
+	if pi.Status == IntentSucceeded || pi.Status == IntentProcessing {
+		return fmt.Errorf("payment_intent %s is %s and can't be canceled", id, pi.Status)
+	}
+	pi.Status = IntentCanceled
+	return nil
+}
+
+// Process marks an intent as submitted but not settled yet (bank debits)
+func (f *Fake) Process(id string) error {
+	return f.setStatus(id, IntentProcessing)
+}
+
+// Succeed marks an intent as paid, as a confirmed card payment would
+func (f *Fake) Succeed(id string) error {
+	return f.setStatus(id, IntentSucceeded)
+}
+
+func (f *Fake) setStatus(id, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, err := f.intent(id)
+	if err != nil {
+		return err
+	}
+	if pi.Status == IntentCanceled || pi.Status == IntentSucceeded {
+		return fmt.Errorf("payment_intent %s is already %s", id, pi.Status)
+	}
+	pi.Status = status
+	return nil
+}
+
+// Params returns what an intent was created (and last updated) with
+func (f *Fake) Params(id string) (IntentParams, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pi, ok := f.intents[id]
+	if !ok {
+		return IntentParams{}, false
+	}
+	return pi.params, true
+}
+
+func (f *Fake) Refund(params RefundParams) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if params.IdempotencyKey != "" {
+		if r, ok := f.refunds[params.IdempotencyKey]; ok {
+			out := *r
+			return &out, nil
+		}
+	}
+
+	pi, err := f.intent(params.PaymentIntentID)
+	if err != nil {
+		return nil, err
+	}
+	if pi.Status != IntentSucceeded {
+		return nil, fmt.Errorf("payment_intent %s has no successful charge to refund", pi.ID)
+	}
+	remaining := pi.Amount - pi.refunded
+	amount := params.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return nil, fmt.Errorf("refund of %d exceeds the %d left on %s", amount, remaining, pi.ID)
+	}
+
+	pi.refunded += amount
+	r := &Refund{ID: f.id("re"), Status: "succeeded", Amount: amount}
+	if params.IdempotencyKey != "" {
+		f.refunds[params.IdempotencyKey] = r
+	}
+	f.Refunds = append(f.Refunds, *r)
+	out := *r
+	return &out, nil
+}
+
+func (f *Fake) CardFingerprint(chargeID string) (string, error) {
+	return "fp_" + chargeID, nil
+}
+
+func (f *Fake) CreateConnectedAccount(email, restaurantID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := f.id("acct")
+	f.accounts[id] = &ConnectedAccount{
+		ID:               id,
+		ChargesEnabled:   true,
+		PayoutsEnabled:   true,
+		DetailsSubmitted: true,
+		CurrentlyDue:     []string{},
+	}
+	return id, nil
+}
+
+func (f *Fake) CreateOnboardingLink(accountID, refreshURL, returnURL string) (string, error) {
+	return returnURL + "?account=" + accountID, nil
+}
+
+func (f *Fake) GetConnectedAccount(accountID string) (*ConnectedAccount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	acct, ok := f.accounts[accountID]
+	if !ok {
+		return nil, fmt.Errorf("no such account: %s", accountID)
+	}
+	out := *acct
+	return &out, nil
+}
+
+func (f *Fake) GetBalance(accountID string) (*Balance, error) {
+	return &Balance{Available: []BalanceAmount{}, Pending: []BalanceAmount{}}, nil
+}
+
+func (f *Fake) ListPayouts(accountID string, limit int) ([]Payout, error) {
+	return []Payout{}, nil
+}
+
+func (f *Fake) ListBalanceTransactions(accountID string, from, to time.Time) ([]BalanceTransaction, error) {
+	return []BalanceTransaction{}, nil
+}
@@ -0,0 +1,130 @@
+package payments
+
+import "testing"
+
+func TestFakeIntentLifecycle(t *testing.T) {
+	f := NewFake()
+
+	pi, err := f.CreatePaymentIntent(IntentParams{Amount: 2500, Currency: "usd", OrderID: "o1", IdempotencyKey: "order-o1"})
+	if err != nil {
+		t.Fatalf("CreatePaymentIntent: %v", err)
+	}
+	again, _ := f.CreatePaymentIntent(IntentParams{Amount: 9999, Currency: "usd", OrderID: "o1", IdempotencyKey: "order-o1"})
+	if again.ID != pi.ID || again.Amount != 2500 {
+		t.Errorf("same idempotency key gave %+v, want the original intent", again)
+	}
+
+	if _, err := f.UpdatePaymentIntent(pi.ID, 2000, 200); err != nil {
+		t.Fatalf("UpdatePaymentIntent before payment: %v", err)
+	}
+	if p, _ := f.Params(pi.ID); p.Amount != 2000 || p.ApplicationFee != 200 {
+		t.Errorf("params after update = %+v", p)
+	}
+
+	if err := f.Process(pi.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.UpdatePaymentIntent(pi.ID, 1000, 0); err == nil {
+		t.Error("updated an intent that is processing")
+	}
+	if err := f.CancelPaymentIntent(pi.ID); err == nil {
+		t.Error("canceled an intent that is processing")
+	}
+	if _, err := f.Refund(RefundParams{PaymentIntentID: pi.ID}); err == nil {
+		t.Error("refunded an intent that hasn't succeeded")
+	}
+
+	if err := f.Succeed(pi.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := f.GetPaymentIntent(pi.ID)
+	if got.Status != IntentSucceeded {
+		t.Errorf("status = %s, want %s", got.Status, IntentSucceeded)
+	}
+}
+
+func TestFakeRefunds(t *testing.T) {
+	f := NewFake()
+	pi, _ := f.CreatePaymentIntent(IntentParams{Amount: 1000, Currency: "eur"})
+	if err := f.Succeed(pi.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		params RefundParams
+		want   int64 // refunded amount; 0 means an error
+	}{
+		{"partial", RefundParams{Amount: 300, IdempotencyKey: "r1"}, 300},
+		{"retry returns the same refund", RefundParams{Amount: 300, IdempotencyKey: "r1"}, 300},
+		{"more than what's left", RefundParams{Amount: 800, IdempotencyKey: "r2"}, 0},
+		{"the remainder", RefundParams{IdempotencyKey: "r3"}, 700},
+		{"nothing left", RefundParams{Amount: 1, IdempotencyKey: "r4"}, 0},
+	}
+	for _, tt := range tests {
+		tt.params.PaymentIntentID = pi.ID
+		r, err := f.Refund(tt.params)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%s: refunded %d, want an error", tt.name, r.Amount)
+			}
+			continue
+		}
+		if err != nil || r.Amount != tt.want {
+			t.Errorf("%s: Refund = %+v, %v, want %d", tt.name, r, err, tt.want)
+		}
+	}
+	if len(f.Refunds) != 2 {
+		t.Errorf("issued %d refunds, want 2", len(f.Refunds))
+	}
+}
+
+func TestFakeCancel(t *testing.T) {
+	f := NewFake()
+	pi, _ := f.CreatePaymentIntent(IntentParams{Amount: 500, Currency: "usd"})
+	if err := f.CancelPaymentIntent(pi.ID); err != nil {
+		t.Fatalf("CancelPaymentIntent: %v", err)
+	}
+	if err := f.Succeed(pi.ID); err == nil {
+		t.Error("a canceled intent succeeded")
+	}
+	if _, err := f.GetPaymentIntent("pi_missing"); err == nil {
+		t.Error("GetPaymentIntent found an unknown intent")
+	}
+}
+
+func TestApplicationFee(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		percent float64
+		want    int64
+	}{
+		{10000, 10, 1000},
+		{999, 10, 100}, // 99.9 rounds up
+		{1005, 2.5, 25},
+		{500, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := ApplicationFee(tt.amount, tt.percent); got != tt.want {
+			t.Errorf("ApplicationFee(%d, %g) = %d, want %d", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestCommissionPercent(t *testing.T) {
+	t.Setenv("PLATFORM_COMMISSION_PERCENT", "")
+	if got := CommissionPercent(-1); got != DefaultCommissionPercent {
+		t.Errorf("no override, no env: %g, want %g", got, DefaultCommissionPercent)
+	}
+	t.Setenv("PLATFORM_COMMISSION_PERCENT", "7.5")
+	if got := CommissionPercent(-1); got != 7.5 {
+		t.Errorf("env: %g, want 7.5", got)
+	}
+	if got := CommissionPercent(0); got != 0 {
+		t.Errorf("a zero override is an override: %g", got)
+	}
+	t.Setenv("PLATFORM_COMMISSION_PERCENT", "150")
+	if got := CommissionPercent(-1); got != DefaultCommissionPercent {
+		t.Errorf("out-of-range env: %g, want the default", got)
+	}
+}
@@ -0,0 +1,95 @@
+package payments
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------------------------
+PAYMENT PROVIDER
+-----------------------------------------------------
+Handlers talk to this interface, never to Stripe
+directly, so the provider can be swapped for a fake
+in tests or when running offline.
+*/
+
+type Provider interface {
+	CreatePaymentIntent(params IntentParams) (*Intent, error)
+	GetPaymentIntent(id string) (*Intent, error)
+	// UpdatePaymentIntent changes the amount of an intent that has not been
+	// confirmed yet
+	UpdatePaymentIntent(id string, amount, applicationFee int64) (*Intent, error)
+	CancelPaymentIntent(id string) error
+	Refund(params RefundParams) (*Refund, error)
+	// CardFingerprint identifies the card behind a charge across customers
+	CardFingerprint(chargeID string) (string, error)
+
+	// Stripe Connect (restaurant payouts)
+	CreateConnectedAccount(email, restaurantID string) (string, error)
+	CreateOnboardingLink(accountID, refreshURL, returnURL string) (string, error)
+	GetConnectedAccount(accountID string) (*ConnectedAccount, error)
+	GetBalance(accountID string) (*Balance, error)
+	ListPayouts(accountID string, limit int) ([]Payout, error)
+	ListBalanceTransactions(accountID string, from, to time.Time) ([]BalanceTransaction, error)
+}
+
+type IntentParams struct {
+	Amount         int64 // smallest currency unit
+	Currency       string
+	OrderID        string
+	SplitID        string // one share of a split bill
+	GiftCardID     string // purchase of a gift card, not an order
+	CustomerID     string
+	RestaurantID   string
+	IdempotencyKey string
+
+	// Destination charge: funds go to the restaurant's connected
+	// account, minus ApplicationFee kept by the platform
+	DestinationAccount string
+	ApplicationFee     int64
+}
+
+// Intent statuses the handlers act on
+const (
+	IntentProcessing = "processing"
+	IntentSucceeded  = "succeeded"
+	IntentCanceled   = "canceled"
+)
+
+type Intent struct {
+	ID           string `json:"id"`
+	ClientSecret string `json:"client_secret"`
+	Status       string `json:"status"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+}
+
+type RefundParams struct {
+	PaymentIntentID string
+	Amount          int64 // smallest currency unit; 0 refunds the remainder
+	Reason          string
+	IdempotencyKey  string
+
+	// For destination charges: pull the funds back from the restaurant
+	// and return the platform commission proportionally
+	ReverseTransfer bool
+}
+
+type Refund struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount int64  `json:"amount"`
+}
+
+// Default is the provider used by handlers
+var Default Provider = NewStripeProvider()
+
+// Currency is the platform default for restaurants without their own currency
+func Currency() string {
+	if cur := os.Getenv("STRIPE_CURRENCY"); cur != "" {
+		return strings.ToLower(cur)
+	}
+	return "usd"
+}
@@ -0,0 +1,275 @@
+package payments
+
+import (
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/account"
+	"github.com/stripe/stripe-go/v76/accountlink"
+	"github.com/stripe/stripe-go/v76/balance"
+	"github.com/stripe/stripe-go/v76/balancetransaction"
+	"github.com/stripe/stripe-go/v76/charge"
+	"github.com/stripe/stripe-go/v76/paymentintent"
+	"github.com/stripe/stripe-go/v76/payout"
+	"github.com/stripe/stripe-go/v76/refund"
+)
+
+// stripeProvider implements Provider on top of the Stripe API.
+// stripe.Key is configured in handlers/subscription.go.
+type stripeProvider struct{}
+
+func NewStripeProvider() Provider {
+	return &stripeProvider{}
+}
+
+func (p *stripeProvider) CreatePaymentIntent(in IntentParams) (*Intent, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(in.Amount),
+		Currency: stripe.String(in.Currency),
+		// Cards plus wallets (Apple Pay / Google Pay)
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+		Metadata: map[string]string{
+			"order_id":      in.OrderID,
+			"customer_id":   in.CustomerID,
+			"restaurant_id": in.RestaurantID,
+		},
+	}
+	if in.SplitID != "" {
+		params.AddMetadata("split_id", in.SplitID)
+	}
+	if in.GiftCardID != "" {
+		params.AddMetadata("gift_card_id", in.GiftCardID)
+	}
+	if in.DestinationAccount != "" {
+		params.TransferData = &stripe.PaymentIntentTransferDataParams{
+			Destination: stripe.String(in.DestinationAccount),
+		}
+		if in.ApplicationFee > 0 {
+			params.ApplicationFeeAmount = stripe.Int64(in.ApplicationFee)
+		}
+	}
+	if in.IdempotencyKey != "" {
+		params.SetIdempotencyKey(in.IdempotencyKey)
+	}
+
+	pi, err := paymentintent.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return toIntent(pi), nil
+}
+
+func (p *stripeProvider) GetPaymentIntent(id string) (*Intent, error) {
+	pi, err := paymentintent.Get(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toIntent(pi), nil
+}
+
+func (p *stripeProvider) UpdatePaymentIntent(id string, amount, applicationFee int64) (*Intent, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount: stripe.Int64(amount),
+	}
+	if applicationFee > 0 {
+		params.ApplicationFeeAmount = stripe.Int64(applicationFee)
+	}
+
+	pi, err := paymentintent.Update(id, params)
+	if err != nil {
+		return nil, err
+	}
+	return toIntent(pi), nil
+}
+
+func (p *stripeProvider) CancelPaymentIntent(id string) error {
+	_, err := paymentintent.Cancel(id, nil)
+	return err
+}
+
+func toIntent(pi *stripe.PaymentIntent) *Intent {
+	return &Intent{
+		ID:           pi.ID,
+		ClientSecret: pi.ClientSecret,
+		Status:       string(pi.Status),
+		Amount:       pi.Amount,
+		Currency:     string(pi.Currency),
+	}
+}
+
+func (p *stripeProvider) Refund(in RefundParams) (*Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(in.PaymentIntentID),
+	}
+	if in.Amount > 0 {
+		params.Amount = stripe.Int64(in.Amount)
+	}
+	if in.ReverseTransfer {
+		params.ReverseTransfer = stripe.Bool(true)
+		params.RefundApplicationFee = stripe.Bool(true)
+	}
+	switch in.Reason {
+	case "duplicate", "fraudulent", "requested_by_customer":
+		params.Reason = stripe.String(in.Reason)
+	}
+	if in.IdempotencyKey != "" {
+		params.SetIdempotencyKey(in.IdempotencyKey)
+	}
+
+	r, err := refund.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Refund{
+		ID:     r.ID,
+		Status: string(r.Status),
+		Amount: r.Amount,
+	}, nil
+}
+
+func (p *stripeProvider) CardFingerprint(chargeID string) (string, error) {
+	ch, err := charge.Get(chargeID, nil)
+	if err != nil {
+		return "", err
+	}
+	if ch.PaymentMethodDetails == nil || ch.PaymentMethodDetails.Card == nil {
+		return "", nil
+	}
+	return ch.PaymentMethodDetails.Card.Fingerprint, nil
+}
+
+func (p *stripeProvider) CreateConnectedAccount(email, restaurantID string) (string, error) {
+	params := &stripe.AccountParams{
+		Type:  stripe.String(string(stripe.AccountTypeExpress)),
+		Email: stripe.String(email),
+		Capabilities: &stripe.AccountCapabilitiesParams{
+			CardPayments: &stripe.AccountCapabilitiesCardPaymentsParams{Requested: stripe.Bool(true)},
+			Transfers:    &stripe.AccountCapabilitiesTransfersParams{Requested: stripe.Bool(true)},
+		},
+	}
+	params.AddMetadata("restaurant_id", restaurantID)
+	params.SetIdempotencyKey("connect-account-" + restaurantID)
+
+	acct, err := account.New(params)
+	if err != nil {
+		return "", err
+	}
+	return acct.ID, nil
+}
+
+func (p *stripeProvider) CreateOnboardingLink(accountID, refreshURL, returnURL string) (string, error) {
+	link, err := accountlink.New(&stripe.AccountLinkParams{
+		Account:    stripe.String(accountID),
+		RefreshURL: stripe.String(refreshURL),
+		ReturnURL:  stripe.String(returnURL),
+		Type:       stripe.String("account_onboarding"),
+	})
+	if err != nil {
+		return "", err
+	}
+	return link.URL, nil
+}
+
+func (p *stripeProvider) GetConnectedAccount(accountID string) (*ConnectedAccount, error) {
+	acct, err := account.GetByID(accountID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &ConnectedAccount{
+		ID:               acct.ID,
+		ChargesEnabled:   acct.ChargesEnabled,
+		PayoutsEnabled:   acct.PayoutsEnabled,
+		DetailsSubmitted: acct.DetailsSubmitted,
+		CurrentlyDue:     []string{},
+	}
+	if acct.Requirements != nil {
+		out.CurrentlyDue = append(out.CurrentlyDue, acct.Requirements.CurrentlyDue...)
+		out.DisabledReason = string(acct.Requirements.DisabledReason)
+	}
+	return out, nil
+}
+
+func (p *stripeProvider) GetBalance(accountID string) (*Balance, error) {
+	params := &stripe.BalanceParams{}
+	params.SetStripeAccount(accountID)
+
+	bal, err := balance.Get(params)
+	if err != nil {
+		return nil, err
+	}
+
+	convert := func(amounts []*stripe.Amount) []BalanceAmount {
+		out := make([]BalanceAmount, 0, len(amounts))
+		for _, a := range amounts {
+			out = append(out, BalanceAmount{Amount: a.Amount, Currency: string(a.Currency)})
+		}
+		return out
+	}
+
+	return &Balance{
+		Available: convert(bal.Available),
+		Pending:   convert(bal.Pending),
+	}, nil
+}
+
+func (p *stripeProvider) ListPayouts(accountID string, limit int) ([]Payout, error) {
+	params := &stripe.PayoutListParams{}
+	params.Limit = stripe.Int64(int64(limit))
+	params.SetStripeAccount(accountID)
+
+	out := []Payout{}
+	iter := payout.List(params)
+	for iter.Next() && len(out) < limit {
+		po := iter.Payout()
+		out = append(out, Payout{
+			ID:          po.ID,
+			Amount:      po.Amount,
+			Currency:    string(po.Currency),
+			Status:      string(po.Status),
+			ArrivalDate: time.Unix(po.ArrivalDate, 0).UTC(),
+			CreatedAt:   time.Unix(po.Created, 0).UTC(),
+		})
+	}
+	return out, iter.Err()
+}
+
+func (p *stripeProvider) ListBalanceTransactions(accountID string, from, to time.Time) ([]BalanceTransaction, error) {
+	params := &stripe.BalanceTransactionListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+	params.Limit = stripe.Int64(100)
+	// Destination payments carry the platform charge on their source transfer
+	params.AddExpand("data.source.source_transfer")
+	params.SetStripeAccount(accountID)
+
+	out := []BalanceTransaction{}
+	iter := balancetransaction.List(params)
+	for iter.Next() {
+		bt := iter.BalanceTransaction()
+		tx := BalanceTransaction{
+			ID:        bt.ID,
+			Type:      string(bt.Type),
+			Amount:    bt.Amount,
+			Fee:       bt.Fee,
+			Net:       bt.Net,
+			Currency:  string(bt.Currency),
+			Status:    string(bt.Status),
+			CreatedAt: time.Unix(bt.Created, 0).UTC(),
+		}
+		if bt.Source != nil && bt.Source.Charge != nil {
+			ch := bt.Source.Charge
+			if ch.SourceTransfer != nil && ch.SourceTransfer.SourceTransaction != nil {
+				tx.ChargeID = ch.SourceTransfer.SourceTransaction.ID
+			}
+		}
+		out = append(out, tx)
+	}
+	return out, iter.Err()
+}
@@ -0,0 +1,277 @@
+package plans
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+)
+
+/*
+-----------------------------------------------------
+SUBSCRIPTION PLANS
+-----------------------------------------------------
+- free / pro / enterprise
+- Each plan maps to a Stripe price ID (env configured)
+- Features are on/off gates, quotas are numeric limits
+  counted against usage, rate limits cap requests per
+  minute
+- Unlimited quotas and rates are expressed as -1
+*/
+
+type Feature string
+
+const (
+	FeatureAnalytics  Feature = "analytics"
+	FeatureInventory  Feature = "inventory"
+	FeatureScheduling Feature = "employee_scheduling"
+	FeatureAPIAccess  Feature = "api_access"
+)
+
+type Quota string
+
+const (
+	QuotaMenuItems     Quota = "menu_items"
+	QuotaDealsPerMonth Quota = "deals_per_month"
+)
+
+type RateLimit string
+
+const (
+	RateAPICalls RateLimit = "api_calls_per_minute"
+)
+
+const Unlimited = -1
+
+type Plan struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	StripePriceID   string            `json:"-"`
+	TrialDays       int               `json:"trial_days"`
+	AnalyticsPeriod []string          `json:"analytics_periods"`
+	Features        map[Feature]bool  `json:"features"`
+	Quotas          map[Quota]int     `json:"quotas"`
+	RateLimits      map[RateLimit]int `json:"rate_limits"`
+}
+
+const (
+	Free       = "free"
+	Pro        = "pro"
+	Enterprise = "enterprise"
+)
+
+// order is used for "requires at least" comparisons
+var order = []string{Free, Pro, Enterprise}
+
+var catalog = map[string]*Plan{
+	Free: {
+		ID:              Free,
+		Name:            "Free",
+		AnalyticsPeriod: []string{"week"},
+		Features: map[Feature]bool{
+			FeatureAnalytics: true,
+		},
+		Quotas: map[Quota]int{
+			QuotaMenuItems:     25,
+			QuotaDealsPerMonth: 2,
+		},
+		RateLimits: map[RateLimit]int{
+			RateAPICalls: 0,
+		},
+	},
+	Pro: {
+		ID:              Pro,
+		Name:            "Pro",
+		TrialDays:       14,
+		AnalyticsPeriod: []string{"week", "month"},
+		Features: map[Feature]bool{
+			FeatureAnalytics:  true,
+			FeatureInventory:  true,
+			FeatureScheduling: true,
+			FeatureAPIAccess:  true,
+		},
+		Quotas: map[Quota]int{
+			QuotaMenuItems:     200,
+			QuotaDealsPerMonth: 20,
+		},
+		RateLimits: map[RateLimit]int{
+			RateAPICalls: 120,
+		},
+	},
+	Enterprise: {
+		ID:              Enterprise,
+		Name:            "Enterprise",
+		TrialDays:       14,
+		AnalyticsPeriod: []string{"week", "month", "year"},
+		Features: map[Feature]bool{
+			FeatureAnalytics:  true,
+			FeatureInventory:  true,
+			FeatureScheduling: true,
+			FeatureAPIAccess:  true,
+		},
+		Quotas: map[Quota]int{
+			QuotaMenuItems:     Unlimited,
+			QuotaDealsPerMonth: Unlimited,
+		},
+		RateLimits: map[RateLimit]int{
+			RateAPICalls: 600,
+		},
+	},
+}
+
+func init() {
+	catalog[Pro].StripePriceID = os.Getenv("STRIPE_PRO_PRICE_ID")
+	if catalog[Pro].StripePriceID == "" {
+		// Legacy single-plan setup
+		catalog[Pro].StripePriceID = os.Getenv("STRIPE_ANNUAL_PRICE_ID")
+	}
+	catalog[Enterprise].StripePriceID = os.Getenv("STRIPE_ENTERPRISE_PRICE_ID")
+}
+
+/*
+-----------------------------------------------------
+CATALOG LOOKUPS
+-----------------------------------------------------
+*/
+
+// Get returns a plan by ID, falling back to free
+func Get(id string) *Plan {
+	if p, ok := catalog[id]; ok {
+		return p
+	}
+	return catalog[Free]
+}
+
+// All returns every plan from cheapest to most expensive
+func All() []*Plan {
+	all := make([]*Plan, 0, len(order))
+	for _, id := range order {
+		all = append(all, catalog[id])
+	}
+	return all
+}
+
+// ByPriceID resolves a Stripe price to a plan
+func ByPriceID(priceID string) (*Plan, bool) {
+	if priceID == "" {
+		return nil, false
+	}
+	for _, p := range catalog {
+		if p.StripePriceID == priceID {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Has reports whether the plan includes feature
+func (p *Plan) Has(feature Feature) bool {
+	return p.Features[feature]
+}
+
+// Limit returns the quota value (Unlimited = -1)
+func (p *Plan) Limit(quota Quota) int {
+	return p.Quotas[quota]
+}
+
+// Rate returns the per-minute rate limit (Unlimited = -1)
+func (p *Plan) Rate(limit RateLimit) int {
+	return p.RateLimits[limit]
+}
+
+// AllowsAnalyticsPeriod reports whether period is within the plan's analytics depth
+func (p *Plan) AllowsAnalyticsPeriod(period string) bool {
+	for _, allowed := range p.AnalyticsPeriod {
+		if allowed == period {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUpgrade reports whether moving from one plan to another costs more
+func IsUpgrade(from, to string) bool {
+	return rank(to) > rank(from)
+}
+
+func rank(id string) int {
+	for i, candidate := range order {
+		if candidate == id {
+			return i
+		}
+	}
+	return 0
+}
+
+// CheapestWith returns the cheapest plan that includes feature
+func CheapestWith(feature Feature) *Plan {
+	for _, id := range order {
+		if catalog[id].Has(feature) {
+			return catalog[id]
+		}
+	}
+	return catalog[Enterprise]
+}
+
+/*
+-----------------------------------------------------
+RESTAURANT SUBSCRIPTION
+-----------------------------------------------------
+*/
+
+const (
+	planCacheTTL = 5 * time.Minute
+
+	// Stripe renews at the period end; allow time for the renewal webhook
+	renewalGrace = 24 * time.Hour
+)
+
+func cacheKey(restaurantID string) string {
+	return "plan:" + restaurantID
+}
+
+// ForRestaurant returns the plan a restaurant is currently entitled to.
+// Anything other than an active (or trialing), unexpired subscription is free.
+func ForRestaurant(restaurantID string) *Plan {
+	var planID string
+	if err := cache.SafeGet(cacheKey(restaurantID), &planID); err == nil {
+		return Get(planID)
+	}
+
+	planID = Free
+
+	result, _, err := database.Query("restaurants").
+		Select("subscription_plan, subscription_status, subscription_expires_at", "", false).
+		Eq("id", restaurantID).
+		Single().
+		Execute()
+
+	if err == nil {
+		var row struct {
+			Plan      string     `json:"subscription_plan"`
+			Status    string     `json:"subscription_status"`
+			ExpiresAt *time.Time `json:"subscription_expires_at"`
+		}
+		if json.Unmarshal(result, &row) == nil {
+			active := row.Status == "active" || row.Status == "trialing"
+			unexpired := row.ExpiresAt == nil || time.Now().Before(row.ExpiresAt.Add(renewalGrace))
+			if active && unexpired {
+				planID = Get(row.Plan).ID
+				if row.Plan == "" {
+					// Subscribed before plans existed
+					planID = Pro
+				}
+			}
+		}
+	}
+
+	cache.SafeSet(cacheKey(restaurantID), planID, planCacheTTL)
+	return Get(planID)
+}
+
+// Forget drops the cached plan after a subscription change
+func Forget(restaurantID string) {
+	cache.SafeDelete(cacheKey(restaurantID))
+}
@@ -0,0 +1,88 @@
+package promo
+
+import (
+	"encoding/json"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/deals"
+)
+
+/*
+-----------------------------------------------------
+AUTOMATIC OFFERS
+-----------------------------------------------------
+- offers are promotions applied at checkout without
+  a coupon, on the same schedule fields as deals
+- The restaurant's offers are cached as stored; the
+  schedule is evaluated on every read
+*/
+
+const offersCacheTTL = 5 * time.Minute
+
+type offer struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Rules     []Rule `json:"rules"`
+	Stackable bool   `json:"stackable"`
+	deals.Schedule
+}
+
+type offerSet struct {
+	Timezone string  `json:"timezone"`
+	Offers   []offer `json:"offers"`
+}
+
+func offersKey(restaurantID string) string {
+	return "offers:" + restaurantID
+}
+
+func loadOffers(restaurantID string) offerSet {
+	var set offerSet
+	if err := cache.SafeGet(offersKey(restaurantID), &set); err == nil {
+		return set
+	}
+
+	result, _, err := database.Query("restaurants").
+		Select("timezone, offers(id, title, rules, stackable, valid_from, valid_till, days_available, start_time, end_time)", "", false).
+		Eq("id", restaurantID).
+		Eq("offers.is_active", "true").
+		Single().
+		Execute()
+	if err != nil {
+		return set
+	}
+	if json.Unmarshal(result, &set) != nil {
+		return offerSet{}
+	}
+
+	cache.SafeSet(offersKey(restaurantID), set, offersCacheTTL)
+	return set
+}
+
+// Offers returns the restaurant's automatic promotions live at now
+func Offers(restaurantID string, now time.Time) []Promotion {
+	set := loadOffers(restaurantID)
+	loc := deals.Location(set.Timezone)
+
+	promos := make([]Promotion, 0, len(set.Offers))
+	for _, o := range set.Offers {
+		if len(o.Rules) == 0 || !o.ActiveAt(now, loc) {
+			continue
+		}
+		promos = append(promos, Promotion{
+			ID:        o.ID,
+			Kind:      "offer",
+			Title:     o.Title,
+			Rules:     o.Rules,
+			Stackable: o.Stackable,
+		})
+	}
+	return promos
+}
+
+// ForgetOffers drops the cached offers after the owner edits them
+func ForgetOffers(restaurantID string) {
+	cache.SafeDelete(offersKey(restaurantID))
+}
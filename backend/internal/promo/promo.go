@@ -0,0 +1,336 @@
+package promo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"finedine/backend/internal/money"
+)
+
+/*
+-----------------------------------------------------
+PROMOTION RULES ENGINE
+-----------------------------------------------------
+- A Promotion is a set of Rules; all of its rules
+  apply together (e.g. 10% off + a free drink)
+- Rules: percent_off, amount_off (optionally limited
+  to a category), buy_x_get_y, free_item (with a
+  minimum spend) and first_order
+- Every rule is priced against the undiscounted
+  basket, then capped by max_discount and the base
+  it applies to
+- Stacking: stackable promotions add up; a
+  non-stackable one is used alone. Apply picks
+  whichever combination saves the customer most
+- Order checkout and coupon validation both price
+  through Apply, so a quote always matches the order
+*/
+
+type RuleType string
+
+const (
+	PercentOff RuleType = "percent_off"
+	AmountOff  RuleType = "amount_off"
+	BuyXGetY   RuleType = "buy_x_get_y"
+	FreeItem   RuleType = "free_item"
+	FirstOrder RuleType = "first_order"
+)
+
+type Rule struct {
+	Type RuleType `json:"type"`
+
+	Percent  float64      `json:"percent,omitempty"`
+	Amount   money.Amount `json:"amount,omitempty"`
+	Category string       `json:"category,omitempty"` // limits the rule to one menu category
+	ItemID   string       `json:"item_id,omitempty"`  // menu item for buy_x_get_y / free_item
+
+	BuyQuantity int `json:"buy_quantity,omitempty"`
+	GetQuantity int `json:"get_quantity,omitempty"`
+
+	MinSpend    money.Amount `json:"min_spend,omitempty"`
+	MaxDiscount money.Amount `json:"max_discount,omitempty"`
+}
+
+type Promotion struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"` // "offer" | "coupon"
+	Title     string `json:"title"`
+	Rules     []Rule `json:"rules"`
+	Stackable bool   `json:"stackable"`
+}
+
+type Line struct {
+	ItemID    string
+	Category  string
+	Quantity  int
+	UnitPrice money.Amount
+}
+
+// Basket is what promotions are priced against. Lines may be empty when
+// only a total is known (in-store); item and category rules then give nothing.
+type Basket struct {
+	Lines      []Line
+	Subtotal   money.Amount
+	Currency   string
+	FirstOrder bool
+}
+
+type Applied struct {
+	PromotionID string       `json:"promotion_id"`
+	Kind        string       `json:"kind"`
+	Title       string       `json:"title"`
+	Discount    money.Amount `json:"discount"`
+}
+
+type Result struct {
+	Subtotal money.Amount `json:"subtotal"`
+	Discount money.Amount `json:"discount"`
+	Total    money.Amount `json:"total"`
+	Applied  []Applied    `json:"applied"`
+}
+
+// Find returns the applied entry for a promotion, if it was used
+func (r *Result) Find(promotionID string) (Applied, bool) {
+	for _, a := range r.Applied {
+		if a.PromotionID == promotionID {
+			return a, true
+		}
+	}
+	return Applied{}, false
+}
+
+/*
+-----------------------------------------------------
+VALIDATION
+-----------------------------------------------------
+*/
+
+func (r *Rule) Validate() error {
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("%s: percent must be between 0 and 100", r.Type)
+	}
+	if r.Amount < 0 || r.MinSpend < 0 || r.MaxDiscount < 0 {
+		return fmt.Errorf("%s: amounts cannot be negative", r.Type)
+	}
+
+	switch r.Type {
+	case PercentOff:
+		if r.Percent <= 0 {
+			return fmt.Errorf("percent_off needs a percent")
+		}
+	case AmountOff:
+		if r.Amount <= 0 {
+			return fmt.Errorf("amount_off needs an amount")
+		}
+	case BuyXGetY:
+		if r.BuyQuantity < 1 || r.GetQuantity < 1 {
+			return fmt.Errorf("buy_x_get_y needs buy_quantity and get_quantity of at least 1")
+		}
+	case FreeItem:
+		if r.ItemID == "" {
+			return fmt.Errorf("free_item needs an item_id")
+		}
+	case FirstOrder:
+		if (r.Percent > 0) == (r.Amount > 0) {
+			return fmt.Errorf("first_order needs either a percent or an amount")
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", r.Type)
+	}
+	return nil
+}
+
+// ValidateFields checks an optional "rules" array in a raw deal/offer body
+func ValidateFields(fields map[string]interface{}) error {
+	raw, ok := fields["rules"]
+	if !ok || raw == nil {
+		return nil
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(buf, &rules); err != nil {
+		return fmt.Errorf("rules must be a list of promotion rules")
+	}
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+-----------------------------------------------------
+PRICING
+-----------------------------------------------------
+*/
+
+func (r *Rule) matches(l Line) bool {
+	if r.ItemID != "" && l.ItemID != r.ItemID {
+		return false
+	}
+	if r.Category != "" && !strings.EqualFold(l.Category, r.Category) {
+		return false
+	}
+	return true
+}
+
+// base is what the rule discounts: the whole basket, or its matching lines
+func (r *Rule) base(b Basket) money.Amount {
+	if r.Category == "" && r.ItemID == "" {
+		return b.Subtotal
+	}
+	var total money.Amount
+	for _, l := range b.Lines {
+		if r.matches(l) {
+			total += l.UnitPrice.Mul(int64(l.Quantity))
+		}
+	}
+	return total
+}
+
+// units lists the matching unit prices, most expensive first
+func (r *Rule) units(b Basket) []money.Amount {
+	var units []money.Amount
+	for _, l := range b.Lines {
+		if !r.matches(l) {
+			continue
+		}
+		for i := 0; i < l.Quantity; i++ {
+			units = append(units, l.UnitPrice)
+		}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i] > units[j] })
+	return units
+}
+
+func (r *Rule) discount(b Basket) money.Amount {
+	if r.MinSpend > 0 && b.Subtotal < r.MinSpend {
+		return 0
+	}
+
+	base := r.base(b)
+	var d money.Amount
+	switch r.Type {
+	case PercentOff:
+		d = base.Percent(r.Percent)
+	case AmountOff:
+		d = r.Amount
+	case BuyXGetY:
+		// Every group of buy+get units gets its cheapest get units off
+		pct := r.Percent
+		if pct == 0 {
+			pct = 100
+		}
+		units := r.units(b)
+		group := r.BuyQuantity + r.GetQuantity
+		for start := 0; start+group <= len(units); start += group {
+			for _, u := range units[start+r.BuyQuantity : start+group] {
+				d += u.Percent(pct)
+			}
+		}
+	case FreeItem:
+		units := r.units(b)
+		n := r.GetQuantity
+		if n < 1 {
+			n = 1
+		}
+		for i := len(units) - 1; i >= 0 && n > 0; i, n = i-1, n-1 {
+			d += units[i]
+		}
+	case FirstOrder:
+		if !b.FirstOrder {
+			return 0
+		}
+		if r.Percent > 0 {
+			d = base.Percent(r.Percent)
+		} else {
+			d = r.Amount
+		}
+	}
+
+	if r.MaxDiscount > 0 && d > r.MaxDiscount {
+		d = r.MaxDiscount
+	}
+	if d > base {
+		d = base
+	}
+	return d.Round(b.Currency)
+}
+
+// Discount prices one promotion on its own
+func (p *Promotion) Discount(b Basket) money.Amount {
+	var d money.Amount
+	for i := range p.Rules {
+		d += p.Rules[i].discount(b)
+	}
+	if d > b.Subtotal {
+		d = b.Subtotal
+	}
+	return d
+}
+
+// UsesFirstOrder tells callers whether Basket.FirstOrder needs looking up
+func UsesFirstOrder(promos []Promotion) bool {
+	for _, p := range promos {
+		for _, r := range p.Rules {
+			if r.Type == FirstOrder {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Apply prices promotions on a basket and applies the best allowed combination
+func Apply(b Basket, promos ...Promotion) Result {
+	var stacked []Applied
+	var stackedTotal money.Amount
+	var best Applied
+
+	for i := range promos {
+		p := &promos[i]
+		d := p.Discount(b)
+		if d <= 0 {
+			continue
+		}
+		a := Applied{PromotionID: p.ID, Kind: p.Kind, Title: p.Title, Discount: d}
+		if p.Stackable {
+			stacked = append(stacked, a)
+			stackedTotal += d
+		} else if d > best.Discount {
+			best = a
+		}
+	}
+
+	applied := stacked
+	if best.Discount > stackedTotal {
+		applied = []Applied{best}
+	}
+
+	// Stacked promotions can't take the basket below zero
+	var total money.Amount
+	kept := make([]Applied, 0, len(applied))
+	for _, a := range applied {
+		if total+a.Discount > b.Subtotal {
+			a.Discount = b.Subtotal - total
+		}
+		if a.Discount <= 0 {
+			continue
+		}
+		total += a.Discount
+		kept = append(kept, a)
+	}
+
+	return Result{
+		Subtotal: b.Subtotal,
+		Discount: total,
+		Total:    b.Subtotal - total,
+		Applied:  kept,
+	}
+}
@@ -0,0 +1,199 @@
+package promo
+
+import (
+	"testing"
+
+	"finedine/backend/internal/money"
+)
+
+func amt(t *testing.T, s string) money.Amount {
+	t.Helper()
+	a, err := money.Parse(s)
+	if err != nil {
+		t.Fatalf("money.Parse(%q): %v", s, err)
+	}
+	return a
+}
+
+// basket builds a usd basket from item/category/quantity/price lines
+func basket(lines ...Line) Basket {
+	b := Basket{Lines: lines, Currency: "usd"}
+	for _, l := range lines {
+		b.Subtotal += l.UnitPrice.Mul(int64(l.Quantity))
+	}
+	return b
+}
+
+func line(t *testing.T, item, category string, qty int, price string) Line {
+	return Line{ItemID: item, Category: category, Quantity: qty, UnitPrice: amt(t, price)}
+}
+
+func promotion(id string, stackable bool, rules ...Rule) Promotion {
+	return Promotion{ID: id, Kind: "offer", Title: id, Rules: rules, Stackable: stackable}
+}
+
+func TestRuleDiscount(t *testing.T) {
+	fifty := basket(line(t, "steak", "mains", 2, "20.00"), line(t, "cola", "drinks", 2, "3.00"), line(t, "water", "drinks", 2, "2.00"))
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		basket Basket
+		want   string
+	}{
+		{"percent off", Rule{Type: PercentOff, Percent: 10}, fifty, "5"},
+		{"percent off rounds to the currency", Rule{Type: PercentOff, Percent: 15}, basket(line(t, "soup", "starters", 1, "9.99")), "1.5"},
+		{"percent off a category", Rule{Type: PercentOff, Percent: 20, Category: "Drinks"}, fifty, "2"},
+		{"max discount", Rule{Type: PercentOff, Percent: 50, MaxDiscount: amt(t, "10")}, fifty, "10"},
+		{"min spend not met", Rule{Type: AmountOff, Amount: amt(t, "5"), MinSpend: amt(t, "60")}, fifty, "0"},
+		{"min spend met", Rule{Type: AmountOff, Amount: amt(t, "5"), MinSpend: amt(t, "50")}, fifty, "5"},
+		{"amount off capped by its category", Rule{Type: AmountOff, Amount: amt(t, "15"), Category: "drinks"}, fifty, "10"},
+		{"amount off an item not in the basket", Rule{Type: AmountOff, Amount: amt(t, "5"), ItemID: "cake"}, fifty, "0"},
+
+		{"free item is the cheapest match", Rule{Type: FreeItem, ItemID: "water"}, fifty, "2"},
+		{"free drinks, cheapest first", Rule{Type: FreeItem, Category: "drinks", GetQuantity: 3}, fifty, "7"},
+
+		{"first order, returning customer", Rule{Type: FirstOrder, Percent: 15}, fifty, "0"},
+		{"first order percent", Rule{Type: FirstOrder, Percent: 15}, func() Basket { b := fifty; b.FirstOrder = true; return b }(), "7.5"},
+		{"first order amount", Rule{Type: FirstOrder, Amount: amt(t, "8")}, func() Basket { b := fifty; b.FirstOrder = true; return b }(), "8"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.discount(tt.basket); got != amt(t, tt.want) {
+			t.Errorf("%s: discount = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuyXGetY(t *testing.T) {
+	buy2get1 := Rule{Type: BuyXGetY, Category: "pizza", BuyQuantity: 2, GetQuantity: 1}
+	bogoHalf := Rule{Type: BuyXGetY, ItemID: "burger", BuyQuantity: 1, GetQuantity: 1, Percent: 50}
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		basket Basket
+		want   string
+	}{
+		{"not enough units", buy2get1, basket(line(t, "margherita", "pizza", 2, "10.00")), "0"},
+		{"one group, cheapest free",
+			buy2get1,
+			basket(line(t, "margherita", "pizza", 1, "10.00"), line(t, "diavola", "pizza", 1, "12.00"), line(t, "marinara", "pizza", 1, "8.00")),
+			"8"},
+		{"leftover units stay full price",
+			buy2get1,
+			basket(line(t, "margherita", "pizza", 1, "10.00"), line(t, "diavola", "pizza", 1, "12.00"), line(t, "marinara", "pizza", 1, "8.00"), line(t, "bianca", "pizza", 1, "6.00")),
+			"8"},
+		{"units are grouped most expensive first",
+			buy2get1,
+			basket(line(t, "diavola", "pizza", 2, "12.00"), line(t, "margherita", "pizza", 2, "10.00"), line(t, "marinara", "pizza", 2, "5.00")),
+			"15"},
+		{"other categories don't count",
+			buy2get1,
+			basket(line(t, "margherita", "pizza", 2, "10.00"), line(t, "cola", "drinks", 1, "3.00")),
+			"0"},
+		{"percent off the get unit", bogoHalf, basket(line(t, "burger", "mains", 2, "9.00")), "4.5"},
+		{"two groups at percent", bogoHalf, basket(line(t, "burger", "mains", 5, "9.00")), "9"},
+		{"capped by max discount",
+			Rule{Type: BuyXGetY, ItemID: "burger", BuyQuantity: 1, GetQuantity: 1, MaxDiscount: amt(t, "5")},
+			basket(line(t, "burger", "mains", 2, "9.00")),
+			"5"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.discount(tt.basket); got != amt(t, tt.want) {
+			t.Errorf("%s: discount = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	b := basket(line(t, "steak", "mains", 2, "20.00"), line(t, "cola", "drinks", 2, "5.00")) // 50.00
+
+	tenPercent := promotion("ten-percent", true, Rule{Type: PercentOff, Percent: 10})
+	threeOff := promotion("three-off", true, Rule{Type: AmountOff, Amount: amt(t, "3")})
+	sevenOff := promotion("seven-off", false, Rule{Type: AmountOff, Amount: amt(t, "7")})
+	nineOff := promotion("nine-off", false, Rule{Type: AmountOff, Amount: amt(t, "9")})
+	thirtyOff := promotion("thirty-off", true, Rule{Type: AmountOff, Amount: amt(t, "30")})
+	bundle := promotion("bundle", false,
+		Rule{Type: PercentOff, Percent: 10, Category: "mains"},
+		Rule{Type: FreeItem, ItemID: "cola"})
+	unmet := promotion("unmet", true, Rule{Type: AmountOff, Amount: amt(t, "20"), MinSpend: amt(t, "100")})
+
+	type applied struct {
+		id       string
+		discount string
+	}
+	tests := []struct {
+		name   string
+		promos []Promotion
+		want   []applied
+	}{
+		{"nothing", nil, nil},
+		{"stackable promotions add up", []Promotion{tenPercent, threeOff}, []applied{{"ten-percent", "5"}, {"three-off", "3"}}},
+		{"stack beats a smaller exclusive", []Promotion{tenPercent, sevenOff, threeOff}, []applied{{"ten-percent", "5"}, {"three-off", "3"}}},
+		{"exclusive beats a smaller stack", []Promotion{tenPercent, nineOff, threeOff}, []applied{{"nine-off", "9"}}},
+		{"best exclusive is picked", []Promotion{sevenOff, nineOff}, []applied{{"nine-off", "9"}}},
+		{"a tie keeps the stack", []Promotion{tenPercent, threeOff, promotion("eight-off", false, Rule{Type: AmountOff, Amount: amt(t, "8")})}, []applied{{"ten-percent", "5"}, {"three-off", "3"}}},
+		{"rules in a promotion apply together", []Promotion{bundle, tenPercent}, []applied{{"bundle", "9"}}},
+		{"stack is capped at the subtotal", []Promotion{thirtyOff, promotion("thirty-more", true, Rule{Type: AmountOff, Amount: amt(t, "30")}), threeOff},
+			[]applied{{"thirty-off", "30"}, {"thirty-more", "20"}}},
+		{"promotions that give nothing are left out", []Promotion{unmet, threeOff}, []applied{{"three-off", "3"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Apply(b, tt.promos...)
+
+			var total money.Amount
+			if len(r.Applied) != len(tt.want) {
+				t.Fatalf("applied %v, want %v", r.Applied, tt.want)
+			}
+			for i, w := range tt.want {
+				got := r.Applied[i]
+				if got.PromotionID != w.id || got.Discount != amt(t, w.discount) {
+					t.Errorf("applied[%d] = %s %s, want %s %s", i, got.PromotionID, got.Discount, w.id, w.discount)
+				}
+				total += amt(t, w.discount)
+			}
+			if r.Subtotal != b.Subtotal || r.Discount != total || r.Total != b.Subtotal-total {
+				t.Errorf("subtotal/discount/total = %s/%s/%s, want %s/%s/%s",
+					r.Subtotal, r.Discount, r.Total, b.Subtotal, total, b.Subtotal-total)
+			}
+		})
+	}
+}
+
+func TestApplyWithoutLines(t *testing.T) {
+	// In-store baskets only know their total; item rules give nothing
+	b := Basket{Subtotal: amt(t, "40"), Currency: "usd"}
+	r := Apply(b,
+		promotion("ten-percent", true, Rule{Type: PercentOff, Percent: 10}),
+		promotion("drinks", true, Rule{Type: PercentOff, Percent: 50, Category: "drinks"}))
+	if r.Discount != amt(t, "4") || len(r.Applied) != 1 {
+		t.Errorf("Apply = %+v, want only the basket-wide 4.00", r)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		ok   bool
+	}{
+		{"percent off", Rule{Type: PercentOff, Percent: 10}, true},
+		{"percent off without a percent", Rule{Type: PercentOff}, false},
+		{"percent over 100", Rule{Type: PercentOff, Percent: 120}, false},
+		{"negative amount", Rule{Type: AmountOff, Amount: -1}, false},
+		{"buy x get y", Rule{Type: BuyXGetY, BuyQuantity: 2, GetQuantity: 1}, true},
+		{"buy x get nothing", Rule{Type: BuyXGetY, BuyQuantity: 2}, false},
+		{"free item without an item", Rule{Type: FreeItem, Category: "drinks"}, false},
+		{"first order with both", Rule{Type: FirstOrder, Percent: 10, Amount: 10}, false},
+		{"unknown", Rule{Type: "mystery"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package purchasing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+	"finedine/backend/internal/stock"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+/*
+-----------------------------------------------------
+SUPPLIERS & PURCHASE ORDERS
+-----------------------------------------------------
+- Inventory items can point at a supplier record
+  (inventory.supplier_id); the free-text supplier
+  column stays for items without one
+- Suggestions: every low item (quantity <= min_stock)
+  is topped up to its par level, ParFactor x min_stock,
+  minus what is already on order
+- Generate turns the suggestions into one draft PO per
+  supplier
+- draft -> sent -> partial -> received. Only drafts can
+  be edited or deleted; deliveries are received against
+  sent or partial orders
+- Every received line is a purchase movement at the
+  PO's unit cost, which also moves the item's
+  cost_per_unit to the weighted average cost
+*/
+
+type Status string
+
+const (
+	Draft    Status = "draft"
+	Sent     Status = "sent"
+	Partial  Status = "partial"
+	Received Status = "received"
+)
+
+// ParFactor is how far above min_stock a suggested order brings an item
+const ParFactor = 2.0
+
+const epsilon = 1e-6
+
+var (
+	ErrNotFound      = errors.New("purchase order not found")
+	ErrNotDraft      = errors.New("only draft purchase orders can be changed")
+	ErrNotReceivable = errors.New("purchase order must be sent before it can be received")
+	ErrOverReceived  = errors.New("received quantity is more than is outstanding")
+	ErrUnknownLine   = errors.New("line is not part of this purchase order")
+	ErrReceiveRace   = errors.New("purchase order was received by someone else meanwhile, reload it")
+	ErrNothingToDo   = errors.New("nothing to order")
+)
+
+type Line struct {
+	ID               string       `json:"id"`
+	PurchaseOrderID  string       `json:"purchase_order_id"`
+	InventoryID      string       `json:"inventory_id"`
+	Quantity         float64      `json:"quantity"`
+	UnitCost         money.Amount `json:"unit_cost"`
+	ReceivedQuantity float64      `json:"received_quantity"`
+	Item             *struct {
+		Name string `json:"name"`
+		Unit string `json:"unit"`
+	} `json:"inventory,omitempty"`
+}
+
+func (l *Line) Outstanding() float64 {
+	return math.Max(l.Quantity-l.ReceivedQuantity, 0)
+}
+
+type Order struct {
+	ID           string       `json:"id"`
+	RestaurantID string       `json:"restaurant_id"`
+	SupplierID   *string      `json:"supplier_id"`
+	Status       Status       `json:"status"`
+	Notes        string       `json:"notes"`
+	ExpectedAt   *string      `json:"expected_at"`
+	Total        money.Amount `json:"total"`
+	CreatedBy    *string      `json:"created_by"`
+	SentAt       *time.Time   `json:"sent_at"`
+	ReceivedAt   *time.Time   `json:"received_at"`
+	CreatedAt    time.Time    `json:"created_at"`
+	Supplier     *struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"suppliers,omitempty"`
+	Lines []Line `json:"purchase_order_items"`
+}
+
+const orderColumns = "*, suppliers(name, email), purchase_order_items(*, inventory(name, unit))"
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Get loads a purchase order with its lines
+func Get(orderID string) (*Order, error) {
+	result, _, err := database.Query("purchase_orders").
+		Select(orderColumns, "", false).
+		Eq("id", orderID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var po Order
+	if err := json.Unmarshal(result, &po); err != nil {
+		return nil, err
+	}
+	return &po, nil
+}
+
+// List returns a restaurant's purchase orders, newest first
+func List(restaurantID, status string) ([]Order, error) {
+	query := database.Query("purchase_orders").
+		Select(orderColumns, "", false).
+		Eq("restaurant_id", restaurantID)
+	if status != "" {
+		query = query.Eq("status", status)
+	}
+	result, _, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	orders := []Order{}
+	if err := json.Unmarshal(result, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+/*
+-----------------------------------------------------
+SUGGESTIONS
+-----------------------------------------------------
+*/
+
+type Suggestion struct {
+	InventoryID string       `json:"inventory_id"`
+	Name        string       `json:"name"`
+	Unit        string       `json:"unit"`
+	SupplierID  *string      `json:"supplier_id"`
+	Quantity    float64      `json:"quantity"`
+	MinStock    float64      `json:"min_stock"`
+	OnOrder     float64      `json:"on_order"`
+	Suggested   float64      `json:"suggested_quantity"`
+	UnitCost    money.Amount `json:"unit_cost"`
+}
+
+// onOrder sums what open (sent or partial) orders still have to deliver, per item
+func onOrder(restaurantID string) (map[string]float64, error) {
+	result, _, err := database.Query("purchase_order_items").
+		Select("inventory_id, quantity, received_quantity, purchase_orders!inner(restaurant_id, status)", "", false).
+		Eq("purchase_orders.restaurant_id", restaurantID).
+		In("purchase_orders.status", []string{string(Sent), string(Partial)}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var lines []Line
+	if err := json.Unmarshal(result, &lines); err != nil {
+		return nil, err
+	}
+	out := map[string]float64{}
+	for _, l := range lines {
+		out[l.InventoryID] += l.Outstanding()
+	}
+	return out, nil
+}
+
+// Suggest lists the restaurant's low items with how much to order, optionally
+// only those of one supplier
+func Suggest(restaurantID, supplierID string) ([]Suggestion, error) {
+	query := database.Query("inventory").
+		Select("id, name, unit, quantity, min_stock, cost_per_unit, supplier_id", "", false).
+		Eq("restaurant_id", restaurantID).
+		Gt("min_stock", "0")
+	if supplierID != "" {
+		query = query.Eq("supplier_id", supplierID)
+	}
+	result, _, err := query.Order("name", nil).Execute()
+	if err != nil {
+		return nil, err
+	}
+	var items []struct {
+		ID          string       `json:"id"`
+		Name        string       `json:"name"`
+		Unit        string       `json:"unit"`
+		Quantity    float64      `json:"quantity"`
+		MinStock    float64      `json:"min_stock"`
+		CostPerUnit money.Amount `json:"cost_per_unit"`
+		SupplierID  *string      `json:"supplier_id"`
+	}
+	if err := json.Unmarshal(result, &items); err != nil {
+		return nil, err
+	}
+
+	pending, err := onOrder(restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []Suggestion{}
+	for _, it := range items {
+		if it.Quantity > it.MinStock {
+			continue
+		}
+		need := math.Ceil(it.MinStock*ParFactor - it.Quantity - pending[it.ID])
+		if need <= 0 {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{
+			InventoryID: it.ID,
+			Name:        it.Name,
+			Unit:        it.Unit,
+			SupplierID:  it.SupplierID,
+			Quantity:    it.Quantity,
+			MinStock:    it.MinStock,
+			OnOrder:     pending[it.ID],
+			Suggested:   need,
+			UnitCost:    it.CostPerUnit,
+		})
+	}
+	return suggestions, nil
+}
+
+/*
+-----------------------------------------------------
+DRAFTS
+-----------------------------------------------------
+*/
+
+// NewLine is a line of a purchase order being created
+type NewLine struct {
+	InventoryID string       `json:"inventory_id" binding:"required"`
+	Quantity    float64      `json:"quantity" binding:"required,gt=0"`
+	UnitCost    money.Amount `json:"unit_cost" binding:"min=0"`
+}
+
+// NewOrder is a purchase order being created
+type NewOrder struct {
+	RestaurantID string
+	SupplierID   string
+	Notes        string
+	ExpectedAt   string
+	CreatedBy    string
+	Currency     string
+	Lines        []NewLine
+}
+
+func lineTotal(lines []NewLine, currency string) money.Amount {
+	var total money.Amount
+	for _, l := range lines {
+		total += l.UnitCost.MulFloat(l.Quantity)
+	}
+	return total.Round(currency)
+}
+
+// Create saves a draft purchase order with its lines
+func Create(d NewOrder) (*Order, error) {
+	if len(d.Lines) == 0 {
+		return nil, ErrNothingToDo
+	}
+	result, _, err := database.Query("purchase_orders").
+		Insert(map[string]interface{}{
+			"restaurant_id": d.RestaurantID,
+			"supplier_id":   nullable(d.SupplierID),
+			"status":        Draft,
+			"notes":         d.Notes,
+			"expected_at":   nullable(d.ExpectedAt),
+			"total":         lineTotal(d.Lines, d.Currency),
+			"created_by":    nullable(d.CreatedBy),
+		}, false, "", "", "").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var created []Order
+	if err := json.Unmarshal(result, &created); err != nil || len(created) == 0 {
+		return nil, fmt.Errorf("purchase order not created: %s", result)
+	}
+	po := created[0]
+
+	if err := insertLines(po.ID, d.Lines); err != nil {
+		database.Query("purchase_orders").Delete("", "").Eq("id", po.ID).Execute()
+		return nil, err
+	}
+	return Get(po.ID)
+}
+
+func insertLines(orderID string, lines []NewLine) error {
+	rows := make([]map[string]interface{}, 0, len(lines))
+	for _, l := range lines {
+		rows = append(rows, map[string]interface{}{
+			"purchase_order_id": orderID,
+			"inventory_id":      l.InventoryID,
+			"quantity":          l.Quantity,
+			"unit_cost":         l.UnitCost,
+		})
+	}
+	_, _, err := database.Query("purchase_order_items").
+		Insert(rows, false, "", "", "").
+		Execute()
+	return err
+}
+
+// Generate creates one draft per supplier from the restaurant's suggestions
+func Generate(restaurantID, supplierID, userID, currency string) ([]*Order, error) {
+	suggestions, err := Suggest(restaurantID, supplierID)
+	if err != nil {
+		return nil, err
+	}
+	if len(suggestions) == 0 {
+		return nil, ErrNothingToDo
+	}
+
+	bySupplier := map[string][]NewLine{}
+	order := []string{}
+	for _, s := range suggestions {
+		key := ""
+		if s.SupplierID != nil {
+			key = *s.SupplierID
+		}
+		if _, seen := bySupplier[key]; !seen {
+			order = append(order, key)
+		}
+		bySupplier[key] = append(bySupplier[key], NewLine{
+			InventoryID: s.InventoryID,
+			Quantity:    s.Suggested,
+			UnitCost:    s.UnitCost,
+		})
+	}
+
+	drafts := []*Order{}
+	for _, key := range order {
+		po, err := Create(NewOrder{
+			RestaurantID: restaurantID,
+			SupplierID:   key,
+			Notes:        "Generated from low stock",
+			CreatedBy:    userID,
+			Currency:     currency,
+			Lines:        bySupplier[key],
+		})
+		if err != nil {
+			return drafts, err
+		}
+		drafts = append(drafts, po)
+	}
+	return drafts, nil
+}
+
+// ReplaceLines swaps a draft's lines and recomputes its total
+func ReplaceLines(po *Order, lines []NewLine, currency string) error {
+	if po.Status != Draft {
+		return ErrNotDraft
+	}
+	if len(lines) == 0 {
+		return ErrNothingToDo
+	}
+	if _, _, err := database.Query("purchase_order_items").
+		Delete("", "").
+		Eq("purchase_order_id", po.ID).
+		Execute(); err != nil {
+		return err
+	}
+	if err := insertLines(po.ID, lines); err != nil {
+		return err
+	}
+	_, _, err := database.Query("purchase_orders").
+		Update(map[string]interface{}{"total": lineTotal(lines, currency)}, "", "").
+		Eq("id", po.ID).
+		Execute()
+	return err
+}
+
+// MarkSent moves a draft to sent
+func MarkSent(po *Order) error {
+	if po.Status != Draft {
+		return ErrNotDraft
+	}
+	result, _, err := database.Query("purchase_orders").
+		Update(map[string]interface{}{
+			"status":  Sent,
+			"sent_at": time.Now().UTC(),
+		}, "", "").
+		Eq("id", po.ID).
+		Eq("status", string(Draft)).
+		Execute()
+	if err != nil {
+		return err
+	}
+	var rows []map[string]interface{}
+	if json.Unmarshal(result, &rows) != nil || len(rows) == 0 {
+		return ErrNotDraft
+	}
+	return nil
+}
+
+/*
+-----------------------------------------------------
+RECEIVING
+-----------------------------------------------------
+*/
+
+// Receipt is a delivered quantity of one PO line; a zero unit cost keeps the
+// line's cost
+type Receipt struct {
+	LineID   string       `json:"line_id" binding:"required"`
+	Quantity float64      `json:"quantity" binding:"required,gt=0"`
+	UnitCost money.Amount `json:"unit_cost" binding:"min=0"`
+}
+
+// Receive books a delivery against a sent or partial order. Without receipts
+// everything outstanding is received. Returns the movements it recorded.
+func Receive(po *Order, receipts []Receipt, userID string) ([]*stock.Movement, error) {
+	if po.Status != Sent && po.Status != Partial {
+		return nil, ErrNotReceivable
+	}
+
+	lines := map[string]*Line{}
+	for i := range po.Lines {
+		lines[po.Lines[i].ID] = &po.Lines[i]
+	}
+	if len(receipts) == 0 {
+		for _, l := range po.Lines {
+			if l.Outstanding() > epsilon {
+				receipts = append(receipts, Receipt{LineID: l.ID, Quantity: l.Outstanding()})
+			}
+		}
+	}
+	if len(receipts) == 0 {
+		return nil, ErrNothingToDo
+	}
+
+	// Several receipts for one line count as one, at their average cost
+	combined := map[string]*Receipt{}
+	order := []string{}
+	for _, r := range receipts {
+		l, ok := lines[r.LineID]
+		if !ok {
+			return nil, ErrUnknownLine
+		}
+		cost := r.UnitCost
+		if cost.IsZero() {
+			cost = l.UnitCost
+		}
+		sum, seen := combined[r.LineID]
+		if !seen {
+			sum = &Receipt{LineID: r.LineID}
+			combined[r.LineID] = sum
+			order = append(order, r.LineID)
+		}
+		if total := sum.Quantity + r.Quantity; total > 0 {
+			sum.UnitCost = sum.UnitCost.MulFloat(sum.Quantity/total) + cost.MulFloat(r.Quantity/total)
+		}
+		sum.Quantity += r.Quantity
+	}
+	for _, id := range order {
+		if combined[id].Quantity > lines[id].Outstanding()+epsilon {
+			return nil, ErrOverReceived
+		}
+	}
+
+	movements := []*stock.Movement{}
+	for _, id := range order {
+		r, l := combined[id], lines[id]
+		if r.Quantity <= 0 {
+			continue
+		}
+
+		// Claim the quantity first: a concurrent receipt of the same line
+		// fails here instead of booking the delivery twice
+		before := l.ReceivedQuantity
+		if err := setReceived(l.ID, before, before+r.Quantity); err != nil {
+			return movements, err
+		}
+		l.ReceivedQuantity = before + r.Quantity
+
+		m, err := stock.Record(stock.Change{
+			InventoryID: l.InventoryID,
+			Kind:        stock.Purchase,
+			Quantity:    r.Quantity,
+			UnitCost:    r.UnitCost,
+			Reason:      "Purchase order received",
+			UserID:      userID,
+			ReferenceID: po.ID,
+		})
+		if err != nil {
+			if setReceived(l.ID, l.ReceivedQuantity, before) == nil {
+				l.ReceivedQuantity = before
+			}
+			return movements, err
+		}
+		movements = append(movements, m)
+	}
+
+	status := Received
+	for _, l := range po.Lines {
+		if l.Outstanding() > epsilon {
+			status = Partial
+			break
+		}
+	}
+	update := map[string]interface{}{"status": status}
+	if status == Received {
+		update["received_at"] = time.Now().UTC()
+	}
+	_, _, err := database.Query("purchase_orders").
+		Update(update, "", "").
+		Eq("id", po.ID).
+		Execute()
+	return movements, err
+}
+
+// setReceived moves a line's received_quantity from one value to another,
+// only if nobody changed it in the meantime
+func setReceived(lineID string, from, to float64) error {
+	result, _, err := database.Query("purchase_order_items").
+		Update(map[string]interface{}{"received_quantity": to}, "", "").
+		Eq("id", lineID).
+		Eq("received_quantity", strconv.FormatFloat(from, 'f', -1, 64)).
+		Execute()
+	if err != nil {
+		return err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(result, &rows); err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrReceiveRace
+	}
+	return nil
+}
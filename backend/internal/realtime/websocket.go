@@ -223,11 +223,11 @@ func SendBookingConfirmation(bookingID, customerID string) {
 	WSHub.SendToUser(customerID, message)
 }
 
-// Broadcast new deal
-func BroadcastNewDeal(deal interface{}) {
+// Send a new deal to one targeted user (see notify.NewOffer)
+func SendNewDeal(userID string, deal interface{}) {
 	message := RealtimeMessage{
 		Type:    "new_deal",
 		Payload: deal,
 	}
-	WSHub.BroadcastMessage(message)
+	WSHub.SendToUser(userID, message)
 }
@@ -0,0 +1,277 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/money"
+)
+
+/*
+-----------------------------------------------------
+RECEIPTS / INVOICES
+-----------------------------------------------------
+- A Receipt is a frozen snapshot of a paid order
+- Rendered as HTML (browser / email) or as a plain
+  single-font PDF written by hand (no PDF dependency)
+*/
+
+type Item struct {
+	Name      string       `json:"name"`
+	Quantity  int          `json:"quantity"`
+	UnitPrice money.Amount `json:"unit_price"`
+	Total     money.Amount `json:"total"`
+}
+
+type TaxLine struct {
+	Label   string       `json:"label"`
+	Taxable money.Amount `json:"taxable"`
+	Amount  money.Amount `json:"amount"`
+}
+
+type Receipt struct {
+	InvoiceNumber     string       `json:"invoice_number"`
+	IssuedAt          time.Time    `json:"issued_at"`
+	RestaurantName    string       `json:"restaurant_name"`
+	RestaurantAddress string       `json:"restaurant_address"`
+	RestaurantPhone   string       `json:"restaurant_phone"`
+	OrderID           string       `json:"order_id"`
+	CustomerName      string       `json:"customer_name"`
+	Currency          string       `json:"currency"`
+	TaxInclusive      bool         `json:"tax_inclusive"`
+	Items             []Item       `json:"items"`
+	Subtotal          money.Amount `json:"subtotal"`
+	Discount          money.Amount `json:"discount"`
+	ServiceCharge     money.Amount `json:"service_charge"`
+	TaxLines          []TaxLine    `json:"tax_lines"`
+	Tax               money.Amount `json:"tax"`
+	Tip               money.Amount `json:"tip"`
+	Total             money.Amount `json:"total"`
+	Refunded          money.Amount `json:"refunded"`
+}
+
+func (r *Receipt) format(v money.Amount) string {
+	return money.New(v, r.Currency).String()
+}
+
+var htmlTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("02 Jan 2006 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Receipt {{.R.InvoiceNumber}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; max-width: 480px; margin: 24px auto; color: #222; }
+h1 { font-size: 20px; margin-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; margin-top: 16px; }
+td { padding: 4px 0; }
+td.num { text-align: right; }
+tr.total td { font-weight: bold; border-top: 1px solid #222; }
+.muted { color: #777; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>{{.R.RestaurantName}}</h1>
+<div class="muted">{{.R.RestaurantAddress}}{{if .R.RestaurantPhone}} · {{.R.RestaurantPhone}}{{end}}</div>
+<p>Invoice <strong>{{.R.InvoiceNumber}}</strong><br>
+<span class="muted">Issued {{date .R.IssuedAt}} · Order {{.R.OrderID}}</span>
+{{if .R.CustomerName}}<br>Billed to {{.R.CustomerName}}{{end}}</p>
+<table>
+{{range .Items}}<tr><td>{{.Quantity}} × {{.Name}}</td><td class="num">{{.Total}}</td></tr>
+{{end}}</table>
+<table>
+{{range .Totals}}<tr{{if .Strong}} class="total"{{end}}><td>{{.Label}}</td><td class="num">{{.Value}}</td></tr>
+{{end}}</table>
+{{if .R.TaxInclusive}}<p class="muted">Prices include tax.</p>{{end}}
+</body>
+</html>
+`))
+
+type row struct {
+	Label  string
+	Value  string
+	Strong bool
+}
+
+// totals lists the summary rows shared by both formats
+func (r *Receipt) totals() []row {
+	rows := []row{{Label: "Subtotal", Value: r.format(r.Subtotal)}}
+	if r.Discount > 0 {
+		rows = append(rows, row{Label: "Discount", Value: "-" + r.format(r.Discount)})
+	}
+	if r.ServiceCharge > 0 {
+		rows = append(rows, row{Label: "Service charge", Value: r.format(r.ServiceCharge)})
+	}
+	for _, l := range r.TaxLines {
+		label := l.Label
+		if r.TaxInclusive {
+			label += " (included)"
+		}
+		rows = append(rows, row{Label: label, Value: r.format(l.Amount)})
+	}
+	if r.Tip > 0 {
+		rows = append(rows, row{Label: "Tip", Value: r.format(r.Tip)})
+	}
+	rows = append(rows, row{Label: "Total", Value: r.format(r.Total), Strong: true})
+	if r.Refunded > 0 {
+		rows = append(rows, row{Label: "Refunded", Value: "-" + r.format(r.Refunded)})
+	}
+	return rows
+}
+
+func (r *Receipt) HTML() ([]byte, error) {
+	type itemRow struct {
+		Name     string
+		Quantity int
+		Total    string
+	}
+	items := make([]itemRow, 0, len(r.Items))
+	for _, it := range r.Items {
+		items = append(items, itemRow{Name: it.Name, Quantity: it.Quantity, Total: r.format(it.Total)})
+	}
+
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, map[string]interface{}{
+		"R":      r,
+		"Items":  items,
+		"Totals": r.totals(),
+	})
+	return buf.Bytes(), err
+}
+
+/*
+-----------------------------------------------------
+PDF
+-----------------------------------------------------
+*/
+
+const (
+	pageWidth    = 595 // A4 in points
+	pageHeight   = 842
+	margin       = 56
+	lineHeight   = 16
+	columnRight  = pageWidth - margin
+	linesPerPage = (pageHeight - 2*margin) / lineHeight
+)
+
+type pdfLine struct {
+	text  string
+	value string
+	size  int
+}
+
+func (r *Receipt) PDF() []byte {
+	lines := []pdfLine{
+		{text: r.RestaurantName, size: 16},
+		{text: r.RestaurantAddress, size: 9},
+		{text: r.RestaurantPhone, size: 9},
+		{},
+		{text: "Invoice " + r.InvoiceNumber, size: 12},
+		{text: "Issued " + r.IssuedAt.Format("02 Jan 2006 15:04") + "  Order " + r.OrderID, size: 9},
+	}
+	if r.CustomerName != "" {
+		lines = append(lines, pdfLine{text: "Billed to " + r.CustomerName, size: 9})
+	}
+	lines = append(lines, pdfLine{})
+	for _, it := range r.Items {
+		lines = append(lines, pdfLine{text: fmt.Sprintf("%d x %s", it.Quantity, it.Name), value: r.format(it.Total), size: 10})
+	}
+	lines = append(lines, pdfLine{})
+	for _, t := range r.totals() {
+		size := 10
+		if t.Strong {
+			size = 12
+		}
+		lines = append(lines, pdfLine{text: t.Label, value: t.Value, size: size})
+	}
+	if r.TaxInclusive {
+		lines = append(lines, pdfLine{}, pdfLine{text: "Prices include tax.", size: 9})
+	}
+
+	var pages []string
+	for start := 0; start < len(lines); start += linesPerPage {
+		end := start + linesPerPage
+		if end > len(lines) {
+			end = len(lines)
+		}
+		pages = append(pages, pageContent(lines[start:end]))
+	}
+	return writePDF(pages)
+}
+
+func pageContent(lines []pdfLine) string {
+	var b strings.Builder
+	y := pageHeight - margin
+	for _, l := range lines {
+		y -= lineHeight
+		if l.text == "" && l.value == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", l.size, margin, y, pdfEscape(l.text))
+		if l.value != "" {
+			// Helvetica digits are ~0.55em; close enough to right-align amounts
+			x := columnRight - int(float64(len(l.value)*l.size)*0.55)
+			fmt.Fprintf(&b, "BT /F1 %d Tf %d %d Td (%s) Tj ET\n", l.size, x, y, pdfEscape(l.value))
+		}
+	}
+	return b.String()
+}
+
+// pdfEscape keeps text inside a literal string; non-Latin-1 runes become '?'
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r > 255:
+			b.WriteByte('?')
+		case r > 126:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// writePDF lays out catalog, page tree, font, then one page + content
+// stream per page, followed by the xref table
+func writePDF(pages []string) []byte {
+	var buf bytes.Buffer
+	offsets := []int{}
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	for i, content := range pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 5+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
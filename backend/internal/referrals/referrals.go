@@ -0,0 +1,445 @@
+package referrals
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/coupons"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/loyalty"
+)
+
+/*
+-----------------------------------------------------
+REFERRAL PROGRAM
+-----------------------------------------------------
+- Every customer gets a referral code (users.
+  referral_code), created the first time they ask
+- A new customer applies a code before their first
+  completed order; one referral per referred user
+- When that first order completes, both sides get
+  the reward: loyalty points, or a coupon claimed
+  from the deal in REFERRAL_DEAL_ID
+- Self-referrals are rejected when the two accounts
+  share a device (user_sessions.device_id) or a card
+  (payments.card_fingerprint), both when the code is
+  applied and again before rewarding
+- Rejected attempts are kept with their reason for
+  the admin reports
+*/
+
+var (
+	ErrInvalidCode     = errors.New("referral code not found")
+	ErrOwnCode         = errors.New("you can't use your own referral code")
+	ErrAlreadyReferred = errors.New("a referral code has already been applied to this account")
+	ErrNotNewCustomer  = errors.New("referral codes are only for customers who haven't ordered yet")
+	ErrSelfReferral    = errors.New("this referral can't be accepted")
+)
+
+const (
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no 0/O, 1/I
+	codeLength   = 8
+	codeRetries  = 5
+
+	defaultRewardPoints = 500
+)
+
+// Fraud reasons stored on rejected referrals
+const (
+	SameDevice        = "same_device"
+	SamePaymentMethod = "same_payment_method"
+)
+
+type Referral struct {
+	ID             string          `json:"id"`
+	ReferrerID     string          `json:"referrer_id"`
+	ReferredUserID string          `json:"referred_user_id"`
+	Code           string          `json:"code"`
+	Status         string          `json:"status"` // pending | rewarded | rejected
+	FraudReason    *string         `json:"fraud_reason"`
+	OrderID        *string         `json:"order_id"`
+	ReferrerReward json.RawMessage `json:"referrer_reward"`
+	ReferredReward json.RawMessage `json:"referred_reward"`
+	RewardedAt     *time.Time      `json:"rewarded_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+/*
+-----------------------------------------------------
+PROGRAM
+-----------------------------------------------------
+*/
+
+type Program struct {
+	RewardType     string `json:"reward_type"` // points | coupon
+	ReferrerPoints int    `json:"referrer_points,omitempty"`
+	ReferredPoints int    `json:"referred_points,omitempty"`
+	DealID         string `json:"deal_id,omitempty"`
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n >= 0 {
+		return n
+	}
+	return fallback
+}
+
+// Current reads the program from the environment
+func Current() Program {
+	p := Program{
+		RewardType:     "points",
+		ReferrerPoints: envInt("REFERRAL_REFERRER_POINTS", defaultRewardPoints),
+		ReferredPoints: envInt("REFERRAL_REFERRED_POINTS", defaultRewardPoints),
+	}
+	if dealID := os.Getenv("REFERRAL_DEAL_ID"); dealID != "" && os.Getenv("REFERRAL_REWARD_TYPE") == "coupon" {
+		p = Program{RewardType: "coupon", DealID: dealID}
+	}
+	return p
+}
+
+/*
+-----------------------------------------------------
+CODES
+-----------------------------------------------------
+*/
+
+func newCode() (string, error) {
+	buf := make([]byte, codeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// CodeFor returns the user's referral code, creating it on first use
+func CodeFor(userID string) (string, error) {
+	result, _, err := database.Query("users").
+		Select("referral_code", "", false).
+		Eq("id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return "", err
+	}
+	var user struct {
+		ReferralCode *string `json:"referral_code"`
+	}
+	if err := json.Unmarshal(result, &user); err != nil {
+		return "", err
+	}
+	if user.ReferralCode != nil && *user.ReferralCode != "" {
+		return *user.ReferralCode, nil
+	}
+
+	for i := 0; i < codeRetries; i++ {
+		code, err := newCode()
+		if err != nil {
+			return "", err
+		}
+		// Only set it if still empty, so two first requests agree on one code
+		result, _, err := database.Query("users").
+			Update(map[string]interface{}{"referral_code": code}, "", "").
+			Eq("id", userID).
+			Is("referral_code", "null").
+			Execute()
+		if err != nil {
+			if strings.Contains(err.Error(), "duplicate") {
+				continue
+			}
+			return "", err
+		}
+		var rows []map[string]interface{}
+		if json.Unmarshal(result, &rows) == nil && len(rows) > 0 {
+			return code, nil
+		}
+		return CodeFor(userID)
+	}
+	return "", fmt.Errorf("could not allocate a referral code")
+}
+
+/*
+-----------------------------------------------------
+FRAUD CHECKS
+-----------------------------------------------------
+*/
+
+func distinctValues(table, column, userColumn, userID string) map[string]bool {
+	values := map[string]bool{}
+	result, _, err := database.Query(table).
+		Select(column, "", false).
+		Eq(userColumn, userID).
+		Not(column, "is", "null").
+		Execute()
+	if err != nil {
+		return values
+	}
+	var rows []map[string]interface{}
+	if json.Unmarshal(result, &rows) != nil {
+		return values
+	}
+	for _, row := range rows {
+		if v, ok := row[column].(string); ok && v != "" {
+			values[v] = true
+		}
+	}
+	return values
+}
+
+func overlaps(a, b map[string]bool) bool {
+	for v := range a {
+		if b[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// fraudReason reports why two accounts look like the same person, or ""
+func fraudReason(referrerID, referredID string, extraDevices ...string) string {
+	devices := distinctValues("user_sessions", "device_id", "user_id", referredID)
+	for _, d := range extraDevices {
+		if d != "" {
+			devices[d] = true
+		}
+	}
+	if overlaps(devices, distinctValues("user_sessions", "device_id", "user_id", referrerID)) {
+		return SameDevice
+	}
+
+	cards := distinctValues("payments", "card_fingerprint", "user_id", referredID)
+	if overlaps(cards, distinctValues("payments", "card_fingerprint", "user_id", referrerID)) {
+		return SamePaymentMethod
+	}
+	return ""
+}
+
+/*
+-----------------------------------------------------
+APPLYING A CODE
+-----------------------------------------------------
+*/
+
+func completedOrders(userID string) (int64, error) {
+	_, count, err := database.Query("orders").
+		Select("id", "exact", true).
+		Eq("customer_id", userID).
+		Eq("status", "completed").
+		Execute()
+	return count, err
+}
+
+// Apply links a new customer to the owner of code. deviceID is the device
+// the request came from, checked along with the customer's known sessions.
+func Apply(referredID, code, deviceID string) (*Referral, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	result, _, err := database.Query("users").
+		Select("id", "", false).
+		Eq("referral_code", code).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, ErrInvalidCode
+	}
+	var referrer struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(result, &referrer); err != nil || referrer.ID == "" {
+		return nil, ErrInvalidCode
+	}
+	if referrer.ID == referredID {
+		return nil, ErrOwnCode
+	}
+
+	_, existing, err := database.Query("referrals").
+		Select("id", "exact", true).
+		Eq("referred_user_id", referredID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, ErrAlreadyReferred
+	}
+	if n, err := completedOrders(referredID); err != nil {
+		return nil, err
+	} else if n > 0 {
+		return nil, ErrNotNewCustomer
+	}
+
+	row := map[string]interface{}{
+		"referrer_id":      referrer.ID,
+		"referred_user_id": referredID,
+		"code":             code,
+		"status":           "pending",
+	}
+	reason := fraudReason(referrer.ID, referredID, deviceID)
+	if reason != "" {
+		row["status"] = "rejected"
+		row["fraud_reason"] = reason
+	}
+
+	result, _, err = database.Query("referrals").
+		Insert(row, false, "", "", "").
+		Execute()
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate") {
+			return nil, ErrAlreadyReferred
+		}
+		return nil, err
+	}
+	if reason != "" {
+		return nil, ErrSelfReferral
+	}
+
+	var rows []Referral
+	if err := json.Unmarshal(result, &rows); err != nil || len(rows) == 0 {
+		return nil, fmt.Errorf("referral insert returned nothing")
+	}
+	return &rows[0], nil
+}
+
+/*
+-----------------------------------------------------
+REWARDS
+-----------------------------------------------------
+*/
+
+// Reward is what one side of a referral received
+type Reward struct {
+	Type     string `json:"type"`
+	Points   int    `json:"points,omitempty"`
+	CouponID string `json:"coupon_id,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (p Program) grant(userID string, points int, note string) Reward {
+	if p.RewardType == "coupon" {
+		coupon, err := coupons.Claim(p.DealID, userID)
+		if err != nil {
+			return Reward{Type: "coupon", Error: err.Error()}
+		}
+		return Reward{Type: "coupon", CouponID: coupon.ID, Code: coupon.Code}
+	}
+
+	if points <= 0 {
+		return Reward{Type: "points"}
+	}
+	expires := time.Now().UTC().Add(loyalty.Expiry)
+	if _, err := loyalty.Post(loyalty.Posting{
+		UserID:    userID,
+		Points:    points,
+		Kind:      "bonus",
+		Note:      note,
+		ExpiresAt: &expires,
+	}); err != nil {
+		return Reward{Type: "points", Error: err.Error()}
+	}
+	return Reward{Type: "points", Points: points}
+}
+
+// Qualify rewards both sides once the referred customer's first order is
+// completed. It returns the referral when rewards were issued by this call.
+func Qualify(orderID string) (*Referral, error) {
+	result, _, err := database.Query("orders").
+		Select("customer_id, status", "", false).
+		Eq("id", orderID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var order struct {
+		CustomerID string `json:"customer_id"`
+		Status     string `json:"status"`
+	}
+	if err := json.Unmarshal(result, &order); err != nil {
+		return nil, err
+	}
+	if order.Status != "completed" || order.CustomerID == "" {
+		return nil, nil
+	}
+
+	result, _, err = database.Query("referrals").
+		Select("*", "", false).
+		Eq("referred_user_id", order.CustomerID).
+		Eq("status", "pending").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Referral
+	if err := json.Unmarshal(result, &pending); err != nil || len(pending) == 0 {
+		return nil, nil
+	}
+	ref := pending[0]
+
+	// Only the first completed order counts
+	if n, err := completedOrders(order.CustomerID); err != nil || n != 1 {
+		return nil, err
+	}
+
+	// The referred customer has now paid at least once, so check again
+	if reason := fraudReason(ref.ReferrerID, ref.ReferredUserID); reason != "" {
+		database.Query("referrals").
+			Update(map[string]interface{}{
+				"status":       "rejected",
+				"fraud_reason": reason,
+				"order_id":     orderID,
+			}, "", "").
+			Eq("id", ref.ID).
+			Eq("status", "pending").
+			Execute()
+		return nil, nil
+	}
+
+	// Claim the referral before issuing anything, so a repeated status
+	// update can't reward twice
+	now := time.Now().UTC()
+	result, _, err = database.Query("referrals").
+		Update(map[string]interface{}{
+			"status":      "rewarded",
+			"order_id":    orderID,
+			"rewarded_at": now.Format(time.RFC3339),
+		}, "", "").
+		Eq("id", ref.ID).
+		Eq("status", "pending").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var claimed []Referral
+	if err := json.Unmarshal(result, &claimed); err != nil || len(claimed) == 0 {
+		return nil, nil
+	}
+
+	program := Current()
+	referrerReward := program.grant(ref.ReferrerID, program.ReferrerPoints, "Referral reward")
+	referredReward := program.grant(ref.ReferredUserID, program.ReferredPoints, "Welcome reward")
+
+	if _, _, err := database.Query("referrals").
+		Update(map[string]interface{}{
+			"referrer_reward": referrerReward,
+			"referred_reward": referredReward,
+		}, "", "").
+		Eq("id", ref.ID).
+		Execute(); err != nil {
+		return nil, err
+	}
+
+	ref.Status = "rewarded"
+	ref.OrderID = &orderID
+	ref.RewardedAt = &now
+	ref.ReferrerReward, _ = json.Marshal(referrerReward)
+	ref.ReferredReward, _ = json.Marshal(referredReward)
+	return &ref, nil
+}
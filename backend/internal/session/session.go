@@ -0,0 +1,389 @@
+package session
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+/*
+-----------------------------------------------------
+SESSION TRACKING & TOKEN REVOCATION
+-----------------------------------------------------
+- One row per device session in `user_sessions`
+- Revocations are written to Redis so every replica sees them
+- An in-memory store is always kept as a fallback;
+  entries are swept once they can no longer matter
+- Per-user cutoffs are also stored on the user row
+  so a restart without Redis still honours them
+- Never crashes if Redis or Supabase are unavailable
+*/
+
+const (
+	// RevocationTTL bounds how long a revoked session is remembered.
+	// It must outlive the longest refresh-token lifetime.
+	RevocationTTL = 30 * 24 * time.Hour
+
+	// touchInterval throttles last_seen_at writes per session
+	touchInterval = 5 * time.Minute
+
+	// sweepInterval is how often the in-memory store drops stale entries
+	sweepInterval = 10 * time.Minute
+)
+
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	DeviceID   string     `json:"device_id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// Device describes the client a request came from
+type Device struct {
+	DeviceID  string
+	UserAgent string
+	IPAddress string
+}
+
+type memoryStore struct {
+	mu        sync.RWMutex
+	revoked   map[string]time.Time // key -> expiry
+	cutoffs   map[string]int64     // userID -> unix cutoff
+	lastSeen  map[string]time.Time // sessionID -> last DB touch
+	lastSweep time.Time
+}
+
+var local = &memoryStore{
+	revoked:  make(map[string]time.Time),
+	cutoffs:  make(map[string]int64),
+	lastSeen: make(map[string]time.Time),
+}
+
+// sweepLocked drops revocations past their expiry, cutoffs older than any
+// token they could reject, and touch times outside the throttle window.
+// The caller holds mu.
+func (m *memoryStore) sweepLocked(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for id, expiry := range m.revoked {
+		if now.After(expiry) {
+			delete(m.revoked, id)
+		}
+	}
+	oldest := now.Add(-RevocationTTL).Unix()
+	for userID, cutoff := range m.cutoffs {
+		if cutoff < oldest {
+			delete(m.cutoffs, userID)
+		}
+	}
+	for id, last := range m.lastSeen {
+		if now.Sub(last) >= touchInterval {
+			delete(m.lastSeen, id)
+		}
+	}
+}
+
+/*
+-----------------------------------------------------
+CACHE KEY HELPERS
+-----------------------------------------------------
+*/
+
+func revokedSessionKey(sessionID string) string {
+	return "session:revoked:" + sessionID
+}
+
+func userCutoffKey(userID string) string {
+	return "session:cutoff:" + userID
+}
+
+/*
+-----------------------------------------------------
+REVOCATION CHECKS (called from AuthMiddleware)
+-----------------------------------------------------
+*/
+
+// IsRevoked reports whether a token belonging to sessionID/userID and
+// issued at issuedAt must be rejected.
+func IsRevoked(sessionID, userID string, issuedAt time.Time) bool {
+	if sessionID != "" && isSessionRevoked(sessionID) {
+		return true
+	}
+
+	cutoff := userCutoff(userID)
+	return cutoff > 0 && !issuedAt.IsZero() && issuedAt.Unix() <= cutoff
+}
+
+func isSessionRevoked(sessionID string) bool {
+	local.mu.RLock()
+	expiry, ok := local.revoked[sessionID]
+	local.mu.RUnlock()
+	if ok {
+		if time.Now().Before(expiry) {
+			return true
+		}
+		local.mu.Lock()
+		delete(local.revoked, sessionID)
+		local.mu.Unlock()
+	}
+
+	if cache.Client.IsAvailable() {
+		exists, err := cache.Client.Exists(revokedSessionKey(sessionID))
+		if err == nil && exists {
+			rememberRevoked(sessionID, RevocationTTL)
+			return true
+		}
+	}
+
+	return false
+}
+
+func userCutoff(userID string) int64 {
+	local.mu.RLock()
+	cutoff := local.cutoffs[userID]
+	local.mu.RUnlock()
+
+	// Redis may hold a newer cutoff written by another replica
+	if cache.Client.IsAvailable() {
+		val, err := cache.Client.GetString(userCutoffKey(userID))
+		if err == nil {
+			if parsed, err := strconv.ParseInt(val, 10, 64); err == nil && parsed > cutoff {
+				return parsed
+			}
+		}
+	}
+
+	return cutoff
+}
+
+func rememberRevoked(sessionID string, ttl time.Duration) {
+	now := time.Now()
+	local.mu.Lock()
+	local.revoked[sessionID] = now.Add(ttl)
+	local.sweepLocked(now)
+	local.mu.Unlock()
+}
+
+func rememberCutoff(userID string, cutoff int64) {
+	local.mu.Lock()
+	if cutoff > local.cutoffs[userID] {
+		local.cutoffs[userID] = cutoff
+	}
+	local.sweepLocked(time.Now())
+	local.mu.Unlock()
+}
+
+/*
+-----------------------------------------------------
+REVOCATION WRITES
+-----------------------------------------------------
+*/
+
+// Revoke marks a single session as revoked everywhere
+func Revoke(sessionID string) error {
+	rememberRevoked(sessionID, RevocationTTL)
+
+	if err := cache.Client.SetString(revokedSessionKey(sessionID), "1", RevocationTTL); err != nil {
+		log.Printf("⚠️  Failed to publish session revocation to Redis: %v", err)
+	}
+
+	_, _, err := database.Query("user_sessions").
+		Update(map[string]interface{}{
+			"revoked_at": time.Now().UTC().Format(time.RFC3339),
+		}, "", "").
+		Eq("id", sessionID).
+		Execute()
+	return err
+}
+
+// RevokeAllForUser revokes every known session of a user and rejects any
+// token for that user issued before now (covers sessions never seen here).
+func RevokeAllForUser(userID string) error {
+	now := time.Now()
+	cutoff := now.Unix()
+	rememberCutoff(userID, cutoff)
+
+	if err := cache.Client.SetString(userCutoffKey(userID), strconv.FormatInt(cutoff, 10), RevocationTTL); err != nil {
+		log.Printf("⚠️  Failed to publish user revocation to Redis: %v", err)
+	}
+
+	_, _, err := database.Query("users").
+		Update(map[string]interface{}{
+			"sessions_revoked_before": now.UTC().Format(time.RFC3339),
+		}, "", "").
+		Eq("id", userID).
+		Execute()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := ListForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		if s.RevokedAt == nil {
+			if err := Revoke(s.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+-----------------------------------------------------
+SESSION TRACKING
+-----------------------------------------------------
+*/
+
+// Touch records that sessionID was used from device. Writes are throttled
+// and run in the background so they never slow the request down.
+func Touch(sessionID, userID string, device Device) {
+	if sessionID == "" || database.Client == nil {
+		return
+	}
+
+	now := time.Now()
+
+	local.mu.Lock()
+	last, seen := local.lastSeen[sessionID]
+	if seen && now.Sub(last) < touchInterval {
+		local.mu.Unlock()
+		return
+	}
+	local.lastSeen[sessionID] = now
+	local.sweepLocked(now)
+	local.mu.Unlock()
+
+	go func() {
+		row := map[string]interface{}{
+			"id":           sessionID,
+			"user_id":      userID,
+			"device_id":    device.DeviceID,
+			"user_agent":   device.UserAgent,
+			"ip_address":   device.IPAddress,
+			"last_seen_at": now.UTC().Format(time.RFC3339),
+		}
+
+		_, _, err := database.Query("user_sessions").
+			Insert(row, true, "id", "", "").
+			Execute()
+		if err != nil {
+			log.Printf("⚠️  Failed to record session %s: %v", sessionID, err)
+		}
+	}()
+}
+
+// ListForUser returns all sessions of a user, most recently used first
+func ListForUser(userID string) ([]Session, error) {
+	result, _, err := database.Query("user_sessions").
+		Select("id, user_id, device_id, user_agent, ip_address, created_at, last_seen_at, revoked_at", "", false).
+		Eq("user_id", userID).
+		Order("last_seen_at", &postgrest.OrderOpts{Ascending: false}).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	if err := json.Unmarshal(result, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Get returns a session only if it belongs to userID
+func Get(sessionID, userID string) (*Session, error) {
+	result, _, err := database.Query("user_sessions").
+		Select("id, user_id, device_id, user_agent, ip_address, created_at, last_seen_at, revoked_at", "", false).
+		Eq("id", sessionID).
+		Eq("user_id", userID).
+		Single().
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var s Session
+	if err := json.Unmarshal(result, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+/*
+-----------------------------------------------------
+STARTUP
+-----------------------------------------------------
+*/
+
+// LoadRevoked warms the in-memory store from the database so revocations
+// survive restarts even when Redis is unavailable.
+func LoadRevoked() {
+	if database.Client == nil {
+		return
+	}
+
+	since := time.Now().Add(-RevocationTTL).UTC().Format(time.RFC3339)
+
+	result, _, err := database.Query("user_sessions").
+		Select("id, revoked_at", "", false).
+		Gte("revoked_at", since).
+		Execute()
+	if err != nil {
+		log.Printf("⚠️  Failed to load revoked sessions: %v", err)
+		return
+	}
+
+	var rows []Session
+	if err := json.Unmarshal(result, &rows); err != nil {
+		return
+	}
+
+	for _, s := range rows {
+		if s.RevokedAt != nil {
+			rememberRevoked(s.ID, RevocationTTL-time.Since(*s.RevokedAt))
+		}
+	}
+
+	log.Printf("✅ Loaded %d revoked sessions", len(rows))
+
+	result, _, err = database.Query("users").
+		Select("id, sessions_revoked_before", "", false).
+		Gte("sessions_revoked_before", since).
+		Execute()
+	if err != nil {
+		log.Printf("⚠️  Failed to load session cutoffs: %v", err)
+		return
+	}
+
+	var users []struct {
+		ID     string    `json:"id"`
+		Cutoff time.Time `json:"sessions_revoked_before"`
+	}
+	if err := json.Unmarshal(result, &users); err != nil {
+		return
+	}
+
+	for _, u := range users {
+		rememberCutoff(u.ID, u.Cutoff.Unix())
+	}
+
+	log.Printf("✅ Loaded %d session cutoffs", len(users))
+}
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSweepDropsStaleEntries(t *testing.T) {
+	now := time.Now()
+	m := &memoryStore{
+		revoked: map[string]time.Time{
+			"expired": now.Add(-time.Second),
+			"live":    now.Add(time.Hour),
+		},
+		cutoffs: map[string]int64{
+			"old":    now.Add(-RevocationTTL - time.Hour).Unix(),
+			"recent": now.Add(-time.Hour).Unix(),
+		},
+		lastSeen: map[string]time.Time{
+			"idle":   now.Add(-touchInterval),
+			"active": now.Add(-time.Minute),
+		},
+	}
+
+	m.sweepLocked(now)
+
+	if _, ok := m.revoked["expired"]; ok {
+		t.Error("expired revocation was kept")
+	}
+	if _, ok := m.revoked["live"]; !ok {
+		t.Error("live revocation was dropped")
+	}
+	if _, ok := m.cutoffs["old"]; ok {
+		t.Error("cutoff older than RevocationTTL was kept")
+	}
+	if _, ok := m.cutoffs["recent"]; !ok {
+		t.Error("recent cutoff was dropped")
+	}
+	if _, ok := m.lastSeen["idle"]; ok {
+		t.Error("touch outside the throttle window was kept")
+	}
+	if _, ok := m.lastSeen["active"]; !ok {
+		t.Error("touch inside the throttle window was dropped")
+	}
+
+	// Sweeps are rate limited
+	m.revoked["expired"] = now.Add(-time.Second)
+	m.sweepLocked(now.Add(time.Minute))
+	if _, ok := m.revoked["expired"]; !ok {
+		t.Error("swept again before sweepInterval")
+	}
+	m.sweepLocked(now.Add(sweepInterval))
+	if _, ok := m.revoked["expired"]; ok {
+		t.Error("no sweep after sweepInterval")
+	}
+}
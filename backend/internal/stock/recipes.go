@@ -0,0 +1,239 @@
+package stock
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"finedine/backend/internal/database"
+)
+
+/*
+-----------------------------------------------------
+RECIPES & DEPLETION
+-----------------------------------------------------
+- A recipe lists the inventory a menu item uses per
+  portion, in any unit convertible to the item's unit
+- When an order is completed its ingredients leave
+  stock as usage movements, once per order
+  (orders.stock_deducted_at)
+- Ingredients of the whole order are summed first, so
+  each inventory item moves once
+- Only the order's restaurant's menu items and
+  inventory are touched, whatever ids the order holds
+*/
+
+// Item is the part of an inventory row stock logic needs
+type Item struct {
+	ID           string  `json:"id"`
+	RestaurantID string  `json:"restaurant_id"`
+	Name         string  `json:"name"`
+	Unit         string  `json:"unit"`
+	Quantity     float64 `json:"quantity"`
+	MinStock     float64 `json:"min_stock"`
+}
+
+type Ingredient struct {
+	ID          string  `json:"id,omitempty"`
+	MenuItemID  string  `json:"menu_item_id"`
+	InventoryID string  `json:"inventory_id"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	Item        *Item   `json:"inventory,omitempty"`
+}
+
+const ingredientColumns = "id, menu_item_id, inventory_id, quantity, unit, inventory(id, restaurant_id, name, unit, quantity, min_stock)"
+
+// scopedIngredientColumns only matches rows whose menu item and inventory
+// item can be filtered to one restaurant
+const scopedIngredientColumns = "id, menu_item_id, inventory_id, quantity, unit, inventory!inner(id, restaurant_id, name, unit, quantity, min_stock), menu_items!inner(restaurant_id)"
+
+// Recipe lists a menu item's ingredients
+func Recipe(menuItemID string) ([]Ingredient, error) {
+	result, _, err := database.Query("recipe_ingredients").
+		Select(ingredientColumns, "", false).
+		Eq("menu_item_id", menuItemID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	ingredients := []Ingredient{}
+	if err := json.Unmarshal(result, &ingredients); err != nil {
+		return nil, err
+	}
+	return ingredients, nil
+}
+
+// SetRecipe replaces a menu item's ingredients
+func SetRecipe(menuItemID string, ingredients []Ingredient) error {
+	if _, _, err := database.Query("recipe_ingredients").
+		Delete("", "").
+		Eq("menu_item_id", menuItemID).
+		Execute(); err != nil {
+		return err
+	}
+	if len(ingredients) == 0 {
+		return nil
+	}
+
+	rows := make([]map[string]interface{}, 0, len(ingredients))
+	for _, ing := range ingredients {
+		rows = append(rows, map[string]interface{}{
+			"menu_item_id": menuItemID,
+			"inventory_id": ing.InventoryID,
+			"quantity":     ing.Quantity,
+			"unit":         ing.Unit,
+		})
+	}
+	_, _, err := database.Query("recipe_ingredients").
+		Insert(rows, false, "", "", "").
+		Execute()
+	return err
+}
+
+// Line is a sold menu item and how many portions
+type Line struct {
+	MenuItemID string
+	Quantity   float64
+}
+
+// Depleted is one inventory item taken out of stock by an order
+type Depleted struct {
+	Item     Item
+	Movement *Movement
+}
+
+// Deplete takes the ingredients of a completed order out of stock. It runs
+// once per order; later calls return nothing. Lines naming another
+// restaurant's menu items are ignored.
+func Deplete(orderID, restaurantID string, lines []Line) ([]Depleted, error) {
+	if restaurantID == "" {
+		return nil, fmt.Errorf("order %s has no restaurant", orderID)
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	claimed, _, err := database.Query("orders").
+		Update(map[string]interface{}{"stock_deducted_at": time.Now().UTC()}, "", "").
+		Eq("id", orderID).
+		Is("stock_deducted_at", "null").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(claimed, &rows); err != nil || len(rows) == 0 {
+		return nil, nil
+	}
+
+	portions := map[string]float64{}
+	ids := make([]string, 0, len(lines))
+	for _, l := range lines {
+		if _, seen := portions[l.MenuItemID]; !seen {
+			ids = append(ids, l.MenuItemID)
+		}
+		portions[l.MenuItemID] += l.Quantity
+	}
+
+	result, _, err := database.Query("recipe_ingredients").
+		Select(scopedIngredientColumns, "", false).
+		In("menu_item_id", ids).
+		Eq("menu_items.restaurant_id", restaurantID).
+		Eq("inventory.restaurant_id", restaurantID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var ingredients []Ingredient
+	if err := json.Unmarshal(result, &ingredients); err != nil {
+		return nil, err
+	}
+
+	needed := map[string]float64{}
+	items := map[string]Item{}
+	order := []string{}
+	for _, ing := range ingredients {
+		if ing.Item == nil || ing.Item.RestaurantID != restaurantID {
+			continue
+		}
+		qty, ok := Convert(ing.Quantity*portions[ing.MenuItemID], ing.Unit, ing.Item.Unit)
+		if !ok {
+			log.Printf("⚠️  Recipe for %s uses %s, which can't be converted to %s (%s)", ing.MenuItemID, ing.Unit, ing.Item.Unit, ing.Item.Name)
+			continue
+		}
+		if _, seen := items[ing.InventoryID]; !seen {
+			items[ing.InventoryID] = *ing.Item
+			order = append(order, ing.InventoryID)
+		}
+		needed[ing.InventoryID] += qty
+	}
+
+	depleted := []Depleted{}
+	for _, id := range order {
+		if needed[id] <= 0 {
+			continue
+		}
+		m, err := Record(Change{
+			InventoryID: id,
+			Kind:        Usage,
+			Quantity:    -needed[id],
+			Reason:      fmt.Sprintf("Order %s", orderID),
+			OrderID:     orderID,
+		})
+		if err != nil {
+			log.Printf("⚠️  Failed to deduct %s for order %s: %v", items[id].Name, orderID, err)
+			continue
+		}
+		depleted = append(depleted, Depleted{Item: items[id], Movement: m})
+	}
+	return depleted, nil
+}
+
+// Dish is a menu item taken off the menu
+type Dish struct {
+	ID           string `json:"id"`
+	RestaurantID string `json:"restaurant_id"`
+	Name         string `json:"name"`
+}
+
+// DisableDishes marks every available menu item of the restaurant that
+// needs the inventory item as unavailable, and returns the ones it changed
+func DisableDishes(restaurantID, inventoryID string) ([]Dish, error) {
+	result, _, err := database.Query("recipe_ingredients").
+		Select("menu_item_id", "", false).
+		Eq("inventory_id", inventoryID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	var uses []struct {
+		MenuItemID string `json:"menu_item_id"`
+	}
+	if err := json.Unmarshal(result, &uses); err != nil {
+		return nil, err
+	}
+	if len(uses) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(uses))
+	for _, u := range uses {
+		ids = append(ids, u.MenuItemID)
+	}
+
+	result, _, err = database.Query("menu_items").
+		Update(map[string]interface{}{"is_available": false}, "", "").
+		In("id", ids).
+		Eq("restaurant_id", restaurantID).
+		Eq("is_available", "true").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	dishes := []Dish{}
+	if err := json.Unmarshal(result, &dishes); err != nil {
+		return nil, err
+	}
+	return dishes, nil
+}
@@ -0,0 +1,283 @@
+package stock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+/*
+-----------------------------------------------------
+STOCK MOVEMENTS
+-----------------------------------------------------
+- inventory.quantity only changes through a movement
+  in inventory_movements: purchase, usage, waste,
+  adjustment or transfer, with who, why and the cost
+- The inventory_move SQL function locks the item,
+  applies the change and writes the movement with the
+  quantity before and after, so the two never drift
+- Stock can't go below zero, except usage (food that
+  was sold anyway), which stops at zero and records
+  only what was actually taken
+- Purchases and incoming transfers set last_restocked
+- A purchase at a unit cost moves the item's
+  cost_per_unit to the weighted average cost
+- A stock count passes the counted quantity; the
+  change is worked out under the item lock
+- Reconcile compares each item with the sum of its
+  movements (summed in SQL by inventory_drift) and
+  can book the difference
+*/
+
+type Kind string
+
+const (
+	Purchase   Kind = "purchase"
+	Usage      Kind = "usage"
+	Waste      Kind = "waste"
+	Adjustment Kind = "adjustment"
+	Transfer   Kind = "transfer"
+)
+
+var (
+	ErrInvalidKind  = errors.New("movement type must be purchase, usage, waste, adjustment or transfer")
+	ErrInsufficient = errors.New("not enough stock for this movement")
+	ErrNotFound     = errors.New("inventory item not found")
+)
+
+// epsilon absorbs float noise when comparing quantities
+const epsilon = 1e-6
+
+func ValidKind(kind string) bool {
+	switch Kind(kind) {
+	case Purchase, Usage, Waste, Adjustment, Transfer:
+		return true
+	}
+	return false
+}
+
+// Signed turns an entered quantity into the change it makes: purchases add,
+// usage and waste take away, adjustments and transfers carry their own sign
+func Signed(kind Kind, quantity float64) float64 {
+	switch kind {
+	case Purchase:
+		return math.Abs(quantity)
+	case Usage, Waste:
+		return -math.Abs(quantity)
+	}
+	return quantity
+}
+
+type Movement struct {
+	ID             string       `json:"id"`
+	RestaurantID   string       `json:"restaurant_id"`
+	InventoryID    string       `json:"inventory_id"`
+	Kind           Kind         `json:"kind"`
+	Quantity       float64      `json:"quantity"`
+	QuantityBefore float64      `json:"quantity_before"`
+	QuantityAfter  float64      `json:"quantity_after"`
+	UnitCost       money.Amount `json:"unit_cost"`
+	TotalCost      money.Amount `json:"total_cost"`
+	Reason         string       `json:"reason"`
+	UserID         *string      `json:"user_id"`
+	OrderID        *string      `json:"order_id"`
+	ReferenceID    *string      `json:"reference_id"`
+	TransferID     *string      `json:"transfer_id"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+// CrossedBelow reports whether this movement took stock down to or under level
+func (m *Movement) CrossedBelow(level float64) bool {
+	return level > 0 && m.QuantityBefore > level && m.QuantityAfter <= level+epsilon
+}
+
+// RanOut reports whether this movement used up the last of the stock
+func (m *Movement) RanOut() bool {
+	return m.QuantityBefore > epsilon && m.QuantityAfter <= epsilon
+}
+
+// MarkLowStockAlerted records that the owner has been told these items are
+// low, so the inventory watcher doesn't repeat it
+func MarkLowStockAlerted(inventoryIDs ...string) {
+	if len(inventoryIDs) == 0 {
+		return
+	}
+	database.Query("inventory").
+		Update(map[string]interface{}{"low_stock_alerted_at": time.Now().UTC()}, "", "").
+		In("id", inventoryIDs).
+		Execute()
+}
+
+// Change is one movement to record
+type Change struct {
+	InventoryID string
+	Kind        Kind
+	Quantity    float64 // signed: negative takes stock away
+	UnitCost    money.Amount
+	Reason      string
+	UserID      string
+	OrderID     string
+	ReferenceID string // e.g. the waste entry or purchase order behind it
+	TransferID  string
+	Absolute    bool // Quantity is the counted stock level, not a change
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// unitCost lets the item's cost_per_unit apply when none was given
+func unitCost(a money.Amount) interface{} {
+	if a.IsZero() {
+		return nil
+	}
+	return a
+}
+
+// Record applies a change through inventory_move
+func Record(ch Change) (*Movement, error) {
+	if !ValidKind(string(ch.Kind)) {
+		return nil, ErrInvalidKind
+	}
+	resultStr := database.Client.Rpc("inventory_move", "", map[string]interface{}{
+		"p_inventory_id": ch.InventoryID,
+		"p_kind":         ch.Kind,
+		"p_quantity":     ch.Quantity,
+		"p_unit_cost":    unitCost(ch.UnitCost),
+		"p_reason":       nullable(ch.Reason),
+		"p_user_id":      nullable(ch.UserID),
+		"p_order_id":     nullable(ch.OrderID),
+		"p_reference_id": nullable(ch.ReferenceID),
+		"p_transfer_id":  nullable(ch.TransferID),
+		"p_absolute":     ch.Absolute,
+	})
+
+	var movements []Movement
+	if err := json.Unmarshal([]byte(resultStr), &movements); err != nil || len(movements) == 0 {
+		switch {
+		case strings.Contains(resultStr, "insufficient stock"):
+			return nil, ErrInsufficient
+		case strings.Contains(resultStr, "inventory item not found"):
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("inventory_move: %s", resultStr)
+	}
+	return &movements[0], nil
+}
+
+// Move moves stock from one item to another (e.g. between two branches).
+// If the incoming side fails, the outgoing side is put back.
+func Move(fromID, toID string, quantity float64, reason, userID string) (*Movement, *Movement, error) {
+	quantity = math.Abs(quantity)
+
+	// The outgoing movement's id becomes the transfer id for both sides
+	out, err := Record(Change{
+		InventoryID: fromID,
+		Kind:        Transfer,
+		Quantity:    -quantity,
+		Reason:      reason,
+		UserID:      userID,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	transferID := out.ID
+
+	in, err := Record(Change{
+		InventoryID: toID,
+		Kind:        Transfer,
+		Quantity:    quantity,
+		UnitCost:    out.UnitCost,
+		Reason:      reason,
+		UserID:      userID,
+		TransferID:  transferID,
+	})
+	if err != nil {
+		Record(Change{
+			InventoryID: fromID,
+			Kind:        Adjustment,
+			Quantity:    quantity,
+			Reason:      "Transfer failed, stock returned",
+			UserID:      userID,
+			TransferID:  transferID,
+		})
+		return nil, nil, err
+	}
+	return out, in, nil
+}
+
+// History lists an item's movements, newest first
+func History(inventoryID string, limit int) ([]Movement, error) {
+	result, _, err := database.Query("inventory_movements").
+		Select("*", "", false).
+		Eq("inventory_id", inventoryID).
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+	movements := []Movement{}
+	if err := json.Unmarshal(result, &movements); err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+/*
+-----------------------------------------------------
+RECONCILIATION
+-----------------------------------------------------
+*/
+
+// Drift is an item whose quantity doesn't match its movements
+type Drift struct {
+	InventoryID string  `json:"inventory_id"`
+	Name        string  `json:"name"`
+	Quantity    float64 `json:"quantity"`
+	Ledger      float64 `json:"ledger_quantity"`
+	Difference  float64 `json:"difference"` // quantity - ledger
+}
+
+// Reconcile lists the restaurant's items whose quantity differs from the
+// sum of their movements
+func Reconcile(restaurantID string) ([]Drift, error) {
+	resultStr := database.Client.Rpc("inventory_drift", "", map[string]interface{}{
+		"p_restaurant_id": restaurantID,
+	})
+
+	drifts := []Drift{}
+	if err := json.Unmarshal([]byte(resultStr), &drifts); err != nil {
+		return nil, fmt.Errorf("inventory_drift: %s", resultStr)
+	}
+	return drifts, nil
+}
+
+// Book records an adjustment that brings the ledger in line with the
+// item's quantity, without changing the quantity itself
+func Book(restaurantID string, d Drift, userID string) error {
+	_, _, err := database.Query("inventory_movements").
+		Insert(map[string]interface{}{
+			"restaurant_id":   restaurantID,
+			"inventory_id":    d.InventoryID,
+			"kind":            Adjustment,
+			"quantity":        d.Difference,
+			"quantity_before": d.Ledger,
+			"quantity_after":  d.Quantity,
+			"reason":          "Reconciliation",
+			"user_id":         nullable(userID),
+		}, false, "", "", "").
+		Execute()
+	return err
+}
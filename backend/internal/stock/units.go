@@ -0,0 +1,55 @@
+package stock
+
+import "strings"
+
+/*
+-----------------------------------------------------
+UNITS
+-----------------------------------------------------
+- Recipes may measure in a different unit than the
+  inventory item is stocked in (grams of a flour
+  stocked in kg), so quantities are converted within
+  the same dimension: mass, volume or count
+*/
+
+type unit struct {
+	dimension string
+	factor    float64 // in the dimension's base unit (g, ml, piece)
+}
+
+var units = map[string]unit{
+	"mg": {"mass", 0.001}, "g": {"mass", 1}, "gram": {"mass", 1}, "grams": {"mass", 1},
+	"kg": {"mass", 1000}, "kilogram": {"mass", 1000}, "kilograms": {"mass", 1000},
+	"oz": {"mass", 28.3495}, "ounce": {"mass", 28.3495}, "ounces": {"mass", 28.3495},
+	"lb": {"mass", 453.592}, "lbs": {"mass", 453.592}, "pound": {"mass", 453.592}, "pounds": {"mass", 453.592},
+
+	"ml": {"volume", 1}, "cl": {"volume", 10}, "dl": {"volume", 100},
+	"l": {"volume", 1000}, "liter": {"volume", 1000}, "liters": {"volume", 1000},
+	"litre": {"volume", 1000}, "litres": {"volume", 1000},
+	"tsp": {"volume", 4.92892}, "tbsp": {"volume", 14.7868}, "cup": {"volume", 240}, "cups": {"volume", 240},
+	"fl oz": {"volume", 29.5735},
+
+	"pc": {"count", 1}, "pcs": {"count", 1}, "piece": {"count", 1}, "pieces": {"count", 1},
+	"unit": {"count", 1}, "units": {"count", 1}, "each": {"count", 1}, "ea": {"count", 1},
+	"dozen": {"count", 12},
+}
+
+func normalizeUnit(u string) string {
+	return strings.ToLower(strings.TrimSpace(u))
+}
+
+// Convert expresses quantity in from-units as to-units. An empty from-unit
+// means the quantity is already in to-units. ok is false when the units
+// can't be converted (e.g. grams to pieces).
+func Convert(quantity float64, from, to string) (float64, bool) {
+	from, to = normalizeUnit(from), normalizeUnit(to)
+	if from == "" || from == to {
+		return quantity, true
+	}
+	f, okFrom := units[from]
+	t, okTo := units[to]
+	if !okFrom || !okTo || f.dimension != t.dimension {
+		return 0, false
+	}
+	return quantity * f.factor / t.factor, true
+}
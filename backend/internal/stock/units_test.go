@@ -0,0 +1,34 @@
+package stock
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		quantity float64
+		from, to string
+		want     float64
+		ok       bool
+	}{
+		{250, "g", "kg", 0.25, true},
+		{1.5, "kg", "g", 1500, true},
+		{2, " KG ", "grams", 2000, true},
+		{1, "lb", "oz", 16, true},
+		{33, "cl", "l", 0.33, true},
+		{2, "tbsp", "ml", 29.5736, true},
+		{2, "dozen", "pcs", 24, true},
+		{3, "", "kg", 3, true}, // already in stock units
+		{3, "bunch", "bunch", 3, true},
+		{100, "g", "pcs", 0, false},
+		{1, "l", "kg", 0, false},
+		{1, "bunch", "g", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Convert(tt.quantity, tt.from, tt.to)
+		if ok != tt.ok || math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("Convert(%g, %q, %q) = %g, %v; want %g, %v", tt.quantity, tt.from, tt.to, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"finedine/backend/internal/cache"
+	"finedine/backend/internal/database"
+	"finedine/backend/internal/money"
+)
+
+/*
+-----------------------------------------------------
+TAX RULES
+-----------------------------------------------------
+- Configured per restaurant (restaurants.tax_rules)
+- Prices are either tax-inclusive or tax-exclusive
+- Menu categories may override the default rate
+- An optional service charge is added on the net
+  amount and may itself be taxed at the default rate
+- Rates are percentages; amounts are rounded to the
+  currency's minor unit per tax line
+*/
+
+type Rules struct {
+	Label                string             `json:"label"`
+	Inclusive            bool               `json:"inclusive"`
+	DefaultRate          float64            `json:"default_rate"`
+	CategoryRates        map[string]float64 `json:"category_rates,omitempty"`
+	ServiceChargePercent float64            `json:"service_charge_percent"`
+	ServiceChargeTaxable bool               `json:"service_charge_taxable"`
+	InvoicePrefix        string             `json:"invoice_prefix,omitempty"`
+}
+
+// Item is one priced order line as charged on the menu
+type Item struct {
+	Category string
+	Amount   money.Amount
+}
+
+type Line struct {
+	Label   string       `json:"label"`
+	Rate    float64      `json:"rate"`
+	Taxable money.Amount `json:"taxable"`
+	Amount  money.Amount `json:"amount"`
+}
+
+type Breakdown struct {
+	Currency      string       `json:"currency"`
+	Inclusive     bool         `json:"inclusive"`
+	Subtotal      money.Amount `json:"subtotal"`
+	Net           money.Amount `json:"net"`
+	ServiceCharge money.Amount `json:"service_charge"`
+	Tax           money.Amount `json:"tax"`
+	Total         money.Amount `json:"total"`
+	Lines         []Line       `json:"tax_lines"`
+}
+
+const DefaultInvoicePrefix = "INV"
+
+func (r Rules) Validate() error {
+	check := func(name string, v float64) error {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", name)
+		}
+		return nil
+	}
+	if err := check("default_rate", r.DefaultRate); err != nil {
+		return err
+	}
+	if err := check("service_charge_percent", r.ServiceChargePercent); err != nil {
+		return err
+	}
+	for category, rate := range r.CategoryRates {
+		if err := check("rate for "+category, rate); err != nil {
+			return err
+		}
+	}
+	if len(r.InvoicePrefix) > 12 {
+		return fmt.Errorf("invoice_prefix must be at most 12 characters")
+	}
+	return nil
+}
+
+// RateFor returns the rate for a menu category (case-insensitive)
+func (r Rules) RateFor(category string) float64 {
+	for name, rate := range r.CategoryRates {
+		if strings.EqualFold(name, strings.TrimSpace(category)) {
+			return rate
+		}
+	}
+	return r.DefaultRate
+}
+
+func (r Rules) Prefix() string {
+	if r.InvoicePrefix == "" {
+		return DefaultInvoicePrefix
+	}
+	return r.InvoicePrefix
+}
+
+// Compute prices items under the rules. Subtotal is what the menu charged,
+// Net excludes tax, and Total is what the customer pays (before tips).
+func Compute(r Rules, currency string, items []Item) Breakdown {
+	b := Breakdown{Currency: money.Normalize(currency), Inclusive: r.Inclusive}
+	round := func(a money.Amount) money.Amount { return a.Round(currency) }
+
+	gross := map[float64]money.Amount{}
+	for _, it := range items {
+		b.Subtotal += it.Amount
+		gross[r.RateFor(it.Category)] += it.Amount
+	}
+
+	rates := make([]float64, 0, len(gross))
+	for rate := range gross {
+		rates = append(rates, rate)
+	}
+	sort.Float64s(rates)
+
+	lines := map[float64]*Line{}
+	for _, rate := range rates {
+		amount := gross[rate]
+		net := amount
+		if r.Inclusive {
+			net = amount.MulFloat(1 / (1 + rate/100))
+		}
+		lines[rate] = &Line{Rate: rate, Taxable: net, Amount: net.Percent(rate)}
+		b.Net += net
+	}
+
+	var serviceTax money.Amount
+	if r.ServiceChargePercent > 0 {
+		b.ServiceCharge = round(b.Net.Percent(r.ServiceChargePercent))
+		if r.ServiceChargeTaxable && r.DefaultRate > 0 {
+			l, ok := lines[r.DefaultRate]
+			if !ok {
+				l = &Line{Rate: r.DefaultRate}
+				lines[r.DefaultRate] = l
+				rates = append(rates, r.DefaultRate)
+				sort.Float64s(rates)
+			}
+			// Service charge is always added on top, so its tax is too
+			serviceTax = round(b.ServiceCharge.Percent(r.DefaultRate))
+			l.Taxable += b.ServiceCharge
+			l.Amount += serviceTax
+		}
+	}
+
+	label := r.Label
+	if label == "" {
+		label = "Tax"
+	}
+
+	for _, rate := range rates {
+		l := lines[rate]
+		if rate <= 0 {
+			continue
+		}
+		l.Label = fmt.Sprintf("%s %g%%", label, rate)
+		l.Taxable = round(l.Taxable)
+		l.Amount = round(l.Amount)
+		b.Tax += l.Amount
+		b.Lines = append(b.Lines, *l)
+	}
+
+	if r.Inclusive {
+		// Menu prices already carry their tax; only the service charge's is extra
+		b.Total = b.Subtotal + b.ServiceCharge + serviceTax
+	} else {
+		b.Total = b.Subtotal + b.ServiceCharge + b.Tax
+	}
+
+	b.Subtotal = round(b.Subtotal)
+	b.Net = round(b.Net)
+	b.Tax = round(b.Tax)
+	b.Total = round(b.Total)
+	if b.Lines == nil {
+		b.Lines = []Line{}
+	}
+	return b
+}
+
+/*
+-----------------------------------------------------
+RESTAURANT RULES
+-----------------------------------------------------
+*/
+
+const rulesCacheTTL = 5 * time.Minute
+
+func cacheKey(restaurantID string) string {
+	return "tax_rules:" + restaurantID
+}
+
+// ForRestaurant returns the restaurant's rules; no configuration means no tax
+func ForRestaurant(restaurantID string) Rules {
+	var rules Rules
+	if err := cache.SafeGet(cacheKey(restaurantID), &rules); err == nil {
+		return rules
+	}
+
+	result, _, err := database.Query("restaurants").
+		Select("tax_rules", "", false).
+		Eq("id", restaurantID).
+		Single().
+		Execute()
+	if err != nil {
+		return rules
+	}
+
+	var row struct {
+		TaxRules *Rules `json:"tax_rules"`
+	}
+	if json.Unmarshal(result, &row) == nil && row.TaxRules != nil {
+		rules = *row.TaxRules
+	}
+
+	cache.SafeSet(cacheKey(restaurantID), rules, rulesCacheTTL)
+	return rules
+}
+
+// Forget drops cached rules after the owner edits them
+func Forget(restaurantID string) {
+	cache.SafeDelete(cacheKey(restaurantID))
+}
@@ -0,0 +1,166 @@
+package tax
+
+import (
+	"testing"
+
+	"finedine/backend/internal/money"
+)
+
+func amount(t *testing.T, s string) money.Amount {
+	t.Helper()
+	a, err := money.Parse(s)
+	if err != nil {
+		t.Fatalf("money.Parse(%q): %v", s, err)
+	}
+	return a
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    Rules
+		currency string
+		items    map[string]string // category -> amount
+
+		net, service, tax, total string
+		lines                    []float64 // rates of the tax lines, in order
+	}{
+		{
+			name:     "exclusive",
+			rules:    Rules{DefaultRate: 10},
+			currency: "usd",
+			items:    map[string]string{"mains": "15.00"},
+			net:      "15", service: "0", tax: "1.5", total: "16.5",
+			lines: []float64{10},
+		},
+		{
+			name:     "inclusive, exact",
+			rules:    Rules{Inclusive: true, DefaultRate: 20},
+			currency: "gbp",
+			items:    map[string]string{"mains": "12.00"},
+			net:      "10", service: "0", tax: "2", total: "12",
+			lines: []float64{20},
+		},
+		{
+			name:     "inclusive, total stays the menu price when tax rounds",
+			rules:    Rules{Inclusive: true, DefaultRate: 20},
+			currency: "gbp",
+			items:    map[string]string{"mains": "10.00"},
+			net:      "8.33", service: "0", tax: "1.67", total: "10",
+			lines: []float64{20},
+		},
+		{
+			name: "inclusive, category rates",
+			rules: Rules{Inclusive: true, DefaultRate: 10,
+				CategoryRates: map[string]float64{"Alcohol": 20}},
+			currency: "eur",
+			items:    map[string]string{"food": "11.00", "alcohol": "6.00"},
+			net:      "15", service: "0", tax: "2", total: "17",
+			lines: []float64{10, 20},
+		},
+		{
+			name: "inclusive, taxable service charge is added on top",
+			rules: Rules{Inclusive: true, DefaultRate: 10,
+				ServiceChargePercent: 10, ServiceChargeTaxable: true},
+			currency: "eur",
+			items:    map[string]string{"food": "11.00"},
+			net:      "10", service: "1", tax: "1.1", total: "12.1",
+			lines: []float64{10},
+		},
+		{
+			name:     "exclusive, untaxed service charge",
+			rules:    Rules{DefaultRate: 5, ServiceChargePercent: 12.5},
+			currency: "usd",
+			items:    map[string]string{"food": "20.00"},
+			net:      "20", service: "2.5", tax: "1", total: "23.5",
+			lines: []float64{5},
+		},
+		{
+			name:     "zero-decimal currency",
+			rules:    Rules{Inclusive: true, DefaultRate: 10},
+			currency: "jpy",
+			items:    map[string]string{"food": "1000"},
+			net:      "909", service: "0", tax: "91", total: "1000",
+			lines: []float64{10},
+		},
+		{
+			name:     "no tax",
+			rules:    Rules{},
+			currency: "usd",
+			items:    map[string]string{"food": "9.99"},
+			net:      "9.99", service: "0", tax: "0", total: "9.99",
+			lines: []float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []Item
+			for category, a := range tt.items {
+				items = append(items, Item{Category: category, Amount: amount(t, a)})
+			}
+			b := Compute(tt.rules, tt.currency, items)
+
+			check := func(field string, got money.Amount, want string) {
+				if got != amount(t, want) {
+					t.Errorf("%s = %s, want %s", field, got, want)
+				}
+			}
+			check("Net", b.Net, tt.net)
+			check("ServiceCharge", b.ServiceCharge, tt.service)
+			check("Tax", b.Tax, tt.tax)
+			check("Total", b.Total, tt.total)
+
+			if b.Lines == nil {
+				t.Fatal("Lines is nil, want an empty list")
+			}
+			if len(b.Lines) != len(tt.lines) {
+				t.Fatalf("got %d tax lines, want %d", len(b.Lines), len(tt.lines))
+			}
+			var sum money.Amount
+			for i, l := range b.Lines {
+				if l.Rate != tt.lines[i] {
+					t.Errorf("line %d rate = %g, want %g", i, l.Rate, tt.lines[i])
+				}
+				sum += l.Amount
+			}
+			if sum != b.Tax {
+				t.Errorf("tax lines add up to %s, Tax is %s", sum, b.Tax)
+			}
+		})
+	}
+}
+
+func TestRateFor(t *testing.T) {
+	r := Rules{DefaultRate: 8, CategoryRates: map[string]float64{"Drinks": 20}}
+	tests := map[string]float64{
+		"drinks":   20,
+		" DRINKS ": 20,
+		"mains":    8,
+		"":         8,
+	}
+	for category, want := range tests {
+		if got := r.RateFor(category); got != want {
+			t.Errorf("RateFor(%q) = %g, want %g", category, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+		ok    bool
+	}{
+		{"defaults", Rules{}, true},
+		{"full", Rules{DefaultRate: 20, ServiceChargePercent: 12.5, CategoryRates: map[string]float64{"food": 5}}, true},
+		{"negative rate", Rules{DefaultRate: -1}, false},
+		{"category over 100", Rules{CategoryRates: map[string]float64{"food": 101}}, false},
+		{"long prefix", Rules{InvoicePrefix: "ABCDEFGHIJKLM"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rules.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"finedine/backend/internal/database"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/supabase-community/postgrest-go"
+)
+
+/*
+-----------------------------------------------------
+STRIPE WEBHOOK EVENT LOG
+-----------------------------------------------------
+- Every event is persisted in `webhook_events` by its Stripe ID
+- Duplicate deliveries of a processed event are acknowledged, not re-run
+- An event is claimed before processing so replicas never run it twice
+- Failures are retried with exponential backoff by a background worker
+- After MaxAttempts the event moves to dead_letter for manual replay
+*/
+
+type Status string
+
+const (
+	StatusReceived   Status = "received"
+	StatusProcessing Status = "processing"
+	StatusProcessed  Status = "processed"
+	StatusFailed     Status = "failed"
+	StatusDeadLetter Status = "dead_letter"
+)
+
+const (
+	// MaxAttempts before an event is dead-lettered
+	MaxAttempts = 5
+
+	retryBase = time.Minute
+	retryMax  = time.Hour
+
+	// A claim older than this is assumed to belong to a crashed worker
+	staleClaim = 10 * time.Minute
+
+	retryBatch = 50
+)
+
+var ErrNotFound = errors.New("webhook event not found")
+
+// Handler processes one Stripe event. A returned error schedules a retry.
+type Handler func(event stripe.Event) error
+
+var (
+	mu       sync.RWMutex
+	handlers = make(map[stripe.EventType]Handler)
+)
+
+// Register routes an event type to its handler. Unregistered types are
+// recorded and acknowledged without further work.
+func Register(eventType stripe.EventType, h Handler) {
+	mu.Lock()
+	handlers[eventType] = h
+	mu.Unlock()
+}
+
+func handlerFor(eventType stripe.EventType) Handler {
+	mu.RLock()
+	defer mu.RUnlock()
+	return handlers[eventType]
+}
+
+type Event struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Status      Status          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	LastError   *string         `json:"last_error"`
+	NextRetryAt *time.Time      `json:"next_retry_at"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+}
+
+// Done reports whether no further processing will happen automatically
+func (s Status) Done() bool {
+	return s == StatusProcessed || s == StatusDeadLetter
+}
+
+// backoff grows exponentially with each failed attempt
+func backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 10 {
+		return retryMax
+	}
+	d := retryBase << uint(attempts-1)
+	if d > retryMax {
+		return retryMax
+	}
+	return d
+}
+
+/*
+-----------------------------------------------------
+RECEIVE & PROCESS
+-----------------------------------------------------
+*/
+
+// Receive persists a verified event (once) and processes it unless it has
+// already been handled. The returned status is the event's state afterwards.
+func Receive(event stripe.Event, payload []byte) (Status, error) {
+	existing, err := Get(event.ID)
+	switch {
+	case err == nil:
+		if existing.Status.Done() {
+			return existing.Status, nil
+		}
+	case errors.Is(err, ErrNotFound):
+		_, _, err = database.Query("webhook_events").
+			Insert(map[string]interface{}{
+				"id":       event.ID,
+				"type":     string(event.Type),
+				"status":   StatusReceived,
+				"attempts": 0,
+				"payload":  json.RawMessage(payload),
+			}, false, "", "", "").
+			Execute()
+		if err != nil {
+			// Lost an insert race with a concurrent delivery
+			if existing, getErr := Get(event.ID); getErr == nil {
+				if existing.Status.Done() {
+					return existing.Status, nil
+				}
+			} else {
+				return "", fmt.Errorf("record webhook event: %w", err)
+			}
+		}
+	default:
+		return "", err
+	}
+
+	return process(event)
+}
+
+// claim moves an event to processing if nobody else holds it.
+// Returns the attempt number, or 0 when the claim was not won.
+func claim(eventID string) (int, error) {
+	now := time.Now().UTC()
+	stale := now.Add(-staleClaim).Format(time.RFC3339)
+
+	result, _, err := database.Query("webhook_events").
+		Update(map[string]interface{}{
+			"status":     StatusProcessing,
+			"updated_at": now.Format(time.RFC3339),
+		}, "", "id, attempts").
+		Eq("id", eventID).
+		Or("status.in.(received,failed),and(status.eq.processing,updated_at.lt."+stale+")", "").
+		Execute()
+	if err != nil {
+		return 0, err
+	}
+
+	var rows []struct {
+		Attempts int `json:"attempts"`
+	}
+	if err := json.Unmarshal(result, &rows); err != nil || len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0].Attempts + 1, nil
+}
+
+// run calls the handler, turning panics into errors so one bad event
+// can't take the worker down
+func run(h Handler, event stripe.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(event)
+}
+
+func process(event stripe.Event) (Status, error) {
+	attempt, err := claim(event.ID)
+	if err != nil {
+		return "", fmt.Errorf("claim webhook event: %w", err)
+	}
+	if attempt == 0 {
+		// Another delivery or worker is on it
+		return StatusProcessing, nil
+	}
+
+	var runErr error
+	if h := handlerFor(event.Type); h != nil {
+		runErr = run(h, event)
+	}
+
+	now := time.Now().UTC()
+	updates := map[string]interface{}{
+		"attempts":   attempt,
+		"updated_at": now.Format(time.RFC3339),
+	}
+
+	status := StatusProcessed
+	if runErr == nil {
+		updates["last_error"] = nil
+		updates["next_retry_at"] = nil
+		updates["processed_at"] = now.Format(time.RFC3339)
+	} else {
+		status = StatusFailed
+		updates["last_error"] = runErr.Error()
+		if attempt >= MaxAttempts {
+			status = StatusDeadLetter
+			updates["next_retry_at"] = nil
+			log.Printf("🚨 Webhook %s (%s) dead-lettered after %d attempts: %v", event.ID, event.Type, attempt, runErr)
+		} else {
+			updates["next_retry_at"] = now.Add(backoff(attempt)).Format(time.RFC3339)
+			log.Printf("⚠️  Webhook %s (%s) attempt %d failed: %v", event.ID, event.Type, attempt, runErr)
+		}
+	}
+	updates["status"] = status
+
+	if _, _, err := database.Query("webhook_events").
+		Update(updates, "", "").
+		Eq("id", event.ID).
+		Execute(); err != nil {
+		log.Printf("⚠️  Failed to update webhook event %s: %v", event.ID, err)
+	}
+
+	return status, runErr
+}
+
+/*
+-----------------------------------------------------
+RETRY WORKER
+-----------------------------------------------------
+*/
+
+var workerOnce sync.Once
+
+// StartRetryWorker periodically re-processes failed events whose backoff
+// has elapsed, plus claims abandoned by a crashed worker
+func StartRetryWorker(interval time.Duration) {
+	workerOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				retryDue()
+			}
+		}()
+		log.Println("✅ Webhook retry worker started")
+	})
+}
+
+func retryDue() {
+	if database.Client == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	stale := now.Add(-staleClaim).Format(time.RFC3339)
+
+	result, _, err := database.Query("webhook_events").
+		Select("id, payload", "", false).
+		Or("and(status.eq.failed,next_retry_at.lte."+now.Format(time.RFC3339)+"),and(status.eq.processing,updated_at.lt."+stale+")", "").
+		Order("created_at", &postgrest.OrderOpts{Ascending: true}).
+		Limit(retryBatch, "").
+		Execute()
+	if err != nil {
+		log.Printf("⚠️  Webhook retry scan failed: %v", err)
+		return
+	}
+
+	var due []Event
+	if err := json.Unmarshal(result, &due); err != nil {
+		return
+	}
+
+	for _, row := range due {
+		var event stripe.Event
+		if err := json.Unmarshal(row.Payload, &event); err != nil {
+			log.Printf("⚠️  Webhook %s has an unreadable payload: %v", row.ID, err)
+			continue
+		}
+		process(event)
+	}
+}
+
+/*
+-----------------------------------------------------
+ADMIN: LIST & REPLAY
+-----------------------------------------------------
+*/
+
+// Get returns a stored event including its payload
+func Get(eventID string) (*Event, error) {
+	result, _, err := database.Query("webhook_events").
+		Select("*", "", false).
+		Eq("id", eventID).
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Event
+	if err := json.Unmarshal(result, &rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrNotFound
+	}
+	return &rows[0], nil
+}
+
+// List returns the most recent events, optionally filtered by status
+func List(status string, limit int) ([]Event, error) {
+	query := database.Query("webhook_events").
+		Select("id, type, status, attempts, last_error, next_retry_at, processed_at, created_at, updated_at", "", false)
+	if status != "" {
+		query = query.Eq("status", status)
+	}
+
+	result, _, err := query.
+		Order("created_at", &postgrest.OrderOpts{Ascending: false}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	if err := json.Unmarshal(result, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Replay re-runs a stored event immediately with a fresh retry budget.
+// Processed events are only replayed when force is set.
+func Replay(eventID string, force bool) (Status, error) {
+	stored, err := Get(eventID)
+	if err != nil {
+		return "", err
+	}
+	if stored.Status == StatusProcessed && !force {
+		return stored.Status, fmt.Errorf("event %s was already processed", eventID)
+	}
+
+	var event stripe.Event
+	if err := json.Unmarshal(stored.Payload, &event); err != nil {
+		return "", fmt.Errorf("decode stored payload: %w", err)
+	}
+
+	_, _, err = database.Query("webhook_events").
+		Update(map[string]interface{}{
+			"status":        StatusReceived,
+			"attempts":      0,
+			"next_retry_at": nil,
+			"updated_at":    time.Now().UTC().Format(time.RFC3339),
+		}, "", "").
+		Eq("id", eventID).
+		Neq("status", string(StatusProcessing)).
+		Execute()
+	if err != nil {
+		return "", err
+	}
+
+	return process(event)
+}
@@ -15,12 +15,17 @@ CREATE TABLE IF NOT EXISTS users (
   address text,
   role text CHECK (role IN ('customer', 'restaurant_owner')),
   cuisine_preferences text[],
+  latitude numeric,
+  longitude numeric,
+  notification_preferences jsonb,
   restaurant_id uuid,
   photo text,
   password_hash text,
   points integer DEFAULT 0,
+  referral_code text UNIQUE,
   favorites text[] DEFAULT '{}',
   card_details jsonb,
+  sessions_revoked_before timestamptz,
   created_at timestamptz DEFAULT now(),
   updated_at timestamptz DEFAULT now()
 );
@@ -48,6 +53,25 @@ CREATE TABLE IF NOT EXISTS restaurants (
   booking_terms text,
   owner_id uuid REFERENCES users(id),
   tables jsonb DEFAULT '[]'::jsonb,
+  subscription_plan text DEFAULT 'free' CHECK (subscription_plan IN ('free', 'pro', 'enterprise')),
+  subscription_status text,
+  subscription_expires_at timestamptz,
+  stripe_customer_id text,
+  stripe_subscription_id text,
+  subscription_cancel_at_period_end boolean DEFAULT false,
+  trial_ends_at timestamptz,
+  payment_method_status text CHECK (payment_method_status IN ('ok', 'missing', 'failed', 'requires_action')),
+  stripe_account_id text,
+  connect_charges_enabled boolean DEFAULT false,
+  connect_payouts_enabled boolean DEFAULT false,
+  commission_percent numeric CHECK (commission_percent >= 0 AND commission_percent <= 100),
+  tax_rules jsonb,
+  currency text DEFAULT 'usd' CHECK (currency ~ '^[a-z]{3}$'),
+  timezone text DEFAULT 'UTC',
+  latitude numeric,
+  longitude numeric,
+  loyalty_rules jsonb,
+  inventory_digest_on date,
   created_at timestamptz DEFAULT now(),
   updated_at timestamptz DEFAULT now()
 );
@@ -89,12 +113,29 @@ CREATE TABLE IF NOT EXISTS orders (
   subtotal numeric,
   discount numeric DEFAULT 0,
   total numeric,
-  status text DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'preparing', 'ready', 'completed', 'rejected', 'cancelled')),
+  status text DEFAULT 'pending' CHECK (status IN ('awaiting_payment', 'pending', 'accepted', 'preparing', 'ready', 'completed', 'rejected', 'cancelled')),
+  payment_status text DEFAULT 'unpaid' CHECK (payment_status IN ('unpaid', 'pending', 'partially_paid', 'paid', 'failed', 'refunded', 'partially_refunded')),
   table_number text,
   pickup_time text,
   special_instructions text,
   estimated_time integer,
   messages jsonb DEFAULT '[]'::jsonb,
+  source text DEFAULT 'app',
+  external_ref text,
+  refunded_amount numeric DEFAULT 0,
+  refunded_items jsonb DEFAULT '{}'::jsonb,
+  tip_amount numeric DEFAULT 0,
+  tax_amount numeric DEFAULT 0,
+  service_charge numeric DEFAULT 0,
+  tax_inclusive boolean DEFAULT false,
+  tax_lines jsonb DEFAULT '[]'::jsonb,
+  currency text,
+  coupon_code text,
+  promotions jsonb DEFAULT '[]'::jsonb,
+  points_redeemed integer DEFAULT 0,
+  points_discount numeric DEFAULT 0,
+  gift_card_amount numeric DEFAULT 0,
+  stock_deducted_at timestamptz,
   created_at timestamptz DEFAULT now(),
   updated_at timestamptz DEFAULT now()
 );
@@ -167,6 +208,10 @@ CREATE TABLE IF NOT EXISTS payments (
   type text,
   status text DEFAULT 'pending' CHECK (status IN ('pending', 'completed', 'failed', 'refunded')),
   stripe_payment_intent_id text,
+  stripe_destination_account text,
+  application_fee numeric DEFAULT 0,
+  refunded_amount numeric DEFAULT 0,
+  card_fingerprint text,
   metadata jsonb,
   created_at timestamptz DEFAULT now()
 );
@@ -212,6 +257,10 @@ CREATE TABLE IF NOT EXISTS deals (
   max_coupons integer,
   claimed_coupons integer DEFAULT 0,
   min_order numeric,
+  per_user_limit integer DEFAULT 1,
+  rules jsonb,
+  stackable boolean DEFAULT false,
+  valid_from text,
   valid_till text,
   days_available text[],
   start_time text,
@@ -237,6 +286,8 @@ CREATE TABLE IF NOT EXISTS coupons (
   used_at timestamptz,
   expires_at timestamptz,
   code text UNIQUE,
+  order_id uuid REFERENCES orders(id) ON DELETE SET NULL,
+  discount_amount numeric,
   created_at timestamptz DEFAULT now()
 );
 
@@ -253,8 +304,15 @@ CREATE TABLE IF NOT EXISTS transactions (
   original_amount numeric,
   discount_amount numeric,
   final_amount numeric,
-  payment_method text CHECK (payment_method IN ('cash', 'card', 'upi')),
+  payment_method text CHECK (payment_method IN ('cash', 'card', 'upi', 'gift_card')),
   status text DEFAULT 'pending' CHECK (status IN ('pending', 'completed', 'refunded')),
+  refunded_amount numeric DEFAULT 0,
+  order_id uuid REFERENCES orders(id),
+  application_fee numeric DEFAULT 0,
+  stripe_charge_id text,
+  currency text,
+  gift_card_id uuid,
+  gift_card_amount numeric DEFAULT 0,
   created_at timestamptz DEFAULT now()
 );
 
@@ -273,6 +331,10 @@ CREATE TABLE IF NOT EXISTS inventory (
   supplier text,
   expiry_date date,
   last_restocked timestamptz,
+  low_stock_alerted_at timestamptz,
+  expiry_alerted_for date,
+  expired_alerted_for date,
+  supplier_id uuid,
   created_at timestamptz DEFAULT now(),
   updated_at timestamptz DEFAULT now()
 );
@@ -343,6 +405,321 @@ CREATE TABLE IF NOT EXISTS call_bookings (
   created_at timestamptz DEFAULT now()
 );
 
+-- ============================================
+-- 19. USER SESSIONS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS user_sessions (
+  id text PRIMARY KEY,
+  user_id uuid REFERENCES users(id) ON DELETE CASCADE,
+  device_id text,
+  user_agent text,
+  ip_address text,
+  last_seen_at timestamptz DEFAULT now(),
+  revoked_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 20. API KEYS TABLE (POS integrations)
+-- ============================================
+CREATE TABLE IF NOT EXISTS api_keys (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  owner_id uuid REFERENCES users(id) ON DELETE CASCADE,
+  name text NOT NULL,
+  key_prefix text NOT NULL,
+  key_hash text UNIQUE NOT NULL,
+  scopes text[] DEFAULT '{}',
+  last_used_at timestamptz,
+  expires_at timestamptz,
+  revoked_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 21. SECURITY EVENTS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS security_events (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  action text NOT NULL,
+  event text NOT NULL,
+  identity text,
+  ip_address text,
+  user_agent text,
+  details jsonb,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 22. REFUNDS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS refunds (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  order_id uuid REFERENCES orders(id),
+  transaction_id uuid REFERENCES transactions(id),
+  payment_id uuid REFERENCES payments(id),
+  amount numeric NOT NULL CHECK (amount > 0),
+  items jsonb,
+  reason text,
+  provider_refund_id text,
+  status text DEFAULT 'pending',
+  created_by uuid REFERENCES users(id),
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 23. WEBHOOK EVENTS TABLE (Stripe event log)
+-- ============================================
+CREATE TABLE IF NOT EXISTS webhook_events (
+  id text PRIMARY KEY,
+  type text NOT NULL,
+  status text DEFAULT 'received' CHECK (status IN ('received', 'processing', 'processed', 'failed', 'dead_letter')),
+  attempts integer DEFAULT 0,
+  last_error text,
+  next_retry_at timestamptz,
+  processed_at timestamptz,
+  payload jsonb NOT NULL,
+  created_at timestamptz DEFAULT now(),
+  updated_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 24. ORDER SPLITS TABLE (Split bills)
+-- ============================================
+CREATE TABLE IF NOT EXISTS order_splits (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  order_id uuid REFERENCES orders(id) ON DELETE CASCADE,
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  position integer NOT NULL,
+  payer_name text,
+  payer_user_id uuid REFERENCES users(id),
+  amount numeric NOT NULL CHECK (amount > 0),
+  tip_amount numeric DEFAULT 0,
+  items jsonb,
+  split_mode text CHECK (split_mode IN ('even', 'items', 'custom')),
+  status text DEFAULT 'pending' CHECK (status IN ('pending', 'paid', 'failed')),
+  stripe_payment_intent_id text,
+  paid_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 25. TIPS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS tips (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  order_id uuid REFERENCES orders(id) ON DELETE CASCADE,
+  split_id uuid REFERENCES order_splits(id) ON DELETE SET NULL,
+  employee_id uuid REFERENCES employees(id) ON DELETE SET NULL,
+  amount numeric NOT NULL CHECK (amount > 0),
+  tip_type text CHECK (tip_type IN ('percentage', 'fixed')),
+  tip_value numeric,
+  status text DEFAULT 'pending' CHECK (status IN ('pending', 'paid')),
+  stripe_payment_intent_id text,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 26. INVOICES TABLE (Sequential receipt numbers)
+-- ============================================
+CREATE TABLE IF NOT EXISTS invoice_counters (
+  restaurant_id uuid PRIMARY KEY REFERENCES restaurants(id) ON DELETE CASCADE,
+  last_number integer NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  order_id uuid UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+  sequence_number integer NOT NULL,
+  invoice_number text NOT NULL,
+  issued_at timestamptz DEFAULT now(),
+  UNIQUE (restaurant_id, sequence_number)
+);
+
+-- ============================================
+-- 27. OFFERS TABLE (Automatic promotions, no coupon needed)
+-- ============================================
+CREATE TABLE IF NOT EXISTS offers (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  title text NOT NULL,
+  description text,
+  rules jsonb NOT NULL DEFAULT '[]'::jsonb,
+  stackable boolean DEFAULT true,
+  is_active boolean DEFAULT true,
+  valid_from text,
+  valid_till text,
+  days_available text[],
+  start_time text,
+  end_time text,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 28. FAVORITES TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS favorites (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id uuid REFERENCES users(id) ON DELETE CASCADE,
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  created_at timestamptz DEFAULT now(),
+  UNIQUE (user_id, restaurant_id)
+);
+
+-- ============================================
+-- 29. LOYALTY LEDGER (Points earned, spent and expired)
+-- ============================================
+CREATE TABLE IF NOT EXISTS loyalty_ledger (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id uuid REFERENCES users(id) ON DELETE CASCADE,
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE SET NULL,
+  order_id uuid REFERENCES orders(id) ON DELETE SET NULL,
+  booking_id uuid REFERENCES bookings(id) ON DELETE SET NULL,
+  kind text NOT NULL CHECK (kind IN ('earn', 'redeem', 'reversal', 'expire', 'adjust', 'bonus')),
+  points integer NOT NULL,
+  remaining integer NOT NULL DEFAULT 0 CHECK (remaining >= 0),
+  note text,
+  expires_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 30. GIFT CARDS TABLE (Stored value, spendable across orders)
+-- ============================================
+CREATE TABLE IF NOT EXISTS gift_cards (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  code text UNIQUE NOT NULL,
+  currency text,
+  initial_balance numeric NOT NULL CHECK (initial_balance > 0),
+  balance numeric NOT NULL DEFAULT 0 CHECK (balance >= 0),
+  status text DEFAULT 'active' CHECK (status IN ('pending', 'active', 'disabled')),
+  purchaser_id uuid REFERENCES users(id),
+  recipient_name text,
+  recipient_email text,
+  message text,
+  expires_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 31. GIFT CARD LEDGER (Loads, redemptions and reversals)
+-- ============================================
+CREATE TABLE IF NOT EXISTS gift_card_ledger (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  gift_card_id uuid REFERENCES gift_cards(id) ON DELETE CASCADE,
+  kind text NOT NULL CHECK (kind IN ('load', 'redeem', 'reversal', 'adjust')),
+  amount numeric NOT NULL,
+  balance_after numeric NOT NULL,
+  order_id uuid REFERENCES orders(id) ON DELETE SET NULL,
+  transaction_id uuid REFERENCES transactions(id) ON DELETE SET NULL,
+  note text,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 32. REFERRALS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS referrals (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  referrer_id uuid REFERENCES users(id) ON DELETE CASCADE,
+  referred_user_id uuid UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+  code text NOT NULL,
+  status text DEFAULT 'pending' CHECK (status IN ('pending', 'rewarded', 'rejected')),
+  fraud_reason text,
+  order_id uuid REFERENCES orders(id) ON DELETE SET NULL,
+  referrer_reward jsonb,
+  referred_reward jsonb,
+  rewarded_at timestamptz,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 33. INVENTORY MOVEMENTS (Every change to stock, with who and why)
+-- ============================================
+CREATE TABLE IF NOT EXISTS inventory_movements (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  inventory_id uuid REFERENCES inventory(id) ON DELETE CASCADE,
+  kind text NOT NULL CHECK (kind IN ('purchase', 'usage', 'waste', 'adjustment', 'transfer')),
+  quantity numeric NOT NULL,
+  quantity_before numeric NOT NULL,
+  quantity_after numeric NOT NULL,
+  unit_cost numeric DEFAULT 0,
+  total_cost numeric DEFAULT 0,
+  reason text,
+  user_id uuid REFERENCES users(id) ON DELETE SET NULL,
+  order_id uuid REFERENCES orders(id) ON DELETE SET NULL,
+  reference_id uuid,
+  transfer_id uuid,
+  created_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 34. RECIPE INGREDIENTS (Inventory used per portion of a menu item)
+-- ============================================
+CREATE TABLE IF NOT EXISTS recipe_ingredients (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  menu_item_id uuid REFERENCES menu_items(id) ON DELETE CASCADE,
+  inventory_id uuid REFERENCES inventory(id) ON DELETE CASCADE,
+  quantity numeric NOT NULL CHECK (quantity > 0),
+  unit text,
+  created_at timestamptz DEFAULT now(),
+  UNIQUE (menu_item_id, inventory_id)
+);
+
+-- ============================================
+-- 35. SUPPLIERS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS suppliers (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  name text NOT NULL,
+  contact_name text,
+  email text,
+  phone text,
+  address text,
+  lead_time_days integer CHECK (lead_time_days >= 0),
+  notes text,
+  is_active boolean DEFAULT true,
+  created_at timestamptz DEFAULT now(),
+  updated_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 36. PURCHASE ORDERS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS purchase_orders (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  restaurant_id uuid REFERENCES restaurants(id) ON DELETE CASCADE,
+  supplier_id uuid REFERENCES suppliers(id) ON DELETE SET NULL,
+  status text DEFAULT 'draft' CHECK (status IN ('draft', 'sent', 'partial', 'received')),
+  notes text,
+  expected_at date,
+  total numeric DEFAULT 0,
+  created_by uuid REFERENCES users(id) ON DELETE SET NULL,
+  sent_at timestamptz,
+  received_at timestamptz,
+  created_at timestamptz DEFAULT now(),
+  updated_at timestamptz DEFAULT now()
+);
+
+-- ============================================
+-- 37. PURCHASE ORDER ITEMS TABLE
+-- ============================================
+CREATE TABLE IF NOT EXISTS purchase_order_items (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  purchase_order_id uuid REFERENCES purchase_orders(id) ON DELETE CASCADE,
+  inventory_id uuid REFERENCES inventory(id) ON DELETE CASCADE,
+  quantity numeric NOT NULL CHECK (quantity > 0),
+  unit_cost numeric DEFAULT 0,
+  received_quantity numeric DEFAULT 0,
+  created_at timestamptz DEFAULT now()
+);
+
 -- ============================================
 -- INDEXES FOR PERFORMANCE
 -- ============================================
@@ -363,12 +740,51 @@ CREATE INDEX IF NOT EXISTS idx_deals_active ON deals(is_active);
 CREATE INDEX IF NOT EXISTS idx_coupons_user ON coupons(user_id);
 CREATE INDEX IF NOT EXISTS idx_coupons_code ON coupons(code);
 CREATE INDEX IF NOT EXISTS idx_coupons_status ON coupons(status);
+CREATE INDEX IF NOT EXISTS idx_coupons_deal_user ON coupons(deal_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_coupons_order ON coupons(order_id);
 CREATE INDEX IF NOT EXISTS idx_inventory_restaurant ON inventory(restaurant_id);
 CREATE INDEX IF NOT EXISTS idx_food_waste_restaurant ON food_waste(restaurant_id);
 CREATE INDEX IF NOT EXISTS idx_food_waste_date ON food_waste(date);
 CREATE INDEX IF NOT EXISTS idx_employees_restaurant ON employees(restaurant_id);
 CREATE INDEX IF NOT EXISTS idx_schedules_restaurant ON schedules(restaurant_id);
 CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id);
+CREATE INDEX IF NOT EXISTS idx_payments_order ON payments(order_id);
+CREATE INDEX IF NOT EXISTS idx_payments_intent ON payments(stripe_payment_intent_id);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_revoked ON user_sessions(revoked_at);
+CREATE INDEX IF NOT EXISTS idx_api_keys_restaurant ON api_keys(restaurant_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_hash ON api_keys(key_hash);
+CREATE INDEX IF NOT EXISTS idx_security_events_created ON security_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_refunds_restaurant ON refunds(restaurant_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_order ON refunds(order_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_events_status ON webhook_events(status, next_retry_at);
+CREATE INDEX IF NOT EXISTS idx_order_splits_order ON order_splits(order_id);
+CREATE INDEX IF NOT EXISTS idx_tips_restaurant ON tips(restaurant_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_tips_intent ON tips(stripe_payment_intent_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_restaurant ON invoices(restaurant_id);
+CREATE INDEX IF NOT EXISTS idx_offers_restaurant ON offers(restaurant_id, is_active);
+CREATE INDEX IF NOT EXISTS idx_favorites_restaurant ON favorites(restaurant_id);
+CREATE INDEX IF NOT EXISTS idx_users_location ON users(latitude, longitude);
+CREATE INDEX IF NOT EXISTS idx_users_cuisine_preferences ON users USING gin(cuisine_preferences);
+CREATE INDEX IF NOT EXISTS idx_loyalty_ledger_user ON loyalty_ledger(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_loyalty_ledger_order ON loyalty_ledger(order_id);
+CREATE INDEX IF NOT EXISTS idx_loyalty_ledger_lots ON loyalty_ledger(expires_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS idx_gift_cards_restaurant ON gift_cards(restaurant_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_gift_cards_purchaser ON gift_cards(purchaser_id);
+CREATE INDEX IF NOT EXISTS idx_gift_card_ledger_card ON gift_card_ledger(gift_card_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_gift_card_ledger_order ON gift_card_ledger(order_id);
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON referrals(referrer_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_referrals_status ON referrals(status);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_device ON user_sessions(device_id);
+CREATE INDEX IF NOT EXISTS idx_payments_card_fingerprint ON payments(card_fingerprint);
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_item ON inventory_movements(inventory_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_restaurant ON inventory_movements(restaurant_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_order ON inventory_movements(order_id);
+CREATE INDEX IF NOT EXISTS idx_recipe_ingredients_inventory ON recipe_ingredients(inventory_id);
+CREATE INDEX IF NOT EXISTS idx_suppliers_restaurant ON suppliers(restaurant_id);
+CREATE INDEX IF NOT EXISTS idx_inventory_supplier ON inventory(supplier_id);
+CREATE INDEX IF NOT EXISTS idx_purchase_orders_restaurant ON purchase_orders(restaurant_id, status);
+CREATE INDEX IF NOT EXISTS idx_purchase_order_items_order ON purchase_order_items(purchase_order_id);
 
 -- ============================================
 -- FUNCTIONS & TRIGGERS
@@ -389,7 +805,7 @@ DECLARE
     t text;
 BEGIN
     FOR t IN 
-        SELECT unnest(ARRAY['users', 'restaurants', 'menu_items', 'orders', 'bookings', 'services', 'booking_slots', 'inventory', 'food_waste', 'employees', 'schedules'])
+        SELECT unnest(ARRAY['users', 'restaurants', 'menu_items', 'orders', 'bookings', 'services', 'booking_slots', 'inventory', 'food_waste', 'employees', 'schedules', 'suppliers', 'purchase_orders'])
     LOOP
         EXECUTE format('DROP TRIGGER IF EXISTS update_%s_updated_at ON %s', t, t);
         EXECUTE format('CREATE TRIGGER update_%s_updated_at BEFORE UPDATE ON %s FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()', t, t);
@@ -408,6 +824,308 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
+-- Claim a coupon from a deal. Locks the deal, so max_coupons and the
+-- customer's per_user_limit hold under concurrent claims, and counts the
+-- claim in the same transaction as the coupon it issues.
+CREATE OR REPLACE FUNCTION coupon_claim(
+    p_deal_id uuid,
+    p_user_id uuid,
+    p_code text,
+    p_expires_at timestamptz DEFAULT NULL
+)
+RETURNS SETOF coupons AS $$
+DECLARE
+    d deals%ROWTYPE;
+    held integer;
+BEGIN
+    SELECT * INTO d FROM deals WHERE id = p_deal_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'deal not found';
+    END IF;
+    IF d.max_coupons IS NOT NULL AND COALESCE(d.claimed_coupons, 0) >= d.max_coupons THEN
+        RAISE EXCEPTION 'sold out';
+    END IF;
+    IF COALESCE(d.per_user_limit, 1) > 0 THEN
+        SELECT COUNT(*) INTO held FROM coupons
+        WHERE deal_id = p_deal_id AND user_id = p_user_id;
+        IF held >= COALESCE(d.per_user_limit, 1) THEN
+            RAISE EXCEPTION 'limit reached';
+        END IF;
+    END IF;
+
+    UPDATE deals SET claimed_coupons = COALESCE(claimed_coupons, 0) + 1 WHERE id = p_deal_id;
+
+    RETURN QUERY
+    INSERT INTO coupons (deal_id, user_id, deal_title, discount_percent, status, claimed_at, code, expires_at)
+    VALUES (p_deal_id, p_user_id, d.title, d.discount_percent, 'active', now(), p_code, p_expires_at)
+    RETURNING *;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Issue the next sequential invoice number for a restaurant.
+-- Idempotent per order: a second call returns the existing invoice.
+CREATE OR REPLACE FUNCTION issue_invoice(p_restaurant_id uuid, p_order_id uuid, p_prefix text DEFAULT 'INV')
+RETURNS SETOF invoices AS $$
+DECLARE
+    next_number integer;
+BEGIN
+    IF EXISTS (SELECT 1 FROM invoices WHERE order_id = p_order_id) THEN
+        RETURN QUERY SELECT * FROM invoices WHERE order_id = p_order_id;
+        RETURN;
+    END IF;
+
+    INSERT INTO invoice_counters (restaurant_id, last_number)
+    VALUES (p_restaurant_id, 1)
+    ON CONFLICT (restaurant_id) DO UPDATE SET last_number = invoice_counters.last_number + 1
+    RETURNING last_number INTO next_number;
+
+    RETURN QUERY
+    INSERT INTO invoices (restaurant_id, order_id, sequence_number, invoice_number)
+    VALUES (p_restaurant_id, p_order_id, next_number, p_prefix || '-' || lpad(next_number::text, 6, '0'))
+    RETURNING *;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Post a loyalty points change and keep users.points in step.
+-- Earning is idempotent per order / booking. Spending refuses to take the
+-- balance below zero and uses up the lots that expire first.
+CREATE OR REPLACE FUNCTION loyalty_post(
+    p_user_id uuid,
+    p_points integer,
+    p_kind text,
+    p_restaurant_id uuid DEFAULT NULL,
+    p_order_id uuid DEFAULT NULL,
+    p_booking_id uuid DEFAULT NULL,
+    p_note text DEFAULT NULL,
+    p_expires_at timestamptz DEFAULT NULL
+)
+RETURNS SETOF loyalty_ledger AS $$
+DECLARE
+    balance integer;
+    owed integer;
+    lot record;
+    take integer;
+BEGIN
+    SELECT COALESCE(points, 0) INTO balance FROM users WHERE id = p_user_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'user not found';
+    END IF;
+
+    -- Checked under the lock, so concurrent awards can't both pass
+    IF p_kind = 'earn' AND EXISTS (
+        SELECT 1 FROM loyalty_ledger
+        WHERE kind = 'earn' AND user_id = p_user_id
+        AND (order_id = p_order_id OR booking_id = p_booking_id)
+    ) THEN
+        RETURN QUERY SELECT * FROM loyalty_ledger
+        WHERE kind = 'earn' AND user_id = p_user_id
+        AND (order_id = p_order_id OR booking_id = p_booking_id)
+        LIMIT 1;
+        RETURN;
+    END IF;
+    IF balance + p_points < 0 THEN
+        RAISE EXCEPTION 'insufficient points';
+    END IF;
+
+    IF p_points < 0 THEN
+        owed := -p_points;
+        FOR lot IN
+            SELECT id, remaining FROM loyalty_ledger
+            WHERE user_id = p_user_id AND remaining > 0
+            ORDER BY expires_at NULLS LAST, created_at
+            FOR UPDATE
+        LOOP
+            EXIT WHEN owed = 0;
+            take := LEAST(lot.remaining, owed);
+            UPDATE loyalty_ledger SET remaining = remaining - take WHERE id = lot.id;
+            owed := owed - take;
+        END LOOP;
+    END IF;
+
+    UPDATE users SET points = balance + p_points WHERE id = p_user_id;
+
+    RETURN QUERY
+    INSERT INTO loyalty_ledger (user_id, restaurant_id, order_id, booking_id, kind, points, remaining, note, expires_at)
+    VALUES (p_user_id, p_restaurant_id, p_order_id, p_booking_id, p_kind, p_points, GREATEST(p_points, 0), p_note, p_expires_at)
+    RETURNING *;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Give back the points spent on a cancelled order. What is still owed is
+-- worked out under the user lock, so two cancellations can't both pay it.
+CREATE OR REPLACE FUNCTION loyalty_reverse_order(p_order_id uuid)
+RETURNS SETOF loyalty_ledger AS $$
+DECLARE
+    spender uuid;
+    spent_at uuid;
+    owed integer;
+BEGIN
+    SELECT user_id, restaurant_id INTO spender, spent_at FROM loyalty_ledger
+    WHERE order_id = p_order_id AND kind = 'redeem'
+    ORDER BY created_at DESC
+    LIMIT 1;
+    IF NOT FOUND THEN
+        RETURN;
+    END IF;
+
+    PERFORM 1 FROM users WHERE id = spender FOR UPDATE;
+
+    SELECT -COALESCE(SUM(points), 0) INTO owed FROM loyalty_ledger
+    WHERE order_id = p_order_id AND user_id = spender
+    AND kind IN ('redeem', 'reversal');
+    IF owed <= 0 THEN
+        RETURN;
+    END IF;
+
+    RETURN QUERY SELECT * FROM loyalty_post(
+        spender, owed, 'reversal', spent_at, p_order_id, NULL,
+        'Redemption reversed', now() + interval '365 days'
+    );
+END;
+$$ LANGUAGE plpgsql;
+
+-- Post a gift card balance change. Locks the card, refuses to take the
+-- balance below zero and records the balance after the change. A reversal
+-- for an order gives back at most what the order still holds on the card,
+-- worked out under the lock.
+CREATE OR REPLACE FUNCTION gift_card_post(
+    p_gift_card_id uuid,
+    p_amount numeric,
+    p_kind text,
+    p_order_id uuid DEFAULT NULL,
+    p_transaction_id uuid DEFAULT NULL,
+    p_note text DEFAULT NULL
+)
+RETURNS SETOF gift_card_ledger AS $$
+DECLARE
+    card gift_cards%ROWTYPE;
+    held numeric;
+BEGIN
+    SELECT * INTO card FROM gift_cards WHERE id = p_gift_card_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'gift card not found';
+    END IF;
+    IF p_kind = 'reversal' AND p_order_id IS NOT NULL THEN
+        SELECT -COALESCE(SUM(amount), 0) INTO held FROM gift_card_ledger
+        WHERE gift_card_id = p_gift_card_id AND order_id = p_order_id
+        AND kind IN ('redeem', 'reversal');
+        IF held <= 0 THEN
+            RAISE EXCEPTION 'already reversed';
+        END IF;
+        p_amount := LEAST(p_amount, held);
+    END IF;
+    IF p_kind = 'redeem' AND card.status <> 'active' THEN
+        RAISE EXCEPTION 'gift card not active';
+    END IF;
+    IF card.balance + p_amount < 0 THEN
+        RAISE EXCEPTION 'insufficient balance';
+    END IF;
+
+    UPDATE gift_cards SET balance = card.balance + p_amount WHERE id = p_gift_card_id;
+
+    RETURN QUERY
+    INSERT INTO gift_card_ledger (gift_card_id, kind, amount, balance_after, order_id, transaction_id, note)
+    VALUES (p_gift_card_id, p_kind, p_amount, card.balance + p_amount, p_order_id, p_transaction_id, p_note)
+    RETURNING *;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Move stock in or out of an inventory item. Locks the item, refuses to take
+-- it below zero (usage stops at zero instead), records the movement with the
+-- quantity before and after, and stamps last_restocked on incoming stock.
+-- Purchases at a given unit cost move cost_per_unit to the weighted average.
+CREATE OR REPLACE FUNCTION inventory_move(
+    p_inventory_id uuid,
+    p_kind text,
+    p_quantity numeric,
+    p_unit_cost numeric DEFAULT NULL,
+    p_reason text DEFAULT NULL,
+    p_user_id uuid DEFAULT NULL,
+    p_order_id uuid DEFAULT NULL,
+    p_reference_id uuid DEFAULT NULL,
+    p_transfer_id uuid DEFAULT NULL,
+    p_absolute boolean DEFAULT false
+)
+RETURNS SETOF inventory_movements AS $$
+DECLARE
+    item inventory%ROWTYPE;
+    qty_before numeric;
+    delta numeric := p_quantity;
+    cost numeric;
+    movement_id uuid := gen_random_uuid();
+BEGIN
+    SELECT * INTO item FROM inventory WHERE id = p_inventory_id FOR UPDATE;
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'inventory item not found';
+    END IF;
+    qty_before := COALESCE(item.quantity, 0);
+    -- A stock count: p_quantity is what is on the shelf, the change is
+    -- worked out against the locked quantity
+    IF p_absolute THEN
+        delta := p_quantity - qty_before;
+    END IF;
+    IF qty_before + delta < 0 THEN
+        IF p_kind <> 'usage' THEN
+            RAISE EXCEPTION 'insufficient stock';
+        END IF;
+        delta := -qty_before;
+    END IF;
+    cost := COALESCE(p_unit_cost, item.cost_per_unit, 0);
+
+    UPDATE inventory SET
+        quantity = qty_before + delta,
+        cost_per_unit = CASE WHEN p_kind = 'purchase' AND delta > 0 AND p_unit_cost IS NOT NULL
+            THEN (GREATEST(qty_before, 0) * COALESCE(item.cost_per_unit, 0) + delta * p_unit_cost) / (GREATEST(qty_before, 0) + delta)
+            ELSE cost_per_unit END,
+        last_restocked = CASE WHEN p_kind IN ('purchase', 'transfer') AND delta > 0 THEN NOW() ELSE last_restocked END
+    WHERE id = p_inventory_id;
+
+    RETURN QUERY
+    INSERT INTO inventory_movements (id, restaurant_id, inventory_id, kind, quantity, quantity_before, quantity_after,
+        unit_cost, total_cost, reason, user_id, order_id, reference_id, transfer_id)
+    VALUES (movement_id, item.restaurant_id, p_inventory_id, p_kind, delta, qty_before, qty_before + delta,
+        cost, ABS(delta) * cost, p_reason, p_user_id, p_order_id, p_reference_id,
+        CASE WHEN p_kind = 'transfer' THEN COALESCE(p_transfer_id, movement_id) ELSE p_transfer_id END)
+    RETURNING *;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Items of a restaurant whose quantity differs from the sum of their
+-- movements, summed in the database however long the history is.
+CREATE OR REPLACE FUNCTION inventory_drift(p_restaurant_id uuid)
+RETURNS TABLE (inventory_id uuid, name text, quantity numeric, ledger_quantity numeric, difference numeric) AS $$
+    SELECT i.id, i.name, COALESCE(i.quantity, 0), COALESCE(SUM(m.quantity), 0),
+        COALESCE(i.quantity, 0) - COALESCE(SUM(m.quantity), 0)
+    FROM inventory i
+    LEFT JOIN inventory_movements m ON m.inventory_id = i.id
+    WHERE i.restaurant_id = p_restaurant_id
+    GROUP BY i.id, i.name, i.quantity
+    HAVING ABS(COALESCE(i.quantity, 0) - COALESCE(SUM(m.quantity), 0)) > 0.000001
+    ORDER BY i.name;
+$$ LANGUAGE sql STABLE;
+
+-- Expire loyalty points past their expiry date. Returns the number of lots expired.
+CREATE OR REPLACE FUNCTION loyalty_expire()
+RETURNS integer AS $$
+DECLARE
+    lot record;
+    expired integer := 0;
+BEGIN
+    FOR lot IN
+        SELECT id, user_id, restaurant_id, remaining FROM loyalty_ledger
+        WHERE remaining > 0 AND expires_at < NOW()
+        FOR UPDATE SKIP LOCKED
+    LOOP
+        UPDATE loyalty_ledger SET remaining = 0 WHERE id = lot.id;
+        UPDATE users SET points = GREATEST(COALESCE(points, 0) - lot.remaining, 0) WHERE id = lot.user_id;
+        INSERT INTO loyalty_ledger (user_id, restaurant_id, kind, points, remaining, note)
+        VALUES (lot.user_id, lot.restaurant_id, 'expire', -lot.remaining, 0, 'Points expired');
+        expired := expired + 1;
+    END LOOP;
+    RETURN expired;
+END;
+$$ LANGUAGE plpgsql;
+
 -- ============================================
 -- ROW LEVEL SECURITY (RLS) - Optional
 -- ============================================